@@ -9,7 +9,10 @@ import (
 )
 
 var (
-	applyDelete bool
+	applyDelete   bool
+	applyPatch    bool
+	applyThreeWay bool
+	applyCheck    bool
 )
 
 var applyCmd = &cobra.Command{
@@ -21,6 +24,12 @@ for you to commit manually, discarding the original commit sequence. This lets y
 review and customize the final commit before making the agent's work permanent.
 Your working directory will be automatically stashed and restored.
 
+With --patch, the environment's diff is applied to your working tree with
+'git apply' instead, as unstaged changes: nothing is merged, and files
+changed by the environment must not have uncommitted changes. --3way falls
+back on a 3-way merge when the diff doesn't apply cleanly (the changes are
+then staged), and --check only checks that it applies. Both imply --patch.
+
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
 	Args:              cobra.MaximumNArgs(1),
@@ -36,6 +45,12 @@ cu apply --delete backend-api
 git status
 git commit -m "Add backend API implementation"
 
+# Apply the environment's diff as unstaged changes
+cu apply --patch backend-api
+
+# Check that the diff applies, falling back on a 3-way merge
+cu apply --check --3way backend-api
+
 # Auto-select environment
 cu apply`,
 	RunE: func(app *cobra.Command, args []string) error {
@@ -52,7 +67,16 @@ cu apply`,
 			return err
 		}
 
-		if err := repo.Apply(ctx, envID, os.Stdout); err != nil {
+		if applyPatch || applyThreeWay || applyCheck {
+			opts := repository.ApplyPatchOpts{ThreeWay: applyThreeWay, Check: applyCheck}
+			if err := repo.ApplyPatch(ctx, envID, opts, os.Stdout); err != nil {
+				return fmt.Errorf("failed to apply environment: %w", err)
+			}
+			if applyCheck {
+				fmt.Printf("Environment '%s' applies cleanly\n", envID)
+				return nil
+			}
+		} else if err := repo.Apply(ctx, envID, os.Stdout); err != nil {
 			return fmt.Errorf("failed to apply environment: %w", err)
 		}
 
@@ -62,6 +86,9 @@ cu apply`,
 
 func init() {
 	applyCmd.Flags().BoolVarP(&applyDelete, "delete", "d", false, "Delete the environment after successful application")
+	applyCmd.Flags().BoolVar(&applyPatch, "patch", false, "Apply the environment's diff as unstaged changes with git apply")
+	applyCmd.Flags().BoolVar(&applyThreeWay, "3way", false, "Fall back on a 3-way merge if the diff doesn't apply cleanly (implies --patch)")
+	applyCmd.Flags().BoolVar(&applyCheck, "check", false, "Only check that the diff applies, without changing anything (implies --patch)")
 
 	rootCmd.AddCommand(applyCmd)
 }
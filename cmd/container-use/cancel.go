@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var cancelCmd = &cobra.Command{
+	Use:   "cancel [<env>]",
+	Short: "Cancel the operations running in an environment",
+	Long: `Cancel the operations an agent is currently running in an environment,
+e.g. a command that hangs. The agent's pending tool calls fail and it can
+carry on with the environment. Operations started afterwards are not affected.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Stop a hung command
+container-use cancel fancy-mallard
+
+# Auto-select environment
+container-use cancel`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		if err := repository.RequestCancel(envID); err != nil {
+			return fmt.Errorf("failed to cancel environment operations: %w", err)
+		}
+
+		fmt.Printf("Requested cancellation of the operations running in environment '%s'.\n", envID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cancelCmd)
+}
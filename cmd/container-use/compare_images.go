@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/spf13/cobra"
+)
+
+var compareImagesCmd = &cobra.Command{
+	Use:   "compare-images <from> <to>",
+	Short: "Show what changed between two images",
+	Long: `Compare two images, typically two checkpoints of an environment, and report
+the files added (+), removed (-) and changed (~) from <from> to <to>.
+
+With --packages, also compare the system packages installed in the images
+(dpkg, apk or rpm).`,
+	Args: cobra.ExactArgs(2),
+	Example: `# What did the agent change between two checkpoints?
+container-use compare-images registry.example.com/app:before registry.example.com/app:after
+
+# Include installed packages
+container-use compare-images --packages app:before app:after`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		packages, _ := app.Flags().GetBool("packages")
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		diff, err := environment.CompareImages(ctx, dag, args[0], args[1], packages)
+		if err != nil {
+			return err
+		}
+
+		if ok, _ := app.Flags().GetBool("json"); ok {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		}
+
+		printImageDiff(diff)
+		return nil
+	},
+}
+
+func printImageDiff(diff *environment.ImageDiff) {
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		fmt.Println("No file changes.")
+	}
+	for _, path := range diff.Added {
+		fmt.Printf("+ %s\n", path)
+	}
+	for _, path := range diff.Removed {
+		fmt.Printf("- %s\n", path)
+	}
+	for _, path := range diff.Changed {
+		fmt.Printf("~ %s\n", path)
+	}
+
+	if diff.Packages == nil {
+		return
+	}
+	fmt.Println()
+	if len(diff.Packages.Added)+len(diff.Packages.Removed)+len(diff.Packages.Changed) == 0 {
+		fmt.Println("No package changes.")
+		return
+	}
+	fmt.Println("Packages:")
+	for _, pkg := range diff.Packages.Added {
+		fmt.Printf("+ %s\n", pkg)
+	}
+	for _, pkg := range diff.Packages.Removed {
+		fmt.Printf("- %s\n", pkg)
+	}
+	for _, change := range diff.Packages.Changed {
+		fmt.Printf("~ %s %s -> %s\n", change.Name, change.From, change.To)
+	}
+}
+
+func init() {
+	compareImagesCmd.Flags().Bool("packages", false, "Also compare installed system packages")
+	compareImagesCmd.Flags().Bool("json", false, "Output the differences in JSON")
+	rootCmd.AddCommand(compareImagesCmd)
+}
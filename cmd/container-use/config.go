@@ -3,13 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path"
+	"slices"
+	"strings"
 	"text/tabwriter"
 
+	"github.com/charmbracelet/huh"
 	"github.com/dagger/container-use/cmd/container-use/agent"
 	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 // Helper function for read-only config operations
@@ -61,6 +68,8 @@ These settings are stored in .container-use/environment.json and apply to all ne
 
 func init() {
 	configShowCmd.Flags().Bool("json", false, "Dump the configuration in JSON")
+	configShowCmd.Flags().Bool("effective", false, "Show the resolved configuration as YAML, annotating each field with the layer it comes from")
+	configResetCmd.Flags().BoolP("force", "f", false, "Don't ask for confirmation")
 }
 
 var configShowCmd = &cobra.Command{
@@ -68,12 +77,19 @@ var configShowCmd = &cobra.Command{
 	Short: "Show environment configuration",
 	Long: `Display environment configuration including base image and setup commands.
 Without an environment argument, shows the default configuration used for new environments.
-With an environment argument, shows the configuration for that specific environment.`,
+With an environment argument, shows the configuration for that specific environment.
+
+With --effective, each field is annotated with where it comes from: built-in defaults,
+the repository configuration (.container-use/environment.json), or changes made to
+the environment itself.`,
 	Example: `# Show the default environment configuration
 container-use config show
 
 # Show the configuration for a specific environment
 container-use config show my-env
+
+# Find out where each setting of an environment comes from
+container-use config show --effective my-env
 `,
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: suggestEnvironments,
@@ -85,6 +101,29 @@ container-use config show my-env
 			return fmt.Errorf("failed to open repository: %w", err)
 		}
 
+		if ok, _ := cmd.Flags().GetBool("effective"); ok {
+			var envConfig *environment.EnvironmentConfig
+			if len(args) > 0 {
+				env, err := repo.Info(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				envConfig = env.State.Config
+			}
+
+			effective, err := environment.ResolveConfig(repo.SourcePath(), envConfig)
+			if err != nil {
+				return fmt.Errorf("failed to resolve configuration: %w", err)
+			}
+
+			if ok, _ := cmd.Flags().GetBool("json"); ok {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(effective)
+			}
+			return printEffectiveConfig(os.Stdout, effective)
+		}
+
 		var config *environment.EnvironmentConfig
 
 		// If no environment is specified, use the default configuration
@@ -112,6 +151,12 @@ container-use config show my-env
 		defer tw.Flush()
 
 		fmt.Fprintf(tw, "Base Image:\t%s\n", config.BaseImage)
+		switch {
+		case config.BaseImageDigest != "":
+			fmt.Fprintf(tw, "Base Image Digest:\t%s\n", config.BaseImageDigest)
+		case config.PinBaseImage:
+			fmt.Fprintf(tw, "Base Image Digest:\t(pinned when built)\n")
+		}
 		fmt.Fprintf(tw, "Workdir:\t%s\n", config.Workdir)
 
 		if len(config.SetupCommands) > 0 {
@@ -123,6 +168,16 @@ container-use config show my-env
 			fmt.Fprintf(tw, "Setup Commands:\t(none)\n")
 		}
 
+		if len(config.SetupStages) > 0 {
+			fmt.Fprintf(tw, "Setup Stages:\t\n")
+			for i, stage := range config.SetupStages {
+				fmt.Fprintf(tw, "  %d.\t%s\n", i+1, stage)
+				for _, cmd := range stage.Commands {
+					fmt.Fprintf(tw, "\t  %s\n", cmd)
+				}
+			}
+		}
+
 		if len(config.InstallCommands) > 0 {
 			fmt.Fprintf(tw, "Install Commands:\t\n")
 			for i, cmd := range config.InstallCommands {
@@ -132,6 +187,21 @@ container-use config show my-env
 			fmt.Fprintf(tw, "Install Commands:\t(none)\n")
 		}
 
+		if len(config.SeedCommands) > 0 {
+			fmt.Fprintf(tw, "Seed Commands:\t\n")
+			for i, cmd := range config.SeedCommands {
+				fmt.Fprintf(tw, "  %d.\t%s\n", i+1, cmd)
+			}
+		} else {
+			fmt.Fprintf(tw, "Seed Commands:\t(none)\n")
+		}
+
+		if len(config.PathPrepend) > 0 {
+			fmt.Fprintf(tw, "PATH Prepend:\t%s\n", strings.Join(config.PathPrepend, ":"))
+		} else {
+			fmt.Fprintf(tw, "PATH Prepend:\t(none)\n")
+		}
+
 		envKeys := config.Env.Keys()
 		if len(envKeys) > 0 {
 			fmt.Fprintf(tw, "Environment Variables:\t\n")
@@ -143,6 +213,15 @@ container-use config show my-env
 			fmt.Fprintf(tw, "Environment Variables:\t(none)\n")
 		}
 
+		if len(config.Variables) > 0 {
+			fmt.Fprintf(tw, "Template Variables:\t\n")
+			for i, name := range slices.Sorted(maps.Keys(config.Variables)) {
+				fmt.Fprintf(tw, "  %d.\t%s=%s\n", i+1, name, config.Variables[name])
+			}
+		} else {
+			fmt.Fprintf(tw, "Template Variables:\t(none)\n")
+		}
+
 		secretKeys := config.Secrets.Keys()
 		if len(secretKeys) > 0 {
 			fmt.Fprintf(tw, "Secrets:\t\n")
@@ -154,10 +233,81 @@ container-use config show my-env
 			fmt.Fprintf(tw, "Secrets:\t(none)\n")
 		}
 
+		if len(config.Services) > 0 {
+			fmt.Fprintf(tw, "Services:\t\n")
+			for i, service := range config.Services {
+				fmt.Fprintf(tw, "  %d.\t%s\n", i+1, formatServiceConfig(service))
+			}
+		} else {
+			fmt.Fprintf(tw, "Services:\t(none)\n")
+		}
+
+		if len(config.Volumes) > 0 {
+			fmt.Fprintf(tw, "Volumes:\t\n")
+			for i, volume := range config.Volumes {
+				fmt.Fprintf(tw, "  %d.\t%s\n", i+1, volume)
+			}
+		} else {
+			fmt.Fprintf(tw, "Volumes:\t(none)\n")
+		}
+
+		if config.ContextDir != "" {
+			fmt.Fprintf(tw, "Context Directory:\t%s (mounted at %s)\n", config.ContextDir, environment.ContextMountPath)
+		} else {
+			fmt.Fprintf(tw, "Context Directory:\t(none)\n")
+		}
+
+		if config.CABundle != "" {
+			fmt.Fprintf(tw, "CA Bundle:\t%s\n", config.CABundle)
+		} else {
+			fmt.Fprintf(tw, "CA Bundle:\t(none)\n")
+		}
+
+		if config.AutoTitle {
+			fmt.Fprintf(tw, "Auto Title:\tenabled\n")
+		} else {
+			fmt.Fprintf(tw, "Auto Title:\tdisabled\n")
+		}
+
+		if config.DeleteAfterMerge {
+			fmt.Fprintf(tw, "Delete After Merge:\tenabled\n")
+		} else {
+			fmt.Fprintf(tw, "Delete After Merge:\tdisabled\n")
+		}
+
+		if len(config.GitConfig) > 0 {
+			fmt.Fprintf(tw, "Git Config:\t\n")
+			for i, key := range slices.Sorted(maps.Keys(config.GitConfig)) {
+				fmt.Fprintf(tw, "  %d.\t%s=%s\n", i+1, key, config.GitConfig[key])
+			}
+		} else {
+			fmt.Fprintf(tw, "Git Config:\t(none)\n")
+		}
+
 		return nil
 	},
 }
 
+// printEffectiveConfig writes the configuration as YAML with the source of each field as a comment.
+func printEffectiveConfig(w io.Writer, effective *environment.EffectiveConfig) error {
+	var doc yaml.Node
+	if err := doc.Encode(effective.Config); err != nil {
+		return err
+	}
+	// Keys and values alternate in mapping nodes
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key := doc.Content[i]
+		if source, ok := effective.Sources[key.Value]; ok {
+			key.LineComment = "from " + string(source)
+		}
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(&doc)
+}
+
 var configImportCmd = &cobra.Command{
 	Use:   "import <env>",
 	Short: "Import configuration from an environment",
@@ -194,6 +344,75 @@ container-use config import my-env`,
 	},
 }
 
+var configResetCmd = &cobra.Command{
+	Use:   "reset <env>",
+	Short: "Reset an environment's configuration to the default",
+	Long: `Discard configuration changes made in an environment and reset it to the
+default configuration used for new environments. The environment is rebuilt with
+the default configuration on top of its current files; files are left untouched.`,
+	Example: `# Discard configuration changes made by an agent
+container-use config reset my-env
+
+# Reset without asking for confirmation
+container-use config reset my-env --force`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID := args[0]
+		if _, err := repo.Info(ctx, envID); err != nil {
+			return err
+		}
+
+		config := environment.DefaultConfig()
+		if err := config.Load(repo.SourcePath()); err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+
+		if force, _ := cmd.Flags().GetBool("force"); !force {
+			confirmed := false
+			prompt := huh.NewConfirm().
+				Title(fmt.Sprintf("Discard configuration changes in environment '%s'?", envID)).
+				Value(&confirmed)
+			if err := prompt.Run(); err != nil {
+				return err
+			}
+			if !confirmed {
+				fmt.Println("Reset cancelled.")
+				return nil
+			}
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		if err := env.UpdateConfig(ctx, config); err != nil {
+			return fmt.Errorf("failed to rebuild environment: %w", err)
+		}
+
+		if err := repo.Update(ctx, env, "Reset configuration to default"); err != nil {
+			return fmt.Errorf("failed to update environment: %w", err)
+		}
+
+		fmt.Printf("Configuration of environment '%s' reset to default\n", envID)
+		return nil
+	},
+}
+
 // Base image object commands
 var configBaseImageCmd = &cobra.Command{
 	Use:   "base-image",
@@ -209,72 +428,603 @@ var configBaseImageSetCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		baseImage := args[0]
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.BaseImage = baseImage
+			config.SetBaseImage(baseImage)
 			fmt.Printf("Base image set to: %s\n", baseImage)
 			return nil
 		})
 	},
 }
 
-var configBaseImageGetCmd = &cobra.Command{
-	Use:   "get",
-	Short: "Get the current base container image",
-	Long:  `Display the current base container image.`,
+var configBaseImageGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the current base container image",
+	Long:  `Display the current base container image.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			fmt.Println(config.BaseImage)
+			return nil
+		})
+	},
+}
+
+var configBaseImageResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Reset base image to default",
+	Long:  `Reset the base container image to the default (ubuntu:24.04).`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			defaultConfig := environment.DefaultConfig()
+			config.SetBaseImage(defaultConfig.BaseImage)
+			fmt.Printf("Base image reset to default: %s\n", defaultConfig.BaseImage)
+			return nil
+		})
+	},
+}
+
+var configBaseImagePinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Pin the base image of new environments",
+	Long: `Pin the base image of new environments to the digest its tag resolves to when they are
+built, so that rebuilds use the same image even if the tag moves. Refresh the pin with
+container-use config update-base.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.PinBaseImage = true
+			fmt.Println("Base image pinning enabled")
+			return nil
+		})
+	},
+}
+
+var configBaseImageUnpinCmd = &cobra.Command{
+	Use:   "unpin",
+	Short: "Stop pinning the base image",
+	Long:  `Stop pinning the base image of new environments, and drop the digest it is pinned to.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.PinBaseImage = false
+			config.BaseImageDigest = ""
+			fmt.Println("Base image pinning disabled")
+			return nil
+		})
+	},
+}
+
+var configUpdateBaseCmd = &cobra.Command{
+	Use:   "update-base [<env>]",
+	Short: "Pin the base image to the digest its tag resolves to now",
+	Long: `Resolve the tag of the base image again and pin the base image to the digest it
+resolves to now, e.g. to pick up security updates of a pinned image.
+
+Without an environment argument, the pin of the default configuration is refreshed,
+which new environments use. With an environment argument, the pin of that environment
+is refreshed and the environment is rebuilt with the new image.`,
+	Example: `# Pin the base image of new environments to its current digest
+container-use config update-base
+
+# Rebuild an environment with the current image of its base image tag
+container-use config update-base my-env`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		if len(args) == 0 {
+			return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+				if strings.Contains(config.BaseImage, "${") {
+					return fmt.Errorf("base image %s depends on template variables: pin environments instead", config.BaseImage)
+				}
+				digest, err := environment.ResolveImageDigest(ctx, dag, config.BaseImage)
+				if err != nil {
+					return err
+				}
+				config.BaseImageDigest = digest
+				fmt.Printf("Base image %s pinned to %s\n", config.BaseImage, digest)
+				return nil
+			})
+		}
+
+		envID := args[0]
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+		// Services started by the CLI would stop when it exits: leave them to the MCP server
+		env.DeferServices = true
+		digest, err := env.UpdateBaseImage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to update the base image: %w", err)
+		}
+		if err := repo.Update(ctx, env, "Update base image"); err != nil {
+			return err
+		}
+		fmt.Printf("Base image %s of environment '%s' pinned to %s\n", env.State.Config.BaseImage, envID, digest)
+		return nil
+	},
+}
+
+// Context directory object commands
+var configContextDirCmd = &cobra.Command{
+	Use:   "context-dir",
+	Short: "Manage the context directory",
+	Long: fmt.Sprintf(`Manage a host directory with reference material (specs, design docs, ...) mounted at %s in new environments.
+Its contents are available to agents but never committed to the environment's branch.`, environment.ContextMountPath),
+}
+
+var configContextDirSetCmd = &cobra.Command{
+	Use:   "set <path>",
+	Short: "Set the context directory",
+	Long:  `Set the context directory, absolute or relative to the repository root (e.g., docs/specs).`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		contextDir := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.ContextDir = contextDir
+			fmt.Printf("Context directory set to: %s (mounted at %s)\n", contextDir, environment.ContextMountPath)
+			return nil
+		})
+	},
+}
+
+var configContextDirGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the current context directory",
+	Long:  `Display the current context directory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			fmt.Println(config.ContextDir)
+			return nil
+		})
+	},
+}
+
+var configContextDirResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Stop mounting a context directory",
+	Long:  `Remove the context directory from the configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.ContextDir = ""
+			fmt.Println("Context directory removed")
+			return nil
+		})
+	},
+}
+
+// CA bundle object commands
+var configCABundleCmd = &cobra.Command{
+	Use:   "ca-bundle",
+	Short: "Manage the CA certificates trusted by environments",
+	Long: `Manage a host file with PEM encoded CA certificates installed in the trust store of new
+environments, e.g. the certificate of a corporate proxy intercepting TLS, which otherwise makes
+apt, pip or npm fail with certificate errors.
+
+The certificates are added to the system trust store before the setup commands run, and
+SSL_CERT_FILE, REQUESTS_CA_BUNDLE, PIP_CERT, CURL_CA_BUNDLE and NODE_EXTRA_CA_CERTS point to
+them. Environment variables of the configuration take precedence.`,
+}
+
+var configCABundleSetCmd = &cobra.Command{
+	Use:   "set <path>",
+	Short: "Set the CA bundle",
+	Long:  `Set the CA bundle, absolute or relative to the repository root (e.g., /usr/local/share/ca-certificates/corp.crt).`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		caBundle := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.CABundle = caBundle
+			fmt.Printf("CA bundle set to: %s\n", caBundle)
+			return nil
+		})
+	},
+}
+
+var configCABundleGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the current CA bundle",
+	Long:  `Display the current CA bundle.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			fmt.Println(config.CABundle)
+			return nil
+		})
+	},
+}
+
+var configCABundleResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Stop installing a CA bundle",
+	Long:  `Remove the CA bundle from the configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.CABundle = ""
+			fmt.Println("CA bundle removed")
+			return nil
+		})
+	},
+}
+
+// Auto title object commands
+var configAutoTitleCmd = &cobra.Command{
+	Use:   "auto-title",
+	Short: "Manage automatic environment titles",
+	Long: `When enabled, environments created without a meaningful title are titled after
+their first substantive commit, or their branch until then. Explicit titles are never changed.`,
+}
+
+var configAutoTitleEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Derive titles of untitled environments",
+	Long:  `Title new environments without a meaningful title after their first substantive commit.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.AutoTitle = true
+			fmt.Println("Automatic titles enabled")
+			return nil
+		})
+	},
+}
+
+var configAutoTitleDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Keep titles as set by agents",
+	Long:  `Stop deriving titles of new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.AutoTitle = false
+			fmt.Println("Automatic titles disabled")
+			return nil
+		})
+	},
+}
+
+// Delete after merge object commands
+var configDeleteAfterMergeCmd = &cobra.Command{
+	Use:   "delete-after-merge",
+	Short: "Manage the deletion of merged environments",
+	Long: `When enabled, container-use merge deletes the environment once its changes
+are merged cleanly, as with --delete. Environments are kept when the merge
+fails or conflicts, or with --delete=false.`,
+}
+
+var configDeleteAfterMergeEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Delete environments once merged",
+	Long:  `Delete environments once container-use merge merges them cleanly.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.DeleteAfterMerge = true
+			fmt.Println("Deletion after merge enabled")
+			return nil
+		})
+	},
+}
+
+var configDeleteAfterMergeDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Keep environments once merged",
+	Long:  `Keep environments once merged, unless container-use merge is run with --delete.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.DeleteAfterMerge = false
+			fmt.Println("Deletion after merge disabled")
+			return nil
+		})
+	},
+}
+
+// Setup command object commands
+var configSetupCommandCmd = &cobra.Command{
+	Use:   "setup-command",
+	Short: "Manage setup commands",
+	Long:  `Manage setup commands that are run when creating environments.`,
+}
+
+var configSetupCommandAddCmd = &cobra.Command{
+	Use:   "add <command>",
+	Short: "Add a setup command",
+	Long:  `Add a command to be run when creating new environments (e.g., "apt update && apt install -y python3").`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		command := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.SetupCommands = append(config.SetupCommands, command)
+			fmt.Printf("Setup command added: %s\n", command)
+			return nil
+		})
+	},
+}
+
+var configSetupCommandRemoveCmd = &cobra.Command{
+	Use:   "remove <command>",
+	Short: "Remove a setup command",
+	Long:  `Remove a setup command from the environment configuration.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		command := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			found := false
+			newCommands := make([]string, 0, len(config.SetupCommands))
+			for _, existing := range config.SetupCommands {
+				if existing != command {
+					newCommands = append(newCommands, existing)
+				} else {
+					found = true
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("setup command not found: %s", command)
+			}
+
+			config.SetupCommands = newCommands
+			fmt.Printf("Setup command removed: %s\n", command)
+			return nil
+		})
+	},
+}
+
+var configSetupCommandListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all setup commands",
+	Long:  `List all setup commands that will be run when creating environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.SetupCommands) == 0 {
+				fmt.Println("No setup commands configured")
+				return nil
+			}
+
+			for i, command := range config.SetupCommands {
+				fmt.Printf("%d. %s\n", i+1, command)
+			}
+			return nil
+		})
+	},
+}
+
+var configSetupCommandClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all setup commands",
+	Long:  `Remove all setup commands from the environment configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.SetupCommands = []string{}
+			fmt.Println("All setup commands cleared")
+			return nil
+		})
+	},
+}
+
+// Setup stage object commands
+var configSetupStageCmd = &cobra.Command{
+	Use:   "setup-stage",
+	Short: "Manage setup stages",
+	Long: `Manage named groups of setup commands, run in order after the setup commands when creating
+environments (e.g. OS packages, then language toolchains, then project tooling).
+
+Each stage is cached on its own: changing a stage only reruns it and the following ones, so put
+the stages that change the most last. Cache hints rerun a stage without changing its commands
+(--cache-key, --no-cache) or keep package manager caches across its runs (--cache-path).`,
+}
+
+var configSetupStageAddCmd = &cobra.Command{
+	Use:   "add <name> <command>...",
+	Short: "Add a setup stage",
+	Long:  `Add a stage running the given commands, in order, after the existing stages.`,
+	Example: `# Install OS packages, keeping downloaded packages across reruns
+container-use config setup-stage add os "apt-get update" "apt-get install -y build-essential" --cache-path /var/cache/apt
+
+# Rerun the stage to pick up new package versions
+container-use config setup-stage remove os
+container-use config setup-stage add os "apt-get update" "apt-get install -y build-essential" --cache-key 2024-07
+
+# Always fetch the latest tools
+container-use config setup-stage add tools "go install golang.org/x/tools/gopls@latest" --no-cache`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stage := &environment.SetupStage{
+			Name:     args[0],
+			Commands: args[1:],
+		}
+		stage.CacheKey, _ = cmd.Flags().GetString("cache-key")
+		stage.NoCache, _ = cmd.Flags().GetBool("no-cache")
+		stage.CachePaths, _ = cmd.Flags().GetStringArray("cache-path")
+
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.SetupStages.Get(stage.Name) != nil {
+				return fmt.Errorf("setup stage already exists: %s", stage.Name)
+			}
+			config.SetupStages = append(config.SetupStages, stage)
+			if err := config.Validate(); err != nil {
+				return err
+			}
+			fmt.Printf("Setup stage added: %s\n", stage)
+			return nil
+		})
+	},
+}
+
+var configSetupStageRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a setup stage",
+	Long:  `Remove a setup stage from the environment configuration.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if !config.SetupStages.Remove(name) {
+				return fmt.Errorf("setup stage not found: %s", name)
+			}
+			fmt.Printf("Setup stage removed: %s\n", name)
+			return nil
+		})
+	},
+}
+
+var configSetupStageListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all setup stages",
+	Long:  `List the setup stages run when creating environments, with their commands.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.SetupStages) == 0 {
+				fmt.Println("No setup stages configured")
+				return nil
+			}
+
+			for i, stage := range config.SetupStages {
+				fmt.Printf("%d. %s\n", i+1, stage)
+				for _, command := range stage.Commands {
+					fmt.Printf("   %s\n", command)
+				}
+			}
+			return nil
+		})
+	},
+}
+
+var configSetupStageClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all setup stages",
+	Long:  `Remove all setup stages from the environment configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.SetupStages = nil
+			fmt.Println("All setup stages cleared")
+			return nil
+		})
+	},
+}
+
+// Install command object commands
+var configInstallCommandCmd = &cobra.Command{
+	Use:   "install-command",
+	Short: "Manage install commands",
+	Long:  `Manage install commands that are run after copying code to environments.`,
+}
+
+var configInstallCommandAddCmd = &cobra.Command{
+	Use:   "add <command>",
+	Short: "Add an install command",
+	Long:  `Add a command to be run after copying code to new environments (e.g., "go mod download").`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		command := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.InstallCommands = append(config.InstallCommands, command)
+			fmt.Printf("Install command added: %s\n", command)
+			return nil
+		})
+	},
+}
+
+var configInstallCommandRemoveCmd = &cobra.Command{
+	Use:   "remove <command>",
+	Short: "Remove an install command",
+	Long:  `Remove an install command from the environment configuration.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		command := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			found := false
+			newCommands := make([]string, 0, len(config.InstallCommands))
+			for _, existing := range config.InstallCommands {
+				if existing != command {
+					newCommands = append(newCommands, existing)
+				} else {
+					found = true
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("install command not found: %s", command)
+			}
+
+			config.InstallCommands = newCommands
+			fmt.Printf("Install command removed: %s\n", command)
+			return nil
+		})
+	},
+}
+
+var configInstallCommandListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all install commands",
+	Long:  `List all install commands that will be run after copying code to environments.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			fmt.Println(config.BaseImage)
+			if len(config.InstallCommands) == 0 {
+				fmt.Println("No install commands configured")
+				return nil
+			}
+
+			for i, command := range config.InstallCommands {
+				fmt.Printf("%d. %s\n", i+1, command)
+			}
 			return nil
 		})
 	},
 }
 
-var configBaseImageResetCmd = &cobra.Command{
-	Use:   "reset",
-	Short: "Reset base image to default",
-	Long:  `Reset the base container image to the default (ubuntu:24.04).`,
+var configInstallCommandClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all install commands",
+	Long:  `Remove all install commands from the environment configuration.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			defaultConfig := environment.DefaultConfig()
-			config.BaseImage = defaultConfig.BaseImage
-			fmt.Printf("Base image reset to default: %s\n", defaultConfig.BaseImage)
+			config.InstallCommands = []string{}
+			fmt.Println("All install commands cleared")
 			return nil
 		})
 	},
 }
 
-// Setup command object commands
-var configSetupCommandCmd = &cobra.Command{
-	Use:   "setup-command",
-	Short: "Manage setup commands",
-	Long:  `Manage setup commands that are run when creating environments.`,
+// Seed command object commands
+var configSeedCommandCmd = &cobra.Command{
+	Use:   "seed-command",
+	Short: "Manage seed commands",
+	Long: `Manage seed commands that populate environments with data (e.g. database fixtures).
+Seed commands run after services are up and install commands have run, every time the environment is built.`,
 }
 
-var configSetupCommandAddCmd = &cobra.Command{
+var configSeedCommandAddCmd = &cobra.Command{
 	Use:   "add <command>",
-	Short: "Add a setup command",
-	Long:  `Add a command to be run when creating new environments (e.g., "apt update && apt install -y python3").`,
+	Short: "Add a seed command",
+	Long:  `Add a command to be run once services are up in new environments (e.g., "psql -h db -f fixtures.sql").`,
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		command := args[0]
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.SetupCommands = append(config.SetupCommands, command)
-			fmt.Printf("Setup command added: %s\n", command)
+			config.SeedCommands = append(config.SeedCommands, command)
+			fmt.Printf("Seed command added: %s\n", command)
 			return nil
 		})
 	},
 }
 
-var configSetupCommandRemoveCmd = &cobra.Command{
+var configSeedCommandRemoveCmd = &cobra.Command{
 	Use:   "remove <command>",
-	Short: "Remove a setup command",
-	Long:  `Remove a setup command from the environment configuration.`,
+	Short: "Remove a seed command",
+	Long:  `Remove a seed command from the environment configuration.`,
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		command := args[0]
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
 			found := false
-			newCommands := make([]string, 0, len(config.SetupCommands))
-			for _, existing := range config.SetupCommands {
+			newCommands := make([]string, 0, len(config.SeedCommands))
+			for _, existing := range config.SeedCommands {
 				if existing != command {
 					newCommands = append(newCommands, existing)
 				} else {
@@ -283,28 +1033,28 @@ var configSetupCommandRemoveCmd = &cobra.Command{
 			}
 
 			if !found {
-				return fmt.Errorf("setup command not found: %s", command)
+				return fmt.Errorf("seed command not found: %s", command)
 			}
 
-			config.SetupCommands = newCommands
-			fmt.Printf("Setup command removed: %s\n", command)
+			config.SeedCommands = newCommands
+			fmt.Printf("Seed command removed: %s\n", command)
 			return nil
 		})
 	},
 }
 
-var configSetupCommandListCmd = &cobra.Command{
+var configSeedCommandListCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List all setup commands",
-	Long:  `List all setup commands that will be run when creating environments.`,
+	Short: "List all seed commands",
+	Long:  `List all seed commands that will be run once services are up in environments.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			if len(config.SetupCommands) == 0 {
-				fmt.Println("No setup commands configured")
+			if len(config.SeedCommands) == 0 {
+				fmt.Println("No seed commands configured")
 				return nil
 			}
 
-			for i, command := range config.SetupCommands {
+			for i, command := range config.SeedCommands {
 				fmt.Printf("%d. %s\n", i+1, command)
 			}
 			return nil
@@ -312,97 +1062,94 @@ var configSetupCommandListCmd = &cobra.Command{
 	},
 }
 
-var configSetupCommandClearCmd = &cobra.Command{
+var configSeedCommandClearCmd = &cobra.Command{
 	Use:   "clear",
-	Short: "Clear all setup commands",
-	Long:  `Remove all setup commands from the environment configuration.`,
+	Short: "Clear all seed commands",
+	Long:  `Remove all seed commands from the environment configuration.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.SetupCommands = []string{}
-			fmt.Println("All setup commands cleared")
+			config.SeedCommands = []string{}
+			fmt.Println("All seed commands cleared")
 			return nil
 		})
 	},
 }
 
-// Install command object commands
-var configInstallCommandCmd = &cobra.Command{
-	Use:   "install-command",
-	Short: "Manage install commands",
-	Long:  `Manage install commands that are run after copying code to environments.`,
+// PATH object commands
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Manage directories added to PATH",
+	Long: `Manage directories added in front of PATH for every command run in environments,
+e.g. where setup commands install tools.`,
 }
 
-var configInstallCommandAddCmd = &cobra.Command{
-	Use:   "add <command>",
-	Short: "Add an install command",
-	Long:  `Add a command to be run after copying code to new environments (e.g., "go mod download").`,
+var configPathAddCmd = &cobra.Command{
+	Use:   "add <dir>",
+	Short: "Add a directory to PATH",
+	Long:  `Add a directory in front of PATH in new environments (e.g., "/opt/tools/bin"). Directories added last come last.`,
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		command := args[0]
+		dir := args[0]
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("directory must be an absolute path: %s", dir)
+		}
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.InstallCommands = append(config.InstallCommands, command)
-			fmt.Printf("Install command added: %s\n", command)
+			if slices.Contains(config.PathPrepend, dir) {
+				return fmt.Errorf("directory already in PATH: %s", dir)
+			}
+			config.PathPrepend = append(config.PathPrepend, dir)
+			fmt.Printf("Directory added to PATH: %s\n", dir)
 			return nil
 		})
 	},
 }
 
-var configInstallCommandRemoveCmd = &cobra.Command{
-	Use:   "remove <command>",
-	Short: "Remove an install command",
-	Long:  `Remove an install command from the environment configuration.`,
+var configPathRemoveCmd = &cobra.Command{
+	Use:   "remove <dir>",
+	Short: "Remove a directory from PATH",
+	Long:  `Remove a directory added to PATH from the environment configuration.`,
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		command := args[0]
+		dir := args[0]
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			found := false
-			newCommands := make([]string, 0, len(config.InstallCommands))
-			for _, existing := range config.InstallCommands {
-				if existing != command {
-					newCommands = append(newCommands, existing)
-				} else {
-					found = true
-				}
-			}
-
-			if !found {
-				return fmt.Errorf("install command not found: %s", command)
+			i := slices.Index(config.PathPrepend, dir)
+			if i == -1 {
+				return fmt.Errorf("directory not found: %s", dir)
 			}
-
-			config.InstallCommands = newCommands
-			fmt.Printf("Install command removed: %s\n", command)
+			config.PathPrepend = slices.Delete(config.PathPrepend, i, i+1)
+			fmt.Printf("Directory removed from PATH: %s\n", dir)
 			return nil
 		})
 	},
 }
 
-var configInstallCommandListCmd = &cobra.Command{
+var configPathListCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List all install commands",
-	Long:  `List all install commands that will be run after copying code to environments.`,
+	Short: "List the directories added to PATH",
+	Long:  `List the directories added in front of PATH in environments, in order.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			if len(config.InstallCommands) == 0 {
-				fmt.Println("No install commands configured")
+			if len(config.PathPrepend) == 0 {
+				fmt.Println("No directories added to PATH")
 				return nil
 			}
 
-			for i, command := range config.InstallCommands {
-				fmt.Printf("%d. %s\n", i+1, command)
+			for i, dir := range config.PathPrepend {
+				fmt.Printf("%d. %s\n", i+1, dir)
 			}
 			return nil
 		})
 	},
 }
 
-var configInstallCommandClearCmd = &cobra.Command{
+var configPathClearCmd = &cobra.Command{
 	Use:   "clear",
-	Short: "Clear all install commands",
-	Long:  `Remove all install commands from the environment configuration.`,
+	Short: "Clear the directories added to PATH",
+	Long:  `Remove all the directories added to PATH from the environment configuration.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.InstallCommands = []string{}
-			fmt.Println("All install commands cleared")
+			config.PathPrepend = []string{}
+			fmt.Println("All directories removed from PATH")
 			return nil
 		})
 	},
@@ -482,6 +1229,87 @@ var configEnvClearCmd = &cobra.Command{
 	},
 }
 
+// Template variable object commands
+var configVariableCmd = &cobra.Command{
+	Use:   "variable",
+	Short: "Manage template variables",
+	Long: `Manage the default values of template variables. Variables are referenced as ${NAME} in the
+configuration (e.g. a "python:${PY_VERSION}" base image) and resolved when creating environments.
+Agents can override the defaults when creating an environment.`,
+}
+
+var configVariableSetCmd = &cobra.Command{
+	Use:   "set <name> <value>",
+	Short: "Set the default value of a template variable",
+	Long:  `Set the default value of a template variable referenced as ${NAME} in the configuration.`,
+	Example: `# Parameterize the base image by Python version
+container-use config base-image set 'python:${PY_VERSION}'
+container-use config variable set PY_VERSION 3.12`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		value := args[1]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Variables == nil {
+				config.Variables = map[string]string{}
+			}
+			config.Variables[name] = value
+			fmt.Printf("Template variable set: %s=%s\n", name, value)
+			return nil
+		})
+	},
+}
+
+var configVariableUnsetCmd = &cobra.Command{
+	Use:   "unset <name>",
+	Short: "Unset a template variable",
+	Long:  `Remove the default value of a template variable. Environments referencing it must then be given a value when created.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if _, ok := config.Variables[name]; !ok {
+				return fmt.Errorf("template variable not found: %s", name)
+			}
+			delete(config.Variables, name)
+			fmt.Printf("Template variable unset: %s\n", name)
+			return nil
+		})
+	},
+}
+
+var configVariableListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all template variables",
+	Long:  `List the default values of the template variables.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Variables) == 0 {
+				fmt.Println("No template variables configured")
+				return nil
+			}
+
+			for i, name := range slices.Sorted(maps.Keys(config.Variables)) {
+				fmt.Printf("%d. %s=%s\n", i+1, name, config.Variables[name])
+			}
+			return nil
+		})
+	},
+}
+
+var configVariableClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all template variables",
+	Long:  `Remove the default values of all template variables.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Variables = nil
+			fmt.Println("All template variables cleared")
+			return nil
+		})
+	},
+}
+
 // Secret object commands
 var configSecretCmd = &cobra.Command{
 	Use:   "secret",
@@ -556,11 +1384,251 @@ var configSecretClearCmd = &cobra.Command{
 	},
 }
 
+// Service object commands
+var configServiceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage default services",
+	Long: `Manage services (e.g. databases, caches) that are started with every new environment.
+Services start in the order they are listed, after the setup commands and before the
+install commands, so install commands can already reach them.`,
+}
+
+var configServiceAddCmd = &cobra.Command{
+	Use:   "add <name> <image>",
+	Short: "Add a default service",
+	Long: `Add a service to be started with every new environment. Environments reach it by its name
+used as hostname (e.g. "postgres:5432").`,
+	Example: `# Postgres, reachable at postgres:5432
+container-use config service add postgres postgres:17 --port 5432 --env POSTGRES_PASSWORD=postgres
+
+# Redis with a custom command
+container-use config service add redis redis:7 --port 6379 --command "redis-server --appendonly yes"`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service := &environment.ServiceConfig{
+			Name:  args[0],
+			Image: args[1],
+		}
+		service.Command, _ = cmd.Flags().GetString("command")
+		service.Env, _ = cmd.Flags().GetStringArray("env")
+		service.StartupTimeout, _ = cmd.Flags().GetInt("startup-timeout")
+		if service.StartupTimeout < 0 {
+			return fmt.Errorf("--startup-timeout must not be negative")
+		}
+
+		rawPorts, _ := cmd.Flags().GetStringArray("port")
+		for _, raw := range rawPorts {
+			port, err := environment.ParseServicePort(raw)
+			if err != nil {
+				return err
+			}
+			service.ExposedPorts = append(service.ExposedPorts, port)
+		}
+
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Services.Get(service.Name) != nil {
+				return fmt.Errorf("service already exists: %s", service.Name)
+			}
+			config.Services = append(config.Services, service)
+			fmt.Printf("Service added: %s\n", formatServiceConfig(service))
+			return nil
+		})
+	},
+}
+
+var configServiceRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a default service",
+	Long:  `Remove a service from the environment configuration.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if !config.Services.Remove(name) {
+				return fmt.Errorf("service not found: %s", name)
+			}
+			fmt.Printf("Service removed: %s\n", name)
+			return nil
+		})
+	},
+}
+
+var configServiceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all default services",
+	Long:  `List all services that will be started when creating environments, in startup order.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Services) == 0 {
+				fmt.Println("No services configured")
+				return nil
+			}
+
+			for i, service := range config.Services {
+				fmt.Printf("%d. %s\n", i+1, formatServiceConfig(service))
+			}
+			return nil
+		})
+	},
+}
+
+var configServiceClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all default services",
+	Long:  `Remove all services from the environment configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Services = environment.ServiceConfigs{}
+			fmt.Println("All services cleared")
+			return nil
+		})
+	},
+}
+
+// Volume object commands
+var configVolumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage shared volumes",
+	Long: `Manage named volumes mounted in new environments. Every environment mounting a volume of the
+same name sees the same files, e.g. a large dataset or a build cache shared by environments working
+on related tasks, without copying them around. Volumes are never committed.
+
+Writes of one environment are immediately visible to the others, and nothing coordinates them: two
+environments writing the same files at the same time can corrupt them. Mount volumes that several
+environments write to with --locked, so that their commands use the volume one at a time (others
+wait), or only write to them from one environment.
+
+Volumes are Dagger cache volumes: they are kept by the Dagger engine and deleted when its cache is
+pruned. Don't use them as the only copy of important data.`,
+}
+
+var configVolumeAddCmd = &cobra.Command{
+	Use:   "add <name> <path>",
+	Short: "Mount a shared volume in new environments",
+	Long:  `Mount the named volume at an absolute path outside the workdir of new environments. The volume is created empty the first time it is mounted.`,
+	Example: `# Share a dataset between environments
+container-use config volume add datasets /data
+
+# Share a package cache that environments write to concurrently
+container-use config volume add pip-cache /root/.cache/pip --locked`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		volume := &environment.VolumeConfig{
+			Name: args[0],
+			Path: args[1],
+		}
+		if locked, _ := cmd.Flags().GetBool("locked"); locked {
+			volume.Sharing = environment.VolumeSharingLocked
+		}
+
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Volumes.Get(volume.Name) != nil {
+				return fmt.Errorf("volume already exists: %s", volume.Name)
+			}
+			config.Volumes = append(config.Volumes, volume)
+			if err := config.Validate(); err != nil {
+				return err
+			}
+			fmt.Printf("Volume added: %s\n", volume)
+			return nil
+		})
+	},
+}
+
+var configVolumeRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Stop mounting a shared volume",
+	Long:  `Stop mounting a volume in new environments. Its contents are kept for the environments still mounting it.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if !config.Volumes.Remove(name) {
+				return fmt.Errorf("volume not found: %s", name)
+			}
+			fmt.Printf("Volume removed: %s\n", name)
+			return nil
+		})
+	},
+}
+
+var configVolumeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all shared volumes",
+	Long:  `List the volumes mounted in new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Volumes) == 0 {
+				fmt.Println("No volumes configured")
+				return nil
+			}
+
+			for i, volume := range config.Volumes {
+				fmt.Printf("%d. %s\n", i+1, volume)
+			}
+			return nil
+		})
+	},
+}
+
+var configVolumeClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all shared volumes",
+	Long:  `Stop mounting any volume in new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Volumes = nil
+			fmt.Println("All volumes cleared")
+			return nil
+		})
+	},
+}
+
+// formatServiceConfig describes a service on a single line, e.g. "postgres (postgres:17, ports: 5432)".
+func formatServiceConfig(service *environment.ServiceConfig) string {
+	details := []string{service.Image}
+	if len(service.ExposedPorts) > 0 {
+		ports := make([]string, 0, len(service.ExposedPorts))
+		for _, port := range service.ExposedPorts {
+			ports = append(ports, port.String())
+		}
+		details = append(details, "ports: "+strings.Join(ports, ", "))
+	}
+	if service.Command != "" {
+		details = append(details, "command: "+service.Command)
+	}
+	if len(service.Env) > 0 {
+		details = append(details, "env: "+strings.Join(environment.KVList(service.Env).Keys(), ", "))
+	}
+	if service.StartupTimeout > 0 {
+		details = append(details, fmt.Sprintf("startup timeout: %ds", service.StartupTimeout))
+	}
+	return fmt.Sprintf("%s (%s)", service.Name, strings.Join(details, ", "))
+}
+
 func init() {
 	// Add base-image commands
 	configBaseImageCmd.AddCommand(configBaseImageSetCmd)
 	configBaseImageCmd.AddCommand(configBaseImageGetCmd)
 	configBaseImageCmd.AddCommand(configBaseImageResetCmd)
+	configBaseImageCmd.AddCommand(configBaseImagePinCmd)
+	configBaseImageCmd.AddCommand(configBaseImageUnpinCmd)
+
+	// Add context-dir commands
+	configContextDirCmd.AddCommand(configContextDirSetCmd)
+	configContextDirCmd.AddCommand(configContextDirGetCmd)
+	configContextDirCmd.AddCommand(configContextDirResetCmd)
+
+	// Add ca-bundle commands
+	configCABundleCmd.AddCommand(configCABundleSetCmd)
+	configCABundleCmd.AddCommand(configCABundleGetCmd)
+	configCABundleCmd.AddCommand(configCABundleResetCmd)
+
+	// Auto title commands
+	configAutoTitleCmd.AddCommand(configAutoTitleEnableCmd)
+	configAutoTitleCmd.AddCommand(configAutoTitleDisableCmd)
+	configDeleteAfterMergeCmd.AddCommand(configDeleteAfterMergeEnableCmd)
+	configDeleteAfterMergeCmd.AddCommand(configDeleteAfterMergeDisableCmd)
 
 	// Add setup-command commands
 	configSetupCommandCmd.AddCommand(configSetupCommandAddCmd)
@@ -568,32 +1636,88 @@ func init() {
 	configSetupCommandCmd.AddCommand(configSetupCommandListCmd)
 	configSetupCommandCmd.AddCommand(configSetupCommandClearCmd)
 
+	// Add setup-stage commands
+	configSetupStageAddCmd.Flags().String("cache-key", "", "Arbitrary key: change it to rerun the stage")
+	configSetupStageAddCmd.Flags().Bool("no-cache", false, "Rerun the stage every time an environment is created")
+	configSetupStageAddCmd.Flags().StringArray("cache-path", nil, "Directory kept across runs of the stage, e.g. a package cache (repeatable)")
+	configSetupStageCmd.AddCommand(configSetupStageAddCmd)
+	configSetupStageCmd.AddCommand(configSetupStageRemoveCmd)
+	configSetupStageCmd.AddCommand(configSetupStageListCmd)
+	configSetupStageCmd.AddCommand(configSetupStageClearCmd)
+
 	// Add install-command commands
 	configInstallCommandCmd.AddCommand(configInstallCommandAddCmd)
 	configInstallCommandCmd.AddCommand(configInstallCommandRemoveCmd)
 	configInstallCommandCmd.AddCommand(configInstallCommandListCmd)
 	configInstallCommandCmd.AddCommand(configInstallCommandClearCmd)
 
+	// Add path commands
+	configPathCmd.AddCommand(configPathAddCmd)
+	configPathCmd.AddCommand(configPathRemoveCmd)
+	configPathCmd.AddCommand(configPathListCmd)
+	configPathCmd.AddCommand(configPathClearCmd)
+
+	// Add seed-command commands
+	configSeedCommandCmd.AddCommand(configSeedCommandAddCmd)
+	configSeedCommandCmd.AddCommand(configSeedCommandRemoveCmd)
+	configSeedCommandCmd.AddCommand(configSeedCommandListCmd)
+	configSeedCommandCmd.AddCommand(configSeedCommandClearCmd)
+
 	// Add env commands
 	configEnvCmd.AddCommand(configEnvSetCmd)
 	configEnvCmd.AddCommand(configEnvUnsetCmd)
 	configEnvCmd.AddCommand(configEnvListCmd)
 	configEnvCmd.AddCommand(configEnvClearCmd)
 
+	// Add template variable commands
+	configVariableCmd.AddCommand(configVariableSetCmd)
+	configVariableCmd.AddCommand(configVariableUnsetCmd)
+	configVariableCmd.AddCommand(configVariableListCmd)
+	configVariableCmd.AddCommand(configVariableClearCmd)
+
 	// Add secret commands
 	configSecretCmd.AddCommand(configSecretSetCmd)
 	configSecretCmd.AddCommand(configSecretUnsetCmd)
 	configSecretCmd.AddCommand(configSecretListCmd)
 	configSecretCmd.AddCommand(configSecretClearCmd)
 
+	// Add service commands
+	configServiceAddCmd.Flags().String("command", "", "Command to start the service (defaults to the image's command)")
+	configServiceAddCmd.Flags().StringArray("port", nil, "Port to expose, as PORT or PORT/udp (repeatable)")
+	configServiceAddCmd.Flags().StringArray("env", nil, "Environment variable to set, as KEY=VALUE (repeatable)")
+	configServiceAddCmd.Flags().Int("startup-timeout", 0, "Seconds the service may take to accept connections on its ports (default: 30)")
+	configServiceCmd.AddCommand(configServiceAddCmd)
+	configServiceCmd.AddCommand(configServiceRemoveCmd)
+	configServiceCmd.AddCommand(configServiceListCmd)
+	configServiceCmd.AddCommand(configServiceClearCmd)
+
+	// Add volume commands
+	configVolumeAddCmd.Flags().Bool("locked", false, "Let commands use the volume one at a time, across environments")
+	configVolumeCmd.AddCommand(configVolumeAddCmd)
+	configVolumeCmd.AddCommand(configVolumeRemoveCmd)
+	configVolumeCmd.AddCommand(configVolumeListCmd)
+	configVolumeCmd.AddCommand(configVolumeClearCmd)
+
 	// Add object commands to config
 	configCmd.AddCommand(configBaseImageCmd)
+	configCmd.AddCommand(configUpdateBaseCmd)
 	configCmd.AddCommand(configSetupCommandCmd)
+	configCmd.AddCommand(configSetupStageCmd)
 	configCmd.AddCommand(configInstallCommandCmd)
+	configCmd.AddCommand(configSeedCommandCmd)
+	configCmd.AddCommand(configPathCmd)
 	configCmd.AddCommand(configEnvCmd)
+	configCmd.AddCommand(configVariableCmd)
 	configCmd.AddCommand(configSecretCmd)
+	configCmd.AddCommand(configServiceCmd)
+	configCmd.AddCommand(configVolumeCmd)
+	configCmd.AddCommand(configContextDirCmd)
+	configCmd.AddCommand(configCABundleCmd)
+	configCmd.AddCommand(configAutoTitleCmd)
+	configCmd.AddCommand(configDeleteAfterMergeCmd)
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configImportCmd)
+	configCmd.AddCommand(configResetCmd)
 
 	// Add agent command
 	configCmd.AddCommand(agent.AgentCmd)
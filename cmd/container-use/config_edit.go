@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const configEditErrorPrefix = "# ERROR: "
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit [<env>]",
+	Short: "Edit configuration in your editor",
+	Long: `Open the configuration as YAML in $VISUAL or $EDITOR and apply it on save.
+Without an environment argument, edits the default configuration used for new environments.
+With an environment argument, edits and applies the configuration of that environment, rebuilding its container.
+
+If the edited configuration is invalid, the editor is re-opened with the error at the top of the file.
+Save an empty file to abort.`,
+	Example: `# Edit the default configuration
+container-use config edit
+
+# Edit the configuration of a specific environment
+EDITOR=nano container-use config edit fancy-mallard`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		config := environment.DefaultConfig()
+		if len(args) == 0 {
+			if err := config.Load(repo.SourcePath()); err != nil {
+				return fmt.Errorf("failed to load configuration: %w", err)
+			}
+		} else {
+			envInfo, err := repo.Info(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			config = envInfo.State.Config
+		}
+
+		edited, err := editConfig(config)
+		if err != nil {
+			return err
+		}
+		if edited == nil {
+			fmt.Println("No changes made.")
+			return nil
+		}
+
+		if len(args) == 0 {
+			if err := edited.Save(repo.SourcePath()); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+			fmt.Println("Default configuration updated.")
+			return nil
+		}
+
+		envID := args[0]
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+		if err := env.UpdateConfig(ctx, edited); err != nil {
+			return fmt.Errorf("failed to apply configuration: %w", err)
+		}
+		if err := repo.Update(ctx, env, "Edit configuration"); err != nil {
+			return fmt.Errorf("failed to update environment: %w", err)
+		}
+
+		fmt.Printf("Configuration of environment '%s' updated.\n", envID)
+		return nil
+	},
+}
+
+// editConfig opens the config as YAML in the user's editor until it is saved in a valid state.
+// It returns nil if the config was left unchanged or the file was emptied.
+func editConfig(config *environment.EnvironmentConfig) (*environment.EnvironmentConfig, error) {
+	original, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "container-use-config-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	contents := original
+	for {
+		if err := os.WriteFile(f.Name(), contents, 0600); err != nil {
+			return nil, err
+		}
+		if err := runEditor(f.Name()); err != nil {
+			return nil, err
+		}
+
+		contents, err = os.ReadFile(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		contents = stripEditError(contents)
+		if len(bytes.TrimSpace(contents)) == 0 || bytes.Equal(contents, original) {
+			return nil, nil
+		}
+
+		edited, err := parseEditedConfig(contents)
+		if err == nil {
+			return edited, nil
+		}
+		contents = append([]byte(configEditErrorPrefix+strings.ReplaceAll(err.Error(), "\n", " ")+"\n"), contents...)
+	}
+}
+
+func parseEditedConfig(contents []byte) (*environment.EnvironmentConfig, error) {
+	config := &environment.EnvironmentConfig{}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// stripEditError removes the error annotation added by a previous attempt.
+func stripEditError(contents []byte) []byte {
+	for bytes.HasPrefix(contents, []byte(configEditErrorPrefix)) {
+		_, rest, _ := bytes.Cut(contents, []byte("\n"))
+		contents = rest
+	}
+	return contents
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, which may include arguments (e.g. "code --wait").
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("editor exited with code %d, aborting", exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run editor %q: %w", editor, err)
+	}
+	return nil
+}
+
+func init() {
+	configCmd.AddCommand(configEditCmd)
+}
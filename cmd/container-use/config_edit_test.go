@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseEditedConfig(t *testing.T) {
+	config := environment.DefaultConfig()
+	config.SetupCommands = []string{"apt-get update && apt-get install -y git"}
+	config.Services = environment.ServiceConfigs{{
+		Name:         "dns",
+		Image:        "coredns/coredns",
+		ExposedPorts: []environment.ServicePort{{Port: 53, Protocol: environment.ProtocolUDP}, {Port: 8080, Protocol: environment.ProtocolTCP}},
+	}}
+
+	contents, err := yaml.Marshal(config)
+	require.NoError(t, err)
+
+	parsed, err := parseEditedConfig(contents)
+	require.NoError(t, err)
+	assert.Equal(t, config, parsed, "config should round-trip through YAML")
+
+	_, err = parseEditedConfig([]byte("base_image: alpine\nworkdir: /workdir\nsetup_comands: [ls]\n"))
+	assert.ErrorContains(t, err, "setup_comands", "unknown fields should be reported")
+
+	_, err = parseEditedConfig([]byte("base_image: alpine\nworkdir: workdir\n"))
+	assert.ErrorContains(t, err, "workdir must be an absolute path")
+}
+
+func TestStripEditError(t *testing.T) {
+	contents := []byte(configEditErrorPrefix + "first\n" + configEditErrorPrefix + "second\nbase_image: alpine\n")
+	assert.Equal(t, "base_image: alpine\n", string(stripEditError(contents)))
+}
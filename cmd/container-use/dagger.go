@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"dagger.io/dagger"
+)
+
+// connectDagger connects to the Dagger engine for commands that need to run containers.
+// Engine progress is written to stderr.
+func connectDagger(ctx context.Context) (*dagger.Client, error) {
+	dag, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+	if err != nil {
+		if isDockerDaemonError(err) {
+			handleDockerDaemonError()
+		}
+		return nil, fmt.Errorf("failed to connect to dagger: %w", err)
+	}
+	return dag, nil
+}
@@ -1,48 +1,111 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
 
 var diffCmd = &cobra.Command{
-	Use:   "diff [<env>]",
+	Use:   "diff [<env>] [<other-env>]",
 	Short: "Show what files an agent changed",
 	Long: `Display the code changes made by an agent in an environment.
 Shows a git diff between the environment's state and your current branch.
 
+With --stat, summarizes the changes instead: the lines added and removed in
+each file, and the totals.
+
+With two environments, shows the differences between their files instead,
+e.g. to compare two attempts at the same task.
+
+With --format pr, writes one patch file per commit instead, keeping each
+commit's message and author. Apply the series with ` + "`git am`" + ` to open a pull
+request with meaningful history.
+
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
-	Args:              cobra.MaximumNArgs(1),
+	Args:              cobra.MaximumNArgs(2),
 	ValidArgsFunction: suggestEnvironments,
 	Example: `# See what changes the agent made
 container-use diff fancy-mallard
 
+# Summarize the changes of an agent that touched many files
+container-use diff --stat fancy-mallard
+
+# Compare two attempts at the same task
+container-use diff fancy-mallard quick-otter
+
 # Quick assessment before merging
 container-use diff backend-api
 
+# Export the commits as patches and apply them to a new branch
+container-use diff fancy-mallard --format pr --output-dir patches
+git switch -c fancy-mallard && git am patches/*.patch
+
 # Auto-select environment
 container-use diff`,
 	RunE: func(app *cobra.Command, args []string) error {
 		ctx := app.Context()
 
+		format, _ := app.Flags().GetString("format")
+		outputDir, _ := app.Flags().GetString("output-dir")
+		if format != "diff" && format != "pr" {
+			return fmt.Errorf("invalid format %q, must be one of: diff, pr", format)
+		}
+		stat, _ := app.Flags().GetBool("stat")
+		if stat && format != "diff" {
+			return fmt.Errorf("--stat can't be used with --format %s", format)
+		}
+		opts := repository.DiffOpts{Stat: stat}
+
 		// Ensure we're in a git repository
 		repo, err := repository.Open(ctx, ".")
 		if err != nil {
 			return err
 		}
 
+		if len(args) == 2 {
+			if format != "diff" {
+				return fmt.Errorf("--format %s is not supported when comparing two environments", format)
+			}
+			return repo.DiffEnvironmentsWithOpts(ctx, args[0], args[1], opts, os.Stdout)
+		}
+
 		envID, err := resolveEnvironmentID(ctx, repo, args)
 		if err != nil {
 			return err
 		}
 
-		return repo.Diff(ctx, envID, os.Stdout)
+		if format == "diff" {
+			return repo.DiffWithOpts(ctx, envID, opts, os.Stdout)
+		}
+
+		// git runs from the repository root, resolve the directory from where we are
+		outputDir, err = filepath.Abs(outputDir)
+		if err != nil {
+			return err
+		}
+		patches, err := repo.FormatPatch(ctx, envID, outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to export patches: %w", err)
+		}
+		if len(patches) == 0 {
+			fmt.Printf("Environment '%s' has no commits to export.\n", envID)
+			return nil
+		}
+		for _, patch := range patches {
+			fmt.Println(patch)
+		}
+		return nil
 	},
 }
 
 func init() {
+	diffCmd.Flags().String("format", "diff", "Output format: diff (single diff on stdout) or pr (one patch file per commit)")
+	diffCmd.Flags().StringP("output-dir", "o", ".", "Directory to write patch files to with --format pr")
+	diffCmd.Flags().Bool("stat", false, "Summarize the lines added and removed in each file instead of printing the diff")
 	rootCmd.AddCommand(diffCmd)
 }
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage environments",
+	Long:  `Maintenance commands for individual environments.`,
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envAutocommitCmd = &cobra.Command{
+	Use:   "autocommit [<env>]",
+	Short: "Commit changes made by hand to an environment as they happen",
+	Long: `Watch the worktree of an environment and commit the changes made to it by
+hand, e.g. with your editor, as they happen: they are copied to the
+environment's container and committed with a generated message, like the
+changes made by the agent's tools. Changes are committed once files stop
+changing for the --debounce duration, so a burst of edits makes a single
+commit.
+
+The worktree is printed on startup: open it in your editor. Changes made
+in "container-use terminal" are not persisted, edit files in the worktree
+instead. Press Ctrl+C to stop watching.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Commit hand edits as you save them
+container-use env autocommit fancy-mallard
+
+# Wait for 10 seconds without changes before committing
+container-use env autocommit fancy-mallard --debounce 10s`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+		worktreePath, err := repo.WorktreePath(envID)
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		debounce, _ := app.Flags().GetDuration("debounce")
+		fmt.Printf("Watching %s for changes to environment '%s'. Press Ctrl+C to stop.\n", worktreePath, envID)
+		return repo.AutoCommit(ctx, dag, envID, repository.AutoCommitOpts{
+			Debounce: debounce,
+			OnCommit: func(message string) {
+				fmt.Printf("%s %s\n", time.Now().Format(time.TimeOnly), message)
+			},
+		})
+	},
+}
+
+func init() {
+	envAutocommitCmd.Flags().Duration("debounce", 2*time.Second, "How long files must stop changing before they are committed")
+	envCmd.AddCommand(envAutocommitCmd)
+}
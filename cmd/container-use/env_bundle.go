@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envBundleCmd = &cobra.Command{
+	Use:   "bundle [<env>]",
+	Short: "Write an environment to a git bundle file",
+	Long: `Write an environment to a git bundle file, to share it or move it to
+another machine: the bundle holds the environment's branch with its whole
+history, along with its state and log. Import it with env import, in any
+clone of the repository.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Bundle an environment to fancy-mallard.bundle
+container-use env bundle fancy-mallard
+
+# Bundle it to a specific file
+container-use env bundle fancy-mallard --output /tmp/env.bundle`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		output, _ := app.Flags().GetString("output")
+		if output == "" {
+			output = envID + ".bundle"
+		}
+		if err := repo.Bundle(ctx, envID, output); err != nil {
+			return err
+		}
+		fmt.Printf("Bundled environment '%s' to %s\n", envID, output)
+		return nil
+	},
+}
+
+var envImportCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Create an environment from a git bundle file",
+	Long: `Create an environment from a bundle written by env bundle, e.g. in
+another clone of the repository. The environment keeps its name unless
+--name is set; importing fails if an environment of that name exists.`,
+	Args: cobra.ExactArgs(1),
+	Example: `# Import an environment under its original name
+container-use env import fancy-mallard.bundle
+
+# Import it under another name
+container-use env import fancy-mallard.bundle --name login-form`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		name, _ := app.Flags().GetString("name")
+		envID, err := repo.ImportBundle(ctx, args[0], name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported environment '%s' from %s\n", envID, args[0])
+		return nil
+	},
+}
+
+func init() {
+	envBundleCmd.Flags().StringP("output", "o", "", "File to write the bundle to (default: <env>.bundle)")
+	envImportCmd.Flags().String("name", "", "Name of the imported environment (default: its name when bundled)")
+	envCmd.AddCommand(envBundleCmd)
+	envCmd.AddCommand(envImportCmd)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envDiffConfigCmd = &cobra.Command{
+	Use:   "diff-config <from> <to>",
+	Short: "Compare the configuration of two environments",
+	Long: `Show how the configuration of environment <to> differs from the one of
+environment <from>: base image, commands, variables, services, volumes...
+Entries are reported as added (+), removed (-) or changed (~).
+
+Useful when a command works in one environment but not in another, e.g.
+between environments forked from one another. Values of sensitive variables
+are masked.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Why do the tests pass in one environment but not in the other?
+container-use env diff-config fancy-mallard clever-otter`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		configs := make([]*environment.EnvironmentConfig, len(args))
+		for i, envID := range args {
+			envInfo, err := repo.Info(ctx, envID)
+			if err != nil {
+				return err
+			}
+			configs[i] = envInfo.State.Config
+		}
+
+		changes := environment.DiffConfigs(configs[0], configs[1])
+		if ok, _ := app.Flags().GetBool("json"); ok {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(changes)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("No configuration differences.")
+			return nil
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		return nil
+	},
+}
+
+func init() {
+	envDiffConfigCmd.Flags().Bool("json", false, "Output the differences in JSON")
+	envCmd.AddCommand(envDiffConfigCmd)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envExportPRCmd = &cobra.Command{
+	Use:   "export-pr [<env>]",
+	Short: "Open a GitHub pull request from an environment",
+	Long: `Push the work of an environment to a GitHub remote (origin by default) and
+open a pull request from it, titled after the environment, with the list of
+its commits as description. If a pull request is already open from the
+branch, it is updated instead: run the command again to publish the new
+commits of the environment.
+
+The branch is container-use/<env> unless --branch is set, and the pull
+request targets the default branch of the repository unless --base is set.
+Pushing uses your git credentials for the remote, and the GitHub API is
+called with the token of the GITHUB_TOKEN (or GH_TOKEN) environment variable.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Open a pull request against the default branch
+GITHUB_TOKEN=$(gh auth token) container-use env export-pr fancy-mallard
+
+# Open a draft pull request from feature/login against develop
+container-use env export-pr fancy-mallard --branch feature/login --base develop --draft`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		token := os.Getenv("GITHUB_TOKEN")
+		if token == "" {
+			token = os.Getenv("GH_TOKEN")
+		}
+		if token == "" {
+			return fmt.Errorf("a GitHub token is required: set GITHUB_TOKEN (e.g. to the output of gh auth token)")
+		}
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		remote, _ := app.Flags().GetString("remote")
+		gh, err := repo.GitHubRemote(ctx, remote)
+		if err != nil {
+			return err
+		}
+		content, err := repo.PullRequestContent(ctx, envID)
+		if err != nil {
+			return err
+		}
+
+		branch, _ := app.Flags().GetString("branch")
+		if branch == "" {
+			branch = "container-use/" + envID
+		}
+		force, _ := app.Flags().GetBool("force")
+		if err := repo.PushBranch(ctx, envID, remote, branch, force, os.Stderr); err != nil {
+			return err
+		}
+
+		client := &githubClient{remote: gh, token: token}
+		base, _ := app.Flags().GetString("base")
+		draft, _ := app.Flags().GetBool("draft")
+		pr, created, err := client.upsertPullRequest(ctx, branch, base, content, draft)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("Opened pull request #%d for environment '%s': %s\n", pr.Number, envID, pr.HTMLURL)
+		} else {
+			fmt.Printf("Updated pull request #%d for environment '%s': %s\n", pr.Number, envID, pr.HTMLURL)
+		}
+		return nil
+	},
+}
+
+// githubPullRequest is a pull request, as returned by the GitHub API.
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// githubClient calls the GitHub API of a repository.
+type githubClient struct {
+	remote *repository.GitHubRemote
+	token  string
+}
+
+// upsertPullRequest updates the open pull request from branch with content, or opens one against base (the
+// repository's default branch if empty). It reports whether the pull request was created.
+func (c *githubClient) upsertPullRequest(ctx context.Context, branch, base string, content *repository.PullRequestContent, draft bool) (*githubPullRequest, bool, error) {
+	var existing []githubPullRequest
+	query := url.Values{"state": {"open"}, "head": {c.remote.Owner + ":" + branch}}
+	if err := c.do(ctx, http.MethodGet, "/pulls?"+query.Encode(), nil, &existing); err != nil {
+		return nil, false, fmt.Errorf("failed to look for an existing pull request: %w", err)
+	}
+	if len(existing) > 0 {
+		pr := existing[0]
+		update := map[string]any{"title": content.Title, "body": content.Body}
+		if base != "" {
+			update["base"] = base
+		}
+		if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/pulls/%d", pr.Number), update, &pr); err != nil {
+			return nil, false, fmt.Errorf("failed to update pull request #%d: %w", pr.Number, err)
+		}
+		return &pr, false, nil
+	}
+
+	if base == "" {
+		var repo struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := c.do(ctx, http.MethodGet, "", nil, &repo); err != nil {
+			return nil, false, fmt.Errorf("failed to get the default branch of %s/%s: %w", c.remote.Owner, c.remote.Name, err)
+		}
+		base = repo.DefaultBranch
+	}
+	var pr githubPullRequest
+	create := map[string]any{"title": content.Title, "body": content.Body, "head": branch, "base": base, "draft": draft}
+	if err := c.do(ctx, http.MethodPost, "/pulls", create, &pr); err != nil {
+		return nil, false, fmt.Errorf("failed to open pull request: %w", err)
+	}
+	return &pr, true, nil
+}
+
+// do calls an endpoint of the repository's API (e.g. "/pulls"), sending body and decoding the response into out.
+func (c *githubClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/%s%s", c.remote.APIURL, c.remote.Owner, c.remote.Name, path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		msg := apiErr.Message
+		for _, e := range apiErr.Errors {
+			if e.Message != "" {
+				msg += ": " + e.Message
+			}
+		}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("GitHub API returned %s, check your GITHUB_TOKEN", resp.Status)
+		case http.StatusNotFound:
+			return fmt.Errorf("GitHub API returned %s: %s/%s doesn't exist or the token can't access it", resp.Status, c.remote.Owner, c.remote.Name)
+		}
+		return fmt.Errorf("GitHub API returned %s: %s", resp.Status, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	envExportPRCmd.Flags().String("remote", "origin", "GitHub remote to push the branch to")
+	envExportPRCmd.Flags().String("branch", "", "Branch to push the environment to (default: container-use/<env>)")
+	envExportPRCmd.Flags().String("base", "", "Branch the pull request targets (default: the repository's default branch)")
+	envExportPRCmd.Flags().Bool("draft", false, "Open the pull request as a draft")
+	envExportPRCmd.Flags().BoolP("force", "f", false, "Overwrite the branch if it has commits that aren't in the environment")
+	envCmd.AddCommand(envExportPRCmd)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertPullRequest(t *testing.T) {
+	content := &repository.PullRequestContent{Title: "Add login form", Body: "## Commits\n\n- Add form (abc1234)"}
+
+	var open []githubPullRequest
+	var requests []string
+	var created map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/app/pulls":
+			assert.Equal(t, "acme:feature/login", r.URL.Query().Get("head"))
+			json.NewEncoder(w).Encode(open)
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/app":
+			fmt.Fprint(w, `{"default_branch": "main"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/acme/app/pulls":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"number": 7, "html_url": "https://github.com/acme/app/pull/7"}`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/acme/app/pulls/7":
+			fmt.Fprint(w, `{"number": 7, "html_url": "https://github.com/acme/app/pull/7"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &githubClient{remote: &repository.GitHubRemote{APIURL: server.URL, Owner: "acme", Name: "app"}, token: "secret"}
+
+	pr, isNew, err := client.upsertPullRequest(t.Context(), "feature/login", "", content, false)
+	require.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, 7, pr.Number)
+	assert.Equal(t, []string{"GET /repos/acme/app/pulls", "GET /repos/acme/app", "POST /repos/acme/app/pulls"}, requests)
+	assert.Equal(t, "main", created["base"])
+	assert.Equal(t, "feature/login", created["head"])
+	assert.Equal(t, content.Title, created["title"])
+
+	// The open pull request is updated rather than opening another one
+	open = []githubPullRequest{{Number: 7}}
+	requests = nil
+	pr, isNew, err = client.upsertPullRequest(t.Context(), "feature/login", "", content, false)
+	require.NoError(t, err)
+	assert.False(t, isNew)
+	assert.Equal(t, "https://github.com/acme/app/pull/7", pr.HTMLURL)
+	assert.Equal(t, []string{"GET /repos/acme/app/pulls", "PATCH /repos/acme/app/pulls/7"}, requests)
+
+	// Errors of the API are reported
+	client.remote.Name = "unknown"
+	_, _, err = client.upsertPullRequest(t.Context(), "feature/login", "", content, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "acme/unknown doesn't exist")
+}
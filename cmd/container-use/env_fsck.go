@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envFsckCmd = &cobra.Command{
+	Use:   "fsck [<env>]",
+	Short: "Check that an environment's branch, worktree and state agree",
+	Long: `Check that the container-use/<env> branch of an environment, its worktree
+and the state and configuration stored with it agree, and report the issues
+found, e.g. after an interrupted operation left the environment acting weird.
+
+With --fix, the issues that can be fixed automatically are fixed: the
+worktree is recreated from the branch (discarding its uncommitted changes),
+and a missing or unreadable state is restored from the most recent commit
+that has one. The command fails if issues are left.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Check an environment
+container-use env fsck fancy-mallard
+
+# Check it and fix the issues found
+container-use env fsck fancy-mallard --fix`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		fix, _ := app.Flags().GetBool("fix")
+		issues, err := repo.Fsck(ctx, envID, fix)
+		if err != nil {
+			return err
+		}
+		if len(issues) == 0 {
+			fmt.Printf("Environment '%s' is consistent.\n", envID)
+			return nil
+		}
+
+		left, fixable := 0, 0
+		fmt.Printf("Found %d issue(s) in environment '%s':\n", len(issues), envID)
+		for _, issue := range issues {
+			fmt.Printf("  - %s\n", issue.Problem)
+			switch {
+			case issue.Fixed:
+				fmt.Printf("    fixed: %s\n", issue.Fix)
+				continue
+			case issue.Fix != "":
+				fmt.Printf("    fix: %s\n", issue.Fix)
+				fixable++
+			default:
+				fmt.Printf("    can't be fixed automatically\n")
+			}
+			left++
+		}
+
+		if left == 0 {
+			return nil
+		}
+		if fixable > 0 {
+			return fmt.Errorf("%d issue(s) left in environment '%s', run with --fix to fix %d of them", left, envID, fixable)
+		}
+		return fmt.Errorf("%d issue(s) left in environment '%s'", left, envID)
+	},
+}
+
+func init() {
+	envFsckCmd.Flags().Bool("fix", false, "Fix the issues found")
+	envCmd.AddCommand(envFsckCmd)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Show which environments were forked from which",
+	Long: `Display the fork relationships between environments as a tree: an environment
+created from the branch of another one (e.g. with from_git_ref set to
+container-use/<env>) is shown under it, along with the commit they have in common.
+
+Use --dot to get a Graphviz graph instead.`,
+	Args: cobra.NoArgs,
+	Example: `# Show families of environments
+container-use env graph
+
+# Render them with Graphviz
+container-use env graph --dot | dot -Tsvg > environments.svg`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envs, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+		if len(envs) == 0 {
+			fmt.Println("No environments found.")
+			return nil
+		}
+		forks := repo.Forks(ctx, envs)
+
+		if dot, _ := app.Flags().GetBool("dot"); dot {
+			renderForkDOT(os.Stdout, envs, forks)
+			return nil
+		}
+		renderForkTree(os.Stdout, envs, forks)
+		return nil
+	},
+}
+
+// renderForkTree writes environments as a tree, forks under their parent, oldest first.
+// Forks of deleted environments are shown at the top level.
+func renderForkTree(w io.Writer, envs []*environment.EnvironmentInfo, forks []repository.Fork) {
+	byID := map[string]*environment.EnvironmentInfo{}
+	for _, env := range envs {
+		byID[env.ID] = env
+	}
+	mergeBases := map[string]string{}
+	children := map[string][]*environment.EnvironmentInfo{}
+	for _, fork := range forks {
+		mergeBases[fork.Child] = fork.MergeBase
+		if _, ok := byID[fork.Parent]; ok {
+			children[fork.Parent] = append(children[fork.Parent], byID[fork.Child])
+		}
+	}
+
+	label := func(env *environment.EnvironmentInfo) string {
+		l := env.ID
+		if env.State.Title != "" {
+			l += "  " + env.State.Title
+		}
+		if parent := env.State.Parent; parent != "" {
+			if _, ok := byID[parent]; !ok {
+				l += fmt.Sprintf(" (forked from %s, deleted)", parent)
+			} else if mergeBase := mergeBases[env.ID]; mergeBase != "" {
+				l += fmt.Sprintf(" (forked at %s)", shortCommit(mergeBase))
+			}
+		}
+		return l
+	}
+
+	var walk func(env *environment.EnvironmentInfo, prefix string)
+	walk = func(env *environment.EnvironmentInfo, prefix string) {
+		kids := sortedByCreation(children[env.ID])
+		for i, child := range kids {
+			branch, indent := "├── ", "│   "
+			if i == len(kids)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, label(child))
+			walk(child, prefix+indent)
+		}
+	}
+
+	for _, env := range sortedByCreation(envs) {
+		if _, ok := byID[env.State.Parent]; ok {
+			continue
+		}
+		fmt.Fprintln(w, label(env))
+		walk(env, "")
+	}
+}
+
+// renderForkDOT writes environments and their forks as a Graphviz graph.
+func renderForkDOT(w io.Writer, envs []*environment.EnvironmentInfo, forks []repository.Fork) {
+	fmt.Fprintln(w, "digraph environments {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	known := map[string]bool{}
+	for _, env := range sortedByCreation(envs) {
+		known[env.ID] = true
+		fmt.Fprintf(w, "  %s [label=%s];\n", dotQuote(env.ID), dotQuote(env.ID+"\n"+env.State.Title))
+	}
+	for _, fork := range forks {
+		if !known[fork.Parent] {
+			fmt.Fprintf(w, "  %s [label=%s, style=dashed];\n", dotQuote(fork.Parent), dotQuote(fork.Parent+"\n(deleted)"))
+			known[fork.Parent] = true
+		}
+		attrs := ""
+		if fork.MergeBase != "" {
+			attrs = fmt.Sprintf(" [label=%s]", dotQuote(shortCommit(fork.MergeBase)))
+		}
+		fmt.Fprintf(w, "  %s -> %s%s;\n", dotQuote(fork.Parent), dotQuote(fork.Child), attrs)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func sortedByCreation(envs []*environment.EnvironmentInfo) []*environment.EnvironmentInfo {
+	return slices.SortedFunc(slices.Values(envs), func(a, b *environment.EnvironmentInfo) int {
+		if c := a.State.CreatedAt.Compare(b.State.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func init() {
+	envGraphCmd.Flags().Bool("dot", false, "Output a Graphviz (DOT) graph")
+	envCmd.AddCommand(envGraphCmd)
+}
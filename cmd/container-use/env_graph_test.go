@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func graphTestEnvironments() ([]*environment.EnvironmentInfo, []repository.Fork) {
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	env := func(id, title, parent string, age int) *environment.EnvironmentInfo {
+		return &environment.EnvironmentInfo{
+			ID:    id,
+			State: &environment.State{Title: title, Parent: parent, CreatedAt: start.Add(time.Duration(age) * time.Minute)},
+		}
+	}
+	envs := []*environment.EnvironmentInfo{
+		env("calm-heron", "Try channels", "fancy-mallard", 2),
+		env("fancy-mallard", "Fix the flaky test", "", 0),
+		env("quick-otter", "Try a mutex", "fancy-mallard", 1),
+		env("brave-lynx", "Mutex with timeout", "quick-otter", 3),
+		env("lone-wolf", "Orphan", "gone-env", 4),
+	}
+	forks := []repository.Fork{
+		{Parent: "fancy-mallard", Child: "calm-heron", MergeBase: "1a2b3c4d5e6f"},
+		{Parent: "fancy-mallard", Child: "quick-otter", MergeBase: "1a2b3c4d5e6f"},
+		{Parent: "quick-otter", Child: "brave-lynx", MergeBase: "9f8e7d6c5b4a"},
+		{Parent: "gone-env", Child: "lone-wolf"},
+	}
+	return envs, forks
+}
+
+func TestRenderForkTree(t *testing.T) {
+	envs, forks := graphTestEnvironments()
+
+	var buf bytes.Buffer
+	renderForkTree(&buf, envs, forks)
+	assert.Equal(t, `fancy-mallard  Fix the flaky test
+├── quick-otter  Try a mutex (forked at 1a2b3c4)
+│   └── brave-lynx  Mutex with timeout (forked at 9f8e7d6)
+└── calm-heron  Try channels (forked at 1a2b3c4)
+lone-wolf  Orphan (forked from gone-env, deleted)
+`, buf.String())
+}
+
+func TestRenderForkDOT(t *testing.T) {
+	envs, forks := graphTestEnvironments()
+
+	var buf bytes.Buffer
+	renderForkDOT(&buf, envs, forks)
+	out := buf.String()
+	assert.Contains(t, out, `"fancy-mallard" [label="fancy-mallard\nFix the flaky test"];`)
+	assert.Contains(t, out, `"fancy-mallard" -> "quick-otter" [label="1a2b3c4"];`)
+	assert.Contains(t, out, `"gone-env" [label="gone-env\n(deleted)", style=dashed];`)
+	assert.Contains(t, out, `"gone-env" -> "lone-wolf";`)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envLockCmd = &cobra.Command{
+	Use:   "lock [<env>]",
+	Short: "Prevent further changes to an environment",
+	Long: `Lock an environment holding finalized work, e.g. once you reviewed it, so that
+an agent still running in it can't overwrite it by accident.
+
+Agents can still read files of a locked environment and run commands with
+no_commit, but tools modifying it (file writes, edits and deletions, commands,
+configuration changes, ...) fail until it is unlocked. Locked environments are
+never pruned.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Protect reviewed work
+container-use env lock fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		return setEnvironmentLocked(app, args, true)
+	},
+}
+
+var envUnlockCmd = &cobra.Command{
+	Use:   "unlock [<env>]",
+	Short: "Allow changes to a locked environment again",
+	Long: `Unlock an environment locked with "container-use env lock", so that agents can
+modify it again.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Let an agent continue working in the environment
+container-use env unlock fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		return setEnvironmentLocked(app, args, false)
+	},
+}
+
+func setEnvironmentLocked(app *cobra.Command, args []string, locked bool) error {
+	ctx := app.Context()
+
+	repo, err := repository.Open(ctx, ".")
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	envID, err := resolveEnvironmentID(ctx, repo, args)
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		if err := repo.Unlock(ctx, envID); err != nil {
+			return fmt.Errorf("failed to unlock environment %s: %w", envID, err)
+		}
+		fmt.Printf("Environment '%s' unlocked.\n", envID)
+		return nil
+	}
+
+	if err := repo.Lock(ctx, envID); err != nil {
+		return fmt.Errorf("failed to lock environment %s: %w", envID, err)
+	}
+	fmt.Printf("Environment '%s' locked. Unlock it with: container-use env unlock %s\n", envID, envID)
+	return nil
+}
+
+func init() {
+	envCmd.AddCommand(envLockCmd)
+	envCmd.AddCommand(envUnlockCmd)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envPromoteCmd = &cobra.Command{
+	Use:   "promote [<env>]",
+	Short: "Mark an environment as the chosen result",
+	Long: `Mark an environment as the canonical result among several attempts, e.g. the
+one you picked among alternatives explored by agents in forked environments.
+
+Only one environment of a family (environments forked from one another, see
+"container-use env graph") is promoted at a time: promoting an environment
+demotes the others. Promoted environments are listed first by
+"container-use list", are never pruned, and are selected by default by
+commands such as merge, apply and diff when several environments match.
+
+Use --undo to remove the mark.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Pick the winner among forked attempts
+container-use env promote fancy-mallard
+
+# Change your mind
+container-use env promote --undo fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		if undo, _ := app.Flags().GetBool("undo"); undo {
+			if err := repo.Demote(ctx, envID); err != nil {
+				return fmt.Errorf("failed to demote environment %s: %w", envID, err)
+			}
+			fmt.Printf("Environment '%s' is no longer promoted.\n", envID)
+			return nil
+		}
+
+		demoted, err := repo.Promote(ctx, envID)
+		if err != nil {
+			return fmt.Errorf("failed to promote environment %s: %w", envID, err)
+		}
+		fmt.Printf("Environment '%s' promoted.\n", envID)
+		if len(demoted) > 0 {
+			fmt.Printf("Demoted: %s\n", strings.Join(demoted, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	envPromoteCmd.Flags().Bool("undo", false, "Remove the promoted mark")
+	envCmd.AddCommand(envPromoteCmd)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envRebuildCmd = &cobra.Command{
+	Use:   "rebuild [<env>]",
+	Short: "Rebuild an environment's container from scratch",
+	Long: `Rebuild the container of an environment from scratch, re-applying its
+configuration (base image, setup and install commands, services) on top of the
+files of the latest commit of its branch.
+
+Use this when the container state is wedged. Committed file changes are kept,
+anything else done in the container (e.g. installed packages) is discarded.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Rebuild an environment
+container-use env rebuild fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		fmt.Printf("Rebuilding environment '%s'...\n", envID)
+		if _, err := repo.Rebuild(ctx, dag, envID); err != nil {
+			return fmt.Errorf("failed to rebuild environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' rebuilt successfully.\n", envID)
+		return nil
+	},
+}
+
+func init() {
+	envCmd.AddCommand(envRebuildCmd)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the index of environments from git",
+	Long: `Rebuild the index of environment metadata from the environments' branches.
+
+Listing environments reads the state of every environment from git, which
+gets slow with thousands of them. The first reindex creates an index that
+caches their metadata: it is kept up to date as environments are created,
+updated and deleted, and used to list them from then on. Git remains the
+source of truth: run reindex again to reconcile the index with it, e.g. after
+using a version of container-use that doesn't maintain it.
+
+Use --disable to remove the index and list environments from git again.`,
+	Args: cobra.NoArgs,
+	Example: `# Create or rebuild the index
+container-use env reindex
+
+# Stop using an index
+container-use env reindex --disable`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		if disable, _ := app.Flags().GetBool("disable"); disable {
+			if err := repo.DisableIndex(); err != nil {
+				return fmt.Errorf("failed to remove index: %w", err)
+			}
+			fmt.Println("Index removed, environments are listed from git.")
+			return nil
+		}
+
+		count, err := repo.Reindex(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d environment(s).\n", count)
+		return nil
+	},
+}
+
+func init() {
+	envReindexCmd.Flags().Bool("disable", false, "Remove the index and list environments from git")
+	envCmd.AddCommand(envReindexCmd)
+}
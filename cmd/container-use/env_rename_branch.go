@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envRenameBranchCmd = &cobra.Command{
+	Use:   "rename-branch [<env>] <branch>",
+	Short: "Push an environment's work as a regularly named branch",
+	Long: `Push the work of an environment to a remote (origin by default) under a
+branch name of your choosing, e.g. feature/my-work, rather than the
+container-use/<env> ref. The branch shows up on the remote like any other,
+ready for a pull request.
+
+Run it again to push the new commits of the environment. If the branch already
+exists on the remote with other commits, the push is refused: use --force to
+overwrite it. Pushing uses your git credentials for the remote.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args: cobra.RangeArgs(1, 2),
+	ValidArgsFunction: func(app *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return suggestEnvironments(app, args, toComplete)
+	},
+	Example: `# Push an environment's work as feature/my-work on origin
+container-use env rename-branch fancy-mallard feature/my-work
+
+# Push to another remote, overwriting the branch
+container-use env rename-branch fancy-mallard feature/my-work --remote fork --force`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		branch := args[len(args)-1]
+		envID, err := resolveEnvironmentID(ctx, repo, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+
+		remote, _ := app.Flags().GetString("remote")
+		force, _ := app.Flags().GetBool("force")
+		if err := repo.PushBranch(ctx, envID, remote, branch, force, os.Stderr); err != nil {
+			return err
+		}
+		fmt.Printf("Pushed environment '%s' to branch '%s' of %s.\n", envID, branch, remote)
+		return nil
+	},
+}
+
+func init() {
+	envRenameBranchCmd.Flags().String("remote", "origin", "Remote to push the branch to")
+	envRenameBranchCmd.Flags().BoolP("force", "f", false, "Overwrite the branch if it has commits that aren't in the environment")
+	envCmd.AddCommand(envRenameBranchCmd)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envScriptCmd = &cobra.Command{
+	Use:   "script [<env>]",
+	Short: "Export an environment's work as a replayable shell script",
+	Long: `Reconstruct a shell script reproducing the work done in an environment,
+from its configuration and commit history.
+
+The script sets the configured environment variables, runs the setup and
+install commands, then replays each commit of the environment: the commands
+recorded in its log are run again, and the changes of the commit are applied
+as is, so that files end up exactly as in the environment. Secrets and
+services are listed but not included.
+
+Run the script at the root of a clean clone of the repository, in a
+container of the environment's base image.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Print the script
+container-use env script fancy-mallard
+
+# Save it and replay it in a fresh container
+container-use env script fancy-mallard -o replay.sh
+docker run --rm -it -v "$PWD:/workdir" -w /workdir ubuntu:24.04 sh ./replay.sh`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		output, _ := app.Flags().GetString("output")
+		if output != "" {
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+			if err != nil {
+				return fmt.Errorf("failed to create script: %w", err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		if err := repo.Script(ctx, envID, w); err != nil {
+			return fmt.Errorf("failed to export script: %w", err)
+		}
+
+		if output != "" {
+			fmt.Fprintf(os.Stderr, "Environment '%s' exported to %s\n", envID, output)
+		}
+		return nil
+	},
+}
+
+func init() {
+	envScriptCmd.Flags().StringP("output", "o", "", "Write the script to this file instead of stdout")
+	envCmd.AddCommand(envScriptCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/charmbracelet/huh"
@@ -13,7 +14,7 @@ import (
 
 // resolveEnvironmentID resolves the environment ID for commands that take env_id as the only positional argument.
 // If no args are provided, it filters environments to those where the local repo head is a parent of the environment's head,
-// then either auto-selects if there's only one match or prompts the user to select from multiple options.
+// then either auto-selects if there's only one match (or only one promoted match) or prompts the user to select from multiple options.
 func resolveEnvironmentID(ctx context.Context, repo *repository.Repository, args []string) (string, error) {
 	if len(args) == 1 {
 		return args[0], nil
@@ -44,10 +45,31 @@ func resolveEnvironmentID(ctx context.Context, repo *repository.Repository, args
 		return filteredEnvs[0].ID, nil
 	}
 
+	// A promoted environment is the chosen result among several attempts
+	if promoted := promotedEnvironment(filteredEnvs); promoted != nil {
+		fmt.Fprintf(os.Stderr, "Using promoted environment '%s'\n", promoted.ID)
+		return promoted.ID, nil
+	}
+
 	// Multiple environments - prompt user to select
 	return promptForEnvironmentSelection(filteredEnvs)
 }
 
+// promotedEnvironment returns the only promoted environment among envs, or nil if there are none or several.
+func promotedEnvironment(envs []*environment.EnvironmentInfo) *environment.EnvironmentInfo {
+	var promoted *environment.EnvironmentInfo
+	for _, env := range envs {
+		if !env.State.Promoted {
+			continue
+		}
+		if promoted != nil {
+			return nil
+		}
+		promoted = env
+	}
+	return promoted
+}
+
 // promptForEnvironmentSelection prompts the user to select from multiple environments
 func promptForEnvironmentSelection(envs []*environment.EnvironmentInfo) (string, error) {
 	var options []huh.Option[string]
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,3 +33,17 @@ func TestResolveEnvironmentID(t *testing.T) {
 	// Note: Testing with no args requires a real repository and is tested
 	// in environment/integration/environment_selection_test.go
 }
+
+func TestPromotedEnvironment(t *testing.T) {
+	env := func(id string, promoted bool) *environment.EnvironmentInfo {
+		return &environment.EnvironmentInfo{ID: id, State: &environment.State{Promoted: promoted}}
+	}
+
+	assert.Nil(t, promotedEnvironment([]*environment.EnvironmentInfo{env("a", false), env("b", false)}))
+
+	promoted := promotedEnvironment([]*environment.EnvironmentInfo{env("a", false), env("b", true)})
+	require.NotNil(t, promoted)
+	assert.Equal(t, "b", promoted.ID)
+
+	assert.Nil(t, promotedEnvironment([]*environment.EnvironmentInfo{env("a", true), env("b", true)}), "ambiguous")
+}
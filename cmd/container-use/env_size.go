@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var envSizeCmd = &cobra.Command{
+	Use:   "size [<env>...]",
+	Short: "Show the disk footprint of environments",
+	Long: `Show how much disk each environment uses, and the total.
+The footprint of an environment is its worktree (checkout and git metadata)
+plus its state. Git objects shared between environments and the Dagger
+cache are not included.
+
+Without arguments, all environments are reported.`,
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Size of every environment
+container-use env size
+
+# Size of specific environments
+container-use env size fancy-mallard clever-otter`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envIDs := args
+		if len(envIDs) == 0 {
+			envInfos, err := repo.List(ctx)
+			if err != nil {
+				return err
+			}
+			for _, envInfo := range envInfos {
+				envIDs = append(envIDs, envInfo.ID)
+			}
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tWORKTREE\tSTATE\tTOTAL")
+
+		var total int64
+		for _, envID := range envIDs {
+			size, err := repo.Size(ctx, envID)
+			if err != nil {
+				return fmt.Errorf("failed to compute size of environment '%s': %w", envID, err)
+			}
+			total += size.Total()
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envID, formatBytes(size.Worktree), formatBytes(size.State), formatBytes(size.Total()))
+		}
+		fmt.Fprintf(tw, "TOTAL\t\t\t%s\n", formatBytes(total))
+		return nil
+	},
+}
+
+func formatBytes(size int64) string {
+	return humanize.Bytes(uint64(size))
+}
+
+func init() {
+	envCmd.AddCommand(envSizeCmd)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var envTouchCmd = &cobra.Command{
+	Use:   "touch [<env>...]",
+	Short: "Protect environments from being pruned",
+	Long: `Mark environments as updated now, so that they aren't pruned for being
+stale while you are reviewing them. Their branch is left untouched.
+
+Use --pin to exempt environments from pruning entirely, however old they
+get, and --unpin to make them prunable again.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Reset the age of an environment
+container-use env touch fancy-mallard
+
+# Never prune an environment
+container-use env touch --pin fancy-mallard
+
+# Let it be pruned again
+container-use env touch --unpin fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		pin, _ := app.Flags().GetBool("pin")
+		unpin, _ := app.Flags().GetBool("unpin")
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envIDs := args
+		if len(envIDs) == 0 {
+			envID, err := resolveEnvironmentID(ctx, repo, args)
+			if err != nil {
+				return err
+			}
+			envIDs = []string{envID}
+		}
+
+		var errs []error
+		for _, envID := range envIDs {
+			if err := repo.Touch(ctx, envID); err != nil {
+				errs = append(errs, fmt.Errorf("failed to touch environment %s: %w", envID, err))
+				continue
+			}
+
+			switch {
+			case pin:
+				if err := repo.SetPinned(ctx, envID, true); err != nil {
+					errs = append(errs, fmt.Errorf("failed to pin environment %s: %w", envID, err))
+					continue
+				}
+				fmt.Printf("Environment '%s' pinned, it won't be pruned.\n", envID)
+			case unpin:
+				if err := repo.SetPinned(ctx, envID, false); err != nil {
+					errs = append(errs, fmt.Errorf("failed to unpin environment %s: %w", envID, err))
+					continue
+				}
+				fmt.Printf("Environment '%s' unpinned.\n", envID)
+			default:
+				fmt.Printf("Environment '%s' touched.\n", envID)
+			}
+		}
+		return errors.Join(errs...)
+	},
+}
+
+func init() {
+	envTouchCmd.Flags().Bool("pin", false, "Exempt the environments from pruning")
+	envTouchCmd.Flags().Bool("unpin", false, "Allow the environments to be pruned again")
+	envTouchCmd.MarkFlagsMutuallyExclusive("pin", "unpin")
+	envCmd.AddCommand(envTouchCmd)
+}
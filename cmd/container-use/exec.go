@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [<env>] -- <command...>",
+	Short: "Run a command inside an environment",
+	Long: `Run a single command in a new container within the environment, just like
+an agent would with environment_run_cmd. The command's stdout and stderr are
+printed and container-use exits with the command's exit code.
+
+Changes to the workdir are committed to the environment unless --no-commit is set.
+
+With --background, the command is started as a long running process and its
+endpoints are printed. It keeps running until container-use is interrupted.
+Add --qr to also print a QR code of each endpoint, to open it from a phone.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		dash := cmd.ArgsLenAtDash()
+		if dash == -1 || dash == len(args) {
+			return errors.New("a command is required after --")
+		}
+		if dash > 1 {
+			return errors.New("too many arguments before --")
+		}
+		return nil
+	},
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Run the tests of an environment
+container-use exec fancy-mallard -- go test ./...
+
+# Shell syntax is interpreted inside the container
+container-use exec fancy-mallard -- 'ls -la | wc -l'
+
+# Take a look without recording anything
+container-use exec --no-commit fancy-mallard -- git status
+
+# Start a server and expose its port
+container-use exec --background --port 8080 fancy-mallard -- python -m http.server 8080
+
+# Same, with a QR code to open the server from a phone
+container-use exec --background --qr --port 8080 fancy-mallard -- python -m http.server 8080`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		dash := app.ArgsLenAtDash()
+		command := strings.Join(args[dash:], " ")
+
+		shell, _ := app.Flags().GetString("shell")
+		background, _ := app.Flags().GetBool("background")
+		noCommit, _ := app.Flags().GetBool("no-commit")
+		ports, _ := app.Flags().GetIntSlice("port")
+		qr, _ := app.Flags().GetBool("qr")
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args[:dash])
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		update := func() error {
+			if noCommit {
+				return nil
+			}
+			if err := repo.Update(ctx, env, fmt.Sprintf("Run %s", command)); err != nil {
+				return fmt.Errorf("failed to update repository: %w", err)
+			}
+			return nil
+		}
+
+		if background {
+			bg, runErr := env.RunBackground(ctx, command, shell, ports, false, "")
+			// We want to update the repository even if the command failed.
+			if err := update(); err != nil {
+				return err
+			}
+			if runErr != nil {
+				return fmt.Errorf("failed to run command: %w", runErr)
+			}
+
+			if len(bg.Endpoints) > 0 {
+				out, err := json.MarshalIndent(bg.Endpoints, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Endpoints: %s\n", out)
+			}
+			if qr {
+				for _, port := range ports {
+					if endpoint, ok := bg.Endpoints[strconv.Itoa(port)]; ok {
+						printQRCode(os.Stderr, endpointURL(endpoint.HostExternal))
+					}
+				}
+			}
+			fmt.Fprintf(os.Stderr, "Command running in the background with handle %s. Press Ctrl+C to stop it.\n", bg.ID)
+			<-ctx.Done()
+			return nil
+		}
+
+		result, runErr := env.Run(ctx, environment.RunOpts{
+			Command: command,
+			Shell:   shell,
+		})
+		// We want to update the repository even if the command failed.
+		if err := update(); err != nil {
+			return err
+		}
+		if runErr != nil {
+			return fmt.Errorf("failed to run command: %w", runErr)
+		}
+
+		fmt.Fprint(os.Stdout, result.Stdout)
+		fmt.Fprint(os.Stderr, result.Stderr)
+		if msg := result.KillMessage(); msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+
+		if result.ExitCode != 0 {
+			// os.Exit skips deferred calls
+			dag.Close()
+			os.Exit(result.ExitCode)
+		}
+		return nil
+	},
+}
+
+func init() {
+	execCmd.Flags().String("shell", "sh", "Shell interpreting the command")
+	execCmd.Flags().Bool("background", false, "Run the command in the background until interrupted")
+	execCmd.Flags().Bool("no-commit", false, "Don't commit changes made by the command to the environment")
+	execCmd.Flags().IntSlice("port", nil, "Port to expose when running in the background (can be repeated)")
+	execCmd.Flags().Bool("qr", false, "Print a QR code of each exposed endpoint when running in the background")
+	rootCmd.AddCommand(execCmd)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"os"
+	"slices"
 	"text/tabwriter"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
-	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
@@ -14,6 +16,7 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all environments",
 	Long: `Display all active environments with their IDs, titles, and timestamps.
+Promoted environments (see "container-use env promote") are listed first, marked with ★.
 Use -q for environment IDs only, useful for scripting.`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
@@ -25,6 +28,12 @@ Use -q for environment IDs only, useful for scripting.`,
 		if err != nil {
 			return err
 		}
+		envInfos = promotedFirst(envInfos)
+		if promoted, _ := app.Flags().GetBool("promoted"); promoted {
+			envInfos = slices.DeleteFunc(envInfos, func(env *environment.EnvironmentInfo) bool {
+				return !env.State.Promoted
+			})
+		}
 		if quiet, _ := app.Flags().GetBool("quiet"); quiet {
 			for _, envInfo := range envInfos {
 				fmt.Println(envInfo.ID)
@@ -32,29 +41,90 @@ Use -q for environment IDs only, useful for scripting.`,
 			return nil
 		}
 
+		if bySize, _ := app.Flags().GetBool("by-size"); bySize {
+			return listBySize(app, repo, envInfos)
+		}
+
 		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED")
 
 		defer tw.Flush()
 		for _, envInfo := range envInfos {
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), humanize.Time(envInfo.State.CreatedAt), humanize.Time(envInfo.State.UpdatedAt))
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, listTitle(app, envInfo), formatTime(envInfo.State.CreatedAt), formatTime(envInfo.State.UpdatedAt))
 		}
 		return nil
 	},
 }
 
+// promotedMarker prefixes the title of promoted environments.
+const promotedMarker = "★ "
+
+// lockedMarker prefixes the title of locked environments.
+const lockedMarker = "[locked] "
+
+// promotedFirst moves promoted environments to the front, keeping the order otherwise.
+func promotedFirst(envInfos []*environment.EnvironmentInfo) []*environment.EnvironmentInfo {
+	envInfos = slices.Clone(envInfos)
+	slices.SortStableFunc(envInfos, func(a, b *environment.EnvironmentInfo) int {
+		switch {
+		case a.State.Promoted == b.State.Promoted:
+			return 0
+		case a.State.Promoted:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return envInfos
+}
+
+// listTitle is the title of an environment as listed, marking promoted and locked environments.
+func listTitle(app *cobra.Command, envInfo *environment.EnvironmentInfo) string {
+	marker := ""
+	if envInfo.State.Promoted {
+		marker += promotedMarker
+	}
+	if envInfo.State.Locked {
+		marker += lockedMarker
+	}
+	return marker + truncate(app, envInfo.State.Title, 40-len([]rune(marker)))
+}
+
+// listBySize lists environments from the largest to the smallest, along with their disk footprint.
+func listBySize(app *cobra.Command, repo *repository.Repository, envInfos []*environment.EnvironmentInfo) error {
+	sizes := make(map[string]int64, len(envInfos))
+	for _, envInfo := range envInfos {
+		size, err := repo.Size(app.Context(), envInfo.ID)
+		if err != nil {
+			return fmt.Errorf("failed to compute size of environment '%s': %w", envInfo.ID, err)
+		}
+		sizes[envInfo.ID] = size.Total()
+	}
+	envInfos = slices.Clone(envInfos)
+	slices.SortStableFunc(envInfos, func(a, b *environment.EnvironmentInfo) int {
+		return cmp.Compare(sizes[b.ID], sizes[a.ID])
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "ID\tTITLE\tSIZE\tUPDATED")
+	for _, envInfo := range envInfos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, listTitle(app, envInfo), formatBytes(sizes[envInfo.ID]), formatTime(envInfo.State.UpdatedAt))
+	}
+	return nil
+}
+
 func truncate(app *cobra.Command, s string, max int) string {
 	if noTrunc, _ := app.Flags().GetBool("no-trunc"); noTrunc {
 		return s
 	}
-	if len(s) > max {
-		return s[:max] + "…"
-	}
-	return s
+	return truncateWidth(s, max, truncationIndicator)
 }
 
 func init() {
 	listCmd.Flags().BoolP("quiet", "q", false, "Display only environment IDs")
 	listCmd.Flags().BoolP("no-trunc", "", false, "Don't truncate output")
+	listCmd.Flags().Bool("promoted", false, "Only list promoted environments")
+	listCmd.Flags().Bool("by-size", false, "Sort environments by disk footprint, largest first")
 	rootCmd.AddCommand(listCmd)
 }
@@ -12,7 +12,8 @@ var logCmd = &cobra.Command{
 	Short: "View what an agent did step-by-step",
 	Long: `Display the complete development history for an environment.
 Shows all commits made by the agent plus command execution notes.
-Use -p to include code patches in the output.
+Use -p to include code patches in the output, and --notes to include the
+tool call metadata (tool, arguments, duration) recorded for each commit.
 
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
@@ -24,6 +25,9 @@ container-use log fancy-mallard
 # Include code changes
 container-use log fancy-mallard -p
 
+# Include the tool call behind each commit
+container-use log fancy-mallard --notes
+
 # Auto-select environment
 container-use log`,
 	RunE: func(app *cobra.Command, args []string) error {
@@ -41,12 +45,14 @@ container-use log`,
 		}
 
 		patch, _ := app.Flags().GetBool("patch")
+		notes, _ := app.Flags().GetBool("notes")
 
-		return repo.Log(ctx, envID, patch, os.Stdout)
+		return repo.LogWithOpts(ctx, envID, repository.LogOpts{Patch: patch, Metadata: notes, DateFormat: gitDateFormat()}, os.Stdout)
 	},
 }
 
 func init() {
 	logCmd.Flags().BoolP("patch", "p", false, "Generate patch")
+	logCmd.Flags().Bool("notes", false, "Include the tool call metadata of each commit")
 	rootCmd.AddCommand(logCmd)
 }
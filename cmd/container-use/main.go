@@ -8,7 +8,7 @@ import (
 
 	"github.com/charmbracelet/fang"
 	"github.com/dagger/container-use/repository"
-	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-runewidth"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
@@ -61,6 +61,15 @@ func getTerminalWidth() int {
 	return width
 }
 
+// truncationIndicator is appended to text shortened by truncateWidth.
+const truncationIndicator = "…"
+
+// truncateWidth shortens s so that it occupies at most width terminal columns, indicator included.
+// It cuts on rune boundaries and accounts for wide characters so multibyte text is never corrupted.
+func truncateWidth(s string, width int, indicator string) string {
+	return runewidth.Truncate(s, width, indicator)
+}
+
 // calculateMaxTitleLength calculates the maximum length for title truncation
 // based on terminal width, leaving room for environment ID, description format, and padding
 func calculateMaxTitleLength(terminalWidth int) int {
@@ -120,11 +129,8 @@ func suggestEnvironments(cmd *cobra.Command, args []string, toComplete string) (
 
 	completions := make([]string, len(envs))
 	for i, env := range envs {
-		title := env.State.Title
-		if len(title) > maxTitleLength {
-			title = title[:maxTitleLength] + "…"
-		}
-		description := fmt.Sprintf("%s (updated %s)", title, humanize.Time(env.State.UpdatedAt))
+		title := truncateWidth(env.State.Title, maxTitleLength, truncationIndicator)
+		description := fmt.Sprintf("%s (updated %s)", title, formatTime(env.State.UpdatedAt))
 		completions[i] = cobra.CompletionWithDesc(env.ID, description)
 	}
 
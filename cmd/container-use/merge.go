@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
 
 var (
-	mergeDelete bool
+	mergeDelete           bool
+	mergeDeleteAfterMerge bool
+	mergeCheck            bool
+	mergeJSON             bool
+	mergeNoFF             bool
+	mergeSquash           bool
+	mergeMessage          string
 )
 
 var mergeCmd = &cobra.Command{
@@ -20,6 +29,15 @@ var mergeCmd = &cobra.Command{
 This makes the agent's work permanent in your repository.
 Your working directory will be automatically stashed and restored.
 
+A merge commit is always created, unless --no-ff=false lets git fast-forward
+the branch. Use --squash to commit the environment's changes as a single
+commit instead. If the environment conflicts with your branch, nothing is
+merged: the conflicting files are listed and the command fails.
+
+With --delete (or --delete-after-merge), the environment is deleted once
+merged cleanly. Run "container-use config delete-after-merge enable" to make
+it the default, and --delete=false to keep an environment anyway.
+
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
 	Args:              cobra.MaximumNArgs(1),
@@ -31,11 +49,21 @@ container-use merge backend-api
 container-use merge -d backend-api
 container-use merge --delete backend-api
 
+# Check whether the merge would conflict, without merging
+container-use merge --check backend-api
+
+# Merge as a single commit with your own message
+container-use merge --squash -m "Add backend API" backend-api
+
 # Auto-select environment
 container-use merge`,
 	RunE: func(app *cobra.Command, args []string) error {
 		ctx := app.Context()
 
+		if mergeJSON && !mergeCheck {
+			return fmt.Errorf("--json can only be used with --check")
+		}
+
 		// Ensure we're in a git repository
 		repo, err := repository.Open(ctx, ".")
 		if err != nil {
@@ -47,14 +75,81 @@ container-use merge`,
 			return err
 		}
 
-		if err := repo.Merge(ctx, envID, os.Stdout); err != nil {
+		if mergeCheck {
+			return checkMerge(ctx, repo, envID, mergeJSON)
+		}
+
+		if mergeSquash && app.Flags().Changed("no-ff") {
+			return fmt.Errorf("--squash can't be used with --no-ff")
+		}
+		opts := repository.MergeOpts{
+			FastForward: !mergeNoFF,
+			Squash:      mergeSquash,
+			Message:     mergeMessage,
+		}
+		if err := repo.Merge(ctx, envID, opts, os.Stdout); err != nil {
+			var conflictErr *repository.MergeConflictError
+			if errors.As(err, &conflictErr) {
+				fmt.Printf("Environment '%s' conflicts with the current branch in %d file(s), nothing was merged:\n", envID, len(conflictErr.Conflicts))
+				printConflicts(conflictErr.Conflicts)
+				return fmt.Errorf("environment '%s' has merge conflicts", envID)
+			}
 			return fmt.Errorf("failed to merge environment: %w", err)
 		}
 
-		return deleteAfterMerge(ctx, repo, envID, mergeDelete, "merged")
+		var deleteEnv bool
+		switch {
+		case app.Flags().Changed("delete"):
+			deleteEnv = mergeDelete
+		case app.Flags().Changed("delete-after-merge"):
+			deleteEnv = mergeDeleteAfterMerge
+		default:
+			config := environment.DefaultConfig()
+			if err := config.Load(repo.SourcePath()); err != nil {
+				return fmt.Errorf("environment '%s' merged but failed to load configuration: %w", envID, err)
+			}
+			deleteEnv = config.DeleteAfterMerge
+		}
+		return deleteAfterMerge(ctx, repo, envID, deleteEnv, "merged")
 	},
 }
 
+// checkMerge reports whether the environment merges cleanly into the current branch.
+// Conflicts are reported as an error so that scripts can rely on the exit code.
+func checkMerge(ctx context.Context, repo *repository.Repository, envID string, asJSON bool) error {
+	check, err := repo.CheckMerge(ctx, envID)
+	if err != nil {
+		return fmt.Errorf("failed to check merge: %w", err)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(check); err != nil {
+			return err
+		}
+	} else if check.Mergeable {
+		fmt.Printf("Environment '%s' can be merged without conflicts.\n", envID)
+	} else {
+		fmt.Printf("Environment '%s' conflicts with the current branch in %d file(s):\n", envID, len(check.Conflicts))
+		printConflicts(check.Conflicts)
+	}
+
+	if !check.Mergeable {
+		return fmt.Errorf("environment '%s' has merge conflicts", envID)
+	}
+	return nil
+}
+
+func printConflicts(conflicts []repository.MergeConflict) {
+	for _, conflict := range conflicts {
+		fmt.Printf("  %s\n", conflict.Path)
+		for _, message := range conflict.Messages {
+			fmt.Printf("    %s\n", message)
+		}
+	}
+}
+
 func deleteAfterMerge(ctx context.Context, repo *repository.Repository, env string, delete bool, verb string) error {
 	if !delete {
 		fmt.Printf("Environment '%s' %s successfully.\n", env, verb)
@@ -68,7 +163,16 @@ func deleteAfterMerge(ctx context.Context, repo *repository.Repository, env stri
 }
 
 func init() {
-	mergeCmd.Flags().BoolVarP(&mergeDelete, "delete", "d", false, "Delete the environment after successful merge")
+	mergeCmd.Flags().BoolVarP(&mergeDelete, "delete", "d", false, "Delete the environment after successful merge (default: see config delete-after-merge)")
+	mergeCmd.Flags().BoolVar(&mergeDeleteAfterMerge, "delete-after-merge", false, "Same as --delete")
+	mergeCmd.Flags().BoolVar(&mergeCheck, "check", false, "Report whether the merge would conflict, without merging")
+	mergeCmd.Flags().BoolVar(&mergeJSON, "json", false, "With --check, print the result as JSON")
+	mergeCmd.Flags().BoolVar(&mergeNoFF, "no-ff", true, "Always create a merge commit, --no-ff=false allows fast-forwards")
+	mergeCmd.Flags().BoolVar(&mergeSquash, "squash", false, "Commit the changes as a single commit, without the environment's history")
+	mergeCmd.Flags().StringVarP(&mergeMessage, "message", "m", "", "Message of the merge commit (default \"Merge environment <env>\")")
+	mergeCmd.MarkFlagsMutuallyExclusive("check", "delete")
+	mergeCmd.MarkFlagsMutuallyExclusive("check", "delete-after-merge")
+	mergeCmd.MarkFlagsMutuallyExclusive("delete", "delete-after-merge")
 
 	rootCmd.AddCommand(mergeCmd)
 }
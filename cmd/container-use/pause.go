@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause [<env>]",
+	Short: "Stop an environment's services without deleting it",
+	Long: `Pause an environment to release the resources held by its services.
+The environment's branch and committed files are preserved. A paused
+environment can't be used by agents until it is resumed.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Park an environment
+container-use pause fancy-mallard
+
+# Auto-select environment
+container-use pause`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.Pause(ctx, envID); err != nil {
+			return fmt.Errorf("failed to pause environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' paused. Run `container-use resume %s` to use it again.\n", envID, envID)
+		return nil
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [<env>]",
+	Short: "Restart a paused environment",
+	Long: `Resume a paused environment. The container is rebuilt from the latest
+commit of the environment's branch and its services are restarted
+according to its configuration.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Pick up where you left off
+container-use resume fancy-mallard
+
+# Auto-select environment
+container-use resume`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		if _, err := repo.Resume(ctx, dag, envID); err != nil {
+			return fmt.Errorf("failed to resume environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' resumed.\n", envID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
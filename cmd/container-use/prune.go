@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
+	"github.com/charmbracelet/huh"
+	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/karrick/tparse"
 	"github.com/spf13/cobra"
@@ -17,7 +22,11 @@ This permanently removes old environments and their associated resources includi
 branches and container state. By default, environments older than 1 week are pruned.
 
 Use --dry-run to see what would be deleted without actually deleting anything.
-Use --before to configure the age threshold (e.g., 24h, 3d, 2w, 1mo).`,
+Use --before to configure the age threshold (e.g., 24h, 3d, 2w, 1mo).
+Use --orphaned to instead delete environments, of any repository, whose source
+repository no longer exists. It can be run from anywhere.
+Use --interactive to pick the environments to delete from a list showing their
+title, age and size.`,
 	Example: `# Prune environments older than 1 week (default)
 container-use prune
 
@@ -28,45 +37,53 @@ container-use prune --before 3d
 container-use prune --dry-run
 
 # Prune environments older than 2 weeks
-container-use prune --before 2w`,
+container-use prune --before 2w
+
+# Clean up environments of deleted projects
+container-use prune --orphaned
+
+# Choose which environments to delete
+container-use prune --interactive`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 		before, _ := cmd.Flags().GetString("before")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 
+		if orphaned, _ := cmd.Flags().GetBool("orphaned"); orphaned {
+			return pruneOrphaned(ctx, dryRun)
+		}
+
 		repo, err := repository.Open(ctx, ".")
 		if err != nil {
 			return fmt.Errorf("failed to open repository: %w", err)
 		}
 
-		var duration time.Duration
-		if before == "" {
-			duration = 7 * 24 * time.Hour
-		} else {
-			targetTime, err := tparse.ParseNow(time.RFC3339, "now-"+before)
+		if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
+			envs, err := repo.List(ctx)
 			if err != nil {
-				return fmt.Errorf("invalid --before format: %w", err)
+				return fmt.Errorf("failed to list environments: %w", err)
+			}
+			if len(envs) == 0 {
+				fmt.Println("No environments found.")
+				return nil
 			}
-			duration = time.Since(targetTime)
+			return pruneInteractive(ctx, repo, envs, dryRun)
 		}
 
-		envs, err := repo.List(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list environments: %w", err)
+		duration := 7 * 24 * time.Hour
+		if before != "" {
+			if duration, err = parseAge(before); err != nil {
+				return fmt.Errorf("invalid --before format: %w", err)
+			}
 		}
 
-		if len(envs) == 0 {
-			fmt.Println("No environments found.")
-			return nil
+		stale, err := repo.ListStale(ctx, duration)
+		if err != nil {
+			return err
 		}
-
-		cutoff := time.Now().Add(-duration)
 		var envsToPrune []string
-
-		for _, env := range envs {
-			if env.State.UpdatedAt.Before(cutoff) {
-				envsToPrune = append(envsToPrune, env.ID)
-			}
+		for _, env := range stale {
+			envsToPrune = append(envsToPrune, env.ID)
 		}
 
 		if len(envsToPrune) == 0 {
@@ -83,24 +100,145 @@ container-use prune --before 2w`,
 		}
 
 		fmt.Printf("Pruning %d environment(s) older than %s...\n", len(envsToPrune), duration)
+		return deleteEnvironments(ctx, os.Stdout, envsToPrune, func(ctx context.Context, i int) error {
+			return repo.Delete(ctx, envsToPrune[i])
+		})
+	},
+}
 
-		var deletedCount int
-		for _, envID := range envsToPrune {
-			if err := repo.Delete(ctx, envID); err != nil {
-				fmt.Printf("Failed to delete environment '%s': %v\n", envID, err)
-			} else {
-				fmt.Printf("Environment '%s' deleted successfully.\n", envID)
-				deletedCount++
-			}
+// parseAge parses an age such as 24h, 3d, 2w or 1mo.
+func parseAge(age string) (time.Duration, error) {
+	targetTime, err := tparse.ParseNow(time.RFC3339, "now-"+age)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(targetTime), nil
+}
+
+// pruneFailure is an environment prune failed to delete.
+type pruneFailure struct {
+	ID  string
+	Err error
+}
+
+// deleteEnvironments deletes the given environments, del deleting the i-th one, reporting progress as it goes and
+// failures without stopping. It ends with a summary of the failures, and returns an error if any.
+func deleteEnvironments(ctx context.Context, w io.Writer, envIDs []string, del func(ctx context.Context, i int) error) error {
+	var failures []pruneFailure
+	for i, envID := range envIDs {
+		fmt.Fprintf(w, "[%d/%d] Deleting environment '%s'... ", i+1, len(envIDs), envID)
+		if err := del(ctx, i); err != nil {
+			fmt.Fprintln(w, "failed")
+			failures = append(failures, pruneFailure{ID: envID, Err: err})
+			continue
 		}
+		fmt.Fprintln(w, "done")
+	}
 
-		fmt.Printf("Successfully deleted %d environment(s).\n", deletedCount)
+	fmt.Fprintf(w, "\nDeleted %d of %d environment(s).\n", len(envIDs)-len(failures), len(envIDs))
+	if len(failures) == 0 {
 		return nil
-	},
+	}
+	fmt.Fprintf(w, "Failed to delete %d environment(s):\n", len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(w, "  - %s: %v\n", failure.ID, failure.Err)
+	}
+	return fmt.Errorf("failed to delete %d of %d environment(s)", len(failures), len(envIDs))
+}
+
+// pruneInteractive lets the user pick the environments to delete.
+func pruneInteractive(ctx context.Context, repo *repository.Repository, envs []*environment.EnvironmentInfo, dryRun bool) error {
+	var options []huh.Option[string]
+	for _, env := range envs {
+		title := env.State.Title
+		if title == "" {
+			title = "No description"
+		}
+
+		size := "size unknown"
+		if envSize, err := repo.Size(ctx, env.ID); err == nil {
+			size = formatBytes(envSize.Total())
+		}
+
+		label := fmt.Sprintf("%s - %s (updated %s, %s)", env.ID, truncateWidth(title, 40, truncationIndicator), formatTime(env.State.UpdatedAt), size)
+		options = append(options, huh.NewOption(label, env.ID))
+	}
+
+	var selected []string
+	prompt := huh.NewMultiSelect[string]().
+		Title("Select the environments to delete:").
+		Options(options...).
+		Value(&selected)
+	if err := prompt.Run(); err != nil {
+		return err
+	}
+
+	if len(selected) == 0 {
+		fmt.Println("No environments selected.")
+		return nil
+	}
+
+	if dryRun {
+		fmt.Printf("Would prune %d environment(s):\n", len(selected))
+		for _, envID := range selected {
+			fmt.Printf("  - %s\n", envID)
+		}
+		return nil
+	}
+
+	confirmed := false
+	confirm := huh.NewConfirm().
+		Title(fmt.Sprintf("Delete %d environment(s)? This cannot be undone.", len(selected))).
+		Value(&confirmed)
+	if err := confirm.Run(); err != nil {
+		return err
+	}
+	if !confirmed {
+		fmt.Println("Prune cancelled.")
+		return nil
+	}
+
+	fmt.Printf("Pruning %d environment(s)...\n", len(selected))
+	return deleteEnvironments(ctx, os.Stdout, selected, func(ctx context.Context, i int) error {
+		return repo.Delete(ctx, selected[i])
+	})
+}
+
+func pruneOrphaned(ctx context.Context, dryRun bool) error {
+	envs, err := repository.ListOrphaned(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list orphaned environments: %w", err)
+	}
+
+	if len(envs) == 0 {
+		fmt.Println("No orphaned environments found.")
+		return nil
+	}
+
+	if dryRun {
+		fmt.Printf("Would prune %d orphaned environment(s):\n", len(envs))
+		for _, env := range envs {
+			fmt.Printf("  - %s (source %s no longer exists)\n", env.ID, env.SourcePath)
+		}
+		return nil
+	}
+
+	fmt.Printf("Pruning %d orphaned environment(s)...\n", len(envs))
+	envIDs := []string{}
+	for _, env := range envs {
+		envIDs = append(envIDs, env.ID)
+	}
+	return deleteEnvironments(ctx, os.Stdout, envIDs, func(ctx context.Context, i int) error {
+		return envs[i].Delete(ctx)
+	})
 }
 
 func init() {
 	rootCmd.AddCommand(pruneCmd)
 	pruneCmd.Flags().String("before", "1w", "Delete environments older than this duration (e.g., 24h, 3d, 2w, 1mo)")
 	pruneCmd.Flags().Bool("dry-run", false, "Show what would be pruned without actually deleting")
+	pruneCmd.Flags().Bool("orphaned", false, "Delete environments whose source repository no longer exists")
+	pruneCmd.Flags().BoolP("interactive", "i", false, "Pick the environments to delete from a list")
+	pruneCmd.MarkFlagsMutuallyExclusive("interactive", "orphaned")
+	pruneCmd.MarkFlagsMutuallyExclusive("interactive", "before")
 }
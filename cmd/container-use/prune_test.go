@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteEnvironments(t *testing.T) {
+	envIDs := []string{"fancy-mallard", "clever-otter", "brave-lynx"}
+	deleted := []string{}
+	del := func(_ context.Context, i int) error {
+		if envIDs[i] == "clever-otter" {
+			return errors.New("worktree is locked")
+		}
+		deleted = append(deleted, envIDs[i])
+		return nil
+	}
+
+	var out bytes.Buffer
+	err := deleteEnvironments(t.Context(), &out, envIDs, del)
+	assert.EqualError(t, err, "failed to delete 1 of 3 environment(s)")
+	assert.Equal(t, []string{"fancy-mallard", "brave-lynx"}, deleted, "failures don't stop the prune")
+	assert.Equal(t, `[1/3] Deleting environment 'fancy-mallard'... done
+[2/3] Deleting environment 'clever-otter'... failed
+[3/3] Deleting environment 'brave-lynx'... done
+
+Deleted 2 of 3 environment(s).
+Failed to delete 1 environment(s):
+  - clever-otter: worktree is locked
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, deleteEnvironments(t.Context(), &out, envIDs[:1], func(context.Context, int) error { return nil }))
+	assert.Equal(t, "[1/1] Deleting environment 'fancy-mallard'... done\n\nDeleted 1 of 1 environment(s).\n", out.String())
+}
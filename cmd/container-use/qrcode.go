@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/term"
+)
+
+// endpointURL turns a host_external endpoint (e.g. tcp://127.0.0.1:1234) into a URL a browser can open.
+func endpointURL(hostExternal string) string {
+	u, err := url.Parse(hostExternal)
+	if err != nil || u.Host == "" {
+		return "http://" + strings.TrimPrefix(hostExternal, "//")
+	}
+	if u.Scheme == "tcp" || u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	return u.String()
+}
+
+// printQRCode renders content as a QR code on w, or explains why it can't when the terminal is too narrow.
+func printQRCode(w io.Writer, content string) {
+	code, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		fmt.Fprintf(w, "Unable to generate QR code for %s: %s\n", content, err)
+		return
+	}
+
+	// Each module takes one column, and two rows share a line.
+	size := len(code.Bitmap())
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil {
+		// Not a terminal, the code would be garbage
+		return
+	}
+	if width < size {
+		fmt.Fprintf(w, "Terminal too narrow to display the QR code for %s (%d columns needed).\n", content, size)
+		return
+	}
+
+	fmt.Fprintf(w, "Scan to open %s:\n%s", content, code.ToSmallString(false))
+}
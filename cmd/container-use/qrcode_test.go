@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+	}{
+		{"tcp://127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{"http://localhost:3000", "http://localhost:3000"},
+		{"https://example.com", "https://example.com"},
+		{"localhost:5173", "http://localhost:5173"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			assert.Equal(t, tt.expected, endpointURL(tt.endpoint))
+		})
+	}
+}
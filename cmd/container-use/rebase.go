@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase [<env>]",
+	Short: "Replay an environment's work onto an updated branch",
+	Long: `Replay the commits of an environment on top of another revision, by default
+your current HEAD, e.g. once main moved forward since the environment was
+created. The environment's container gets the files of the new base, so the
+agent keeps working from it, and merging the environment later only brings
+its own changes.
+
+If the environment's commits conflict with the new base, the rebase is
+aborted: the conflicting files are listed and the environment is left as it
+was.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Catch up with main
+container-use rebase fancy-mallard --onto main`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		onto, _ := app.Flags().GetString("onto")
+		commits, err := repo.Rebase(ctx, dag, envID, onto)
+		if err != nil {
+			var conflictErr *repository.RebaseConflictError
+			if errors.As(err, &conflictErr) {
+				fmt.Printf("Environment '%s' conflicts with %s in %d file(s), the rebase was aborted:\n", envID, onto, len(conflictErr.Files))
+				for _, file := range conflictErr.Files {
+					fmt.Printf("  %s\n", file)
+				}
+				return fmt.Errorf("environment '%s' has rebase conflicts", envID)
+			}
+			return fmt.Errorf("failed to rebase environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' rebased onto %s, %d commit(s) replayed:\n", envID, onto, len(commits))
+		for _, commit := range commits {
+			fmt.Printf("  %s %s\n", commit.SHA[:min(7, len(commit.SHA))], commit.Subject)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rebaseCmd.Flags().String("onto", "HEAD", "Revision to replay the environment onto")
+	rootCmd.AddCommand(rebaseCmd)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var renameCmd = &cobra.Command{
+	Use:   "rename <env> <new-name>",
+	Short: "Give an environment a new name",
+	Long: `Rename an environment, e.g. to replace its generated name with a meaningful
+one. Its branch becomes container-use/<new-name> and every command takes the
+new name from then on. Names are made of lowercase letters and digits, with
+words separated by dashes, and must not be used by another environment.
+
+Agents still working in the environment under its old name must open it again
+under the new one. Branches created by 'container-use checkout' keep tracking
+the old name: check the environment out again.`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(app *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return suggestEnvironments(app, args, toComplete)
+	},
+	Example: `# Replace a generated name
+container-use rename fancy-mallard login-form`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		oldID, newID := args[0], args[1]
+		if err := repo.Rename(ctx, oldID, newID); err != nil {
+			return fmt.Errorf("failed to rename environment: %w", err)
+		}
+		fmt.Printf("Environment '%s' renamed to '%s'.\n", oldID, newID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+}
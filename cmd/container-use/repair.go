@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair [<env>]",
+	Short: "Recreate an environment's worktree from its branch",
+	Long: `Recreate the local worktree of an environment from its container-use/<env>
+branch. Use this when the worktree was left in a bad state, e.g. by an
+interrupted git operation. The branch holds the environment's history and state,
+so nothing is lost.
+
+Corrupted worktrees are also detected and recreated automatically when the
+environment is accessed.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Repair an environment
+container-use repair fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.RepairWorktree(ctx, envID); err != nil {
+			return fmt.Errorf("failed to repair environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' repaired.\n", envID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/dagger/container-use/mcpserver"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start MCP server over HTTP",
+	Long: `Start the Model Context Protocol server over streamable HTTP, for agents that can't spawn container-use themselves.
+
+The MCP endpoint is served at /mcp. /healthz reports that the server is alive and
+/readyz reports whether the Dagger engine is reachable, returning 503 otherwise,
+for use as liveness and readiness probes.`,
+	Example: `# Serve on the default address
+container-use serve
+
+# Inspection-only server on a custom port
+container-use serve --addr :9000 --read-only`,
+	Args: cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		addr, _ := app.Flags().GetString("addr")
+		opts, err := serverOptions(app)
+		if err != nil {
+			return err
+		}
+
+		dag := connectServerDagger(ctx)
+		defer dag.Close()
+
+		return mcpserver.RunHTTPServer(ctx, dag, addr, opts)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("addr", "localhost:8080", "Address to listen on")
+	addServerFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+}
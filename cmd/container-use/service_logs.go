@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+// serviceLogsPollInterval is how often service-logs --follow reads the output of the service.
+const serviceLogsPollInterval = 2 * time.Second
+
+var serviceLogsCmd = &cobra.Command{
+	Use:   "service-logs <env> <service>",
+	Short: "Show the output of a service of an environment",
+	Long: `Show the output (stdout and stderr) of a service of an environment, e.g.
+to find out why it fails to start. Only the output of services started
+with a command is captured. With --follow, new output is printed as it
+comes until interrupted.`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(app *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return suggestEnvironments(app, args, toComplete)
+	},
+	Example: `# Show the last 100 lines of output of a service
+container-use service-logs fancy-mallard postgres
+
+# Show all of it, then follow new output
+container-use service-logs fancy-mallard postgres --tail 0 --follow`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		tail, _ := app.Flags().GetInt("tail")
+		if tail < 0 {
+			return fmt.Errorf("--tail must not be negative")
+		}
+		follow, _ := app.Flags().GetBool("follow")
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		envID, name := args[0], args[1]
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		logs, err := env.ServiceLogs(ctx, name, tail)
+		if err != nil {
+			return err
+		}
+		fmt.Print(logs)
+		if !follow {
+			return nil
+		}
+		return followServiceLogs(ctx, env, name)
+	},
+}
+
+// followServiceLogs prints the output of the service as it comes, until the context is done. The whole output is
+// read on each poll: the service's output is in a cache volume, which can only be read by running a container.
+func followServiceLogs(ctx context.Context, env *environment.Environment, name string) error {
+	printed, err := env.ServiceLogs(ctx, name, 0)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(serviceLogsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		logs, err := env.ServiceLogs(ctx, name, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !strings.HasPrefix(logs, printed) {
+			// The service was restarted, which starts its output afresh
+			fmt.Print(logs)
+		} else {
+			fmt.Print(logs[len(printed):])
+		}
+		printed = logs
+	}
+}
+
+func init() {
+	serviceLogsCmd.Flags().Int("tail", 100, "Number of lines to show from the end of the output, 0 for all of it")
+	serviceLogsCmd.Flags().BoolP("follow", "f", false, "Print new output as it comes")
+	rootCmd.AddCommand(serviceLogsCmd)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var serviceRmCmd = &cobra.Command{
+	Use:   "service-rm <env> <service>",
+	Short: "Remove a service from an environment",
+	Long: `Stop a service of an environment and remove it from its configuration,
+releasing the ports it exposes on your machine. Unlike stop, the service is
+not started again when the environment is rebuilt or resumed.
+
+The environment is rebuilt from its files for the service's name to stop
+resolving in the environment: changes to the container outside of the files
+are discarded. Its other services keep running in the MCP server.
+Removing a service the environment doesn't have does nothing.`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(app *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return suggestEnvironments(app, args, toComplete)
+	},
+	Example: `# Remove a service
+container-use service-rm fancy-mallard postgres`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		envID, name := args[0], args[1]
+		if err := repo.RemoveService(ctx, dag, envID, name, "Remove service "+name); err != nil {
+			if errors.Is(err, environment.ErrServiceNotFound) {
+				fmt.Printf("Nothing to remove: %s.\n", err)
+				return nil
+			}
+			return fmt.Errorf("failed to remove service %s: %w", name, err)
+		}
+		fmt.Printf("Removed service %s from environment '%s'.\n", name, envID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceRmCmd)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var servicesCmd = &cobra.Command{
+	Use:   "services [<env>]",
+	Short: "List the running services of an environment",
+	Long: `List the running services of an environment with their image and, for
+each port, the address environments reach it at and the address to reach
+it from your machine.
+
+Services run in the agent's MCP server: they are reported with the
+endpoints they had when started, unless they were stopped since.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# List the services of an environment
+container-use services fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		services, err := env.ListServices(ctx)
+		if err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			fmt.Printf("No service running in environment '%s'\n", envID)
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "NAME\tIMAGE\tPORT\tCONTAINER INTERNAL\tHOST EXTERNAL")
+		for _, service := range services {
+			if len(service.Endpoints) == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\n", service.Config.Name, service.Config.Image)
+				continue
+			}
+			for _, port := range service.Config.ExposedPorts {
+				endpoint, ok := service.Endpoints[port.String()]
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", service.Config.Name, service.Config.Image, port, endpoint.EnvironmentInternal, endpoint.HostExternal)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(servicesCmd)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var shellCmd = &cobra.Command{
+	Use:   "shell [<env>]",
+	Short: "Open an interactive shell in an environment's container",
+	Long: `Open an interactive shell in a container of an environment, in its working
+directory and with the variables of its configuration, e.g. to poke around
+its files faster than through the agent's tools. The shell is sh unless
+--shell is set.
+
+Changes made in the shell are NOT committed to the environment: like
+background commands, the shell runs in a copy of the container, discarded
+when it exits.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Open sh in an environment
+container-use shell fancy-mallard
+
+# Open bash instead
+container-use shell fancy-mallard --shell bash`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		if wrapped, err := wrapInDaggerRun(); wrapped || err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		shell, _ := app.Flags().GetString("shell")
+		if shell == "" {
+			return fmt.Errorf("--shell can't be empty")
+		}
+		return env.Shell(ctx, shell)
+	},
+}
+
+func init() {
+	shellCmd.Flags().String("shell", "sh", "Shell to run, e.g. bash")
+	rootCmd.AddCommand(shellCmd)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether environments build and pass their tests",
+	Long: `Display the build status of all environments: whether the last build or
+test command recorded by the agent passed, the command and when it ran.
+Environments without a recorded build status are shown as unknown.`,
+	Args: cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		envInfos, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tTITLE\tBUILD\tCOMMAND\tRAN")
+		for _, envInfo := range promotedFirst(envInfos) {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", envInfo.ID, listTitle(app, envInfo), buildStatusColumns(app, envInfo.State.BuildStatus))
+		}
+		return nil
+	},
+}
+
+// buildStatusColumns are the BUILD, COMMAND and RAN columns of the status of an environment.
+func buildStatusColumns(app *cobra.Command, status *environment.BuildStatus) string {
+	if status == nil {
+		return "unknown\t-\t-"
+	}
+	// Scripts are shown by their first line
+	command, _, _ := strings.Cut(status.Command, "\n")
+	return fmt.Sprintf("%s\t%s\t%s", status, truncate(app, command, 40), formatTime(status.RecordedAt))
+}
+
+func init() {
+	statusCmd.Flags().BoolP("no-trunc", "", false, "Don't truncate output")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 
@@ -9,34 +12,119 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var singleTenant bool
-
 var stdioCmd = &cobra.Command{
 	Use:   "stdio",
 	Short: "Start MCP server for agent integration",
-	Long:  `Start the Model Context Protocol server that enables AI agents to create and manage containerized environments. This is typically used by agents like Claude Code, Cursor, or VSCode.`,
+	Long: `Start the Model Context Protocol server that enables AI agents to create and manage containerized environments. This is typically used by agents like Claude Code, Cursor, or VSCode.
+
+Use --enable-tools, --disable-tools or --read-only to restrict the tools exposed to agents. Tools that aren't enabled are not registered at all.
+
+Use --allow-command to only let agents run the given commands, e.g. in sandboxed deployments. Other commands, and commands chaining, piping or redirecting others, are rejected before they run.
+
+Use --instructions (or $CONTAINER_USE_INSTRUCTIONS) to give agents team or repository specific guidance, e.g. "always run the tests before finishing". It is added to the default rules, or replaces them with --replace-instructions.`,
+	Example: `# Expose every tool
+container-use stdio
+
+# Inspection-only server for untrusted agents
+container-use stdio --read-only
+
+# Everything except running commands
+container-use stdio --disable-tools environment_run_cmd
+
+# Only run the tests
+container-use stdio --allow-command "go test" --allow-command "/npm (ci|test)/"
+
+# Add team guidelines to the default rules
+container-use stdio --instructions .container-use/instructions.md`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
 
-		slog.Info("connecting to dagger")
+		opts, err := serverOptions(app)
+		if err != nil {
+			return err
+		}
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logWriter))
+		dag := connectServerDagger(ctx)
+		defer dag.Close()
+
+		return mcpserver.RunStdioServer(ctx, dag, opts)
+	},
+}
+
+// addServerFlags registers the flags shared by the MCP server commands.
+func addServerFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("single-tenant", false, "Enable single-tenant mode where environment ID is optional (assumes one session per server)")
+	cmd.Flags().StringSlice("enable-tools", nil, "Only register the given tools (comma separated)")
+	cmd.Flags().StringSlice("disable-tools", nil, "Don't register the given tools (comma separated)")
+	cmd.Flags().Bool("read-only", false, "Only register tools that don't modify environments")
+	cmd.Flags().String("instructions", os.Getenv("CONTAINER_USE_INSTRUCTIONS"), "File with instructions for agents, added to the default rules (defaults to $CONTAINER_USE_INSTRUCTIONS)")
+	cmd.Flags().Bool("replace-instructions", false, "Replace the default rules with --instructions instead of adding to them")
+	cmd.Flags().String("auto-prune", "", "On startup, delete the environments of the current repository older than this duration (e.g., 3d, 2w, 1mo)")
+	cmd.Flags().Duration("auto-prune-interval", 0, "Repeat --auto-prune at this interval (e.g., 24h) instead of only on startup")
+	cmd.Flags().StringArray("allow-command", nil, "Only run commands starting with this prefix (e.g. \"go test\"), or matching this /regex/ (repeatable)")
+}
+
+func serverOptions(cmd *cobra.Command) (mcpserver.ServerOptions, error) {
+	singleTenant, _ := cmd.Flags().GetBool("single-tenant")
+	enabledTools, _ := cmd.Flags().GetStringSlice("enable-tools")
+	disabledTools, _ := cmd.Flags().GetStringSlice("disable-tools")
+	readOnly, _ := cmd.Flags().GetBool("read-only")
+	if readOnly {
+		if len(enabledTools) > 0 {
+			return mcpserver.ServerOptions{}, errors.New("--read-only and --enable-tools are mutually exclusive")
+		}
+		enabledTools = mcpserver.ReadOnlyTools
+	}
+
+	opts := mcpserver.ServerOptions{
+		SingleTenant:  singleTenant,
+		EnabledTools:  enabledTools,
+		DisabledTools: disabledTools,
+	}
+
+	if autoPrune, _ := cmd.Flags().GetString("auto-prune"); autoPrune != "" {
+		var err error
+		if opts.AutoPrune, err = parseAge(autoPrune); err != nil {
+			return mcpserver.ServerOptions{}, fmt.Errorf("invalid --auto-prune format: %w", err)
+		}
+	}
+	opts.AllowedCommands, _ = cmd.Flags().GetStringArray("allow-command")
+	opts.AutoPruneInterval, _ = cmd.Flags().GetDuration("auto-prune-interval")
+	if opts.AutoPruneInterval > 0 && opts.AutoPrune == 0 {
+		return mcpserver.ServerOptions{}, errors.New("--auto-prune-interval requires --auto-prune")
+	}
+
+	opts.ReplaceInstructions, _ = cmd.Flags().GetBool("replace-instructions")
+	if path, _ := cmd.Flags().GetString("instructions"); path != "" {
+		instructions, err := os.ReadFile(path)
 		if err != nil {
-			slog.Error("Error starting dagger", "error", err)
+			return mcpserver.ServerOptions{}, fmt.Errorf("failed to read instructions: %w", err)
+		}
+		opts.Instructions = string(instructions)
+	} else if opts.ReplaceInstructions {
+		return mcpserver.ServerOptions{}, errors.New("--replace-instructions requires --instructions")
+	}
+	return opts, nil
+}
 
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
+// connectServerDagger connects to dagger for the lifetime of an MCP server, exiting on failure.
+func connectServerDagger(ctx context.Context) *dagger.Client {
+	slog.Info("connecting to dagger")
 
-			os.Exit(1)
+	dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logWriter))
+	if err != nil {
+		slog.Error("Error starting dagger", "error", err)
+
+		if isDockerDaemonError(err) {
+			handleDockerDaemonError()
 		}
-		defer dag.Close()
 
-		return mcpserver.RunStdioServer(ctx, dag, singleTenant)
-	},
+		os.Exit(1)
+	}
+	return dag
 }
 
 func init() {
-	stdioCmd.Flags().BoolVar(&singleTenant, "single-tenant", false, "Enable single-tenant mode where environment ID is optional (assumes one session per server)")
+	addServerFlags(stdioCmd)
 	rootCmd.AddCommand(stdioCmd)
 }
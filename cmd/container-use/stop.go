@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop <env> <id|service>",
+	Short: "Stop a background command or a service of an environment",
+	Long: `Stop a background command started by an agent, given the ID it was
+returned (e.g. bg-1a2b3c4d), or a service, given its name, releasing the
+ports they expose on your machine.
+
+Background commands and services run in the agent's MCP server: it is asked
+to stop them and does so within a second. Stopped background commands are
+removed from the environment; stopped services stay in its configuration and
+are started again when the environment is rebuilt or resumed.`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(app *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return suggestEnvironments(app, args, toComplete)
+	},
+	Example: `# Stop a background server
+container-use stop fancy-mallard bg-1a2b3c4d
+
+# Stop a service
+container-use stop fancy-mallard postgres`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		envID, handle := args[0], args[1]
+		if err := repo.Stop(ctx, envID, handle); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", handle, err)
+		}
+		fmt.Printf("Requested to stop %s in environment '%s'.\n", handle, envID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 	"os/exec"
 
-	"dagger.io/dagger"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
@@ -36,25 +35,13 @@ container-use terminal`,
 			return err
 		}
 
-		// FIXME(aluzzardi): This is a hack to make sure we're wrapped in `dagger run` since `Terminal()` only works with the CLI.
-		// If not, it will auto-wrap this command in a `dagger run`.
-		if _, ok := os.LookupEnv("DAGGER_SESSION_TOKEN"); !ok {
-			daggerBin, err := exec.LookPath("dagger")
-			if err != nil {
-				if errors.Is(err, exec.ErrNotFound) {
-					return fmt.Errorf("dagger is not installed. Please install it from https://docs.dagger.io/install/")
-				}
-				return fmt.Errorf("failed to look up dagger binary: %w", err)
-			}
-			return execDaggerRun(daggerBin, append([]string{"dagger", "run"}, os.Args...), os.Environ())
+		if wrapped, err := wrapInDaggerRun(); wrapped || err != nil {
+			return err
 		}
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+		dag, err := connectDagger(ctx)
 		if err != nil {
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
-			return fmt.Errorf("failed to connect to dagger: %w", err)
+			return err
 		}
 		defer dag.Close()
 
@@ -72,6 +59,22 @@ container-use terminal`,
 	},
 }
 
+// wrapInDaggerRun runs the command again wrapped in `dagger run` if it isn't already, and reports whether it did.
+// FIXME(aluzzardi): This is a hack to make sure we're wrapped in `dagger run` since `Terminal()` only works with the CLI.
+func wrapInDaggerRun() (bool, error) {
+	if _, ok := os.LookupEnv("DAGGER_SESSION_TOKEN"); ok {
+		return false, nil
+	}
+	daggerBin, err := exec.LookPath("dagger")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return false, fmt.Errorf("dagger is not installed. Please install it from https://docs.dagger.io/install/")
+		}
+		return false, fmt.Errorf("failed to look up dagger binary: %w", err)
+	}
+	return true, execDaggerRun(daggerBin, append([]string{"dagger", "run"}, os.Args...), os.Environ())
+}
+
 func init() {
 	rootCmd.AddCommand(terminalCmd)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// Formats of the timestamps shown by the CLI, set with the global --time-format option.
+const (
+	timeFormatRelative = "relative"
+	timeFormatAbsolute = "absolute"
+	timeFormatISO      = "iso"
+)
+
+// absoluteTimeLayout is the layout of absolute timestamps: precise and sortable, but easier to read than ISO 8601.
+const absoluteTimeLayout = "2006-01-02 15:04:05 MST"
+
+var (
+	timeFormatOption string
+	timezoneOption   string
+)
+
+// timeLocation returns the timezone timestamps are shown in.
+func timeLocation() (*time.Location, error) {
+	switch strings.ToLower(timezoneOption) {
+	case "", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(timezoneOption)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --timezone %q: use local, UTC or a name like Europe/Paris", timezoneOption)
+	}
+	return loc, nil
+}
+
+// validateTimeOptions checks the global time options, and makes git honor the timezone, e.g. in log.
+func validateTimeOptions(*cobra.Command, []string) error {
+	switch timeFormatOption {
+	case timeFormatRelative, timeFormatAbsolute, timeFormatISO:
+	default:
+		return fmt.Errorf("invalid --time-format %q: use %s, %s or %s", timeFormatOption, timeFormatRelative, timeFormatAbsolute, timeFormatISO)
+	}
+	loc, err := timeLocation()
+	if err != nil {
+		return err
+	}
+	if loc != time.Local {
+		return os.Setenv("TZ", loc.String())
+	}
+	return nil
+}
+
+// formatTime formats a timestamp as set by the global --time-format and --timezone options. Invalid options, only
+// reported by commands, fall back to the defaults so that shell completions keep working.
+func formatTime(t time.Time) string {
+	loc, err := timeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	switch timeFormatOption {
+	case timeFormatAbsolute:
+		return t.In(loc).Format(absoluteTimeLayout)
+	case timeFormatISO:
+		return t.In(loc).Format(time.RFC3339)
+	default:
+		return humanize.Time(t)
+	}
+}
+
+// gitDateFormat returns the git date format (see git log --date) matching the global --time-format option, or an
+// empty string for relative dates. Dates are shown in the timezone of the TZ variable, see validateTimeOptions.
+func gitDateFormat() string {
+	switch timeFormatOption {
+	case timeFormatAbsolute:
+		return "format-local:%Y-%m-%d %H:%M:%S %Z"
+	case timeFormatISO:
+		return "iso-strict-local"
+	default:
+		return ""
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&timeFormatOption, "time-format", timeFormatRelative, "How timestamps are shown: relative (e.g. 2 hours ago), absolute or iso")
+	rootCmd.PersistentFlags().StringVar(&timezoneOption, "timezone", "local", "Timezone of absolute and iso timestamps: local, UTC or a name like Europe/Paris")
+	rootCmd.PersistentPreRunE = validateTimeOptions
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatTime(t *testing.T) {
+	defer func(format, timezone string) {
+		timeFormatOption, timezoneOption = format, timezone
+	}(timeFormatOption, timezoneOption)
+
+	ts := time.Date(2025, 6, 12, 12, 3, 27, 0, time.UTC)
+	tests := []struct {
+		name     string
+		format   string
+		timezone string
+		expected string
+	}{
+		{
+			name:     "absolute in UTC",
+			format:   timeFormatAbsolute,
+			timezone: "UTC",
+			expected: "2025-06-12 12:03:27 UTC",
+		},
+		{
+			name:     "iso in a named timezone",
+			format:   timeFormatISO,
+			timezone: "Europe/Paris",
+			expected: "2025-06-12T14:03:27+02:00",
+		},
+		{
+			name:     "invalid timezone falls back to local",
+			format:   timeFormatISO,
+			timezone: "Nowhere/Special",
+			expected: ts.Local().Format(time.RFC3339),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeFormatOption, timezoneOption = tt.format, tt.timezone
+			assert.Equal(t, tt.expected, formatTime(ts))
+		})
+	}
+
+	t.Run("relative", func(t *testing.T) {
+		timeFormatOption, timezoneOption = timeFormatRelative, "UTC"
+		assert.Equal(t, "2 hours ago", formatTime(time.Now().Add(-2*time.Hour)))
+	})
+}
+
+func TestValidateTimeOptions(t *testing.T) {
+	defer func(format, timezone string) {
+		timeFormatOption, timezoneOption = format, timezone
+	}(timeFormatOption, timezoneOption)
+
+	timeFormatOption, timezoneOption = "epoch", "local"
+	require.ErrorContains(t, validateTimeOptions(nil, nil), "invalid --time-format")
+
+	timeFormatOption, timezoneOption = timeFormatAbsolute, "Nowhere/Special"
+	require.ErrorContains(t, validateTimeOptions(nil, nil), "invalid --timezone")
+
+	timeFormatOption, timezoneOption = timeFormatAbsolute, "local"
+	require.NoError(t, validateTimeOptions(nil, nil))
+}
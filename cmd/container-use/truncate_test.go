@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		width    int
+		expected string
+	}{
+		{
+			name:     "short text is left alone",
+			input:    "Flask app",
+			width:    20,
+			expected: "Flask app",
+		},
+		{
+			name:     "ascii text is cut",
+			input:    "Flask App with Login",
+			width:    10,
+			expected: "Flask App…",
+		},
+		{
+			name:     "multibyte text is cut on rune boundaries",
+			input:    "Überarbeitung der Anmeldung",
+			width:    6,
+			expected: "Übera…",
+		},
+		{
+			name:     "wide characters count double",
+			input:    "ログイン機能を追加",
+			width:    7,
+			expected: "ログイ…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, truncateWidth(tt.input, tt.width, truncationIndicator))
+		})
+	}
+}
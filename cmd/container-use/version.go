@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os/exec"
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +24,12 @@ var (
 
 const defaultTimeout = 2 * time.Second
 
+// latestReleaseURL is the GitHub API endpoint describing the latest release of container-use.
+var latestReleaseURL = "https://api.github.com/repos/dagger/container-use/releases/latest"
+
+// updateCheckTimeout bounds the update check so that a slow or unreachable network never blocks the command.
+const updateCheckTimeout = 5 * time.Second
+
 func init() {
 	if version == "dev" {
 		if buildCommit, buildTime := getBuildInfoFromBinary(); buildCommit != "unknown" {
@@ -30,15 +39,25 @@ func init() {
 	}
 
 	versionCmd.Flags().BoolP("system", "s", false, "Show system information")
+	versionCmd.Flags().Bool("check", false, "Check whether a newer release is available")
 	rootCmd.AddCommand(versionCmd)
 }
 
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print version information",
-	Long:  `Print the version, commit hash, and build date of the container-use binary.`,
+	Long: `Print the version, commit hash, and build date of the container-use binary.
+
+With --check, also look up the latest release on GitHub and tell whether an
+update is available. Network failures are reported but never make the command fail.`,
+	Example: `# Show the version
+container-use version
+
+# Check for updates
+container-use version --check`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		showSystem, _ := cmd.Flags().GetBool("system")
+		check, _ := cmd.Flags().GetBool("check")
 
 		// Always show basic version info
 		cmd.Printf("container-use version %s\n", version)
@@ -75,10 +94,107 @@ var versionCmd = &cobra.Command{
 			}
 		}
 
+		if check {
+			cmd.Printf("\n%s\n", checkForUpdate(cmd.Context(), version))
+		}
+
 		return nil
 	},
 }
 
+// checkForUpdate compares current to the latest release and describes the outcome.
+// Failing to reach GitHub is part of the description rather than an error: the check is best effort.
+func checkForUpdate(ctx context.Context, current string) string {
+	ctx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+	defer cancel()
+
+	latest, err := latestRelease(ctx)
+	if err != nil {
+		return fmt.Sprintf("Could not check for updates: %s", err)
+	}
+
+	if current == "dev" {
+		return fmt.Sprintf("This is a development build. The latest release is %s.", latest)
+	}
+	if !newerVersion(current, latest) {
+		return fmt.Sprintf("container-use is up to date (latest release: %s).", latest)
+	}
+	return fmt.Sprintf(`A new version of container-use is available: %s (current: %s)
+Upgrade with your package manager (e.g. brew upgrade container-use), or with:
+  curl -fsSL https://raw.githubusercontent.com/dagger/container-use/main/install.sh | bash
+Release notes: https://github.com/dagger/container-use/releases/tag/%s`, latest, current, latest)
+}
+
+// latestRelease returns the tag of the latest release of container-use.
+func latestRelease(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub API returned %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("failed to decode release: %w", err)
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("latest release has no tag")
+	}
+	return release.TagName, nil
+}
+
+// newerVersion reports whether latest is a newer version than current, comparing major, minor and patch
+// numbers (e.g. "v0.4.2"). At equal numbers, a release is newer than a pre-release (e.g. "v0.5.0-rc1").
+// Versions that can't be parsed are never considered newer.
+func newerVersion(current, latest string) bool {
+	currentParts, currentPre, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	latestParts, latestPre, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	for i := range currentParts {
+		if latestParts[i] != currentParts[i] {
+			return latestParts[i] > currentParts[i]
+		}
+	}
+	return currentPre != "" && latestPre == ""
+}
+
+func parseVersion(v string) ([3]int, string, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, pre, _ := strings.Cut(v, "-")
+	v, _, _ = strings.Cut(v, "+")
+
+	fields := strings.Split(v, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, "", false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, "", false
+		}
+		parts[i] = n
+	}
+	return parts, pre, true
+}
+
 // runtimeInfo holds container runtime information
 type runtimeInfo struct {
 	Name    string
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -127,3 +130,45 @@ func TestVersionParsing(t *testing.T) {
 		})
 	}
 }
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		newer           bool
+	}{
+		{"v0.4.2", "v0.4.3", true},
+		{"0.4.2", "v0.5.0", true},
+		{"v0.9.0", "v0.10.0", true},
+		{"v1.0.0-rc1", "v1.0.0", true},
+		{"v0.4.2", "v0.4.2", false},
+		{"v0.5.0", "v0.4.9", false},
+		{"v1.0.0", "v1.0.0-rc1", false},
+		{"dev", "v0.4.2", false},
+		{"v0.4.2", "nightly", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current+" to "+tt.latest, func(t *testing.T) {
+			assert.Equal(t, tt.newer, newerVersion(tt.current, tt.latest))
+		})
+	}
+}
+
+func TestCheckForUpdate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "v0.5.0"}`)
+	}))
+	defer server.Close()
+
+	originalURL := latestReleaseURL
+	defer func() { latestReleaseURL = originalURL }()
+	latestReleaseURL = server.URL
+
+	assert.Contains(t, checkForUpdate(t.Context(), "v0.4.2"), "A new version of container-use is available: v0.5.0 (current: v0.4.2)")
+	assert.Contains(t, checkForUpdate(t.Context(), "v0.5.0"), "up to date")
+	assert.Contains(t, checkForUpdate(t.Context(), "dev"), "development build")
+
+	// Network failures are reported, not returned
+	server.Close()
+	assert.Contains(t, checkForUpdate(t.Context(), "v0.4.2"), "Could not check for updates")
+}
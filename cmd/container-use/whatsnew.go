@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var whatsnewCmd = &cobra.Command{
+	Use:   "whatsnew [<env>]",
+	Short: "Show what an agent did since you last looked",
+	Long: `Display the commits made in an environment since the last time you ran
+whatsnew on it, then mark them as seen. The first time, shows the whole history
+of the environment, like log.
+
+If no environment is specified, automatically selects from environments 
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Catch up on a long running session
+container-use whatsnew fancy-mallard
+
+# Include code changes
+container-use whatsnew fancy-mallard -p`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		patch, _ := app.Flags().GetBool("patch")
+
+		changed, err := repo.WhatsNewWithOpts(ctx, envID, repository.LogOpts{Patch: patch, DateFormat: gitDateFormat()}, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			fmt.Printf("Nothing new in environment '%s' since you last looked.\n", envID)
+		}
+		return nil
+	},
+}
+
+func init() {
+	whatsnewCmd.Flags().BoolP("patch", "p", false, "Generate patch")
+	rootCmd.AddCommand(whatsnewCmd)
+}
@@ -0,0 +1,212 @@
+package containeruse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+)
+
+// Client manages the environments of a git repository.
+type Client struct {
+	repo *repository.Repository
+	dag  *dagger.Client
+}
+
+// Open returns a client for the environments of the git repository containing path.
+// dag is used for all container operations. It is owned by the caller, who must keep it
+// connected as long as the client and its environments are in use.
+func Open(ctx context.Context, path string, dag *dagger.Client) (*Client, error) {
+	if dag == nil {
+		return nil, errors.New("a dagger client is required")
+	}
+	repo, err := repository.Open(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return &Client{repo: repo, dag: dag}, nil
+}
+
+// CreateOpts are the optional arguments of Create.
+type CreateOpts struct {
+	// Explanation is recorded in the environment's log. Defaults to the title.
+	Explanation string
+	// GitRef is the commit, branch or tag the environment starts from. Defaults to HEAD.
+	GitRef string
+	// Progress, if set, is notified of the setup commands and services as they run.
+	Progress func(message string)
+	// Variables are the values of the template variables referenced as ${NAME} in the configuration.
+	Variables map[string]string
+}
+
+// Create creates an environment from the repository's configuration (see `container-use config`).
+func (c *Client) Create(ctx context.Context, title string, opts CreateOpts) (*Environment, error) {
+	explanation := opts.Explanation
+	if explanation == "" {
+		explanation = title
+	}
+	gitRef := opts.GitRef
+	if gitRef == "" {
+		gitRef = "HEAD"
+	}
+
+	env, err := c.repo.CreateWithOpts(ctx, c.dag, title, explanation, gitRef, repository.CreateOpts{
+		Progress:  opts.Progress,
+		Variables: opts.Variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Environment{client: c, env: env}, nil
+}
+
+// Get returns an existing environment.
+func (c *Client) Get(ctx context.Context, id string) (*Environment, error) {
+	env, err := c.repo.Get(ctx, c.dag, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Environment{client: c, env: env}, nil
+}
+
+// List returns the metadata of all the environments of the repository.
+// It doesn't need any container operation: use Get to work with one of them.
+func (c *Client) List(ctx context.Context) ([]*EnvironmentInfo, error) {
+	envInfos, err := c.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*EnvironmentInfo, len(envInfos))
+	for i, envInfo := range envInfos {
+		infos[i] = newEnvironmentInfo(envInfo)
+	}
+	return infos, nil
+}
+
+// Delete deletes an environment along with its branch.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.repo.Delete(ctx, id)
+}
+
+// Environment is a container-use environment.
+// Its methods aren't safe for concurrent use: the changes made by concurrent calls may not all be committed.
+type Environment struct {
+	client *Client
+	env    *environment.Environment
+}
+
+// ID returns the ID of the environment, which is also the name of its branch under container-use/.
+func (e *Environment) ID() string {
+	return e.env.ID
+}
+
+// EnvironmentInfo is the metadata of an environment.
+type EnvironmentInfo struct {
+	// ID is the ID of the environment, which is also the name of its branch under container-use/.
+	ID    string
+	Title string
+	// Parent is the ID of the environment this one was forked from, if any.
+	Parent    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Paused environments must be resumed with `container-use resume` before use.
+	Paused bool
+	// Locked environments refuse changes until they are unlocked with `container-use env unlock`.
+	Locked bool
+}
+
+func newEnvironmentInfo(envInfo *environment.EnvironmentInfo) *EnvironmentInfo {
+	return &EnvironmentInfo{
+		ID:        envInfo.ID,
+		Title:     envInfo.State.Title,
+		Parent:    envInfo.State.Parent,
+		CreatedAt: envInfo.State.CreatedAt,
+		UpdatedAt: envInfo.State.UpdatedAt,
+		Paused:    envInfo.State.Paused,
+		Locked:    envInfo.State.Locked,
+	}
+}
+
+// Info returns the metadata of the environment.
+func (e *Environment) Info() *EnvironmentInfo {
+	return newEnvironmentInfo(e.env.EnvironmentInfo)
+}
+
+// RunOpts are the optional arguments of Run.
+type RunOpts struct {
+	// Explanation is recorded along with the command in the environment's log.
+	Explanation string
+	// Shell interprets the command. Defaults to sh.
+	Shell string
+	// Stdin is fed to the standard input of the command.
+	Stdin string
+}
+
+// RunResult is the outcome of a command.
+type RunResult struct {
+	// Stdout and Stderr are the bytes written by the command, as is.
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Duration is how long the command ran.
+	Duration time.Duration
+}
+
+// Run runs a shell command in the environment and commits the changes it made to the workdir.
+// A command exiting with a non-zero code isn't an error: check the ExitCode of the result.
+func (e *Environment) Run(ctx context.Context, command string, opts RunOpts) (*RunResult, error) {
+	shell := opts.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+
+	result, runErr := e.env.Run(ctx, environment.RunOpts{
+		Command: command,
+		Shell:   shell,
+		Stdin:   opts.Stdin,
+	})
+	// Commit whatever the command did, even if it failed.
+	if err := e.client.repo.Update(ctx, e.env, opts.Explanation); err != nil {
+		return nil, fmt.Errorf("failed to update repository: %w", err)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("failed to run command: %w", runErr)
+	}
+	return &RunResult{
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+		ExitCode: result.ExitCode,
+		Duration: result.Timing.Execution,
+	}, nil
+}
+
+// FileRead returns the contents of a file, absolute or relative to the workdir.
+func (e *Environment) FileRead(ctx context.Context, path string) (string, error) {
+	return e.env.FileRead(ctx, path, true, 0, 0)
+}
+
+// FileWrite writes a file, absolute or relative to the workdir, and commits it.
+func (e *Environment) FileWrite(ctx context.Context, path, contents, explanation string) error {
+	if err := e.env.FileWrite(ctx, explanation, path, contents); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := e.client.repo.UpdateFile(ctx, e.env, path, explanation); err != nil {
+		return fmt.Errorf("failed to update repository: %w", err)
+	}
+	return nil
+}
+
+// FileDelete deletes a file, absolute or relative to the workdir, and commits the deletion.
+func (e *Environment) FileDelete(ctx context.Context, path, explanation string) error {
+	if err := e.env.FileDelete(ctx, explanation, path); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	if err := e.client.repo.Update(ctx, e.env, explanation); err != nil {
+		return fmt.Errorf("failed to update repository: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// Package containeruse lets Go programs manage container-use environments without going through
+// the MCP server or the CLI.
+//
+// A [Client] manages the environments of one git repository. It needs a Dagger client, which the
+// caller connects and closes:
+//
+//	dag, err := dagger.Connect(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer dag.Close()
+//
+//	cu, err := containeruse.Open(ctx, ".", dag)
+//	if err != nil {
+//		return err
+//	}
+//	env, err := cu.Create(ctx, "Fix the flaky test", containeruse.CreateOpts{})
+//
+// Every change made through an [Environment] is committed to its container-use/<id> branch, the same
+// way changes made by agents are, so environments can be reviewed and merged with the CLI.
+//
+// The API of this package is stable: it only exposes its own types. The repository and environment
+// packages it builds on are internal building blocks of container-use and may change between releases.
+package containeruse
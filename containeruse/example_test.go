@@ -0,0 +1,39 @@
+package containeruse_test
+
+import (
+	"context"
+	"fmt"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/containeruse"
+)
+
+func Example() {
+	ctx := context.Background()
+
+	dag, err := dagger.Connect(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer dag.Close()
+
+	cu, err := containeruse.Open(ctx, ".", dag)
+	if err != nil {
+		panic(err)
+	}
+
+	env, err := cu.Create(ctx, "Add a greeting", containeruse.CreateOpts{})
+	if err != nil {
+		panic(err)
+	}
+	if err := env.FileWrite(ctx, "hello.txt", "Hello, world!\n", "Add greeting"); err != nil {
+		panic(err)
+	}
+	result, err := env.Run(ctx, "cat hello.txt", containeruse.RunOpts{Explanation: "Check the greeting"})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(result.Stdout)
+
+	fmt.Printf("Review the changes with `container-use diff %s`\n", env.ID())
+}
@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"fmt"
+	"time"
+)
+
+// BuildStatus is the outcome of the last build or test command of an environment, recorded with RecordBuildStatus
+// to tell at a glance whether the environment is green.
+type BuildStatus struct {
+	Passed   bool   `json:"passed"`
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	// Tests summarizes the results of the tests run by the command, if they were parsed.
+	Tests      *TestResults `json:"tests,omitempty"`
+	RecordedAt time.Time    `json:"recorded_at"`
+}
+
+func (s *BuildStatus) String() string {
+	status := "failing"
+	if s.Passed {
+		status = "passing"
+	}
+	if s.Tests != nil {
+		return fmt.Sprintf("%s (%d passed, %d failed, %d errors)", status, s.Tests.Passed, s.Tests.Failed, s.Tests.Errors)
+	}
+	if !s.Passed {
+		return fmt.Sprintf("%s (exit code %d)", status, s.ExitCode)
+	}
+	return status
+}
+
+// RecordBuildStatus records the outcome of a build or test command as the build status of the environment. The
+// command passed if it exited as expected and none of its tests, if parsed, failed. Failures are only kept in the
+// results of the command: the status is a summary.
+func (env *Environment) RecordBuildStatus(command string, result *RunResult, exitedAsExpected bool, tests *TestResults) *BuildStatus {
+	status := &BuildStatus{
+		Passed:     exitedAsExpected,
+		Command:    command,
+		ExitCode:   result.ExitCode,
+		RecordedAt: time.Now(),
+	}
+	if tests != nil {
+		status.Passed = status.Passed && tests.Failed == 0 && tests.Errors == 0
+		status.Tests = &TestResults{Passed: tests.Passed, Failed: tests.Failed, Skipped: tests.Skipped, Errors: tests.Errors}
+	}
+	env.State.BuildStatus = status
+	return status
+}
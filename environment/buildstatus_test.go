@@ -0,0 +1,30 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordBuildStatus(t *testing.T) {
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{}}}
+
+	status := env.RecordBuildStatus("go build ./...", &RunResult{ExitCode: 0}, true, nil)
+	assert.Same(t, status, env.State.BuildStatus)
+	assert.True(t, status.Passed)
+	assert.Equal(t, "go build ./...", status.Command)
+	assert.Equal(t, "passing", status.String())
+
+	status = env.RecordBuildStatus("make", &RunResult{ExitCode: 2}, false, nil)
+	assert.False(t, status.Passed)
+	assert.Equal(t, "failing (exit code 2)", status.String())
+
+	tests := &TestResults{Passed: 3, Failed: 1, Failures: []TestFailure{{Name: "TestX"}}}
+	status = env.RecordBuildStatus("go test -json ./...", &RunResult{ExitCode: 0}, true, tests)
+	assert.False(t, status.Passed, "failed tests fail the build even if the command exited as expected")
+	assert.Equal(t, &TestResults{Passed: 3, Failed: 1}, status.Tests, "failures aren't kept in the status")
+	assert.Equal(t, "failing (3 passed, 1 failed, 0 errors)", status.String())
+
+	status = env.RecordBuildStatus("go test -json ./...", &RunResult{ExitCode: 0}, true, &TestResults{Passed: 4})
+	assert.True(t, status.Passed)
+}
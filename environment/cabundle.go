@@ -0,0 +1,115 @@
+package environment
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// caCertPath is where the configured CA bundle is copied. update-ca-certificates (Debian, Ubuntu, Alpine)
+	// picks up certificates from this directory, including when ca-certificates is installed by a later command.
+	caCertPath = "/usr/local/share/ca-certificates/container-use-ca.crt"
+	// caTrustStorePath is the system trust store that the certificate is added to, and that the CA variables
+	// point to. Images without one get it created with the configured certificates only.
+	caTrustStorePath = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// caInstallScript adds the certificate at caCertPath to the trust store of the image, whatever the distribution.
+// The certificate is appended to caTrustStorePath unless the trust store tools already did.
+const caInstallScript = `set -e
+cert=` + caCertPath + `
+bundle=` + caTrustStorePath + `
+if command -v update-ca-certificates >/dev/null 2>&1; then
+	update-ca-certificates >/dev/null 2>&1 || true
+elif command -v update-ca-trust >/dev/null 2>&1; then
+	mkdir -p /etc/pki/ca-trust/source/anchors
+	cp "$cert" /etc/pki/ca-trust/source/anchors/
+	update-ca-trust extract || true
+fi
+mkdir -p "$(dirname "$bundle")"
+if [ ! -e "$bundle" ] && [ -f /etc/pki/tls/certs/ca-bundle.crt ]; then
+	ln -s /etc/pki/tls/certs/ca-bundle.crt "$bundle"
+fi
+if ! grep -qF -- "$(sed -n 2p "$cert")" "$bundle" 2>/dev/null; then
+	cat "$cert" >> "$bundle"
+fi
+`
+
+// caBundleEnv are the variables pointing TLS clients that ignore the system trust store to the configured CAs.
+// Variables of the configuration take precedence.
+var caBundleEnv = map[string]string{
+	"SSL_CERT_FILE":       caTrustStorePath, // OpenSSL, Go, Ruby
+	"REQUESTS_CA_BUNDLE":  caTrustStorePath, // Python requests
+	"PIP_CERT":            caTrustStorePath,
+	"CURL_CA_BUNDLE":      caTrustStorePath,
+	"NODE_EXTRA_CA_CERTS": caCertPath, // Added to Node's built-in CAs
+}
+
+// caBundlePath returns the host path of the configured CA bundle.
+func (env *Environment) caBundlePath() (string, error) {
+	path := env.State.Config.CABundle
+	if !filepath.IsAbs(path) {
+		if env.State.SourcePath == "" {
+			return "", fmt.Errorf("CA bundle %q must be an absolute path for environments created by older versions", path)
+		}
+		path = filepath.Join(env.State.SourcePath, path)
+	}
+	return path, nil
+}
+
+// containerWithCABundle installs the configured CA bundle in the trust store of the container, e.g. for
+// networks where a proxy intercepts TLS with its own certificate.
+func (env *Environment) containerWithCABundle(ctx context.Context, container *dagger.Container) (*dagger.Container, error) {
+	if env.State.Config.CABundle == "" {
+		return container, nil
+	}
+	path, err := env.caBundlePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA bundle: %w", err)
+	}
+	certs := certificateBlocks(data)
+	if certs == "" {
+		return nil, fmt.Errorf("CA bundle %s has no PEM encoded certificate", path)
+	}
+
+	// The contents, rather than the host file, make the layer cache key: the setup commands rerun when they change
+	container, err = container.
+		WithNewFile(caCertPath, certs).
+		WithExec([]string{"sh", "-c", caInstallScript}).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install CA bundle: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(caBundleEnv)) {
+		container = container.WithEnvVariable(name, caBundleEnv[name])
+	}
+	return container, nil
+}
+
+// certificateBlocks returns the PEM encoded certificates of a PEM file, leaving out any other block such as the
+// private key of a combined key and certificate file.
+func certificateBlocks(data []byte) string {
+	certs := &strings.Builder{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs.String()
+		}
+		if block.Type == "CERTIFICATE" {
+			certs.Write(pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes}))
+		}
+	}
+}
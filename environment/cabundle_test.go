@@ -0,0 +1,23 @@
+package environment
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateBlocks(t *testing.T) {
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")})
+	key := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("secret")})
+	other := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED"}, Bytes: []byte("other")})
+
+	data := append(append(append([]byte("comment\n"), key...), cert...), other...)
+	certs := certificateBlocks(data)
+	assert.NotContains(t, certs, "PRIVATE KEY", "only certificates are kept")
+	assert.NotContains(t, certs, "Proc-Type", "headers are dropped")
+	assert.Equal(t, string(cert)+string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("other")})), certs)
+
+	assert.Empty(t, certificateBlocks(key))
+	assert.Empty(t, certificateBlocks([]byte("not PEM")))
+}
@@ -3,10 +3,17 @@ package environment
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -24,21 +31,153 @@ func DefaultConfig() *EnvironmentConfig {
 }
 
 type EnvironmentConfig struct {
-	Workdir         string         `json:"workdir,omitempty"`
-	BaseImage       string         `json:"base_image,omitempty"`
-	SetupCommands   []string       `json:"setup_commands,omitempty"`
-	InstallCommands []string       `json:"install_commands,omitempty"`
-	Env             KVList         `json:"env,omitempty"`
-	Secrets         KVList         `json:"secrets,omitempty"`
-	Services        ServiceConfigs `json:"services,omitempty"`
+	Workdir       string   `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	BaseImage     string   `json:"base_image,omitempty" yaml:"base_image,omitempty"`
+	SetupCommands []string `json:"setup_commands,omitempty" yaml:"setup_commands,omitempty"`
+	// SetupStages run after the setup commands, in order. Each stage is cached on its own, see SetupStage.
+	SetupStages     SetupStages    `json:"setup_stages,omitempty" yaml:"setup_stages,omitempty"`
+	InstallCommands []string       `json:"install_commands,omitempty" yaml:"install_commands,omitempty"`
+	SeedCommands    []string       `json:"seed_commands,omitempty" yaml:"seed_commands,omitempty"`
+	Env             KVList         `json:"env,omitempty" yaml:"env,omitempty"`
+	Secrets         KVList         `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Services        ServiceConfigs `json:"services,omitempty" yaml:"services,omitempty"`
+
+	// PathPrepend are directories added in front of PATH for every command run in the environment,
+	// e.g. where setup commands install tools.
+	PathPrepend []string `json:"path_prepend,omitempty" yaml:"path_prepend,omitempty"`
+
+	// ContextDir is a host directory, absolute or relative to the source repository, mounted at ContextMountPath.
+	// Its contents are available to the environment but never committed.
+	ContextDir string `json:"context_dir,omitempty" yaml:"context_dir,omitempty"`
+
+	// CABundle is a host file, absolute or relative to the source repository, with PEM encoded CA certificates
+	// added to the trust store of the environment, e.g. the certificate of a proxy intercepting TLS.
+	CABundle string `json:"ca_bundle,omitempty" yaml:"ca_bundle,omitempty"`
+
+	// Volumes are named volumes mounted in the environment. Every environment mounting a volume of the same name
+	// sees the same data, e.g. a large dataset or a build cache shared by environments working on related tasks.
+	Volumes VolumeConfigs `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+
+	// GitConfig is applied to every git command run inside the environment (e.g. "user.name", "safe.directory").
+	GitConfig map[string]string `json:"git_config,omitempty" yaml:"git_config,omitempty"`
+
+	// Variables are the default values of the template variables referenced as ${NAME} in the configuration,
+	// see Resolve. Values given when creating an environment take precedence.
+	Variables map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+
+	// AutoTitle derives the title of environments created without a meaningful one from their first substantive commit.
+	AutoTitle bool `json:"auto_title,omitempty" yaml:"auto_title,omitempty"`
+
+	// BaseImageDigest pins BaseImage to an image digest (e.g. "sha256:..."), so that rebuilds use the same image even
+	// if its tag moves. Changing BaseImage drops it.
+	BaseImageDigest string `json:"base_image_digest,omitempty" yaml:"base_image_digest,omitempty"`
+	// PinBaseImage records the digest the base image resolves to in BaseImageDigest when the environment is built.
+	PinBaseImage bool `json:"pin_base_image,omitempty" yaml:"pin_base_image,omitempty"`
+
+	// DeleteAfterMerge deletes environments once they're merged cleanly with `container-use merge`, unless
+	// --delete=false is given.
+	DeleteAfterMerge bool `json:"delete_after_merge,omitempty" yaml:"delete_after_merge,omitempty"`
 }
 
 type ServiceConfig struct {
-	Name         string   `json:"name,omitempty"`
-	Image        string   `json:"image,omitempty"`
-	Command      string   `json:"command,omitempty"`
-	ExposedPorts []int    `json:"exposed_ports,omitempty"`
-	Env          []string `json:"env,omitempty"`
+	Name         string        `json:"name,omitempty" yaml:"name,omitempty"`
+	Image        string        `json:"image,omitempty" yaml:"image,omitempty"`
+	Command      string        `json:"command,omitempty" yaml:"command,omitempty"`
+	ExposedPorts []ServicePort `json:"exposed_ports,omitempty" yaml:"exposed_ports,omitempty"`
+	Env          []string      `json:"env,omitempty" yaml:"env,omitempty"`
+
+	// RegistryUsername and RegistryPassword authenticate pulls of Image from a private registry.
+	// RegistryPassword is a secret reference (e.g. "env://REGISTRY_TOKEN"), like Secrets values.
+	// Without them, the credentials of the host (e.g. from `docker login`) are used.
+	RegistryUsername string `json:"registry_username,omitempty" yaml:"registry_username,omitempty"`
+	RegistryPassword string `json:"registry_password,omitempty" yaml:"registry_password,omitempty"`
+
+	// StartupTimeout is how many seconds the service may take to start, i.e. for its exposed ports to accept
+	// connections. Zero uses the default of 30 seconds.
+	StartupTimeout int `json:"startup_timeout,omitempty" yaml:"startup_timeout,omitempty"`
+}
+
+// Supported protocols for service ports
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// ServicePort is a port exposed by a service.
+// In JSON, TCP ports are plain numbers (e.g. 5432) and other protocols
+// are written as "PORT/PROTOCOL" (e.g. "53/udp").
+type ServicePort struct {
+	Port     int
+	Protocol string
+}
+
+// ParseServicePort parses a port in the "PORT" or "PORT/PROTOCOL" format. Protocol defaults to TCP.
+func ParseServicePort(raw string) (ServicePort, error) {
+	portStr, protocol, found := strings.Cut(raw, "/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return ServicePort{}, fmt.Errorf("invalid port: %s", raw)
+	}
+	if !found {
+		protocol = ProtocolTCP
+	}
+	protocol = strings.ToLower(protocol)
+	if protocol != ProtocolTCP && protocol != ProtocolUDP {
+		return ServicePort{}, fmt.Errorf("invalid protocol %q for port %d: must be %s or %s", protocol, port, ProtocolTCP, ProtocolUDP)
+	}
+	return ServicePort{Port: port, Protocol: protocol}, nil
+}
+
+func (p ServicePort) String() string {
+	if p.Protocol == "" || p.Protocol == ProtocolTCP {
+		return strconv.Itoa(p.Port)
+	}
+	return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+}
+
+func (p ServicePort) MarshalJSON() ([]byte, error) {
+	if p.Protocol == "" || p.Protocol == ProtocolTCP {
+		return json.Marshal(p.Port)
+	}
+	return json.Marshal(p.String())
+}
+
+func (p ServicePort) MarshalYAML() (any, error) {
+	if p.Protocol == "" || p.Protocol == ProtocolTCP {
+		return p.Port, nil
+	}
+	return p.String(), nil
+}
+
+func (p *ServicePort) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseServicePort(value.Value)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+func (p *ServicePort) UnmarshalJSON(data []byte) error {
+	var port int
+	if err := json.Unmarshal(data, &port); err == nil {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+		*p = ServicePort{Port: port, Protocol: ProtocolTCP}
+		return nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid port: %s", string(data))
+	}
+	parsed, err := ParseServicePort(raw)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
 }
 
 type ServiceConfigs []*ServiceConfig
@@ -52,6 +191,137 @@ func (sc ServiceConfigs) Get(name string) *ServiceConfig {
 	return nil
 }
 
+// Remove removes a service by name and returns true if it was found
+func (sc *ServiceConfigs) Remove(name string) bool {
+	found := false
+	newList := make(ServiceConfigs, 0, len(*sc))
+	for _, cfg := range *sc {
+		if cfg.Name != name {
+			newList = append(newList, cfg)
+		} else {
+			found = true
+		}
+	}
+	*sc = newList
+	return found
+}
+
+// Sharing modes of volumes
+const (
+	// VolumeSharingShared volumes are accessed by the commands of all environments at the same time.
+	VolumeSharingShared = "shared"
+	// VolumeSharingLocked volumes are accessed by one command at a time: commands of other environments
+	// using the volume wait for it to finish.
+	VolumeSharingLocked = "locked"
+)
+
+// volumeNamePattern restricts volume names to what is safe in cache keys and on the command line.
+var volumeNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// VolumeConfig mounts a named volume (a Dagger cache volume) in the environment. Its contents live outside
+// the workdir, so they are never committed.
+type VolumeConfig struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Path is where the volume is mounted, an absolute path outside the workdir.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Sharing is VolumeSharingShared (the default) or VolumeSharingLocked.
+	Sharing string `json:"sharing,omitempty" yaml:"sharing,omitempty"`
+}
+
+func (v *VolumeConfig) String() string {
+	s := fmt.Sprintf("%s at %s", v.Name, v.Path)
+	if v.Sharing == VolumeSharingLocked {
+		s += " (locked)"
+	}
+	return s
+}
+
+type VolumeConfigs []*VolumeConfig
+
+func (vc VolumeConfigs) Get(name string) *VolumeConfig {
+	for _, cfg := range vc {
+		if cfg.Name == name {
+			return cfg
+		}
+	}
+	return nil
+}
+
+// Remove removes a volume by name and returns true if it was found
+func (vc *VolumeConfigs) Remove(name string) bool {
+	found := false
+	newList := make(VolumeConfigs, 0, len(*vc))
+	for _, cfg := range *vc {
+		if cfg.Name != name {
+			newList = append(newList, cfg)
+		} else {
+			found = true
+		}
+	}
+	*vc = newList
+	return found
+}
+
+// SetupStage is a named group of setup commands (e.g. OS packages, then language toolchains, then project
+// tooling). Like every setup command, a stage is cached as long as its commands and everything before it are
+// unchanged: changing a late stage only reruns that stage and the following ones. The cache hints control
+// when a stage is rerun without changing its commands.
+type SetupStage struct {
+	Name     string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Commands []string `json:"commands,omitempty" yaml:"commands,omitempty"`
+	// CacheKey is an arbitrary string: changing it reruns the stage, e.g. to pick up new package versions
+	// with unchanged `apt-get install` commands.
+	CacheKey string `json:"cache_key,omitempty" yaml:"cache_key,omitempty"`
+	// NoCache reruns the stage every time the environment is built, and thus every following stage.
+	NoCache bool `json:"no_cache,omitempty" yaml:"no_cache,omitempty"`
+	// CachePaths are directories, such as package manager caches, persisted across builds while the stage runs:
+	// a rerun stage reuses the downloads of previous runs. They are not part of the resulting environment.
+	CachePaths []string `json:"cache_paths,omitempty" yaml:"cache_paths,omitempty"`
+}
+
+func (s *SetupStage) String() string {
+	var hints []string
+	if s.NoCache {
+		hints = append(hints, "never cached")
+	}
+	if s.CacheKey != "" {
+		hints = append(hints, "cache key "+s.CacheKey)
+	}
+	if len(s.CachePaths) > 0 {
+		hints = append(hints, "caching "+strings.Join(s.CachePaths, ", "))
+	}
+	if len(hints) == 0 {
+		return s.Name
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, strings.Join(hints, "; "))
+}
+
+type SetupStages []*SetupStage
+
+func (ss SetupStages) Get(name string) *SetupStage {
+	for _, stage := range ss {
+		if stage.Name == name {
+			return stage
+		}
+	}
+	return nil
+}
+
+// Remove removes a stage by name and returns true if it was found
+func (ss *SetupStages) Remove(name string) bool {
+	found := false
+	newList := make(SetupStages, 0, len(*ss))
+	for _, stage := range *ss {
+		if stage.Name != name {
+			newList = append(newList, stage)
+		} else {
+			found = true
+		}
+	}
+	*ss = newList
+	return found
+}
+
 // KVList represents a list of key-value pairs in the format KEY=VALUE
 type KVList []string
 
@@ -110,13 +380,212 @@ func (kv KVList) Get(key string) string {
 	return ""
 }
 
+// MaskedValue replaces the values of sensitive variables in masked configurations.
+const MaskedValue = "***"
+
+// sensitiveKeyPatterns are the name fragments of variables whose values are masked.
+var sensitiveKeyPatterns = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "KEY", "CREDENTIAL"}
+
+// IsSensitiveKey reports whether a variable name suggests that its value is sensitive.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range sensitiveKeyPatterns {
+		if strings.Contains(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked returns a copy of the list where the values of sensitive variables are replaced by MaskedValue.
+func (kv KVList) Masked() KVList {
+	if kv == nil {
+		return nil
+	}
+	masked := make(KVList, 0, len(kv))
+	for _, item := range kv {
+		if key, _ := kv.parseKeyValue(item); IsSensitiveKey(key) {
+			item = key + "=" + MaskedValue
+		}
+		masked = append(masked, item)
+	}
+	return masked
+}
+
+// Unmasked returns a copy of the list where masked values are restored from previous, so that a masked
+// configuration handed back by an agent doesn't overwrite the actual values.
+func (kv KVList) Unmasked(previous KVList) KVList {
+	if kv == nil {
+		return nil
+	}
+	unmasked := make(KVList, 0, len(kv))
+	for _, item := range kv {
+		if key, value := kv.parseKeyValue(item); value == MaskedValue && slices.Contains(previous.Keys(), key) {
+			item = key + "=" + previous.Get(key)
+		}
+		unmasked = append(unmasked, item)
+	}
+	return unmasked
+}
+
+// Masked returns a copy of the config safe to display: values of sensitive environment variables,
+// including those of services, are replaced by MaskedValue. The original config must be used for execution.
+func (config *EnvironmentConfig) Masked() *EnvironmentConfig {
+	if config == nil {
+		return nil
+	}
+	masked := config.Copy()
+	masked.Env = config.Env.Masked()
+	for _, svc := range masked.Services {
+		svc.Env = KVList(svc.Env).Masked()
+	}
+	return masked
+}
+
+// Validate reports the first problem that would prevent building an environment from the config.
+func (config *EnvironmentConfig) Validate() error {
+	if config.BaseImage == "" {
+		return errors.New("base_image is required")
+	}
+	if config.BaseImageDigest != "" && !imageDigestPattern.MatchString(config.BaseImageDigest) {
+		return fmt.Errorf("invalid base_image_digest %q: must be sha256:<64 hex digits>", config.BaseImageDigest)
+	}
+	if !strings.HasPrefix(config.Workdir, "/") {
+		return fmt.Errorf("workdir must be an absolute path, got %q", config.Workdir)
+	}
+	for _, env := range config.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("invalid env variable %q: must be KEY=VALUE", env)
+		}
+	}
+	for _, secret := range config.Secrets {
+		if !strings.Contains(secret, "=") {
+			return fmt.Errorf("invalid secret %q: must be KEY=VALUE", secret)
+		}
+	}
+	for i, svc := range config.Services {
+		if svc.Name == "" || svc.Image == "" {
+			return fmt.Errorf("service %d: name and image are required", i+1)
+		}
+		if config.Services.Get(svc.Name) != svc {
+			return fmt.Errorf("service %s is defined more than once", svc.Name)
+		}
+		if svc.StartupTimeout < 0 {
+			return fmt.Errorf("service %s: startup_timeout must not be negative", svc.Name)
+		}
+	}
+	if err := config.SetupStages.validate(); err != nil {
+		return err
+	}
+	return config.Volumes.validate(config.Workdir)
+}
+
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// SetBaseImage changes the base image, dropping the digest the previous one was pinned to.
+func (config *EnvironmentConfig) SetBaseImage(image string) {
+	if image != config.BaseImage {
+		config.BaseImageDigest = ""
+	}
+	config.BaseImage = image
+}
+
+// BaseImageRef returns the reference the base image is pulled from: BaseImage, pinned to BaseImageDigest if set.
+func (config *EnvironmentConfig) BaseImageRef() string {
+	if config.BaseImageDigest == "" || strings.Contains(config.BaseImage, "@") {
+		return config.BaseImage
+	}
+	return config.BaseImage + "@" + config.BaseImageDigest
+}
+
+func (ss SetupStages) validate() error {
+	for _, stage := range ss {
+		// Stage names end up in cache keys, like volume names
+		if !volumeNamePattern.MatchString(stage.Name) {
+			return fmt.Errorf("invalid setup stage name %q: use letters, digits, '.', '_' and '-'", stage.Name)
+		}
+		if ss.Get(stage.Name) != stage {
+			return fmt.Errorf("setup stage %s is defined more than once", stage.Name)
+		}
+		if len(stage.Commands) == 0 {
+			return fmt.Errorf("setup stage %s has no commands", stage.Name)
+		}
+		for _, path := range stage.CachePaths {
+			if !filepath.IsAbs(path) || filepath.Clean(path) == "/" {
+				return fmt.Errorf("setup stage %s: cache path must be an absolute directory other than /, got %q", stage.Name, path)
+			}
+		}
+	}
+	return nil
+}
+
+func (vc VolumeConfigs) validate(workdir string) error {
+	mountPaths := map[string]string{}
+	for _, volume := range vc {
+		if err := volume.validate(workdir); err != nil {
+			return err
+		}
+		if vc.Get(volume.Name) != volume {
+			return fmt.Errorf("volume %s is defined more than once", volume.Name)
+		}
+		path := filepath.Clean(volume.Path)
+		if other, ok := mountPaths[path]; ok {
+			return fmt.Errorf("volumes %s and %s are mounted at the same path %s", other, volume.Name, path)
+		}
+		mountPaths[path] = volume.Name
+	}
+	return nil
+}
+
+func (v *VolumeConfig) validate(workdir string) error {
+	if !volumeNamePattern.MatchString(v.Name) {
+		return fmt.Errorf("invalid volume name %q: use letters, digits, '.', '_' and '-'", v.Name)
+	}
+	if !filepath.IsAbs(v.Path) {
+		return fmt.Errorf("volume %s: path must be absolute, got %q", v.Name, v.Path)
+	}
+	path := filepath.Clean(v.Path)
+	if path == "/" || path == ContextMountPath {
+		return fmt.Errorf("volume %s can't be mounted at %s", v.Name, path)
+	}
+	// Mounts inside the workdir would end up in the environment's branch
+	if rel, err := filepath.Rel(filepath.Clean(workdir), path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("volume %s can't be mounted inside the workdir %s", v.Name, workdir)
+	}
+	if rel, err := filepath.Rel(path, filepath.Clean(workdir)); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("volume %s can't be mounted over the workdir %s", v.Name, workdir)
+	}
+	if v.Sharing != "" && v.Sharing != VolumeSharingShared && v.Sharing != VolumeSharingLocked {
+		return fmt.Errorf("volume %s: invalid sharing %q: must be %s or %s", v.Name, v.Sharing, VolumeSharingShared, VolumeSharingLocked)
+	}
+	return nil
+}
+
 func (config *EnvironmentConfig) Copy() *EnvironmentConfig {
 	copy := *config
+	copy.GitConfig = maps.Clone(config.GitConfig)
+	copy.Variables = maps.Clone(config.Variables)
 	copy.Services = make(ServiceConfigs, len(config.Services))
 	for i, svc := range config.Services {
 		svcCopy := *svc
 		copy.Services[i] = &svcCopy
 	}
+	if config.SetupStages != nil {
+		copy.SetupStages = make(SetupStages, len(config.SetupStages))
+		for i, stage := range config.SetupStages {
+			stageCopy := *stage
+			stageCopy.Commands = slices.Clone(stage.Commands)
+			stageCopy.CachePaths = slices.Clone(stage.CachePaths)
+			copy.SetupStages[i] = &stageCopy
+		}
+	}
+	if config.Volumes != nil {
+		copy.Volumes = make(VolumeConfigs, len(config.Volumes))
+		for i, volume := range config.Volumes {
+			volumeCopy := *volume
+			copy.Volumes[i] = &volumeCopy
+		}
+	}
 	return &copy
 }
 
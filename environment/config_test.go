@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -154,6 +155,148 @@ func TestEnvironmentConfig_PreservesShellOperators(t *testing.T) {
 	}
 }
 
+// TestServicePort_JSON tests that bare port numbers stay TCP for backward compatibility
+// and that other protocols round-trip in the "PORT/PROTOCOL" format
+func TestServicePort_JSON(t *testing.T) {
+	var cfg ServiceConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"name":"dns","exposed_ports":[8080,"53/udp","9000/TCP"]}`), &cfg))
+	assert.Equal(t, []ServicePort{
+		{Port: 8080, Protocol: ProtocolTCP},
+		{Port: 53, Protocol: ProtocolUDP},
+		{Port: 9000, Protocol: ProtocolTCP},
+	}, cfg.ExposedPorts)
+
+	data, err := json.Marshal(cfg.ExposedPorts)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[8080,"53/udp",9000]`, string(data))
+
+	for _, invalid := range []string{`"53/sctp"`, `"http"`, `"0"`, `true`, `0`, `-1`, `65536`, `"70000/udp"`} {
+		var port ServicePort
+		assert.Error(t, json.Unmarshal([]byte(invalid), &port), invalid)
+	}
+}
+
+func TestServiceConfigs_Remove(t *testing.T) {
+	services := ServiceConfigs{
+		{Name: "postgres", Image: "postgres:17"},
+		{Name: "redis", Image: "redis:7"},
+	}
+
+	assert.False(t, services.Remove("mysql"))
+	assert.Len(t, services, 2)
+
+	assert.True(t, services.Remove("postgres"))
+	require.Len(t, services, 1)
+	assert.Equal(t, "redis", services[0].Name)
+	assert.Nil(t, services.Get("postgres"))
+}
+
+func TestEnvironmentConfig_ValidateVolumes(t *testing.T) {
+	config := DefaultConfig()
+	config.Volumes = VolumeConfigs{
+		{Name: "datasets", Path: "/data"},
+		{Name: "pip-cache", Path: "/root/.cache/pip", Sharing: VolumeSharingLocked},
+	}
+	require.NoError(t, config.Validate())
+
+	for volume, expected := range map[VolumeConfig]string{
+		{Name: "", Path: "/data"}:                           "invalid volume name",
+		{Name: "../escape", Path: "/data"}:                  "invalid volume name",
+		{Name: "cache", Path: "data"}:                       "path must be absolute",
+		{Name: "cache", Path: "/workdir/node_modules"}:      "inside the workdir",
+		{Name: "cache", Path: "/workdir"}:                   "inside the workdir",
+		{Name: "cache", Path: "/"}:                          "can't be mounted at /",
+		{Name: "cache", Path: "/context"}:                   "can't be mounted at /context",
+		{Name: "cache", Path: "/cache", Sharing: "private"}: "invalid sharing",
+		{Name: "datasets", Path: "/other"}:                  "defined more than once",
+		{Name: "other", Path: "/data/"}:                     "mounted at the same path",
+	} {
+		invalid := config.Copy()
+		invalid.Volumes = append(invalid.Volumes, &volume)
+		assert.ErrorContains(t, invalid.Validate(), expected, "%+v", volume)
+	}
+
+	nested := DefaultConfig()
+	nested.Workdir = "/src/app"
+	nested.Volumes = VolumeConfigs{{Name: "cache", Path: "/src"}}
+	assert.ErrorContains(t, nested.Validate(), "over the workdir")
+}
+
+func TestEnvironmentConfig_ValidateSetupStages(t *testing.T) {
+	config := DefaultConfig()
+	config.SetupStages = SetupStages{
+		{Name: "os", Commands: []string{"apt-get update"}, CachePaths: []string{"/var/cache/apt"}},
+		{Name: "deps", Commands: []string{"pip install -r requirements.txt"}, CacheKey: "v2"},
+	}
+	require.NoError(t, config.Validate())
+
+	for _, tt := range []struct {
+		stage    SetupStage
+		expected string
+	}{
+		{SetupStage{Name: "", Commands: []string{"true"}}, "invalid setup stage name"},
+		{SetupStage{Name: "os", Commands: []string{"true"}}, "defined more than once"},
+		{SetupStage{Name: "empty"}, "has no commands"},
+		{SetupStage{Name: "cache", Commands: []string{"true"}, CachePaths: []string{"cache"}}, "cache path must be an absolute directory"},
+		{SetupStage{Name: "cache", Commands: []string{"true"}, CachePaths: []string{"/"}}, "cache path must be an absolute directory"},
+	} {
+		invalid := config.Copy()
+		invalid.SetupStages = append(invalid.SetupStages, &tt.stage)
+		assert.ErrorContains(t, invalid.Validate(), tt.expected, "%+v", tt.stage)
+	}
+
+	// Copies don't share the stages' commands
+	copied := config.Copy()
+	copied.SetupStages[0].Commands[0] = "apt-get upgrade"
+	assert.Equal(t, "apt-get update", config.SetupStages[0].Commands[0])
+}
+
+func TestEnvironmentConfig_BaseImageDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+
+	config := DefaultConfig()
+	config.BaseImage = "python:3.12"
+	assert.Equal(t, "python:3.12", config.BaseImageRef())
+
+	config.BaseImageDigest = digest
+	require.NoError(t, config.Validate())
+	assert.Equal(t, "python:3.12@"+digest, config.BaseImageRef())
+
+	// Images pinned in their reference are used as is
+	pinned := config.Copy()
+	pinned.BaseImage = "python:3.12@" + digest
+	assert.Equal(t, pinned.BaseImage, pinned.BaseImageRef())
+
+	config.SetBaseImage("python:3.12")
+	assert.Equal(t, digest, config.BaseImageDigest, "setting the same image keeps the pin")
+	config.SetBaseImage("python:3.13")
+	assert.Empty(t, config.BaseImageDigest, "a new image drops the pin")
+	assert.Equal(t, "python:3.13", config.BaseImageRef())
+
+	config.BaseImageDigest = "latest"
+	assert.ErrorContains(t, config.Validate(), "invalid base_image_digest")
+}
+
+func TestEnvironmentConfig_Masked(t *testing.T) {
+	config := DefaultConfig()
+	config.Env = KVList{"API_TOKEN=abc123", "GITHUB_Key=ghp_xyz", "DEBUG=1"}
+	config.Services = ServiceConfigs{
+		{Name: "db", Image: "postgres", Env: []string{"POSTGRES_PASSWORD=hunter2", "POSTGRES_DB=app"}},
+	}
+
+	masked := config.Masked()
+	assert.Equal(t, KVList{"API_TOKEN=***", "GITHUB_Key=***", "DEBUG=1"}, masked.Env)
+	assert.Equal(t, []string{"POSTGRES_PASSWORD=***", "POSTGRES_DB=app"}, masked.Services[0].Env)
+
+	// The original values are kept for execution
+	assert.Equal(t, "abc123", config.Env.Get("API_TOKEN"))
+	assert.Equal(t, "POSTGRES_PASSWORD=hunter2", config.Services[0].Env[0])
+
+	// Masked values handed back are restored, new values are taken as is
+	updated := KVList{"API_TOKEN=***", "GITHUB_Key=ghp_new", "NEW_SECRET=***"}.Unmasked(config.Env)
+	assert.Equal(t, KVList{"API_TOKEN=abc123", "GITHUB_Key=ghp_new", "NEW_SECRET=***"}, updated)
+}
+
 // Test helper functions
 func createInstructionsFile(t *testing.T, dir, content string) {
 	t.Helper()
@@ -0,0 +1,193 @@
+package environment
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigChange is a difference between two configurations, see DiffConfigs.
+type ConfigChange struct {
+	// Field is the configuration field, as named in environment.json (e.g. "base_image", "env").
+	Field string `json:"field"`
+	// Key identifies the entry of keyed fields: the name of a variable, service, volume or setup stage.
+	Key string `json:"key,omitempty"`
+	// From is empty for additions, To for removals.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func (c ConfigChange) String() string {
+	name := c.Field
+	if c.Key != "" {
+		name += " " + c.Key
+	}
+	switch {
+	case c.From == "":
+		return fmt.Sprintf("+ %s: %s", name, c.To)
+	case c.To == "":
+		return fmt.Sprintf("- %s: %s", name, c.From)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", name, c.From, c.To)
+	}
+}
+
+// DiffConfigs returns the changes from configuration a to b, in the order of the fields of EnvironmentConfig.
+// Values of sensitive variables are masked, a change being reported as "*** -> ***".
+func DiffConfigs(a, b *EnvironmentConfig) []ConfigChange {
+	changes := []ConfigChange{}
+	scalar := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, ConfigChange{Field: field, From: from, To: to})
+		}
+	}
+	list := func(field string, from, to []string) {
+		changes = append(changes, diffLists(field, from, to)...)
+	}
+	keyed := func(field string, from, to map[string]string) {
+		changes = append(changes, diffMaps(field, from, to)...)
+	}
+
+	scalar("workdir", a.Workdir, b.Workdir)
+	scalar("base_image", a.BaseImage, b.BaseImage)
+	list("setup_commands", a.SetupCommands, b.SetupCommands)
+	keyed("setup_stages", stagesByName(a.SetupStages), stagesByName(b.SetupStages))
+	list("install_commands", a.InstallCommands, b.InstallCommands)
+	list("seed_commands", a.SeedCommands, b.SeedCommands)
+	for _, change := range diffMaps("env", kvMap(a.Env), kvMap(b.Env)) {
+		if IsSensitiveKey(change.Key) {
+			change.From, change.To = maskValue(change.From), maskValue(change.To)
+		}
+		changes = append(changes, change)
+	}
+	// Secrets are references (e.g. "op://vault/item/field"), not the secret values
+	keyed("secrets", kvMap(a.Secrets), kvMap(b.Secrets))
+	keyed("services", servicesByName(a.Services), servicesByName(b.Services))
+	list("path_prepend", a.PathPrepend, b.PathPrepend)
+	scalar("context_dir", a.ContextDir, b.ContextDir)
+	scalar("ca_bundle", a.CABundle, b.CABundle)
+	keyed("volumes", volumesByName(a.Volumes), volumesByName(b.Volumes))
+	keyed("git_config", a.GitConfig, b.GitConfig)
+	keyed("variables", a.Variables, b.Variables)
+	scalar("auto_title", strconv.FormatBool(a.AutoTitle), strconv.FormatBool(b.AutoTitle))
+	scalar("base_image_digest", a.BaseImageDigest, b.BaseImageDigest)
+	scalar("pin_base_image", strconv.FormatBool(a.PinBaseImage), strconv.FormatBool(b.PinBaseImage))
+	scalar("delete_after_merge", strconv.FormatBool(a.DeleteAfterMerge), strconv.FormatBool(b.DeleteAfterMerge))
+	return changes
+}
+
+func maskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return MaskedValue
+}
+
+// diffLists reports the entries of an ordered list removed from and added to it. Lists with the same
+// entries in a different order are reported as a whole.
+func diffLists(field string, from, to []string) []ConfigChange {
+	if slices.Equal(from, to) {
+		return nil
+	}
+	changes := []ConfigChange{}
+	remaining := slices.Clone(to)
+	for _, entry := range from {
+		if i := slices.Index(remaining, entry); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: field, From: entry})
+	}
+	available := slices.Clone(from)
+	for _, entry := range to {
+		if i := slices.Index(available, entry); i >= 0 {
+			available = slices.Delete(available, i, i+1)
+			continue
+		}
+		changes = append(changes, ConfigChange{Field: field, To: entry})
+	}
+	if len(changes) == 0 {
+		return []ConfigChange{{Field: field, From: strings.Join(from, "; "), To: strings.Join(to, "; ")}}
+	}
+	return changes
+}
+
+// diffMaps reports the entries removed, added and changed, sorted by key.
+func diffMaps(field string, from, to map[string]string) []ConfigChange {
+	changes := []ConfigChange{}
+	keys := slices.Sorted(maps.Keys(from))
+	for key := range maps.Keys(to) {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fromValue, inFrom := from[key]
+		toValue, inTo := to[key]
+		switch {
+		case !inTo:
+			changes = append(changes, ConfigChange{Field: field, Key: key, From: fromValue})
+		case !inFrom:
+			changes = append(changes, ConfigChange{Field: field, Key: key, To: toValue})
+		case fromValue != toValue:
+			changes = append(changes, ConfigChange{Field: field, Key: key, From: fromValue, To: toValue})
+		}
+	}
+	return changes
+}
+
+func kvMap(kv KVList) map[string]string {
+	m := map[string]string{}
+	for _, key := range kv.Keys() {
+		m[key] = kv.Get(key)
+	}
+	return m
+}
+
+func stagesByName(stages SetupStages) map[string]string {
+	m := map[string]string{}
+	for _, stage := range stages {
+		// The cache hints follow the name, e.g. "os (cache key v2)"
+		m[stage.Name] = strings.Join(stage.Commands, "; ") + strings.TrimPrefix(stage.String(), stage.Name)
+	}
+	return m
+}
+
+func servicesByName(services ServiceConfigs) map[string]string {
+	m := map[string]string{}
+	for _, service := range services {
+		details := []string{service.Image}
+		if service.Command != "" {
+			details = append(details, "command: "+service.Command)
+		}
+		if len(service.ExposedPorts) > 0 {
+			ports := make([]string, 0, len(service.ExposedPorts))
+			for _, port := range service.ExposedPorts {
+				ports = append(ports, port.String())
+			}
+			details = append(details, "ports: "+strings.Join(ports, ", "))
+		}
+		if len(service.Env) > 0 {
+			details = append(details, "env: "+strings.Join(KVList(service.Env).Masked(), " "))
+		}
+		if service.RegistryUsername != "" {
+			details = append(details, "registry user: "+service.RegistryUsername)
+		}
+		if service.StartupTimeout > 0 {
+			details = append(details, fmt.Sprintf("startup timeout: %ds", service.StartupTimeout))
+		}
+		m[service.Name] = strings.Join(details, ", ")
+	}
+	return m
+}
+
+func volumesByName(volumes VolumeConfigs) map[string]string {
+	m := map[string]string{}
+	for _, volume := range volumes {
+		m[volume.Name] = strings.TrimPrefix(volume.String(), volume.Name+" ")
+	}
+	return m
+}
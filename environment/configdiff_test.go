@@ -0,0 +1,54 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	a := DefaultConfig()
+	a.SetupCommands = []string{"apt-get update", "apt-get install -y curl"}
+	a.Env = KVList{"DEBUG=0", "API_TOKEN=abc", "LANG=C"}
+	a.Services = ServiceConfigs{{Name: "db", Image: "postgres:16", ExposedPorts: []ServicePort{{Port: 5432, Protocol: ProtocolTCP}}}}
+	a.Volumes = VolumeConfigs{{Name: "datasets", Path: "/data"}}
+
+	assert.Empty(t, DiffConfigs(a, a.Copy()))
+
+	b := a.Copy()
+	b.BaseImage = "python:3.12"
+	b.SetupCommands = []string{"apt-get update", "apt-get install -y git"}
+	b.Env = KVList{"DEBUG=1", "API_TOKEN=xyz", "PYTHONPATH=/src"}
+	b.Services = ServiceConfigs{{Name: "db", Image: "postgres:17", ExposedPorts: []ServicePort{{Port: 5432, Protocol: ProtocolTCP}}}}
+	b.Volumes = VolumeConfigs{{Name: "datasets", Path: "/data", Sharing: VolumeSharingLocked}}
+	b.SetupStages = SetupStages{{Name: "tools", Commands: []string{"pip install ruff"}, CacheKey: "v2"}}
+	b.AutoTitle = true
+	b.DeleteAfterMerge = true
+
+	assert.Equal(t, []ConfigChange{
+		{Field: "base_image", From: "ubuntu:24.04", To: "python:3.12"},
+		{Field: "setup_commands", From: "apt-get install -y curl"},
+		{Field: "setup_commands", To: "apt-get install -y git"},
+		{Field: "setup_stages", Key: "tools", To: "pip install ruff (cache key v2)"},
+		{Field: "env", Key: "API_TOKEN", From: MaskedValue, To: MaskedValue},
+		{Field: "env", Key: "DEBUG", From: "0", To: "1"},
+		{Field: "env", Key: "LANG", From: "C"},
+		{Field: "env", Key: "PYTHONPATH", To: "/src"},
+		{Field: "services", Key: "db", From: "postgres:16, ports: 5432", To: "postgres:17, ports: 5432"},
+		{Field: "volumes", Key: "datasets", From: "at /data", To: "at /data (locked)"},
+		{Field: "auto_title", From: "false", To: "true"},
+		{Field: "delete_after_merge", From: "false", To: "true"},
+	}, DiffConfigs(a, b))
+}
+
+func TestDiffConfigs_Reordered(t *testing.T) {
+	a := DefaultConfig()
+	a.InstallCommands = []string{"npm ci", "npm run build"}
+	b := a.Copy()
+	b.InstallCommands = []string{"npm run build", "npm ci"}
+
+	changes := DiffConfigs(a, b)
+	assert.Equal(t, []ConfigChange{{Field: "install_commands", From: "npm ci; npm run build", To: "npm run build; npm ci"}}, changes)
+	assert.Equal(t, "~ install_commands: npm ci; npm run build -> npm run build; npm ci", changes[0].String())
+	assert.Equal(t, "+ env DEBUG: 1", ConfigChange{Field: "env", Key: "DEBUG", To: "1"}.String())
+}
@@ -2,9 +2,17 @@ package environment
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,9 +36,33 @@ type Environment struct {
 	Services []*Service
 	Notes    Notes
 
+	// Head is the commit of the environment's branch the environment was loaded from or last saved to.
+	// The repository uses it to detect changes saved concurrently by other processes.
+	Head string
+
+	// DeferServices makes builds bind the services to the container without starting them, and keep the endpoints
+	// of the services already running. It is set by processes other than the MCP server running the environment,
+	// e.g. the CLI: the services they start, and the tunnels exposing them on the host, stop when they exit.
+	DeferServices bool
+
+	// progress is notified while the environment is being built, may be nil
+	progress ProgressFunc
+
 	mu sync.RWMutex
 }
 
+// ProgressFunc receives human readable progress messages, e.g. while an environment's setup commands run.
+type ProgressFunc func(message string)
+
+// maxProgressOutputLines bounds the command output included in progress messages.
+const maxProgressOutputLines = 10
+
+func (env *Environment) reportProgress(format string, args ...any) {
+	if env.progress != nil {
+		env.progress(fmt.Sprintf(format, args...))
+	}
+}
+
 // NewEnvArgs contains the arguments for creating a new environment
 type NewEnvArgs struct {
 	Dag              *dagger.Client
@@ -39,21 +71,33 @@ type NewEnvArgs struct {
 	Config           *EnvironmentConfig
 	InitialSourceDir *dagger.Directory
 	SubmodulePaths   []string
+	SourcePath       string
+	// Progress, if set, is notified of the setup, install and seed commands and services as they run.
+	Progress ProgressFunc
+	// Variables are the values of the template variables referenced in Config, see EnvironmentConfig.Resolve.
+	Variables map[string]string
 }
 
 func New(ctx context.Context, args NewEnvArgs) (*Environment, error) {
+	config, err := args.Config.Resolve(args.Variables)
+	if err != nil {
+		return nil, err
+	}
+
 	env := &Environment{
 		EnvironmentInfo: &EnvironmentInfo{
 			ID: args.ID,
 			State: &State{
-				Config:         args.Config,
+				Config:         config,
 				Title:          args.Title,
 				CreatedAt:      time.Now(),
 				UpdatedAt:      time.Now(),
 				SubmodulePaths: args.SubmodulePaths,
+				SourcePath:     args.SourcePath,
 			},
 		},
-		dag: args.Dag,
+		dag:      args.Dag,
+		progress: args.Progress,
 	}
 
 	container, err := env.buildBase(ctx, args.InitialSourceDir)
@@ -169,21 +213,173 @@ func containerWithEnvAndSecrets(dag *dagger.Client, container *dagger.Container,
 	return container, nil
 }
 
+// seedCacheBusterEnv is set while seed commands run so that they're never served from the cache.
+const seedCacheBusterEnv = "_CONTAINER_USE_SEED"
+
+// ContextMountPath is where the configured context directory is mounted in the environment.
+const ContextMountPath = "/context"
+
+// contextDirPath returns the host path of the configured context directory.
+func (env *Environment) contextDirPath() (string, error) {
+	dir := env.State.Config.ContextDir
+	if !filepath.IsAbs(dir) {
+		if env.State.SourcePath == "" {
+			return "", fmt.Errorf("context directory %q must be an absolute path for environments created by older versions", dir)
+		}
+		dir = filepath.Join(env.State.SourcePath, dir)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("context directory: %w", err)
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("context directory %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// containerWithGitConfig passes git configuration through the GIT_CONFIG_COUNT, GIT_CONFIG_KEY_<n>
+// and GIT_CONFIG_VALUE_<n> variables, so it applies to every git command regardless of the user or $HOME.
+func containerWithGitConfig(container *dagger.Container, gitConfig map[string]string) *dagger.Container {
+	if len(gitConfig) == 0 {
+		return container
+	}
+
+	keys := slices.Sorted(maps.Keys(gitConfig))
+	for i, key := range keys {
+		container = container.
+			WithEnvVariable(fmt.Sprintf("GIT_CONFIG_KEY_%d", i), key).
+			WithEnvVariable(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i), gitConfig[key])
+	}
+	return container.WithEnvVariable("GIT_CONFIG_COUNT", strconv.Itoa(len(keys)))
+}
+
+// volumeCacheKey namespaces the cache volumes of named volumes, to keep them apart from other Dagger caches.
+func volumeCacheKey(name string) string {
+	return "container-use-volume-" + name
+}
+
+// containerWithVolumes mounts the named volumes. They are cache volumes shared by every container mounting
+// them, whatever their environment.
+func containerWithVolumes(dag *dagger.Client, container *dagger.Container, volumes VolumeConfigs, workdir string) (*dagger.Container, error) {
+	if err := volumes.validate(workdir); err != nil {
+		return nil, err
+	}
+	for _, volume := range volumes {
+		sharing := dagger.CacheSharingModeShared
+		if volume.Sharing == VolumeSharingLocked {
+			sharing = dagger.CacheSharingModeLocked
+		}
+		container = container.WithMountedCache(volume.Path, dag.CacheVolume(volumeCacheKey(volume.Name)), dagger.ContainerWithMountedCacheOpts{
+			Sharing: sharing,
+		})
+	}
+	return container, nil
+}
+
+// stageCacheBusterEnv is set while the commands of a setup stage with a cache hint run, so that changing
+// the hint reruns the stage.
+const stageCacheBusterEnv = "_CONTAINER_USE_STAGE_CACHE_KEY"
+
+// stageCacheVolumeKey names the cache volume of a cache path of setup stages. Stages of environments using
+// the same base image share their caches: a package cache filled for one image is useless for another.
+func stageCacheVolumeKey(baseImage, path string) string {
+	sum := sha256.Sum256([]byte(baseImage + "\x00" + filepath.Clean(path)))
+	return "container-use-stage-cache-" + hex.EncodeToString(sum[:8])
+}
+
+// containerWithStageCache applies the cache hints of a setup stage before its commands run.
+func containerWithStageCache(dag *dagger.Client, container *dagger.Container, stage *SetupStage, baseImage string) *dagger.Container {
+	switch {
+	case stage.NoCache:
+		container = container.WithEnvVariable(stageCacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10))
+	case stage.CacheKey != "":
+		container = container.WithEnvVariable(stageCacheBusterEnv, stage.CacheKey)
+	}
+	for _, path := range stage.CachePaths {
+		// Package managers don't expect concurrent writers to their caches
+		container = container.WithMountedCache(path, dag.CacheVolume(stageCacheVolumeKey(baseImage, path)), dagger.ContainerWithMountedCacheOpts{
+			Sharing: dagger.CacheSharingModeLocked,
+		})
+	}
+	return container
+}
+
+// containerWithoutStageCache reverts containerWithStageCache once the commands of the stage have run.
+func containerWithoutStageCache(container *dagger.Container, stage *SetupStage) *dagger.Container {
+	if stage.NoCache || stage.CacheKey != "" {
+		container = container.WithoutEnvVariable(stageCacheBusterEnv)
+	}
+	for _, path := range stage.CachePaths {
+		container = container.WithoutMount(path)
+	}
+	return container
+}
+
+// defaultPath is used as the PATH of images that don't set one.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// containerWithPathPrepend adds dirs in front of the PATH of the container, so that every command finds
+// the tools installed there without having to export PATH.
+func containerWithPathPrepend(ctx context.Context, container *dagger.Container, dirs []string) (*dagger.Container, error) {
+	if len(dirs) == 0 {
+		return container, nil
+	}
+
+	path, err := container.EnvVariable(ctx, "PATH")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PATH: %w", err)
+	}
+	return container.WithEnvVariable("PATH", prependPath(dirs, path)), nil
+}
+
+// prependPath returns path with dirs in front of it, in order. Directories already in path are moved to the front.
+func prependPath(dirs []string, path string) string {
+	if path == "" {
+		path = defaultPath
+	}
+	entries := slices.Clone(dirs)
+	for entry := range strings.SplitSeq(path, ":") {
+		if !slices.Contains(dirs, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(entries, ":")
+}
+
 func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Directory) (*dagger.Container, error) {
+	if config := env.State.Config; config.PinBaseImage && config.BaseImageDigest == "" && !strings.Contains(config.BaseImage, "@") {
+		digest, err := ResolveImageDigest(ctx, env.dag, config.BaseImage)
+		if err != nil {
+			return nil, err
+		}
+		config.BaseImageDigest = digest
+		env.reportProgress("Pinned base image %s to %s", config.BaseImage, digest)
+	}
+
 	container := env.dag.
 		Container().
-		From(env.State.Config.BaseImage).
+		From(env.State.Config.BaseImageRef()).
 		WithWorkdir(env.State.Config.Workdir)
 
-	container, err := containerWithEnvAndSecrets(env.dag, container, env.State.Config.Env, env.State.Config.Secrets)
+	// Install the CAs first, setup commands may download packages through a TLS intercepting proxy
+	container, err := env.containerWithCABundle(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	container, err = containerWithEnvAndSecrets(env.dag, container, env.State.Config.Env, env.State.Config.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	container = containerWithGitConfig(container, env.State.Config.GitConfig)
+	container, err = containerWithPathPrepend(ctx, container, env.State.Config.PathPrepend)
 	if err != nil {
 		return nil, err
 	}
 
-	runCommands := func(commands []string) error {
-		for _, command := range commands {
+	runCommands := func(kind string, commands []string) error {
+		for i, command := range commands {
 			var err error
 
+			env.reportProgress("Running %s command %d/%d: %s", kind, i+1, len(commands), command)
 			container = container.WithExec([]string{"sh", "-c", command})
 
 			exitCode, err := container.ExitCode(ctx)
@@ -191,6 +387,7 @@ func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Dir
 				var exitErr *dagger.ExecError
 				if errors.As(err, &exitErr) {
 					env.Notes.AddCommand(command, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+					env.reportProgress("Failed %s command %d/%d with exit code %d%s", kind, i+1, len(commands), exitErr.ExitCode, progressOutput(exitErr.Stdout, exitErr.Stderr))
 					return fmt.Errorf("exit code %d.\nstdout: %s\nstderr: %s\n%w", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr, err)
 				}
 
@@ -207,96 +404,348 @@ func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Dir
 			}
 
 			env.Notes.AddCommand(command, exitCode, stdout, stderr)
+			env.reportProgress("Finished %s command %d/%d%s", kind, i+1, len(commands), progressOutput(stdout, stderr))
 		}
 
 		return nil
 	}
 
 	// Run setup commands without the source directory for caching purposes
-	if err := runCommands(env.State.Config.SetupCommands); err != nil {
+	if err := runCommands("setup", env.State.Config.SetupCommands); err != nil {
 		return nil, fmt.Errorf("setup command failed: %w", err)
 	}
 
-	env.Services, err = env.startServices(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to start services: %w", err)
+	// Each stage builds on the layers of the previous ones, so changing a stage keeps the cache of the earlier ones
+	if err := env.State.Config.SetupStages.validate(); err != nil {
+		return nil, err
 	}
-	for _, service := range env.Services {
-		container = container.WithServiceBinding(service.Config.Name, service.svc)
+	for _, stage := range env.State.Config.SetupStages {
+		container = containerWithStageCache(env.dag, container, stage, env.State.Config.BaseImage)
+		if err := runCommands(stage.Name+" stage", stage.Commands); err != nil {
+			return nil, fmt.Errorf("setup stage %s failed: %w", stage.Name, err)
+		}
+		container = containerWithoutStageCache(container, stage)
+	}
+
+	if env.DeferServices {
+		container, err = env.bindServices(container)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind services: %w", err)
+		}
+	} else {
+		env.Services, err = env.startServices(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start services: %w", err)
+		}
+		for _, service := range env.Services {
+			container = container.WithServiceBinding(service.Config.Name, service.svc)
+		}
+	}
+
+	// Mount volumes before the install commands, so they can use them (e.g. as a shared package cache)
+	container, err = containerWithVolumes(env.dag, container, env.State.Config.Volumes, env.State.Config.Workdir)
+	if err != nil {
+		return nil, err
 	}
 
 	container = container.WithDirectory(".", baseSourceDir)
 
 	// Run the install commands after the source directory is set up
-	if err := runCommands(env.State.Config.InstallCommands); err != nil {
+	if err := runCommands("install", env.State.Config.InstallCommands); err != nil {
 		return nil, fmt.Errorf("install command failed: %w", err)
 	}
 
+	// Mount the context last so that changes to it don't invalidate the cache of setup and install commands.
+	// Mounts live outside the workdir, so the context is never exported to the environment's branch.
+	if env.State.Config.ContextDir != "" {
+		contextDir, err := env.contextDirPath()
+		if err != nil {
+			return nil, err
+		}
+		container = container.WithMountedDirectory(ContextMountPath, env.dag.Host().Directory(contextDir))
+	}
+
+	// Seed commands populate the services started above (e.g. load fixtures in a database), which start from
+	// scratch every time the environment is built: unlike setup and install commands, they must never be cached.
+	if len(env.State.Config.SeedCommands) > 0 {
+		container = container.WithEnvVariable(seedCacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10))
+		if err := runCommands("seed", env.State.Config.SeedCommands); err != nil {
+			return nil, fmt.Errorf("seed command failed: %w", err)
+		}
+		container = container.WithoutEnvVariable(seedCacheBusterEnv)
+	}
+
 	return container, nil
 }
 
+// progressOutput formats the end of a command's output for a progress message.
+func progressOutput(stdout, stderr string) string {
+	output := strings.TrimSpace(strings.TrimSpace(stdout) + "\n" + strings.TrimSpace(stderr))
+	if output == "" {
+		return ""
+	}
+	lines := strings.Split(output, "\n")
+	if len(lines) > maxProgressOutputLines {
+		lines = lines[len(lines)-maxProgressOutputLines:]
+	}
+	return ":\n" + strings.Join(lines, "\n")
+}
+
 func (env *Environment) UpdateConfig(ctx context.Context, newConfig *EnvironmentConfig) error {
+	newConfig, err := newConfig.ResolveUpdate(env.State.Config)
+	if err != nil {
+		return err
+	}
 	env.State.Config = newConfig
 
 	// Re-build the base image with the new config
-	container, err := env.buildBase(ctx, env.Workdir())
+	return env.Rebuild(ctx, env.Workdir())
+}
+
+// UpdateBaseImage pins the base image to the digest its tag currently resolves to, and rebuilds the environment
+// with it.
+func (env *Environment) UpdateBaseImage(ctx context.Context) (string, error) {
+	digest, err := ResolveImageDigest(ctx, env.dag, env.State.Config.BaseImage)
+	if err != nil {
+		return "", err
+	}
+	previous := env.State.Config.BaseImageDigest
+	env.State.Config.BaseImageDigest = digest
+	if err := env.Rebuild(ctx, env.Workdir()); err != nil {
+		env.State.Config.BaseImageDigest = previous
+		return "", err
+	}
+	return digest, nil
+}
+
+// ResolveImageDigest returns the digest an image reference currently resolves to (e.g. "sha256:...").
+func ResolveImageDigest(ctx context.Context, dag *dagger.Client, image string) (string, error) {
+	if strings.Contains(image, "@") {
+		return "", fmt.Errorf("image %s is already pinned to a digest", image)
+	}
+	ref, err := dag.Container().From(image).ImageRef(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve image %s: %w", image, err)
+	}
+	_, digest, ok := strings.Cut(ref, "@")
+	if !ok {
+		return "", fmt.Errorf("failed to resolve the digest of image %s: got %s", image, ref)
+	}
+	return digest, nil
+}
+
+// Rebuild re-creates the environment container from scratch: the configuration is applied
+// to a fresh base image and the given source directory is copied into the workdir.
+func (env *Environment) Rebuild(ctx context.Context, sourceDir *dagger.Directory) error {
+	container, err := env.buildBase(ctx, sourceDir)
 	if err != nil {
 		return err
 	}
 
-	if err := env.apply(ctx, container); err != nil {
+	return env.apply(ctx, container)
+}
+
+// Resume rebuilds a paused environment from the given source directory, restarting its services.
+func (env *Environment) Resume(ctx context.Context, sourceDir *dagger.Directory) error {
+	if err := env.Rebuild(ctx, sourceDir); err != nil {
 		return err
 	}
 
+	env.State.Paused = false
 	return nil
 }
 
-func (env *Environment) Run(ctx context.Context, command, shell string, useEntrypoint bool) (string, error) {
+// RunTiming breaks down where time was spent while running a command
+type RunTiming struct {
+	// Provisioning is the time spent getting the environment container ready before the command starts
+	Provisioning time.Duration `json:"provisioning"`
+	// Execution is the wall-clock time of the command itself
+	Execution time.Duration `json:"execution"`
+	// Total is the end-to-end time, including collecting the command output
+	Total time.Duration `json:"total"`
+}
+
+func (t RunTiming) String() string {
+	return fmt.Sprintf("provisioning %s, execution %s, total %s",
+		t.Provisioning.Round(time.Millisecond),
+		t.Execution.Round(time.Millisecond),
+		t.Total.Round(time.Millisecond))
+}
+
+// RunResult contains the outcome of a foreground command
+type RunResult struct {
+	// Output is stdout, followed by stderr (if any) prefixed with "stderr: ", in the requested encoding.
+	// It is always valid UTF-8.
+	Output string
+	// Stdout and Stderr are the bytes written by the command, as is.
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Timing   RunTiming
+	// InvalidUTF8 reports that the command wrote bytes that aren't valid UTF-8, replaced in text Output.
+	InvalidUTF8 bool
+	// OOMKilled reports that the command was killed by the OOM killer, see OOMKilledMessage. It is only detected
+	// for commands run with a memory limit.
+	OOMKilled bool
+}
+
+// runScriptPath is where scripts are written inside the container before being executed.
+// The file is removed once the script has run so it doesn't leak into the environment state.
+const runScriptPath = "/tmp/.container-use-script"
+
+// RunOpts contains the arguments for running a foreground command
+type RunOpts struct {
+	// Command is a single shell command. If both Command and Script are empty, the image's default command is used.
+	Command string
+	// Script is a (multiline) script written to a file and executed with Shell.
+	// It avoids quoting issues with complex commands. Mutually exclusive with Command.
+	Script        string
+	Shell         string
+	UseEntrypoint bool
+	// Stdin is fed to the standard input of the command, e.g. answers to interactive prompts.
+	Stdin string
+	// Limits caps the resources of the command, e.g. to sandbox untrusted code.
+	Limits ResourceLimits
+	// OutputEncoding is the encoding of the Output of the result: OutputEncodingText (the default) or OutputEncodingBase64.
+	OutputEncoding string
+	// Timeout stops the command if it runs longer, with ErrCommandTimeout. Zero means no timeout.
+	Timeout time.Duration
+	// TTY runs the command in a pseudo-terminal, for tools that require one or only show colors and progress in
+	// one. Stderr is then merged into stdout, and the output may contain terminal control sequences.
+	TTY bool
+}
+
+func (env *Environment) Run(ctx context.Context, opts RunOpts) (*RunResult, error) {
+	if opts.Command != "" && opts.Script != "" {
+		return nil, errors.New("command and script are mutually exclusive")
+	}
+	if err := validateOutputEncoding(opts.OutputEncoding); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result := &RunResult{}
+
+	command := opts.Command
 	args := []string{}
-	if command != "" {
-		args = []string{shell, "-c", command}
+	if opts.Command != "" {
+		args = []string{opts.Shell, "-c", opts.Command}
+	}
+
+	// Make sure the environment container is ready before starting the clock on the command,
+	// so cold containers show up as provisioning time rather than execution time.
+	container, err := env.container().Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision container: %w", err)
 	}
-	newState := env.container().WithExec(args, dagger.ContainerWithExecOpts{
-		UseEntrypoint:                 useEntrypoint,
+	result.Timing.Provisioning = time.Since(start)
+
+	if opts.Script != "" {
+		command = opts.Script
+		container = container.WithNewFile(runScriptPath, opts.Script)
+		args = []string{opts.Shell, runScriptPath}
+	}
+	if args, err = applyLimits(opts, args); err != nil {
+		return nil, err
+	}
+	if args, err = applyTTY(opts, args); err != nil {
+		return nil, err
+	}
+
+	execOpts := dagger.ContainerWithExecOpts{
+		UseEntrypoint:                 opts.UseEntrypoint,
 		Expect:                        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
 		ExperimentalPrivilegedNesting: true,
-	})
+	}
+	container, cleanupStdin, err := env.withExecStdin(container, opts.Stdin, &execOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupStdin()
+	newState := container.WithExec(args, execOpts)
 
-	exitCode, err := newState.ExitCode(ctx)
+	execStart := time.Now()
+	exitCode, err := execExitCode(ctx, newState, opts.Timeout)
+	if errors.Is(err, ErrCommandTimeout) {
+		env.Notes.AddCommand(command, exitCodeTimeout, "", err.Error())
+		return nil, err
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get exit code: %w", err)
+		return nil, fmt.Errorf("failed to get exit code: %w", err)
+	}
+	result.Timing.Execution = time.Since(execStart)
+	result.ExitCode = exitCode
+	if result.OOMKilled, err = oomKilled(ctx, newState, opts.Limits, exitCode); err != nil {
+		return nil, fmt.Errorf("failed to check for OOM kills: %w", err)
 	}
 
 	stdout, err := newState.Stdout(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stdout: %w", err)
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
 	}
 
 	stderr, err := newState.Stderr(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stderr: %w", err)
+		return nil, fmt.Errorf("failed to get stderr: %w", err)
 	}
 
 	// Log the command execution with all details
 	env.Notes.AddCommand(command, exitCode, stdout, stderr)
 
+	result.Stdout = stdout
+	result.Stderr = stderr
+	result.setOutput(opts.OutputEncoding)
+
+	if opts.Script != "" {
+		newState = newState.WithoutFile(runScriptPath)
+	}
+	if execOpts.RedirectStdin != "" {
+		newState = newState.WithoutFile(runStdinPath)
+	}
+	if result.OOMKilled {
+		newState = newState.WithoutFile(oomKilledPath)
+	}
+
 	// Always apply the container state (preserving changes even on non-zero exit)
 	if err := env.apply(ctx, newState); err != nil {
-		return stdout, fmt.Errorf("failed to apply container state: %w", err)
+		return result, fmt.Errorf("failed to apply container state: %w", err)
 	}
+	result.Timing.Total = time.Since(start)
+
+	return result, nil
+}
 
-	// Return combined output (stdout + stderr if there was stderr)
-	combinedOutput := stdout
+// combinedOutput returns stdout, followed by stderr (if any) prefixed with "stderr: ".
+func combinedOutput(stdout, stderr string) string {
+	output := stdout
 	if stderr != "" {
 		if stdout != "" {
-			combinedOutput += "\n"
+			output += "\n"
 		}
-		combinedOutput += "stderr: " + stderr
+		output += "stderr: " + stderr
 	}
-	return combinedOutput, nil
+	return output
+}
+
+// BackgroundCommand is a command started with RunBackground.
+// Its ID is the handle used to refer to it later on.
+type BackgroundCommand struct {
+	ID        string           `json:"id"`
+	Command   string           `json:"command"`
+	Ports     []int            `json:"ports,omitempty"`
+	StartedAt time.Time        `json:"started_at"`
+	Endpoints EndpointMappings `json:"endpoints,omitempty"`
+}
+
+func newBackgroundCommandID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return "bg-" + hex.EncodeToString(b)
 }
 
-func (env *Environment) RunBackground(ctx context.Context, command, shell string, ports []int, useEntrypoint bool) (EndpointMappings, error) {
+// RunBackground starts a command as a long running service of the environment and records it in the state.
+// Dagger doesn't expose the PID of service processes: commands are identified by the returned handle instead.
+func (env *Environment) RunBackground(ctx context.Context, command, shell string, ports []int, useEntrypoint bool, stdin string) (*BackgroundCommand, error) {
 	args := []string{}
 	if command != "" {
 		args = []string{shell, "-c", command}
@@ -304,6 +753,22 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 	displayCommand := command + " &"
 	serviceState := env.container()
 
+	// Services have no standard input: it's redirected from a file instead
+	if stdin != "" {
+		if useEntrypoint || len(args) == 0 {
+			return nil, errors.New("stdin of background commands requires a command, and can't be used with the image entrypoint")
+		}
+		var (
+			cleanup func()
+			err     error
+		)
+		if serviceState, cleanup, err = env.withStdinFile(serviceState, stdin); err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		args = append([]string{"sh", "-c", `exec "$@" <` + runStdinPath, "sh"}, args...)
+	}
+
 	// Expose ports
 	for _, port := range ports {
 		serviceState = serviceState.WithExposedPort(port, dagger.ContainerWithExposedPortOpts{
@@ -333,12 +798,19 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		return nil, err
 	}
 
+	bg := &BackgroundCommand{
+		ID:        newBackgroundCommandID(),
+		Command:   command,
+		Ports:     ports,
+		StartedAt: time.Now(),
+	}
+	running := trackService(env.ID, bg.ID, svc)
 	env.Notes.AddCommand(displayCommand, 0, "", "")
 
 	endpoints := EndpointMappings{}
 	for _, port := range ports {
-		endpoint := &EndpointMapping{}
-		endpoints[port] = endpoint
+		endpoint := &EndpointMapping{Protocol: ProtocolTCP}
+		endpoints[strconv.Itoa(port)] = endpoint
 
 		// Expose port on the host
 		tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
@@ -352,6 +824,7 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		if err != nil {
 			return nil, err
 		}
+		running.addTunnel(tunnel)
 
 		externalEndpoint, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{
 			Scheme: "tcp",
@@ -370,8 +843,13 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		}
 		endpoint.EnvironmentInternal = internalEndpoint
 	}
+	bg.Endpoints = endpoints
+
+	env.mu.Lock()
+	env.State.BackgroundCommands = append(env.State.BackgroundCommands, bg)
+	env.mu.Unlock()
 
-	return endpoints, nil
+	return bg, nil
 }
 
 func (env *Environment) Terminal(ctx context.Context) error {
@@ -407,6 +885,72 @@ func (env *Environment) Terminal(ctx context.Context) error {
 	return nil
 }
 
-func (env *Environment) Checkpoint(ctx context.Context, target string) (string, error) {
-	return env.container().Publish(ctx, target)
+// Shell opens an interactive shell (e.g. sh or bash) in the working directory of the environment's container, which
+// has the variables of its configuration. Unlike Terminal, the requested shell is used as is, without a custom prompt.
+// Nothing done in the shell is committed to the environment.
+func (env *Environment) Shell(ctx context.Context, shell string) error {
+	container := env.container().WithWorkdir(env.State.Config.Workdir)
+	if _, err := container.WithExec([]string{"sh", "-c", `command -v "$1"`, "sh", shell}).Sync(ctx); err != nil {
+		return fmt.Errorf("shell %s not found in environment %s: install it or use another shell", shell, env.ID)
+	}
+	if _, err := container.Terminal(dagger.ContainerTerminalOpts{
+		ExperimentalPrivilegedNesting: true,
+		Cmd:                           []string{shell},
+	}).Sync(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Labels automatically added to checkpointed images.
+const (
+	CheckpointLabelEnvironmentID = "dev.container-use.environment.id"
+	CheckpointLabelTitle         = "org.opencontainers.image.title"
+	CheckpointLabelRevision      = "org.opencontainers.image.revision"
+	CheckpointLabelCreated       = "org.opencontainers.image.created"
+)
+
+// Checkpoint publishes the environment container to target.
+// The image carries the user provided labels plus the automatic CheckpointLabel* labels,
+// which take precedence so checkpoints can always be traced back to their environment.
+func (env *Environment) Checkpoint(ctx context.Context, target, sourceCommit string, labels map[string]string) (string, error) {
+	container := env.container()
+	for name, value := range labels {
+		container = container.WithLabel(name, value)
+	}
+
+	container = container.
+		WithLabel(CheckpointLabelEnvironmentID, env.ID).
+		WithLabel(CheckpointLabelTitle, env.State.Title).
+		WithLabel(CheckpointLabelCreated, time.Now().UTC().Format(time.RFC3339))
+	if sourceCommit != "" {
+		container = container.WithLabel(CheckpointLabelRevision, sourceCommit)
+	}
+
+	ref, err := container.Publish(ctx, target)
+	if err != nil {
+		return "", err
+	}
+
+	env.mu.Lock()
+	env.State.Checkpoints = append(env.State.Checkpoints, ref)
+	env.mu.Unlock()
+	return ref, nil
+}
+
+// CheckpointImagePrefix references the latest checkpoint of an environment in place of an image, e.g. "checkpoint:fancy-mallard".
+const CheckpointImagePrefix = "checkpoint:"
+
+// ParseCheckpointImage returns the environment whose latest checkpoint is referenced by image, if any.
+func ParseCheckpointImage(image string) (string, bool) {
+	envID, ok := strings.CutPrefix(image, CheckpointImagePrefix)
+	return envID, ok && envID != ""
+}
+
+// LatestCheckpoint returns the reference of the environment's most recent checkpoint.
+func (info *EnvironmentInfo) LatestCheckpoint() (string, error) {
+	if len(info.State.Checkpoints) == 0 {
+		return "", fmt.Errorf("environment %s has no checkpoint, create one with environment_checkpoint first", info.ID)
+	}
+	return info.State.Checkpoints[len(info.State.Checkpoints)-1], nil
 }
@@ -0,0 +1,48 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressOutput(t *testing.T) {
+	assert.Empty(t, progressOutput("", "\n"))
+	assert.Equal(t, ":\nok", progressOutput("ok\n", ""))
+	assert.Equal(t, ":\nout\nerr", progressOutput("out\n", "err\n"))
+
+	var lines []string
+	for i := range 15 {
+		lines = append(lines, strings.Repeat("x", i+1))
+	}
+	output := progressOutput(strings.Join(lines, "\n"), "")
+	assert.Equal(t, ":\n"+strings.Join(lines[5:], "\n"), output)
+}
+
+func TestLatestCheckpoint(t *testing.T) {
+	envID, ok := ParseCheckpointImage("checkpoint:fancy-mallard")
+	assert.True(t, ok)
+	assert.Equal(t, "fancy-mallard", envID)
+
+	_, ok = ParseCheckpointImage("postgres:17")
+	assert.False(t, ok)
+	_, ok = ParseCheckpointImage("checkpoint:")
+	assert.False(t, ok)
+
+	info := &EnvironmentInfo{ID: "fancy-mallard", State: &State{}}
+	_, err := info.LatestCheckpoint()
+	assert.Error(t, err)
+
+	info.State.Checkpoints = []string{"registry.example.com/app@sha256:aaa", "registry.example.com/app@sha256:bbb"}
+	ref, err := info.LatestCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com/app@sha256:bbb", ref)
+}
+
+func TestPrependPath(t *testing.T) {
+	assert.Equal(t, "/opt/tools/bin:/usr/bin:/bin", prependPath([]string{"/opt/tools/bin"}, "/usr/bin:/bin"))
+	assert.Equal(t, "/a:/b:/usr/bin", prependPath([]string{"/a", "/b"}, "/usr/bin"))
+	assert.Equal(t, "/usr/bin:/bin", prependPath([]string{"/usr/bin"}, "/bin:/usr/bin"))
+	assert.Equal(t, "/opt/tools/bin:"+defaultPath, prependPath([]string{"/opt/tools/bin"}, ""))
+}
@@ -0,0 +1,125 @@
+package environment
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FileArchive is a zip of files of an environment, see ExportFiles.
+type FileArchive struct {
+	Data []byte
+	// Files are the paths of the archived files, relative to the workdir.
+	Files []string
+	// Size is the total (uncompressed) size of the archived files.
+	Size int64
+	// Skipped are the matching files left out to stay within the size limit.
+	Skipped []string
+}
+
+// Truncated reports whether matching files were left out of the archive.
+func (a *FileArchive) Truncated() bool {
+	return len(a.Skipped) > 0
+}
+
+// ExportFiles returns a zip of the workdir files matching a glob pattern (e.g. "site/**/*.html"), relative to
+// the workdir. Files that would take the total uncompressed size above maxSize are skipped, so that one large
+// file doesn't prevent the others from being exported.
+func (env *Environment) ExportFiles(ctx context.Context, pattern string, maxSize int64) (*FileArchive, error) {
+	workdir := env.Workdir()
+	matches, err := workdir.Glob(ctx, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match files: %w", err)
+	}
+
+	slices.Sort(matches)
+
+	archive := &FileArchive{}
+	// Directories are matched too: only export the files, with their paths.
+	selected := env.dag.Directory()
+	for _, match := range matches {
+		if strings.HasSuffix(match, "/") {
+			continue
+		}
+		file := workdir.File(match)
+		size, err := file.Size(ctx)
+		if err != nil {
+			// Globs match directories without a trailing slash as well
+			if _, dirErr := workdir.Directory(match).Entries(ctx); dirErr == nil {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", match, err)
+		}
+		if archive.Size+int64(size) > maxSize {
+			archive.Skipped = append(archive.Skipped, match)
+			continue
+		}
+		archive.Size += int64(size)
+		archive.Files = append(archive.Files, match)
+		selected = selected.WithFile(match, file)
+	}
+	if len(archive.Files) == 0 && len(archive.Skipped) == 0 {
+		return nil, fmt.Errorf("no files match %q in %s", pattern, env.State.Config.Workdir)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "container-use-export-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := selected.Export(ctx, tmpDir); err != nil {
+		return nil, fmt.Errorf("failed to export files: %w", err)
+	}
+	if archive.Data, err = zipFiles(tmpDir, archive.Files); err != nil {
+		return nil, fmt.Errorf("failed to archive files: %w", err)
+	}
+	return archive, nil
+}
+
+// zipFiles archives files, relative to root, keeping their paths and permissions.
+func zipFiles(root string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range files {
+		if err := addZipFile(zw, root, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addZipFile(zw *zip.Writer, root, name string) error {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZipFiles(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "site", "css"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>hello</h1>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "css", "style.css"), []byte("h1 {}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "deploy.sh"), []byte("#!/bin/sh\n"), 0755))
+
+	data, err := zipFiles(root, []string{"deploy.sh", "site/css/style.css", "site/index.html"})
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		contents[f.Name] = string(b)
+
+		if f.Name == "deploy.sh" {
+			assert.Equal(t, os.FileMode(0755), f.Mode().Perm())
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"deploy.sh":          "#!/bin/sh\n",
+		"site/css/style.css": "h1 {}",
+		"site/index.html":    "<h1>hello</h1>",
+	}, contents)
+
+	_, err = zipFiles(root, []string{"missing.txt"})
+	assert.Error(t, err)
+}
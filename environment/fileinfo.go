@@ -0,0 +1,68 @@
+package environment
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// FileMetadata describes a file of the environment, see FileStat.
+type FileMetadata struct {
+	// Path is relative to the workdir for files inside it, absolute otherwise.
+	Path string `json:"path"`
+	Size int    `json:"size"`
+	// Mode is the octal permissions of the file (e.g. "644"), empty if the image has no stat command.
+	Mode string `json:"mode,omitempty"`
+	// LastCommit is the last commit of the environment's branch that modified the file, nil if it was never committed.
+	LastCommit *FileCommit `json:"last_commit,omitempty"`
+}
+
+// FileCommit is a commit that modified a file.
+type FileCommit struct {
+	SHA string `json:"sha"`
+	// Explanation is the commit message, i.e. the explanation given for the change.
+	Explanation string `json:"explanation"`
+}
+
+// FileStat returns the size and mode of a file from the container filesystem. The last commit is left
+// for the repository to fill in, see WorkdirRelativePath.
+func (env *Environment) FileStat(ctx context.Context, targetFile string) (*FileMetadata, error) {
+	container := env.container()
+	size, err := container.File(targetFile).Size(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &FileMetadata{Path: targetFile, Size: size}
+	if rel, ok := env.WorkdirRelativePath(targetFile); ok {
+		metadata.Path = rel
+	}
+
+	// Dagger doesn't expose permissions: ask the container, without applying the exec to the environment
+	stat := container.WithExec([]string{"stat", "-c", "%a", "--", targetFile}, dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	})
+	if exitCode, err := stat.ExitCode(ctx); err == nil && exitCode == 0 {
+		if mode, err := stat.Stdout(ctx); err == nil {
+			metadata.Mode = strings.TrimSpace(mode)
+		}
+	}
+	return metadata, nil
+}
+
+// WorkdirRelativePath returns the path of a file relative to the workdir, as tracked in the environment's
+// branch. The file is absolute or relative to the workdir; ok is false for files outside the workdir.
+func (env *Environment) WorkdirRelativePath(targetFile string) (string, bool) {
+	workdir := path.Clean(env.State.Config.Workdir)
+	if !path.IsAbs(targetFile) {
+		targetFile = path.Join(workdir, targetFile)
+	}
+	targetFile = path.Clean(targetFile)
+	rel, ok := strings.CutPrefix(targetFile, strings.TrimSuffix(workdir, "/")+"/")
+	if !ok || rel == "" {
+		return "", false
+	}
+	return rel, true
+}
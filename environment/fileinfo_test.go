@@ -0,0 +1,27 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkdirRelativePath(t *testing.T) {
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{Config: &EnvironmentConfig{Workdir: "/workdir"}}}}
+
+	for target, expected := range map[string]string{
+		"main.go":                "main.go",
+		"./src/app.py":           "src/app.py",
+		"/workdir/src/app.py":    "src/app.py",
+		"/workdir/src/../README": "README",
+	} {
+		rel, ok := env.WorkdirRelativePath(target)
+		assert.True(t, ok, target)
+		assert.Equal(t, expected, rel, target)
+	}
+
+	for _, target := range []string{"/etc/hosts", "/workdir", "/workdir2/file", "../outside"} {
+		_, ok := env.WorkdirRelativePath(target)
+		assert.False(t, ok, target)
+	}
+}
@@ -1,17 +1,23 @@
 package environment
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"dagger.io/dagger"
 	godiffpatch "github.com/sourcegraph/go-diff-patch"
 )
 
 func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldReadEntireFile bool, startLineOneIndexedInclusive int, endLineOneIndexedInclusive int) (string, error) {
-	file, err := env.container().File(targetFile).Contents(ctx)
+	file, err := env.readFile(ctx, targetFile)
 	if err != nil {
 		return "", err
 	}
@@ -40,13 +46,62 @@ func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldR
 	return strings.Join(lines[start:end], "\n"), nil
 }
 
+// readFile returns the contents of a file, served from fileReadCache when the environment hasn't changed since it was last read.
+// Files of mounts (see uncachedPath) are always read from the container.
+func (env *Environment) readFile(ctx context.Context, targetFile string) (string, error) {
+	env.mu.RLock()
+	key := newReadCacheKey(env.State.Container, targetFile)
+	cached := !env.uncachedPath(env.resolvePath(targetFile))
+	env.mu.RUnlock()
+
+	if cached {
+		if contents, ok := fileReadCache.Get(key); ok {
+			return contents, nil
+		}
+	}
+
+	contents, err := env.container().File(targetFile).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	if cached {
+		fileReadCache.Add(key, contents)
+	}
+	return contents, nil
+}
+
+// uncachedPath reports whether p, an absolute path, is in a mount whose contents change without the container
+// changing: named volumes, shared with other environments, and the context directory, read from the host.
+func (env *Environment) uncachedPath(p string) bool {
+	mounts := []string{}
+	if env.State.Config.ContextDir != "" {
+		mounts = append(mounts, ContextMountPath)
+	}
+	for _, volume := range env.State.Config.Volumes {
+		mounts = append(mounts, path.Clean(volume.Path))
+	}
+	return slices.ContainsFunc(mounts, func(mount string) bool {
+		return p == mount || strings.HasPrefix(p, mount+"/")
+	})
+}
+
+// DefaultFileMode is the mode of files written by FileWrite.
+const DefaultFileMode os.FileMode = 0644
+
 func (env *Environment) FileWrite(ctx context.Context, explanation, targetFile, contents string) error {
+	return env.FileWriteWithMode(ctx, explanation, targetFile, contents, DefaultFileMode)
+}
+
+// FileWriteWithMode writes a file with the given permissions, e.g. 0755 for scripts.
+func (env *Environment) FileWriteWithMode(ctx context.Context, explanation, targetFile, contents string, mode os.FileMode) error {
 	// Check if the file is within a submodule
 	if err := env.validateNotSubmoduleFile(targetFile); err != nil {
 		return err
 	}
 
-	err := env.apply(ctx, env.container().WithNewFile(targetFile, contents))
+	err := env.apply(ctx, env.container().WithNewFile(targetFile, contents, dagger.ContainerWithNewFileOpts{
+		Permissions: int(mode.Perm()),
+	}))
 	if err != nil {
 		return fmt.Errorf("failed applying file write, skipping git propagation: %w", err)
 	}
@@ -122,16 +177,69 @@ func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile, s
 	// Replace the specific match
 	newContents := contents[:targetMatchIndex] + replace + contents[targetMatchIndex+len(search):]
 
+	if _, err := env.applyEdit(ctx, targetFile, contents, newContents); err != nil {
+		return err
+	}
+	env.Notes.Add("Edit %s", targetFile)
+	return nil
+}
+
+// FileEditLines replaces lines startLine to endLine (1-indexed, inclusive) of a file with new content, e.g. once
+// known from a previous read, and returns the diff of the change. An empty content deletes the lines.
+func (env *Environment) FileEditLines(ctx context.Context, explanation, targetFile string, startLine, endLine int, replace string) (string, error) {
+	// Check if the file is within a submodule
+	if err := env.validateNotSubmoduleFile(targetFile); err != nil {
+		return "", err
+	}
+
+	contents, err := env.container().File(targetFile).Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	newContents, err := replaceLines(contents, startLine, endLine, replace)
+	if err != nil {
+		return "", fmt.Errorf("cannot edit %s: %w", targetFile, err)
+	}
+
+	patch, err := env.applyEdit(ctx, targetFile, contents, newContents)
+	if err != nil {
+		return "", err
+	}
+	env.Notes.Add("Edit %s (lines %d-%d)", targetFile, startLine, endLine)
+	return patch, nil
+}
+
+// applyEdit applies the change of the contents of a file, and returns its diff.
+func (env *Environment) applyEdit(ctx context.Context, targetFile, contents, newContents string) (string, error) {
 	// Apply the changes using `Directory.withPatch` so we don't have to spit out
 	// the entire contents
 	patch := godiffpatch.GeneratePatch(targetFile, contents, newContents)
 	ctr := env.container()
-	err = env.apply(ctx, ctr.WithDirectory(".", ctr.Directory(".").WithPatch(patch)))
+	err := env.apply(ctx, ctr.WithDirectory(".", ctr.Directory(".").WithPatch(patch)))
 	if err != nil {
-		return fmt.Errorf("failed applying file edit, skipping git propagation: %w", err)
+		return "", fmt.Errorf("failed applying file edit, skipping git propagation: %w", err)
 	}
-	env.Notes.Add("Edit %s", targetFile)
-	return nil
+	return patch, nil
+}
+
+// replaceLines replaces lines start to end (1-indexed, inclusive) of contents. The replacement gets a final
+// newline if the lines it replaces had one.
+func replaceLines(contents string, start, end int, replacement string) (string, error) {
+	lines := strings.SplitAfter(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if start < 1 || end < start {
+		return "", fmt.Errorf("invalid line range %d-%d: lines start at 1 and the end line can't be before the start line", start, end)
+	}
+	if end > len(lines) {
+		return "", fmt.Errorf("line range %d-%d is past the end of the file, which has %d lines", start, end, len(lines))
+	}
+
+	if replacement != "" && !strings.HasSuffix(replacement, "\n") && strings.HasSuffix(lines[end-1], "\n") {
+		replacement += "\n"
+	}
+	return strings.Join(lines[:start-1], "") + replacement + strings.Join(lines[end:], ""), nil
 }
 
 func (env *Environment) FileDelete(ctx context.Context, explanation, targetFile string) error {
@@ -148,18 +256,305 @@ func (env *Environment) FileDelete(ctx context.Context, explanation, targetFile
 	return nil
 }
 
-func (env *Environment) FileList(ctx context.Context, path string) (string, error) {
-	entries, err := env.container().Directory(path).Entries(ctx)
+// FileMove moves a file to a new path, overwriting the destination if it exists and creating its parent
+// directories. The file keeps its permissions.
+func (env *Environment) FileMove(ctx context.Context, explanation, sourceFile, destinationFile string) error {
+	// Check if the file is within a submodule
+	if err := env.validateNotSubmoduleFile(sourceFile); err != nil {
+		return err
+	}
+	container, err := env.copyFile(ctx, sourceFile, destinationFile)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	err = env.apply(ctx, container.WithoutFile(sourceFile))
+	if err != nil {
+		return fmt.Errorf("failed applying file move, skipping git propagation: %w", err)
+	}
+	env.Notes.Add("Move %s to %s", sourceFile, destinationFile)
+	return nil
+}
+
+// FileCopy copies a file to a new path, overwriting the destination if it exists and creating its parent
+// directories. The copy keeps the permissions of the file.
+func (env *Environment) FileCopy(ctx context.Context, explanation, sourceFile, destinationFile string) error {
+	container, err := env.copyFile(ctx, sourceFile, destinationFile)
+	if err != nil {
+		return err
+	}
+
+	err = env.apply(ctx, container)
+	if err != nil {
+		return fmt.Errorf("failed applying file copy, skipping git propagation: %w", err)
+	}
+	env.Notes.Add("Copy %s to %s", sourceFile, destinationFile)
+	return nil
+}
+
+// copyFile returns the container of the environment with a file copied to a new path.
+func (env *Environment) copyFile(ctx context.Context, sourceFile, destinationFile string) (*dagger.Container, error) {
+	// Check if the destination is within a submodule
+	if err := env.validateNotSubmoduleFile(destinationFile); err != nil {
+		return nil, err
+	}
+	if env.resolvePath(sourceFile) == env.resolvePath(destinationFile) {
+		return nil, fmt.Errorf("source and destination are the same file: %s", sourceFile)
 	}
+
+	container := env.container()
+	file, err := container.File(sourceFile).Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sourceFile, err)
+	}
+	return container.WithFile(destinationFile, file), nil
+}
+
+// resolvePath returns the absolute path of a file, absolute or relative to the workdir.
+func (env *Environment) resolvePath(targetFile string) string {
+	if !path.IsAbs(targetFile) {
+		targetFile = path.Join(env.State.Config.Workdir, targetFile)
+	}
+	return path.Clean(targetFile)
+}
+
+// SyncWorkdirFiles copies files of the workdir from hostDir (paths are relative to the workdir) and removes
+// the deleted ones, e.g. to catch up with changes saved concurrently to the environment's branch.
+func (env *Environment) SyncWorkdirFiles(ctx context.Context, hostDir string, changed, deleted []string) error {
+	container := env.container()
+	for _, file := range changed {
+		container = container.WithFile(path.Join(env.State.Config.Workdir, file), env.dag.Host().File(filepath.Join(hostDir, file)))
+	}
+	if len(deleted) > 0 {
+		paths := make([]string, len(deleted))
+		for i, file := range deleted {
+			paths[i] = path.Join(env.State.Config.Workdir, file)
+		}
+		container = container.WithoutFiles(paths)
+	}
+	return env.apply(ctx, container)
+}
+
+func (env *Environment) FileList(ctx context.Context, path string) (string, error) {
+	return env.FileListWithOpts(ctx, path, FileListOpts{})
+}
+
+// DefaultFileListMaxEntries bounds the number of entries of recursive listings by default.
+const DefaultFileListMaxEntries = 1000
+
+// FileListOpts contains the optional arguments of FileListWithOpts.
+type FileListOpts struct {
+	// Recursive lists the contents of the subdirectories too, as paths relative to the listed directory.
+	Recursive bool
+	// MaxDepth limits how deep recursive listings go, 1 listing the directory itself only. 0 means no limit.
+	MaxDepth int
+	// IncludeIgnored includes the files ignored by the .gitignore files of the workdir in recursive listings.
+	IncludeIgnored bool
+	// MaxEntries bounds the number of entries of recursive listings, DefaultFileListMaxEntries if zero.
+	MaxEntries int
+}
+
+// FileListWithOpts is FileList with optional arguments. Directories are listed with a trailing slash.
+func (env *Environment) FileListWithOpts(ctx context.Context, path string, opts FileListOpts) (string, error) {
+	var entries []string
+	truncated := false
+	if opts.Recursive {
+		var err error
+		if entries, truncated, err = env.fileTree(ctx, path, opts); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		if entries, err = env.container().Directory(path).Entries(ctx); err != nil {
+			return "", err
+		}
+	}
+
 	out := &strings.Builder{}
 	for _, entry := range entries {
 		fmt.Fprintf(out, "%s\n", entry)
 	}
+	if truncated {
+		fmt.Fprintf(out, "... (truncated after %d entries: list a subdirectory or set a max depth to see the rest)\n", len(entries))
+	}
 	return out.String(), nil
 }
 
+// fileTreeScript lists the contents of directory $1 recursively, down to depth $2 if set. Directories are listed
+// with a trailing slash. With "ignore" as $3, ignored files are left out: git lists the files of git repositories,
+// after a "git:" line, and elsewhere .git and the directories given by the next arguments (see pruneArgs) aren't
+// walked.
+const fileTreeScript = `cd "$1" || exit
+depth=${2:+-maxdepth $2}
+shift 2
+if [ "$1" = ignore ]; then
+	shift
+	if git rev-parse --is-inside-work-tree >/dev/null 2>&1; then
+		echo "git:"
+		exec git -c core.quotePath=off ls-files --cached --others --exclude-standard
+	fi
+	for rule do
+		shift
+		case $rule in
+		name:*) set -- "$@" -o -name "${rule#name:}" ;;
+		path:*) set -- "$@" -o -path "./${rule#path:}" ;;
+		esac
+	done
+	set -- -type d '(' -name .git "$@" ')' -prune -o
+fi
+find . -mindepth 1 $depth "$@" -type d -print | sed 's|$|/|'
+find . -mindepth 1 $depth "$@" ! -type d -print`
+
+// fileTree returns the paths of the contents of a directory, relative to it, sorted so that the contents of each
+// directory follow it. Dagger only lists one directory at a time: the container lists them all at once instead.
+func (env *Environment) fileTree(ctx context.Context, dir string, opts FileListOpts) ([]string, bool, error) {
+	maxEntries := opts.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultFileListMaxEntries
+	}
+	depth := ""
+	if opts.MaxDepth > 0 {
+		depth = strconv.Itoa(opts.MaxDepth)
+	}
+
+	// Ignored files are matched relative to the workdir: directories outside of it have no .gitignore
+	base, inWorkdir := env.WorkdirRelativePath(dir)
+	if env.resolvePath(dir) == path.Clean(env.State.Config.Workdir) {
+		base, inWorkdir = "", true
+	}
+
+	container := env.container()
+	args := []string{"sh", "-c", fileTreeScript, "sh", dir, depth}
+	if !opts.IncludeIgnored {
+		args = append(args, "ignore")
+		if inWorkdir {
+			// Prune the directories ignored by the .gitignore files of the directory and its parents
+			args = append(args, env.gitignoreRules(ctx, container, base, nil).pruneArgs(base)...)
+		}
+	}
+	output, err := container.WithExec(args).Stdout(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	if files, ok := strings.CutPrefix(output, "git:\n"); ok {
+		// git already left the ignored files out
+		entries, truncated := truncateEntries(gitTreePaths(files, opts.MaxDepth), maxEntries)
+		return entries, truncated, nil
+	}
+	paths := []string{}
+	for line := range strings.SplitSeq(output, "\n") {
+		if p, ok := strings.CutPrefix(line, "./"); ok && p != "" {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+
+	var rules ignoreRules
+	if !opts.IncludeIgnored && inWorkdir {
+		rules = env.gitignoreRules(ctx, container, base, paths)
+	}
+
+	entries := []string{}
+	var ignoredDir string
+	for _, p := range paths {
+		if ignoredDir != "" && strings.HasPrefix(p, ignoredDir) {
+			continue
+		}
+		isDir := strings.HasSuffix(p, "/")
+		name := strings.TrimSuffix(p, "/")
+		if !opts.IncludeIgnored && (path.Base(name) == ".git" || rules.ignored(path.Join(base, name), isDir)) {
+			if isDir {
+				ignoredDir = p
+			}
+			continue
+		}
+		if len(entries) == maxEntries {
+			return entries, true, nil
+		}
+		entries = append(entries, p)
+	}
+	return entries, false, nil
+}
+
+// gitTreePaths returns the files listed by git ls-files along with their directories, down to maxDepth if set,
+// sorted like fileTree's.
+func gitTreePaths(files string, maxDepth int) []string {
+	seen := map[string]bool{}
+	paths := []string{}
+	for file := range strings.SplitSeq(files, "\n") {
+		if file == "" {
+			continue
+		}
+		parts := strings.Split(file, "/")
+		for i := range parts {
+			if maxDepth > 0 && i == maxDepth {
+				break
+			}
+			p := strings.Join(parts[:i+1], "/")
+			if i < len(parts)-1 {
+				p += "/"
+			}
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// truncateEntries bounds a listing to maxEntries entries, reporting whether it was truncated.
+func truncateEntries(entries []string, maxEntries int) ([]string, bool) {
+	if len(entries) > maxEntries {
+		return entries[:maxEntries], true
+	}
+	return entries, false
+}
+
+// gitignoreRules reads the .gitignore files applying to the contents of a directory of the workdir (base, relative
+// to the workdir): the ones of its parent directories, its own and the ones of its subdirectories (paths).
+func (env *Environment) gitignoreRules(ctx context.Context, container *dagger.Container, base string, paths []string) ignoreRules {
+	dirs := []string{""}
+	if base != "" {
+		parts := strings.Split(base, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, p := range paths {
+		if path.Base(p) != ".gitignore" {
+			continue
+		}
+		dir := path.Join(base, path.Dir(p))
+		if dir == "." {
+			dir = ""
+		}
+		dirs = append(dirs, dir)
+	}
+	// Shallower files come first, so that deeper ones take precedence
+	depth := func(dir string) int {
+		if dir == "" {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+	slices.SortFunc(dirs, func(a, b string) int {
+		return cmp.Or(cmp.Compare(depth(a), depth(b)), strings.Compare(a, b))
+	})
+	dirs = slices.Compact(dirs)
+
+	rules := ignoreRules{}
+	for _, dir := range dirs {
+		contents, err := container.File(path.Join(env.State.Config.Workdir, dir, ".gitignore")).Contents(ctx)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, parseGitignore(dir, contents)...)
+	}
+	return rules
+}
+
 // generateMatchID creates a unique ID for a match based on file, search, replace, and index
 func generateMatchID(targetFile, search, replace string, index int) string {
 	data := fmt.Sprintf("%s:%s:%s:%d", targetFile, search, replace, index)
@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceLines(t *testing.T) {
+	contents := "one\ntwo\nthree\nfour\n"
+
+	tests := []struct {
+		name        string
+		contents    string
+		start, end  int
+		replacement string
+		expected    string
+	}{
+		{
+			name:        "single line",
+			contents:    contents,
+			start:       2,
+			end:         2,
+			replacement: "2",
+			expected:    "one\n2\nthree\nfour\n",
+		},
+		{
+			name:        "range with more lines",
+			contents:    contents,
+			start:       2,
+			end:         3,
+			replacement: "a\nb\nc\n",
+			expected:    "one\na\nb\nc\nfour\n",
+		},
+		{
+			name:     "delete lines",
+			contents: contents,
+			start:    1,
+			end:      2,
+			expected: "three\nfour\n",
+		},
+		{
+			name:        "last line without final newline",
+			contents:    "one\ntwo",
+			start:       2,
+			end:         2,
+			replacement: "2",
+			expected:    "one\n2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited, err := replaceLines(tt.contents, tt.start, tt.end, tt.replacement)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, edited)
+		})
+	}
+
+	_, err := replaceLines(contents, 0, 1, "x")
+	assert.ErrorContains(t, err, "lines start at 1")
+	_, err = replaceLines(contents, 3, 2, "x")
+	assert.ErrorContains(t, err, "invalid line range 3-2")
+	_, err = replaceLines(contents, 4, 5, "x")
+	assert.ErrorContains(t, err, "which has 4 lines")
+	_, err = replaceLines("", 1, 1, "x")
+	assert.ErrorContains(t, err, "which has 0 lines")
+}
+
+func TestGitTreePaths(t *testing.T) {
+	files := "main.go\nsrc/app/app.go\nsrc/util.go\n"
+	assert.Equal(t, []string{"main.go", "src/", "src/app/", "src/app/app.go", "src/util.go"}, gitTreePaths(files, 0))
+	assert.Equal(t, []string{"main.go", "src/", "src/app/", "src/util.go"}, gitTreePaths(files, 2))
+	assert.Equal(t, []string{"main.go", "src/"}, gitTreePaths(files, 1))
+}
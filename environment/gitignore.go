@@ -0,0 +1,164 @@
+package environment
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	// base is the directory of the .gitignore file, relative to the workdir ("" for the workdir itself).
+	base string
+	// glob is the pattern as written, without its leading "!" and "/" and trailing "/".
+	glob    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored patterns, which contain a slash, match paths relative to base rather than names.
+	anchored bool
+}
+
+// ignoreRules are the patterns of the .gitignore files applying to a path, ordered from the least to the most
+// specific: shallower files first, then each file's patterns in order. As with git, the last matching pattern wins.
+type ignoreRules []ignoreRule
+
+// parseGitignore parses a .gitignore file of the given directory, relative to the workdir. Patterns that can't
+// be parsed are skipped.
+func parseGitignore(base, contents string) ignoreRules {
+	rules := ignoreRules{}
+	for line := range strings.SplitSeq(contents, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimSuffix(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile(globRegexp(line))
+		if err != nil {
+			continue
+		}
+		rule.glob = line
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globRegexp translates a .gitignore pattern into a regular expression: * and ? don't match slashes, and ** matches
+// any number of directories.
+func globRegexp(glob string) string {
+	b := &strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if negated, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + negated
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func (rule ignoreRule) match(p string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "" {
+		rel, ok := strings.CutPrefix(p, rule.base+"/")
+		if !ok {
+			return false
+		}
+		p = rel
+	}
+	if !rule.anchored {
+		p = path.Base(p)
+	}
+	return rule.pattern.MatchString(p)
+}
+
+// ignored reports whether a path, relative to the workdir, is ignored. The contents of ignored directories are
+// ignored too, which is left for the caller to handle by not looking into them.
+func (rules ignoreRules) ignored(p string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.match(p, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// pruneArgs returns the directories the rules ignore, for fileTreeScript not to walk them: "name:GLOB" for the
+// names matched by unanchored patterns, and "path:PATH" for the literal paths of anchored ones, relative to base.
+// Git doesn't look into ignored directories either, so nothing in them can be re-included, except by negating
+// the pattern ignoring them: nothing is pruned when a pattern is negated.
+func (rules ignoreRules) pruneArgs(base string) []string {
+	if slices.ContainsFunc(rules, func(rule ignoreRule) bool { return rule.negate }) {
+		return nil
+	}
+	args := []string{}
+	for _, rule := range rules {
+		if strings.Contains(rule.glob, "**") {
+			continue
+		}
+		if !rule.anchored {
+			args = append(args, "name:"+rule.glob)
+			continue
+		}
+		if strings.ContainsAny(rule.glob, `*?[\`) {
+			continue
+		}
+		p := path.Join(rule.base, rule.glob)
+		if base != "" {
+			var ok bool
+			if p, ok = strings.CutPrefix(p, base+"/"); !ok {
+				continue
+			}
+		}
+		args = append(args, "path:"+p)
+	}
+	return args
+}
@@ -0,0 +1,63 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseGitignore("", `# dependencies
+node_modules/
+*.log
+!keep.log
+/build
+docs/**/*.tmp
+\#notes
+`)
+	rules = append(rules, parseGitignore("web", "dist\n!*.log\n")...)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"node_modules", true, true},
+		{"web/node_modules", true, true},
+		{"node_modules", false, false},
+		{"app.log", false, true},
+		{"logs/app.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"docs/a/b/c.tmp", false, true},
+		{"docs/c.tmp", false, true},
+		{"src/c.tmp", false, false},
+		{"#notes", false, true},
+		{"web/dist", true, true},
+		{"dist", true, false},
+		{"web/app.log", false, false},
+		{"main.go", false, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.ignored, rules.ignored(tt.path, tt.isDir), tt.path)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	assert.Equal(t, `^[^/]*\.go$`, globRegexp("*.go"))
+	assert.Equal(t, `^(?:.*/)?vendor$`, globRegexp("**/vendor"))
+	assert.Equal(t, `^logs/.*$`, globRegexp("logs/**"))
+	assert.Equal(t, `^file[^0-9]$`, globRegexp("file[!0-9]"))
+	assert.Equal(t, `^a\[b$`, globRegexp("a[b"))
+}
+
+func TestPruneArgs(t *testing.T) {
+	rules := parseGitignore("", "node_modules/\n/build\n*.log\ndocs/**/tmp\n/out-*\n")
+	rules = append(rules, parseGitignore("web", "/dist\n")...)
+	assert.Equal(t, []string{"name:node_modules", "path:build", "name:*.log", "path:web/dist"}, rules.pruneArgs(""))
+	assert.Equal(t, []string{"name:node_modules", "name:*.log", "path:dist"}, rules.pruneArgs("web"))
+
+	rules = append(rules, parseGitignore("", "!build/keep\n")...)
+	assert.Nil(t, rules.pruneArgs(""))
+}
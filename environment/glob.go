@@ -0,0 +1,135 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// MaxGlobResults is how many paths Glob returns at most.
+const MaxGlobResults = DefaultFileListMaxEntries
+
+// maxGlobPatterns bounds how many patterns the braces of a Glob pattern expand to, e.g. "{a,b}/{c,d}" expands to
+// four: each of them is matched separately.
+const maxGlobPatterns = 100
+
+// Glob returns the paths of the files and directories of the workdir matching a shell-style pattern relative to
+// it, e.g. "**/*.go": * and ? don't match slashes, ** matches any number of directories and braces are expanded,
+// e.g. "*.{ts,tsx}". Paths are relative to the workdir and sorted. At most MaxGlobResults paths are returned, the
+// boolean reports whether there were more.
+func (env *Environment) Glob(ctx context.Context, pattern string) ([]string, bool, error) {
+	if path.IsAbs(pattern) {
+		rel, ok := env.WorkdirRelativePath(pattern)
+		if !ok {
+			return nil, false, fmt.Errorf("pattern %q must be relative to the workdir %s", pattern, env.State.Config.Workdir)
+		}
+		pattern = rel
+	}
+	pattern = strings.TrimPrefix(pattern, "./")
+	if pattern == "" {
+		return nil, false, fmt.Errorf("the pattern must not be empty")
+	}
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, false, err
+	}
+
+	workdir := env.Workdir()
+	matches := []string{}
+	for _, expanded := range patterns {
+		expandedMatches, err := workdir.Glob(ctx, expanded)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to match %q: %w", expanded, err)
+		}
+		matches = append(matches, expandedMatches...)
+	}
+	for i, match := range matches {
+		matches[i] = strings.TrimSuffix(match, "/")
+	}
+	slices.Sort(matches)
+	matches = slices.Compact(matches)
+	if len(matches) > MaxGlobResults {
+		return matches[:MaxGlobResults], true, nil
+	}
+	return matches, false, nil
+}
+
+// expandBraces expands the brace alternatives of a pattern, e.g. "src/{a,b}/*.{go,md}" into four patterns.
+// Braces can be nested. Braces without a matching one or a comma, as well as escaped ones, are left as is.
+// Patterns expanding to more than maxGlobPatterns patterns are refused.
+func expandBraces(pattern string) ([]string, error) {
+	budget := maxGlobPatterns
+	patterns, ok := expandBracesWithin(pattern, &budget)
+	if !ok {
+		return nil, fmt.Errorf("pattern %q expands to more than %d patterns: use fewer braces", pattern, maxGlobPatterns)
+	}
+	return patterns, nil
+}
+
+// expandBracesWithin expands the braces of a pattern as long as the budget of patterns isn't exhausted. Duplicates
+// count towards the budget, so that a pattern such as "{a,a}{a,a}..." is refused before its expansions are.
+func expandBracesWithin(pattern string, budget *int) ([]string, bool) {
+	start, end, alternatives := findBraces(pattern)
+	if start < 0 {
+		*budget--
+		return []string{pattern}, *budget >= 0
+	}
+	patterns := []string{}
+	for _, alternative := range alternatives {
+		suffixes, ok := expandBracesWithin(alternative+pattern[end+1:], budget)
+		if !ok {
+			return nil, false
+		}
+		for _, suffix := range suffixes {
+			if !slices.Contains(patterns, pattern[:start]+suffix) {
+				patterns = append(patterns, pattern[:start]+suffix)
+			}
+		}
+	}
+	return patterns, true
+}
+
+// findBraces returns the positions of the first pair of braces of a pattern with alternatives, and the
+// alternatives, or -1 if there is none.
+func findBraces(pattern string) (int, int, []string) {
+	for start := 0; start < len(pattern); start++ {
+		switch pattern[start] {
+		case '\\':
+			start++
+		case '{':
+			if end, alternatives := matchingBrace(pattern, start); end >= 0 && len(alternatives) > 1 {
+				return start, end, alternatives
+			}
+		}
+	}
+	return -1, -1, nil
+}
+
+// matchingBrace returns the position of the brace closing the one at start, and the comma-separated alternatives
+// in between, or -1 if it isn't closed.
+func matchingBrace(pattern string, start int) (int, []string) {
+	depth, last := 0, start+1
+	alternatives := []string{}
+	for i := start + 1; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			return i, append(alternatives, pattern[last:i])
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,38 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandBraces(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{"**/*.go", []string{"**/*.go"}},
+		{"*.{ts,tsx}", []string{"*.ts", "*.tsx"}},
+		{"src/{a,b}/*.{go,md}", []string{"src/a/*.go", "src/a/*.md", "src/b/*.go", "src/b/*.md"}},
+		{"{cmd/{x,y},pkg}/*.go", []string{"cmd/x/*.go", "cmd/y/*.go", "pkg/*.go"}},
+		{"*.{go,go}", []string{"*.go"}},
+		{"{x}/{a,b}", []string{"{x}/a", "{x}/b"}},
+		{"{a,b", []string{"{a,b"}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{"*.{,bak}", []string{"*.", "*.bak"}},
+	} {
+		patterns, err := expandBraces(tc.pattern)
+		require.NoError(t, err, tc.pattern)
+		assert.Equal(t, tc.expected, patterns, tc.pattern)
+	}
+
+	_, err := expandBraces(strings.Repeat("{a,b}", 7))
+	assert.ErrorContains(t, err, "expands to more than 100 patterns")
+	_, err = expandBraces(strings.Repeat("{a,a}", 40))
+	assert.ErrorContains(t, err, "expands to more than 100 patterns")
+	patterns, err := expandBraces(strings.Repeat("{a,b}", 6))
+	require.NoError(t, err)
+	assert.Len(t, patterns, 64)
+}
@@ -0,0 +1,159 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultGrepMaxResults is how many matches Grep returns when GrepOpts.MaxResults isn't set.
+const DefaultGrepMaxResults = 100
+
+// maxGrepLineLength bounds the text of a match, so that a match in e.g. minified code doesn't flood the result.
+const maxGrepLineLength = 500
+
+// GrepOpts are the options of Grep.
+type GrepOpts struct {
+	// Path is the file or directory to search, absolute or relative to the workdir (default: the workdir).
+	Path string
+	// IgnoreCase matches the pattern case-insensitively.
+	IgnoreCase bool
+	// MaxResults is how many matches are returned at most (default: DefaultGrepMaxResults).
+	MaxResults int
+}
+
+// GrepMatch is a line matching the pattern given to Grep.
+type GrepMatch struct {
+	// File is the path of the file, relative to the workdir if it's in it.
+	File string `json:"file"`
+	// Line is the number of the line, starting at 1.
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+func (m GrepMatch) String() string {
+	return fmt.Sprintf("%s:%d:%s", m.File, m.Line, m.Text)
+}
+
+// grepScript searches for the pattern $1 in path $2, an absolute path, and prints the directory it searched from
+// followed by the first $3 matching lines, with paths relative to that directory. The pattern is an extended regular
+// expression. Case is ignored if $4 is set. ripgrep and git grep leave out the files ignored by .gitignore, plain grep
+// is the fallback for images that have neither. The exit code of grep when nothing matches is swallowed, errors are
+// only reported when nothing matched.
+const grepScript = `if [ -d "$2" ]; then
+	cd "$2" && target=.
+else
+	cd "$(dirname "$2")" && target=$(basename "$2")
+fi || exit
+out=$(mktemp) || exit
+if command -v rg >/dev/null 2>&1; then
+	rg --line-number --with-filename --no-heading --null --color never --hidden --no-require-git --glob '!.git' ${4:+--ignore-case} -e "$1" -- "$target"
+elif command -v git >/dev/null 2>&1; then
+	git grep --no-index --exclude-standard --line-number -z -I -E ${4:+--ignore-case} -e "$1" -- "$target"
+else
+	grep -r -n -E ${4:+-i} -e "$1" -- "$target"
+fi >"$out"
+status=$?
+pwd
+head -n "$3" "$out"
+if [ "$status" -gt 1 ] && [ ! -s "$out" ]; then
+	exit "$status"
+fi
+rm -f "$out"`
+
+// Grep searches the files of the environment for lines matching a pattern, an extended regular expression. The
+// files searched are the ones of the environment's container, i.e. what FileRead returns, and files ignored by
+// .gitignore are skipped when the image has ripgrep or git.
+func (env *Environment) Grep(ctx context.Context, pattern string, opts GrepOpts) ([]GrepMatch, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("the pattern must not be empty")
+	}
+	searchPath := opts.Path
+	if searchPath == "" {
+		searchPath = "."
+	}
+	maxResults := opts.MaxResults
+	if maxResults <= 0 {
+		maxResults = DefaultGrepMaxResults
+	}
+	ignoreCase := ""
+	if opts.IgnoreCase {
+		ignoreCase = "1"
+	}
+
+	output, err := env.container().
+		WithExec([]string{"sh", "-c", grepScript, "sh", pattern, env.resolvePath(searchPath), strconv.Itoa(maxResults), ignoreCase}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for %q in %s: %w", pattern, searchPath, err)
+	}
+
+	dir, output, _ := strings.Cut(output, "\n")
+	matches := parseGrepOutput(output)
+	for i, match := range matches {
+		file := path.Join(dir, match.File)
+		if rel, ok := env.WorkdirRelativePath(file); ok {
+			file = rel
+		}
+		matches[i].File = file
+	}
+	if len(matches) > maxResults {
+		matches = matches[:maxResults]
+	}
+	return matches, nil
+}
+
+// parseGrepOutput parses the matching lines printed by grepScript: "file\0line:text" for ripgrep, "file\0line\0text"
+// for git grep and "file:line:text" for grep. Other lines, e.g. grep's "Binary file x matches", are skipped.
+func parseGrepOutput(output string) []GrepMatch {
+	matches := []GrepMatch{}
+	for line := range strings.SplitSeq(strings.TrimSuffix(output, "\n"), "\n") {
+		match, ok := parseGrepLine(line)
+		if !ok {
+			continue
+		}
+		match.File = strings.TrimPrefix(match.File, "./")
+		if len(match.Text) > maxGrepLineLength {
+			end := maxGrepLineLength
+			for end > 0 && !utf8.RuneStart(match.Text[end]) {
+				end--
+			}
+			match.Text = match.Text[:end] + "..."
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func parseGrepLine(line string) (GrepMatch, bool) {
+	if file, rest, ok := strings.Cut(line, "\x00"); ok {
+		number, text, ok := cutLineNumber(rest)
+		return GrepMatch{File: file, Line: number, Text: text}, ok
+	}
+	// Without a separator, file names are delimited by the first ":<line number>:"
+	for i := 0; i < len(line); i++ {
+		if line[i] != ':' {
+			continue
+		}
+		if number, text, ok := cutLineNumber(line[i+1:]); ok && i > 0 {
+			return GrepMatch{File: line[:i], Line: number, Text: text}, true
+		}
+	}
+	return GrepMatch{}, false
+}
+
+// cutLineNumber cuts the line number off "<line number>:text" or "<line number>\0text".
+func cutLineNumber(s string) (int, string, bool) {
+	end := strings.IndexAny(s, ":\x00")
+	if end <= 0 {
+		return 0, "", false
+	}
+	number, err := strconv.Atoi(s[:end])
+	if err != nil || number <= 0 {
+		return 0, "", false
+	}
+	return number, s[end+1:], true
+}
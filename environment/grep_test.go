@@ -0,0 +1,41 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGrepOutput(t *testing.T) {
+	t.Run("ripgrep", func(t *testing.T) {
+		matches := parseGrepOutput("./main.go\x0012:\tfmt.Println(\"TOKEN\")\n./docs/a:b.md\x003:TOKEN: 1:2\n")
+		assert.Equal(t, []GrepMatch{
+			{File: "main.go", Line: 12, Text: "\tfmt.Println(\"TOKEN\")"},
+			{File: "docs/a:b.md", Line: 3, Text: "TOKEN: 1:2"},
+		}, matches)
+	})
+
+	t.Run("git grep", func(t *testing.T) {
+		matches := parseGrepOutput("main.go\x0012\x00a:TOKEN\n")
+		assert.Equal(t, []GrepMatch{{File: "main.go", Line: 12, Text: "a:TOKEN"}}, matches)
+	})
+
+	t.Run("grep", func(t *testing.T) {
+		matches := parseGrepOutput("./main.go:12:a:1:TOKEN\n./a:b.txt:7:TOKEN\nBinary file ./bin matches\n")
+		assert.Equal(t, []GrepMatch{
+			{File: "main.go", Line: 12, Text: "a:1:TOKEN"},
+			{File: "a:b.txt", Line: 7, Text: "TOKEN"},
+		}, matches)
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		assert.Empty(t, parseGrepOutput(""))
+	})
+
+	t.Run("long lines are truncated", func(t *testing.T) {
+		matches := parseGrepOutput("min.js\x001:" + strings.Repeat("é", maxGrepLineLength) + "\n")
+		assert.Len(t, matches, 1)
+		assert.Equal(t, strings.Repeat("é", maxGrepLineLength/2)+"...", matches[0].Text)
+	})
+}
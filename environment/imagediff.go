@@ -0,0 +1,175 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+	"golang.org/x/sync/errgroup"
+)
+
+// ImageDiff describes what changed between two images, e.g. two checkpoints of an environment.
+type ImageDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+
+	// Packages is only set when packages were compared.
+	Packages *PackageDiff `json:"packages,omitempty"`
+}
+
+// PackageDiff describes the system packages changed between two images.
+type PackageDiff struct {
+	Added   []string        `json:"added,omitempty"`
+	Removed []string        `json:"removed,omitempty"`
+	Changed []PackageChange `json:"changed,omitempty"`
+}
+
+// PackageChange is a package whose version changed.
+type PackageChange struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// listPackagesScript prints "<name> <version>" for every package installed by the image's package manager.
+const listPackagesScript = `if command -v dpkg-query >/dev/null 2>&1; then
+	dpkg-query -W -f='${Package} ${Version}\n'
+elif command -v apk >/dev/null 2>&1; then
+	apk list --installed 2>/dev/null | sed -E 's/^([^ ]+)-([^-]+-r[0-9]+) .*/\1 \2/'
+elif command -v rpm >/dev/null 2>&1; then
+	rpm -qa --qf '%{NAME} %{VERSION}-%{RELEASE}\n'
+else
+	echo "no supported package manager found" >&2
+	exit 1
+fi`
+
+// CompareImages reports the files added, removed and changed from image from to image to.
+// If packages is set, the packages installed by the images' package manager (dpkg, apk or rpm) are compared as well.
+func CompareImages(ctx context.Context, dag *dagger.Client, from, to string, packages bool) (*ImageDiff, error) {
+	fromCtr := dag.Container().From(from)
+	toCtr := dag.Container().From(to)
+
+	// Diff only keeps what the other directory adds or modifies: compare both ways to find out about removals.
+	var forward, backward []string
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		forward, err = fromCtr.Rootfs().Diff(toCtr.Rootfs()).Glob(egCtx, "**/*")
+		return err
+	})
+	eg.Go(func() (err error) {
+		backward, err = toCtr.Rootfs().Diff(fromCtr.Rootfs()).Glob(egCtx, "**/*")
+		return err
+	})
+	if err := eg.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to compare images: %w", err)
+	}
+
+	diff := &ImageDiff{}
+	diff.Added, diff.Removed, diff.Changed = diffPaths(forward, backward)
+
+	if packages {
+		var fromPackages, toPackages string
+		eg, egCtx := errgroup.WithContext(ctx)
+		eg.Go(func() (err error) {
+			fromPackages, err = listPackages(egCtx, fromCtr)
+			return err
+		})
+		eg.Go(func() (err error) {
+			toPackages, err = listPackages(egCtx, toCtr)
+			return err
+		})
+		if err := eg.Wait(); err != nil {
+			return nil, fmt.Errorf("failed to list packages: %w", err)
+		}
+		diff.Packages = diffPackages(parsePackageList(fromPackages), parsePackageList(toPackages))
+	}
+
+	return diff, nil
+}
+
+func listPackages(ctx context.Context, ctr *dagger.Container) (string, error) {
+	return ctr.WithExec([]string{"sh", "-c", listPackagesScript}).Stdout(ctx)
+}
+
+// diffPaths sorts out the files of a forward diff (added or changed in the new image)
+// and a backward diff (removed or changed in the new image).
+// Directories are left out: they only show up because files changed inside of them.
+func diffPaths(forward, backward []string) (added, removed, changed []string) {
+	forwardFiles := files(forward)
+	backwardFiles := files(backward)
+
+	for p := range forwardFiles {
+		if backwardFiles[p] {
+			changed = append(changed, p)
+		} else {
+			added = append(added, p)
+		}
+	}
+	for p := range backwardFiles {
+		if !forwardFiles[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
+
+// files returns the set of paths that aren't parent directories of other paths.
+func files(paths []string) map[string]bool {
+	set := map[string]bool{}
+	for _, p := range paths {
+		if !strings.HasSuffix(p, "/") {
+			set[path.Join("/", p)] = true
+		}
+	}
+
+	dirs := map[string]bool{}
+	for p := range set {
+		for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for dir := range dirs {
+		delete(set, dir)
+	}
+	return set
+}
+
+// parsePackageList parses "<name> <version>" lines.
+func parsePackageList(list string) map[string]string {
+	packages := map[string]string{}
+	for line := range strings.SplitSeq(list, "\n") {
+		name, version, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if ok && name != "" {
+			packages[name] = strings.TrimSpace(version)
+		}
+	}
+	return packages
+}
+
+func diffPackages(from, to map[string]string) *PackageDiff {
+	diff := &PackageDiff{}
+	for _, name := range slices.Sorted(maps.Keys(to)) {
+		fromVersion, ok := from[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name+" "+to[name])
+		case fromVersion != to[name]:
+			diff.Changed = append(diff.Changed, PackageChange{Name: name, From: fromVersion, To: to[name]})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(from)) {
+		if _, ok := to[name]; !ok {
+			diff.Removed = append(diff.Removed, name+" "+from[name])
+		}
+	}
+	return diff
+}
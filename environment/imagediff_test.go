@@ -0,0 +1,28 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPaths(t *testing.T) {
+	// Directories show up in diffs when their content changes, with or without a trailing slash
+	forward := []string{"usr/", "usr/bin", "usr/bin/jq", "etc", "etc/hosts", "workdir", "workdir/main.go"}
+	backward := []string{"etc", "etc/hosts", "tmp/", "tmp/build.log"}
+
+	added, removed, changed := diffPaths(forward, backward)
+	assert.Equal(t, []string{"/usr/bin/jq", "/workdir/main.go"}, added)
+	assert.Equal(t, []string{"/tmp/build.log"}, removed)
+	assert.Equal(t, []string{"/etc/hosts"}, changed)
+}
+
+func TestDiffPackages(t *testing.T) {
+	from := parsePackageList("curl 8.5.0-2\nlibc6 2.39-0ubuntu8\nvim 2:9.1.0016-1\n")
+	to := parsePackageList("curl 8.5.0-2\nlibc6 2.39-0ubuntu8.3\njq 1.7.1-3\n\n")
+
+	diff := diffPackages(from, to)
+	assert.Equal(t, []string{"jq 1.7.1-3"}, diff.Added)
+	assert.Equal(t, []string{"vim 2:9.1.0016-1"}, diff.Removed)
+	assert.Equal(t, []PackageChange{{Name: "libc6", From: "2.39-0ubuntu8", To: "2.39-0ubuntu8.3"}}, diff.Changed)
+}
@@ -217,13 +217,16 @@ func (u *UserActions) RunCommand(envID, command, explanation string) string {
 	env, err := u.repo.Get(u.ctx, u.dag, envID)
 	require.NoError(u.t, err, "Failed to get environment %s", envID)
 
-	output, err := env.Run(u.ctx, command, "/bin/sh", false)
+	result, err := env.Run(u.ctx, environment.RunOpts{
+		Command: command,
+		Shell:   "/bin/sh",
+	})
 	require.NoError(u.t, err, "Run command should succeed")
 
 	err = u.repo.Update(u.ctx, env, explanation)
 	require.NoError(u.t, err, "repo.Update after Run should succeed")
 
-	return output
+	return result.Output
 }
 
 // CreateEnvironment mirrors environment_create MCP tool behavior
@@ -261,6 +264,30 @@ func (u *UserActions) FileDelete(envID, targetFile, explanation string) {
 	require.NoError(u.t, err, "repo.Update after FileDelete should succeed")
 }
 
+// FileMove mirrors environment_file_move MCP tool behavior
+func (u *UserActions) FileMove(envID, sourceFile, destinationFile, explanation string) {
+	env, err := u.repo.Get(u.ctx, u.dag, envID)
+	require.NoError(u.t, err, "Failed to get environment %s", envID)
+
+	err = env.FileMove(u.ctx, explanation, sourceFile, destinationFile)
+	require.NoError(u.t, err, "FileMove should succeed")
+
+	err = u.repo.Update(u.ctx, env, explanation)
+	require.NoError(u.t, err, "repo.Update after FileMove should succeed")
+}
+
+// FileCopy mirrors environment_file_copy MCP tool behavior
+func (u *UserActions) FileCopy(envID, sourceFile, destinationFile, explanation string) {
+	env, err := u.repo.Get(u.ctx, u.dag, envID)
+	require.NoError(u.t, err, "Failed to get environment %s", envID)
+
+	err = env.FileCopy(u.ctx, explanation, sourceFile, destinationFile)
+	require.NoError(u.t, err, "FileCopy should succeed")
+
+	err = u.repo.Update(u.ctx, env, explanation)
+	require.NoError(u.t, err, "repo.Update after FileCopy should succeed")
+}
+
 // FileRead mirrors environment_file_read MCP tool behavior (read-only, no update)
 func (u *UserActions) FileRead(envID, targetFile string) string {
 	env, err := u.repo.Get(u.ctx, u.dag, envID)
@@ -1,8 +1,17 @@
 package integration
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -425,6 +434,25 @@ func TestEnvironmentConfigurationPersists(t *testing.T) {
 		})
 	})
 
+	t.Run("EscapedVariablesPersist", func(t *testing.T) {
+		WithRepository(t, "escaped_variables", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+			newEnv := user.CreateEnvironment("Test with variables", "Creating environment with template variables")
+
+			updatedConfig := newEnv.State.Config.Copy()
+			updatedConfig.Env = append(updatedConfig.Env, "TOOLS=/opt/bin:$${PATH}")
+			user.UpdateEnvironment(newEnv.ID, "Test with variables", "Add tools", updatedConfig)
+
+			// Updating the resolved configuration again leaves the literal alone
+			newEnv = user.GetEnvironment(newEnv.ID)
+			updatedConfig = newEnv.State.Config.Copy()
+			updatedConfig.SetupCommands = []string{"echo updated"}
+			user.UpdateEnvironment(newEnv.ID, "Test with variables", "Add a setup command", updatedConfig)
+
+			newEnv = user.GetEnvironment(newEnv.ID)
+			assert.Contains(t, newEnv.State.Config.Env, "TOOLS=/opt/bin:${PATH}")
+		})
+	})
+
 	t.Run("SetupCommandsPersist", func(t *testing.T) {
 		WithRepository(t, "setup_commands", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
 			newEnv := user.CreateEnvironment("Test with setup", "Creating environment with setup commands")
@@ -467,6 +495,48 @@ func TestEnvironmentConfigurationPersists(t *testing.T) {
 		})
 	})
 
+	t.Run("GitConfigApplied", func(t *testing.T) {
+		WithRepository(t, "git_config", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+			newEnv := user.CreateEnvironment("Test git config", "Creating environment with git config")
+
+			updatedConfig := newEnv.State.Config.Copy()
+			updatedConfig.BaseImage = "alpine:latest"
+			updatedConfig.SetupCommands = []string{"apk add --no-cache git"}
+			updatedConfig.GitConfig = map[string]string{
+				"user.name":      "Container Use Agent",
+				"safe.directory": "*",
+			}
+
+			user.UpdateEnvironment(newEnv.ID, "Test git config", "Configure git identity", updatedConfig)
+
+			output := user.RunCommand(newEnv.ID, "git config user.name && git config safe.directory", "Check git config")
+			assert.Equal(t, "Container Use Agent\n*\n", output)
+
+			newConfig := user.GetEnvironment(newEnv.ID).State.Config
+			assert.Equal(t, updatedConfig.GitConfig, newConfig.GitConfig, "Git config should persist")
+		})
+	})
+
+	t.Run("ContextDirMounted", func(t *testing.T) {
+		WithRepository(t, "context_dir", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+			contextDir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(contextDir, "spec.md"), []byte("# Spec"), 0644))
+
+			newEnv := user.CreateEnvironment("Test context", "Creating environment with a context directory")
+			updatedConfig := newEnv.State.Config.Copy()
+			updatedConfig.ContextDir = contextDir
+			user.UpdateEnvironment(newEnv.ID, "Test context", "Mount reference material", updatedConfig)
+
+			output := user.RunCommand(newEnv.ID, "cat /context/spec.md", "Read the spec")
+			assert.Equal(t, "# Spec", output)
+
+			// The context is not part of the environment's source
+			_, err := os.Stat(filepath.Join(user.WorktreePath(newEnv.ID), "spec.md"))
+			assert.True(t, os.IsNotExist(err))
+			assert.NotContains(t, user.GitCommand("ls-tree", "-r", "--name-only", "container-use/"+newEnv.ID), "spec.md")
+		})
+	})
+
 	t.Run("EnvironmentVariable", func(t *testing.T) {
 		t.Run("Persistence", func(t *testing.T) {
 			WithRepository(t, "envvar_persistence", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
@@ -614,3 +684,551 @@ func TestEnvironmentConfigurationPersists(t *testing.T) {
 		})
 	})
 }
+
+// TestRunScript verifies that multiline scripts run without quoting issues and leave no trace in the container
+func TestRunScript(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-script", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run Script", "Testing multiline scripts")
+
+		script := "greeting=\"it's \\\"quoted\\\"\"\necho \"$greeting\"\necho second line\n"
+		result, err := env.Run(ctx, environment.RunOpts{
+			Script: script,
+			Shell:  "sh",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Equal(t, "it's \"quoted\"\nsecond line\n", result.Output)
+
+		output := user.RunCommand(env.ID, "test -e /tmp/.container-use-script && echo present || echo absent", "Check script cleanup")
+		assert.Equal(t, "absent\n", output)
+
+		_, err = env.Run(ctx, environment.RunOpts{
+			Command: "echo hi",
+			Script:  script,
+			Shell:   "sh",
+		})
+		assert.Error(t, err, "command and script should be mutually exclusive")
+	})
+}
+
+// TestRunResourceLimits verifies that per-command limits apply to that command only
+func TestServiceStartupTimeout(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "service-startup-timeout", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Service Startup Timeout", "Testing services that never become ready")
+
+		// The service never listens on its port
+		start := time.Now()
+		_, err := env.AddService(ctx, "Add a stuck service", &environment.ServiceConfig{
+			Name:           "stuck",
+			Image:          "alpine:latest",
+			Command:        "echo still booting; sleep 600",
+			ExposedPorts:   []environment.ServicePort{{Port: 8080, Protocol: environment.ProtocolTCP}},
+			StartupTimeout: 3,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to start within 3s")
+		assert.Contains(t, err.Error(), "still booting", "the output of the service is reported")
+		assert.Less(t, time.Since(start), 2*time.Minute)
+		assert.Nil(t, env.State.Config.Services.Get("stuck"), "the service isn't added")
+	})
+}
+
+func TestRunResourceLimits(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-limits", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run Limits", "Testing per-command resource limits")
+
+		limits := environment.ResourceLimits{MemoryBytes: 64 << 20, CPUSeconds: 1}
+		result, err := env.Run(ctx, environment.RunOpts{
+			Command: "ulimit -v; ulimit -t",
+			Shell:   "sh",
+			Limits:  limits,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "65536\n1\n", result.Stdout)
+
+		result, err = env.Run(ctx, environment.RunOpts{
+			Command: "while :; do :; done",
+			Shell:   "sh",
+			Limits:  limits,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 152, result.ExitCode)
+		assert.Contains(t, limits.Exceeded(result.ExitCode, result.Stderr), "CPU time limit of 1s")
+		assert.False(t, result.OOMKilled)
+
+		// SIGKILLs are only attributed to the OOM killer when the cgroup's oom_kill counter goes up
+		for _, limits := range []environment.ResourceLimits{{}, {MemoryBytes: 256 << 20}} {
+			result, err = env.Run(ctx, environment.RunOpts{
+				Command: "sh -c 'kill -9 $$'",
+				Shell:   "sh",
+				Limits:  limits,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 137, result.ExitCode)
+			assert.False(t, result.OOMKilled)
+			assert.Equal(t, environment.KilledMessage, result.KillMessage())
+		}
+
+		// The next commands run without limits
+		output := user.RunCommand(env.ID, "ulimit -t", "Check limits are gone")
+		assert.Equal(t, "unlimited\n", output)
+	})
+}
+
+func TestRunTimeout(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-timeout", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run Timeout", "Testing command timeouts")
+
+		start := time.Now()
+		_, err := env.Run(ctx, environment.RunOpts{Command: "sleep 10", Shell: "sh", Timeout: time.Second})
+		require.ErrorIs(t, err, environment.ErrCommandTimeout)
+		assert.Less(t, time.Since(start), 5*time.Second)
+
+		// The environment can still be updated and used
+		require.NoError(t, repo.Update(ctx, env, "Timed out command"))
+		output := user.RunCommand(env.ID, "echo still working", "Run after the timeout")
+		assert.Equal(t, "still working\n", output)
+
+		result, err := env.Run(ctx, environment.RunOpts{Command: "echo fast", Shell: "sh", Timeout: 30 * time.Second})
+		require.NoError(t, err)
+		assert.Equal(t, "fast\n", result.Stdout)
+	})
+}
+
+func TestRunStdin(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-stdin", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run Stdin", "Testing feeding stdin to commands")
+
+		result, err := env.Run(ctx, environment.RunOpts{Command: "cat", Shell: "sh", Stdin: "hello\nfrom stdin\n"})
+		require.NoError(t, err)
+		assert.Equal(t, "hello\nfrom stdin\n", result.Stdout)
+
+		result, err = env.Run(ctx, environment.RunOpts{Command: "cat", Shell: "sh", Stdin: "with the entrypoint\n", UseEntrypoint: true})
+		require.NoError(t, err)
+		assert.Equal(t, "with the entrypoint\n", result.Stdout)
+
+		// Large inputs are uploaded rather than inlined, and don't leak into the container
+		large := strings.Repeat("0123456789abcdef\n", 64<<10)
+		result, err = env.Run(ctx, environment.RunOpts{Command: "wc -c", Shell: "sh", Stdin: large})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d\n", len(large)), result.Stdout)
+		output := user.RunCommand(env.ID, "ls -a /tmp", "Check the input was removed")
+		assert.NotContains(t, output, ".container-use-stdin")
+	})
+}
+
+func TestRunTTY(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-tty", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run TTY", "Testing commands in a pseudo-terminal")
+
+		command := "if [ -t 1 ]; then echo terminal; else echo no terminal; fi; echo \"it's $TERM\"; exit 3"
+		result, err := env.Run(ctx, environment.RunOpts{Command: command, Shell: "sh"})
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Contains(t, result.Stdout, "no terminal\n")
+
+		result, err = env.Run(ctx, environment.RunOpts{Command: command, Shell: "sh", TTY: true})
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.ExitCode, "the exit code of the command is kept")
+		assert.Contains(t, result.Stdout, "terminal\r\n")
+		assert.NotContains(t, result.Stdout, "no terminal")
+		assert.Contains(t, result.Stdout, "it's xterm-256color", "a terminal type is set for the command")
+	})
+}
+
+// TestSharedVolumes verifies that environments mounting a volume of the same name see each other's files
+func TestSharedVolumes(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "shared-volumes", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		// Unique per test run, volumes outlive the test
+		volume := fmt.Sprintf("test-volume-%d", time.Now().UnixNano())
+		withVolume := func(env *environment.Environment) *environment.EnvironmentConfig {
+			config := env.State.Config.Copy()
+			config.Volumes = environment.VolumeConfigs{{Name: volume, Path: "/shared"}}
+			return config
+		}
+
+		writer := user.CreateEnvironment("Writer", "Producing a dataset")
+		user.UpdateEnvironment(writer.ID, "", "Mount the shared volume", withVolume(writer))
+		reader := user.CreateEnvironment("Reader", "Consuming the dataset")
+		user.UpdateEnvironment(reader.ID, "", "Mount the shared volume", withVolume(reader))
+
+		user.RunCommand(writer.ID, "echo 42 > /shared/dataset.txt", "Write the dataset")
+		assert.Equal(t, "42\n", user.RunCommand(reader.ID, "cat /shared/dataset.txt", "Read the dataset"))
+
+		// Volume contents are never committed
+		assert.NotContains(t, user.GitCommand("ls-tree", "-r", "--name-only", "container-use/"+writer.ID), "dataset.txt")
+	})
+}
+
+// TestFileMetadata verifies that file metadata combines the container filesystem and the environment's history
+func TestFileMetadata(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "file-metadata", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("File Metadata", "Testing file metadata")
+
+		user.FileWrite(env.ID, "run.sh", "#!/bin/sh\necho hi\n", "Add the run script")
+		user.RunCommand(env.ID, "chmod 755 run.sh", "Make the run script executable")
+
+		env = user.GetEnvironment(env.ID)
+		metadata, err := env.FileStat(ctx, "/workdir/run.sh")
+		require.NoError(t, err)
+		assert.Equal(t, "run.sh", metadata.Path)
+		assert.Equal(t, 18, metadata.Size)
+		assert.Equal(t, "755", metadata.Mode)
+
+		commit, err := repo.LastFileCommit(ctx, env.ID, "run.sh")
+		require.NoError(t, err)
+		require.NotNil(t, commit)
+		assert.Equal(t, "Make the run script executable", commit.Explanation)
+		assert.Equal(t, strings.TrimSpace(user.GitCommand("rev-parse", "container-use/"+env.ID)), commit.SHA)
+
+		commit, err = repo.LastFileCommit(ctx, env.ID, "never-committed.txt")
+		require.NoError(t, err)
+		assert.Nil(t, commit)
+	})
+}
+
+// TestRunBackgroundHandle verifies that background commands get a handle recorded in the environment state
+func TestRunBackgroundHandle(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-background", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Run Background", "Testing background handles")
+
+		bg, err := env.RunBackground(ctx, "sleep 300", "sh", nil, false, "")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(bg.ID, "bg-"))
+		require.NoError(t, repo.Update(ctx, env, "Start a background command"))
+
+		reloaded := user.GetEnvironment(env.ID)
+		require.Len(t, reloaded.State.BackgroundCommands, 1)
+		assert.Equal(t, bg.ID, reloaded.State.BackgroundCommands[0].ID)
+		assert.Equal(t, "sleep 300", reloaded.State.BackgroundCommands[0].Command)
+	})
+}
+
+// TestStopBackground verifies that stopping a background command releases its endpoint and removes it from the state
+func TestStopBackground(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "stop-background", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Stop Background", "Testing stopping background commands")
+
+		server := `perl -MIO::Socket::INET -e '$s = IO::Socket::INET->new(LocalPort => 8080, Listen => 5, ReuseAddr => 1) or die; while ($c = $s->accept) { print $c "hello\n"; close $c }'`
+		bg, err := env.RunBackground(ctx, server, "sh", []int{8080}, false, "")
+		require.NoError(t, err)
+		require.NoError(t, repo.Update(ctx, env, "Start a server"))
+
+		endpoint := strings.TrimPrefix(bg.Endpoints["8080"].HostExternal, "tcp://")
+		respond := func() bool {
+			conn, err := net.DialTimeout("tcp", endpoint, time.Second)
+			if err != nil {
+				return false
+			}
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			return err == nil && line == "hello\n"
+		}
+		require.Eventually(t, respond, 30*time.Second, 500*time.Millisecond, "the server responds")
+
+		assert.Error(t, env.Stop(ctx, "bg-00000000"), "unknown handles are refused")
+		assert.Error(t, env.StopService(ctx, "postgres"), "the environment has no services")
+
+		require.NoError(t, env.Stop(ctx, bg.ID))
+		require.NoError(t, repo.Update(ctx, env, "Stop the server"))
+		assert.Eventually(t, func() bool { return !respond() }, 30*time.Second, 500*time.Millisecond, "the server no longer responds")
+
+		reloaded := user.GetEnvironment(env.ID)
+		assert.Empty(t, reloaded.State.BackgroundCommands)
+		assert.Error(t, reloaded.StopBackground(ctx, bg.ID), "stopped commands are forgotten")
+	})
+}
+
+// TestListServices verifies that the running services are listed with their endpoints, including after a reload
+func TestListServices(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "list-services", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("List Services", "Testing listing services")
+
+		services, err := env.ListServices(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, services)
+
+		_, err = env.AddService(ctx, "Add a cache", &environment.ServiceConfig{
+			Name:         "cache",
+			Image:        "redis:7-alpine",
+			ExposedPorts: []environment.ServicePort{{Port: 6379, Protocol: environment.ProtocolTCP}},
+		})
+		require.NoError(t, err)
+		_, err = env.AddService(ctx, "Add a web server", &environment.ServiceConfig{
+			Name:         "web",
+			Image:        "nginx:alpine",
+			ExposedPorts: []environment.ServicePort{{Port: 80, Protocol: environment.ProtocolTCP}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, repo.Update(ctx, env, "Add services"))
+
+		assertServices := func(t *testing.T, env *environment.Environment) {
+			services, err := env.ListServices(ctx)
+			require.NoError(t, err)
+			require.Len(t, services, 2)
+
+			assert.Equal(t, "cache", services[0].Config.Name)
+			assert.Equal(t, "redis:7-alpine", services[0].Config.Image)
+			require.Contains(t, services[0].Endpoints, "6379")
+			assert.Equal(t, "tcp://cache:6379", services[0].Endpoints["6379"].EnvironmentInternal)
+			assert.NotEmpty(t, services[0].Endpoints["6379"].HostExternal)
+			assert.False(t, services[0].Stale, "this process runs the services")
+
+			assert.Equal(t, "web", services[1].Config.Name)
+			require.Contains(t, services[1].Endpoints, "80")
+			assert.Equal(t, "tcp://web:80", services[1].Endpoints["80"].EnvironmentInternal)
+			assert.NotEmpty(t, services[1].Endpoints["80"].HostExternal)
+		}
+		assertServices(t, env)
+		// Other processes, e.g. the CLI, see the endpoints saved in the state
+		assertServices(t, user.GetEnvironment(env.ID))
+
+		require.NoError(t, env.StopService(ctx, "web"))
+		require.NoError(t, repo.Update(ctx, env, "Stop the web server"))
+		services, err = user.GetEnvironment(env.ID).ListServices(ctx)
+		require.NoError(t, err)
+		require.Len(t, services, 1, "stopped services aren't listed")
+		assert.Equal(t, "cache", services[0].Config.Name)
+	})
+}
+
+// TestRemoveService verifies that removed services are forgotten and their name no longer resolves
+func TestRemoveService(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "remove-service", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Remove Service", "Testing removing services")
+
+		for _, name := range []string{"cache", "web"} {
+			_, err := env.AddService(ctx, "Add "+name, &environment.ServiceConfig{
+				Name:         name,
+				Image:        "nginx:alpine",
+				ExposedPorts: []environment.ServicePort{{Port: 80, Protocol: environment.ProtocolTCP}},
+			})
+			require.NoError(t, err)
+		}
+		require.NoError(t, repo.Update(ctx, env, "Add services"))
+
+		require.NoError(t, env.RemoveService(ctx, "Remove the cache", "cache"))
+		require.NoError(t, repo.Update(ctx, env, "Remove the cache"))
+
+		services, err := env.ListServices(ctx)
+		require.NoError(t, err)
+		require.Len(t, services, 1)
+		assert.Equal(t, "web", services[0].Config.Name)
+
+		reloaded := user.GetEnvironment(env.ID)
+		assert.Nil(t, reloaded.State.Config.Services.Get("cache"))
+		assert.NotContains(t, reloaded.State.ServiceEndpoints, "cache")
+
+		result, err := env.Run(ctx, environment.RunOpts{
+			Command: "getent hosts cache || echo unresolved; getent hosts web > /dev/null && echo resolved",
+			Shell:   "sh",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "unresolved\nresolved\n", result.Stdout, "the removed service's name no longer resolves")
+
+		err = env.RemoveService(ctx, "Remove the cache again", "cache")
+		assert.ErrorIs(t, err, environment.ErrServiceNotFound, "removing a service twice is reported as not found")
+	})
+}
+
+// TestResources verifies that the resources of the container are probed
+func TestResources(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "resources", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Resources", "Testing probing resources")
+		container := env.State.Container
+
+		resources, err := env.Resources(ctx)
+		require.NoError(t, err)
+		assert.Positive(t, resources.DiskTotalBytes)
+		assert.Positive(t, resources.DiskAvailableBytes)
+		assert.LessOrEqual(t, resources.DiskAvailableBytes, resources.DiskTotalBytes)
+		assert.Positive(t, resources.MemoryTotalBytes)
+		assert.Positive(t, resources.CPUCount)
+		assert.Equal(t, container, env.State.Container, "the container is left untouched")
+	})
+}
+
+// TestServiceLogs verifies that the output of services is returned
+func TestServiceLogs(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "service-logs", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+		env := user.CreateEnvironment("Service Logs", "Testing service logs")
+
+		_, err := env.AddService(ctx, "Add a web server", &environment.ServiceConfig{
+			Name:         "web",
+			Image:        "nginx:alpine",
+			Command:      "echo hello from the service; exec nginx -g 'daemon off;'",
+			ExposedPorts: []environment.ServicePort{{Port: 80, Protocol: environment.ProtocolTCP}},
+		})
+		require.NoError(t, err)
+		_, err = env.AddService(ctx, "Add a cache", &environment.ServiceConfig{
+			Name:         "cache",
+			Image:        "redis:7-alpine",
+			ExposedPorts: []environment.ServicePort{{Port: 6379, Protocol: environment.ProtocolTCP}},
+		})
+		require.NoError(t, err)
+
+		logs, err := env.ServiceLogs(ctx, "web", 0)
+		require.NoError(t, err)
+		assert.Contains(t, logs, "hello from the service\n")
+
+		logs, err = env.ServiceLogs(ctx, "web", 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(logs, "\n"), "only the last line is returned: %q", logs)
+
+		_, err = env.ServiceLogs(ctx, "db", 0)
+		assert.ErrorContains(t, err, "service db not found, available services: web, cache")
+		_, err = env.ServiceLogs(ctx, "cache", 0)
+		assert.ErrorIs(t, err, environment.ErrServiceLogsNotCaptured, "services started without a command have no captured output")
+		_, err = env.ServiceLogs(ctx, "web", -1)
+		assert.Error(t, err)
+	})
+}
+
+// TestCABundle verifies that the configured CA certificates are trusted in the environment
+func TestCABundle(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Corporate Proxy CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	bundlePath := filepath.Join(t.TempDir(), "proxy-ca.pem")
+	require.NoError(t, os.WriteFile(bundlePath, []byte(certPEM), 0644))
+
+	WithRepository(t, "ca-bundle", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("CA Bundle", "Testing CA bundles")
+		config := env.State.Config.Copy()
+		config.CABundle = bundlePath
+		user.UpdateEnvironment(env.ID, "", "Trust the proxy CA", config)
+
+		certLine := strings.Split(certPEM, "\n")[1]
+		output := user.RunCommand(env.ID, fmt.Sprintf(`echo "$SSL_CERT_FILE $NODE_EXTRA_CA_CERTS" && grep -qF %q "$SSL_CERT_FILE" && echo trusted`, certLine), "Check the trust store")
+		assert.Contains(t, output, "/etc/ssl/certs/ca-certificates.crt /usr/local/share/ca-certificates/container-use-ca.crt")
+		assert.Contains(t, output, "trusted")
+
+		config.CABundle = filepath.Join(t.TempDir(), "missing.pem")
+		env = user.GetEnvironment(env.ID)
+		assert.ErrorContains(t, env.UpdateConfig(t.Context(), config), "CA bundle")
+	})
+}
+
+// TestRunNonUTF8Output verifies that output that isn't valid UTF-8 is returned as valid text or base64
+func TestRunNonUTF8Output(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "non-utf8-output", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Non UTF-8", "Testing binary output")
+		env = user.GetEnvironment(env.ID)
+
+		opts := environment.RunOpts{Command: `printf 'caf\351\n'`, Shell: "sh"}
+		result, err := env.Run(t.Context(), opts)
+		require.NoError(t, err)
+		assert.True(t, result.InvalidUTF8)
+		assert.Equal(t, "caf�\n", result.Output)
+
+		opts.OutputEncoding = environment.OutputEncodingBase64
+		result, err = env.Run(t.Context(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, "Y2Fm6Qo=", result.Output)
+	})
+}
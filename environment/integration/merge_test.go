@@ -31,7 +31,7 @@ func TestRepositoryMerge(t *testing.T) {
 
 		// Merge the environment (without squash)
 		var mergeOutput bytes.Buffer
-		err = repo.Merge(ctx, env.ID, &mergeOutput)
+		err = repo.Merge(ctx, env.ID, repository.MergeOpts{}, &mergeOutput)
 		require.NoError(t, err, "Merge should succeed: %s", mergeOutput.String())
 
 		// Verify we're still on the initial branch
@@ -132,7 +132,7 @@ func TestRepositoryMergeNonExistent(t *testing.T) {
 
 		// Try to merge non-existent environment
 		var mergeOutput bytes.Buffer
-		err := repo.Merge(ctx, "non-existent-env", &mergeOutput)
+		err := repo.Merge(ctx, "non-existent-env", repository.MergeOpts{}, &mergeOutput)
 		assert.Error(t, err, "Merging non-existent environment should fail")
 		assert.Contains(t, err.Error(), "not found")
 	})
@@ -152,6 +152,49 @@ func TestRepositoryApplyNonExistent(t *testing.T) {
 	})
 }
 
+// TestRepositoryApplyPatch tests applying an environment's diff as uncommitted changes
+func TestRepositoryApplyPatch(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-apply-patch", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+
+		env := user.CreateEnvironment("Test Apply Patch", "Testing applying the diff of an environment")
+		user.FileWrite(env.ID, "README.md", "# Test Project\n\nUpdated by the agent\n", "Update README")
+		user.FileWrite(env.ID, "patch-test.txt", "new file", "Add file")
+
+		headBefore := strings.TrimSpace(user.GitCommand("rev-parse", "HEAD"))
+
+		// Check doesn't change anything
+		var output bytes.Buffer
+		err := repo.ApplyPatch(ctx, env.ID, repository.ApplyPatchOpts{Check: true}, &output)
+		require.NoError(t, err, output.String())
+		assert.Empty(t, strings.TrimSpace(user.GitCommand("status", "--porcelain")))
+
+		// Uncommitted changes to a file changed by the environment are refused
+		user.WriteSourceFile("README.md", "# Test Project\n\nEdited by hand\n")
+		err = repo.ApplyPatch(ctx, env.ID, repository.ApplyPatchOpts{}, &output)
+		var dirtyErr *repository.DirtyFilesError
+		require.ErrorAs(t, err, &dirtyErr)
+		assert.Equal(t, []string{"README.md"}, dirtyErr.Files)
+		user.GitCommand("checkout", "--", "README.md")
+
+		// The changes are left unstaged, without commits
+		output.Reset()
+		err = repo.ApplyPatch(ctx, env.ID, repository.ApplyPatchOpts{}, &output)
+		require.NoError(t, err, output.String())
+
+		content, err := os.ReadFile(filepath.Join(repo.SourcePath(), "README.md"))
+		require.NoError(t, err)
+		assert.Equal(t, "# Test Project\n\nUpdated by the agent\n", string(content))
+		content, err = os.ReadFile(filepath.Join(repo.SourcePath(), "patch-test.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "new file", string(content))
+
+		assert.Empty(t, strings.TrimSpace(user.GitCommand("diff", "--cached", "--name-only")), "changes should not be staged")
+		assert.Equal(t, headBefore, strings.TrimSpace(user.GitCommand("rev-parse", "HEAD")), "nothing should be committed")
+	})
+}
+
 // TestRepositoryMergeWithConflicts tests merge behavior when there are conflicts
 func TestRepositoryMergeWithConflicts(t *testing.T) {
 	t.Parallel()
@@ -173,12 +216,24 @@ func TestRepositoryMergeWithConflicts(t *testing.T) {
 
 		// Try to merge - this should either succeed with conflict resolution or fail gracefully
 		var mergeOutput bytes.Buffer
-		err = repo.Merge(ctx, env.ID, &mergeOutput)
+		err = repo.Merge(ctx, env.ID, repository.MergeOpts{}, &mergeOutput)
 
 		// The merge should fail due to conflict
 		assert.Error(t, err, "Merge should fail due to conflict")
 		outputStr := mergeOutput.String()
 		assert.Contains(t, outputStr, "conflict", "Merge output should mention conflict: %s", outputStr)
+
+		// The conflicting files are reported, and the working directory is left untouched
+		var conflictErr *repository.MergeConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Len(t, conflictErr.Conflicts, 1)
+		assert.Equal(t, "conflict.txt", conflictErr.Conflicts[0].Path)
+		status, err := repository.RunGitCommand(ctx, repo.SourcePath(), "status", "--porcelain")
+		require.NoError(t, err)
+		assert.Empty(t, status)
+		content, err := os.ReadFile(conflictFile)
+		require.NoError(t, err)
+		assert.Equal(t, "main branch content", string(content))
 	})
 }
 
@@ -225,7 +280,7 @@ func TestRepositoryMergeCompleted(t *testing.T) {
 
 		// First merge
 		var mergeOutput1 bytes.Buffer
-		err := repo.Merge(ctx, env.ID, &mergeOutput1)
+		err := repo.Merge(ctx, env.ID, repository.MergeOpts{}, &mergeOutput1)
 		require.NoError(t, err, "First merge should succeed: %s", mergeOutput1.String())
 
 		// Verify first merge content
@@ -239,7 +294,7 @@ func TestRepositoryMergeCompleted(t *testing.T) {
 
 		// Second merge
 		var mergeOutput2 bytes.Buffer
-		err = repo.Merge(ctx, env.ID, &mergeOutput2)
+		err = repo.Merge(ctx, env.ID, repository.MergeOpts{}, &mergeOutput2)
 		require.NoError(t, err, "Second merge should succeed: %s", mergeOutput2.String())
 
 		// Verify second merge content
@@ -255,3 +310,72 @@ func TestRepositoryMergeCompleted(t *testing.T) {
 		assert.Contains(t, log, "Update file content", "Log should contain update commit")
 	})
 }
+
+// TestRepositoryCheckMerge tests that trial merges report conflicts without modifying the repository
+func TestRepositoryCheckMerge(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-check-merge", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+
+		env := user.CreateEnvironment("Test Check Merge", "Testing merge check")
+		user.FileWrite(env.ID, "conflict.txt", "environment branch content", "Modify conflict file")
+
+		check, err := repo.CheckMerge(ctx, env.ID)
+		require.NoError(t, err)
+		assert.True(t, check.Mergeable)
+		assert.Empty(t, check.Conflicts)
+
+		user.WriteFileInSourceRepo("conflict.txt", "main branch content", "Add conflict file in main")
+		head, err := repository.RunGitCommand(ctx, repo.SourcePath(), "rev-parse", "HEAD")
+		require.NoError(t, err)
+
+		check, err = repo.CheckMerge(ctx, env.ID)
+		require.NoError(t, err)
+		assert.False(t, check.Mergeable)
+		require.Len(t, check.Conflicts, 1)
+		assert.Equal(t, "conflict.txt", check.Conflicts[0].Path)
+		assert.NotEmpty(t, check.Conflicts[0].Messages)
+
+		// Nothing was merged
+		newHead, err := repository.RunGitCommand(ctx, repo.SourcePath(), "rev-parse", "HEAD")
+		require.NoError(t, err)
+		assert.Equal(t, head, newHead)
+		status, err := repository.RunGitCommand(ctx, repo.SourcePath(), "status", "--porcelain")
+		require.NoError(t, err)
+		assert.Empty(t, status)
+	})
+}
+
+// TestRepositoryMergeOptions tests fast-forward, squash and custom message merges
+func TestRepositoryMergeOptions(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-merge-options", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+
+		env := user.CreateEnvironment("Test Merge Options", "Testing merge options")
+		user.FileWrite(env.ID, "first.txt", "first", "Add first file")
+
+		// Fast-forward: the branch points to the environment's head, without merge commit
+		var output bytes.Buffer
+		require.NoError(t, repo.Merge(ctx, env.ID, repository.MergeOpts{FastForward: true}, &output), output.String())
+		head, err := repository.RunGitCommand(ctx, repo.SourcePath(), "rev-parse", "HEAD")
+		require.NoError(t, err)
+		envHead, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, envHead, strings.TrimSpace(head))
+
+		// Squash: a single commit with the given message
+		user.FileWrite(env.ID, "second.txt", "second", "Add second file")
+		user.FileWrite(env.ID, "third.txt", "third", "Add third file")
+		require.NoError(t, repo.Merge(ctx, env.ID, repository.MergeOpts{Squash: true, Message: "Add second and third files"}, &output), output.String())
+		log, err := repository.RunGitCommand(ctx, repo.SourcePath(), "log", "--format=%s", "-2")
+		require.NoError(t, err)
+		assert.Equal(t, "Add second and third files\nAdd first file\n", log)
+		parents, err := repository.RunGitCommand(ctx, repo.SourcePath(), "rev-list", "--parents", "-1", "HEAD")
+		require.NoError(t, err)
+		assert.Len(t, strings.Fields(parents), 2, "a squashed commit has a single parent")
+		content, err := os.ReadFile(filepath.Join(repo.SourcePath(), "third.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "third", string(content))
+	})
+}
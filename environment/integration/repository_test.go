@@ -1,12 +1,17 @@
 package integration
 
 import (
+	"archive/zip"
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -104,6 +109,62 @@ func TestRepositoryDelete(t *testing.T) {
 	})
 }
 
+// TestRepositoryPauseResume tests that paused environments can't be used until resumed
+func TestRepositoryPauseResume(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-pause-resume", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Pause", "Testing repository pause")
+		user.FileWrite(env.ID, "state.txt", "kept", "Write a file before pausing")
+		bg, err := env.RunBackground(ctx, "sleep 300", "sh", nil, false, "")
+		require.NoError(t, err)
+		require.NoError(t, repo.Update(ctx, env, "Start a background command"))
+
+		require.NoError(t, repo.Pause(ctx, env.ID))
+
+		info, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		assert.True(t, info.State.Paused)
+		// The process running the background command is asked to stop it
+		assert.True(t, repository.StopRequested(env.ID, bg.ID, time.Time{}))
+		assert.Empty(t, info.State.BackgroundCommands)
+
+		_, err = repo.Get(ctx, user.dag, env.ID)
+		assert.ErrorContains(t, err, "paused")
+		assert.Error(t, repo.Pause(ctx, env.ID), "pausing twice should fail")
+
+		resumed, err := repo.Resume(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+		assert.False(t, resumed.State.Paused)
+
+		// The container is rebuilt from the committed files
+		assert.Equal(t, "kept", user.FileRead(env.ID, "state.txt"))
+
+		_, err = repo.Resume(ctx, user.dag, env.ID)
+		assert.Error(t, err, "resuming an active environment should fail")
+	})
+}
+
+// TestRepositoryRebuild tests that rebuilding keeps committed files and discards other container changes
+func TestRepositoryRebuild(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-rebuild", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Rebuild", "Testing repository rebuild")
+		user.FileWrite(env.ID, "kept.txt", "kept", "Write a committed file")
+		user.RunCommand(env.ID, "touch /tmp/wedged", "Change the container outside the workdir")
+
+		_, err := repo.Rebuild(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, "kept", user.FileRead(env.ID, "kept.txt"))
+		output := user.RunCommand(env.ID, "test -e /tmp/wedged && echo present || echo absent", "Check the container was rebuilt")
+		assert.Equal(t, "absent\n", output)
+	})
+}
+
 // TestRepositoryCheckout tests checking out an environment branch
 func TestRepositoryCheckout(t *testing.T) {
 	t.Parallel()
@@ -143,7 +204,7 @@ func TestRepositoryLog(t *testing.T) {
 
 		// Get commit log without patches
 		var logBuf bytes.Buffer
-		err := repo.Log(ctx, env.ID, false, &logBuf)
+		err := repo.Log(ctx, env.ID, false, false, &logBuf)
 		logOutput := logBuf.String()
 		require.NoError(t, err, logOutput)
 
@@ -154,7 +215,7 @@ func TestRepositoryLog(t *testing.T) {
 
 		// Get commit log with patches
 		logBuf.Reset()
-		err = repo.Log(ctx, env.ID, true, &logBuf)
+		err = repo.Log(ctx, env.ID, true, false, &logBuf)
 		logWithPatchOutput := logBuf.String()
 		require.NoError(t, err, logWithPatchOutput)
 
@@ -163,11 +224,142 @@ func TestRepositoryLog(t *testing.T) {
 		assert.Contains(t, logWithPatchOutput, "+updated content")
 
 		// Test log for non-existent environment
-		err = repo.Log(ctx, "non-existent-env", false, &logBuf)
+		err = repo.Log(ctx, "non-existent-env", false, false, &logBuf)
 		assert.Error(t, err)
 	})
 }
 
+// TestRepositoryCommitMetadata tests that commits made during a tool call are annotated with its metadata
+func TestRepositoryCommitMetadata(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-commit-metadata", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Test Metadata", "Testing commit metadata")
+
+		ctx := repository.WithToolCall(t.Context(), repository.ToolCall{
+			Tool:      "environment_file_write",
+			Arguments: map[string]string{"target_file": "file.txt"},
+			StartedAt: time.Now(),
+		})
+		loaded, err := repo.Get(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+		require.NoError(t, loaded.FileWrite(ctx, "Write file", "file.txt", "content"))
+		require.NoError(t, repo.Update(ctx, loaded, "Write file"))
+
+		metadata, err := repo.CommitMetadata(ctx, loaded.Head)
+		require.NoError(t, err)
+		require.NotNil(t, metadata)
+		assert.Equal(t, "environment_file_write", metadata.Tool)
+		assert.Equal(t, "file.txt", metadata.Arguments["target_file"])
+		assert.Equal(t, env.ID, metadata.Environment)
+
+		// Commits made outside of tool calls have none
+		user.FileWrite(env.ID, "other.txt", "content", "Write other file")
+		head, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		metadata, err = repo.CommitMetadata(ctx, head)
+		require.NoError(t, err)
+		assert.Nil(t, metadata)
+
+		var logBuf bytes.Buffer
+		require.NoError(t, repo.Log(ctx, env.ID, false, true, &logBuf))
+		assert.Contains(t, logBuf.String(), `"tool":"environment_file_write"`)
+	})
+}
+
+// TestRepositoryPushBranch tests pushing an environment's work as a regularly named branch
+func TestRepositoryPushBranch(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-push-branch", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		remote := t.TempDir()
+		_, err := repository.RunGitCommand(ctx, remote, "init", "--bare")
+		require.NoError(t, err)
+		user.GitCommand("remote", "add", "origin", remote)
+
+		env := user.CreateEnvironment("Test Push Branch", "Testing pushing an environment branch")
+		user.FileWrite(env.ID, "feature.txt", "feature", "Add feature")
+
+		var output bytes.Buffer
+		require.NoError(t, repo.PushBranch(ctx, env.ID, "origin", "feature/my-work", false, &output), output.String())
+		head, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		pushed, err := repository.RunGitCommand(ctx, remote, "rev-parse", "refs/heads/feature/my-work")
+		require.NoError(t, err)
+		assert.Equal(t, head, strings.TrimSpace(pushed))
+
+		// New commits fast-forward the branch
+		user.FileWrite(env.ID, "feature.txt", "feature v2", "Update feature")
+		require.NoError(t, repo.PushBranch(ctx, env.ID, "origin", "feature/my-work", false, &output), output.String())
+
+		// A branch with other commits is only overwritten with force
+		user.WriteFileInSourceRepo("other.txt", "other", "Other work")
+		user.GitCommand("push", "origin", "HEAD:refs/heads/taken")
+		err = repo.PushBranch(ctx, env.ID, "origin", "taken", false, &output)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--force")
+		require.NoError(t, repo.PushBranch(ctx, env.ID, "origin", "taken", true, &output), output.String())
+
+		assert.Error(t, repo.PushBranch(ctx, env.ID, "missing", "feature/my-work", false, &output), "unknown remote")
+		assert.Error(t, repo.PushBranch(ctx, env.ID, "origin", "bad..name", false, &output), "invalid branch name")
+	})
+}
+
+// TestRepositoryRebase tests replaying an environment onto a base that moved forward
+func TestRepositoryRebase(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-rebase", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		user.WriteFileInSourceRepo("initial.txt", "initial content", "Initial commit")
+		env := user.CreateEnvironment("Rebase", "Environment to rebase")
+		user.FileWrite(env.ID, "feature.txt", "feature content", "Add feature")
+
+		// main moves forward
+		user.WriteFileInSourceRepo("main.txt", "main content", "Add main file")
+
+		commits, err := repo.Rebase(ctx, user.dag, env.ID, "main")
+		require.NoError(t, err)
+		subjects := []string{}
+		for _, commit := range commits {
+			subjects = append(subjects, commit.Subject)
+		}
+		assert.Contains(t, subjects, "Add feature")
+
+		// The environment picked up the new base, in git and in its container
+		head, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		_, err = repository.RunGitCommand(ctx, repo.SourcePath(), "merge-base", "--is-ancestor", "main", head)
+		assert.NoError(t, err, "main should be an ancestor of the environment")
+		remoteRef, err := repository.RunGitCommand(ctx, repo.SourcePath(), "rev-parse", "container-use/"+env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, head, strings.TrimSpace(remoteRef))
+		assert.Contains(t, user.FileRead(env.ID, "main.txt"), "main content")
+		assert.Contains(t, user.FileRead(env.ID, "feature.txt"), "feature content")
+
+		// Later changes keep the new base
+		user.FileWrite(env.ID, "other.txt", "other content", "Add other file")
+		assert.Equal(t, "main content", user.ReadWorktreeFile(env.ID, "main.txt"))
+
+		// Conflicting commits abort the rebase
+		user.FileWrite(env.ID, "main.txt", "environment content", "Change main file")
+		user.WriteFileInSourceRepo("main.txt", "new main content", "Change main file in main")
+		before, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+
+		_, err = repo.Rebase(ctx, user.dag, env.ID, "main")
+		var conflictErr *repository.RebaseConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		assert.Equal(t, []string{"main.txt"}, conflictErr.Files)
+		after, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+		status, err := repository.RunGitCommand(ctx, user.WorktreePath(env.ID), "status", "--porcelain")
+		require.NoError(t, err)
+		assert.Empty(t, status)
+	})
+}
+
 // TestRepositoryCreateFromGitRef tests creating environments from specific git references
 func TestRepositoryCreateFromGitRef(t *testing.T) {
 	t.Parallel()
@@ -276,7 +468,10 @@ func TestRepositoryWithSubmodule(t *testing.T) {
 		// Below we document the behavior of env.Run-instigated file writes to submodules.
 		// Ideally, these would error, but practically we don't have an easy way to detect them.
 		// env.Run-instigated submodules writes do not error, but they also do not propagate outwards to the fork repository.
-		_, err := env.Run(ctx, "echo 'content from env_run_cmd' > submodule/test-from-cmd.txt", "sh", false)
+		_, err := env.Run(ctx, environment.RunOpts{
+			Command: "echo 'content from env_run_cmd' > submodule/test-from-cmd.txt",
+			Shell:   "sh",
+		})
 		require.NoError(t, err, "env_run_cmd should be able to write files in submodules")
 
 		// Verify the file was created inside the container
@@ -380,3 +575,833 @@ func TestRepositoryDiff(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+// TestRepositoryDiffStat tests summarizing the changes of an environment
+func TestRepositoryDiffStat(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-diff-stat", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Diff Stat", "Testing repository diff summary")
+		user.FileWrite(env.ID, "README.md", "# Project\nline 2\nline 3\n", "Rewrite README")
+		user.FileWrite(env.ID, "notes.txt", "first\nsecond\n", "Add notes")
+
+		var diffBuf bytes.Buffer
+		err := repo.DiffWithOpts(ctx, env.ID, repository.DiffOpts{Stat: true}, &diffBuf)
+		diffOutput := diffBuf.String()
+		require.NoError(t, err, diffOutput)
+
+		assert.Contains(t, diffOutput, "README.md")
+		assert.Contains(t, diffOutput, "notes.txt")
+		assert.Contains(t, diffOutput, "2 files changed, 5 insertions(+), 1 deletion(-)")
+		assert.NotContains(t, diffOutput, "+line 2", "the patch isn't printed")
+	})
+}
+
+// TestRepositoryDiffEnvironments tests comparing the files of two environments
+func TestRepositoryDiffEnvironments(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-diff-environments", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		first := user.CreateEnvironment("First attempt", "Trying one approach")
+		user.FileWrite(first.ID, "first.txt", "first approach\n", "First approach")
+		second := user.CreateEnvironment("Second attempt", "Trying another approach")
+		user.FileWrite(second.ID, "second.txt", "second approach\n", "Second approach")
+
+		var diffBuf bytes.Buffer
+		err := repo.DiffEnvironments(ctx, first.ID, second.ID, &diffBuf)
+		diffOutput := diffBuf.String()
+		require.NoError(t, err, diffOutput)
+		assert.Contains(t, diffOutput, "first.txt")
+		assert.Contains(t, diffOutput, "-first approach")
+		assert.Contains(t, diffOutput, "second.txt")
+		assert.Contains(t, diffOutput, "+second approach")
+
+		// Environments without common history can be compared too
+		emptyTree := strings.TrimSpace(user.GitCommand("hash-object", "-t", "tree", "/dev/null"))
+		orphan := strings.TrimSpace(user.GitCommand("commit-tree", emptyTree, "-m", "Unrelated history"))
+		user.GitCommand("branch", "unrelated", orphan)
+		unrelated, err := repo.Create(ctx, user.dag, "Unrelated", "Starting from scratch", "unrelated")
+		require.NoError(t, err)
+		user.FileWrite(unrelated.ID, "unrelated.txt", "unrelated\n", "Unrelated work")
+
+		diffBuf.Reset()
+		err = repo.DiffEnvironments(ctx, first.ID, unrelated.ID, &diffBuf)
+		diffOutput = diffBuf.String()
+		require.NoError(t, err, diffOutput)
+		assert.Contains(t, diffOutput, "first.txt")
+		assert.Contains(t, diffOutput, "unrelated.txt")
+
+		err = repo.DiffEnvironments(ctx, first.ID, "non-existent-env", &diffBuf)
+		assert.Error(t, err)
+	})
+}
+
+// TestRepositoryFormatPatch tests exporting an environment's commits as patches
+func TestRepositoryFormatPatch(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-format-patch", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Format Patch", "Testing patch export")
+		user.FileWrite(env.ID, "test.txt", "initial content\n", "Add test file")
+		user.FileWrite(env.ID, "test.txt", "initial content\nupdated content\n", "Update test file")
+
+		outputDir := t.TempDir()
+		patches, err := repo.FormatPatch(ctx, env.ID, outputDir)
+		require.NoError(t, err)
+		require.NotEmpty(t, patches)
+
+		var series strings.Builder
+		for _, patch := range patches {
+			assert.Equal(t, outputDir, filepath.Dir(patch))
+			content, err := os.ReadFile(patch)
+			require.NoError(t, err)
+			series.Write(content)
+		}
+
+		// Commit messages are preserved
+		assert.Contains(t, series.String(), "Subject: [PATCH")
+		assert.Contains(t, series.String(), "Update test file")
+		assert.Contains(t, series.String(), "+updated content")
+
+		_, err = repo.FormatPatch(ctx, "non-existent-env", outputDir)
+		assert.Error(t, err)
+	})
+}
+
+// TestRepositoryWhatsNew tests showing the commits made since the user last looked
+func TestRepositoryWhatsNew(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-whatsnew", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Whatsnew", "Testing whatsnew")
+		user.FileWrite(env.ID, "first.txt", "first\n", "Add first file")
+
+		// First call shows the whole history
+		var buf bytes.Buffer
+		changed, err := repo.WhatsNew(ctx, env.ID, false, &buf)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Contains(t, buf.String(), "Add first file")
+
+		// Nothing happened since
+		buf.Reset()
+		changed, err = repo.WhatsNew(ctx, env.ID, false, &buf)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Empty(t, buf.String())
+
+		// Only new commits are shown
+		user.FileWrite(env.ID, "second.txt", "second\n", "Add second file")
+		buf.Reset()
+		changed, err = repo.WhatsNew(ctx, env.ID, true, &buf)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Contains(t, buf.String(), "Add second file")
+		assert.Contains(t, buf.String(), "+second")
+		assert.NotContains(t, buf.String(), "Add first file")
+	})
+}
+
+// TestRepositoryCorruptedWorktree tests that corrupted worktrees are recreated from the environment branch
+func TestRepositoryCorruptedWorktree(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-corrupted-worktree", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Corrupted Worktree", "Testing worktree recovery")
+		user.FileWrite(env.ID, "test.txt", "committed content\n", "Add test file")
+
+		worktreePath := user.WorktreePath(env.ID)
+		gitDir, err := repository.RunGitCommand(ctx, worktreePath, "rev-parse", "--absolute-git-dir")
+		require.NoError(t, err)
+		gitDir = strings.TrimSpace(gitDir)
+
+		t.Run("interrupted git operation", func(t *testing.T) {
+			// A crashed git command leaves its lock behind
+			require.NoError(t, os.WriteFile(filepath.Join(gitDir, "index.lock"), nil, 0644))
+
+			info, err := repo.Info(ctx, env.ID)
+			require.NoError(t, err)
+			assert.Equal(t, env.ID, info.ID)
+			assert.NoFileExists(t, filepath.Join(gitDir, "index.lock"))
+
+			assert.Equal(t, "committed content\n", user.ReadWorktreeFile(env.ID, "test.txt"))
+		})
+
+		t.Run("explicit repair", func(t *testing.T) {
+			user.CorruptWorktree(env.ID)
+
+			require.NoError(t, repo.RepairWorktree(ctx, env.ID))
+			assert.Equal(t, "committed content\n", user.ReadWorktreeFile(env.ID, "test.txt"))
+
+			// The environment keeps working
+			user.FileWrite(env.ID, "test.txt", "new content\n", "Update test file after repair")
+			assert.Equal(t, "new content\n", user.FileRead(env.ID, "test.txt"))
+		})
+
+		assert.Error(t, repo.RepairWorktree(ctx, "non-existent-env"))
+	})
+}
+
+// TestRepositoryFsck tests that inconsistencies between an environment's branch, worktree and state are reported and fixed
+func TestRepositoryFsck(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-fsck", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Fsck", "Testing consistency checks")
+		user.FileWrite(env.ID, "test.txt", "committed content\n", "Add test file")
+
+		issues, err := repo.Fsck(ctx, env.ID, false)
+		require.NoError(t, err)
+		assert.Empty(t, issues)
+
+		// Break everything: the state of the latest commit, the remote branch and the worktree
+		worktreePath := user.WorktreePath(env.ID)
+		_, err = repository.RunGitCommand(ctx, worktreePath, "notes", "--ref", "container-use-state", "remove")
+		require.NoError(t, err)
+		user.GitCommand("update-ref", "-d", "refs/remotes/container-use/"+env.ID)
+		require.NoError(t, os.WriteFile(filepath.Join(worktreePath, "test.txt"), []byte("interrupted\n"), 0644))
+
+		issues, err = repo.Fsck(ctx, env.ID, false)
+		require.NoError(t, err)
+		require.Len(t, issues, 3)
+		assert.Contains(t, issues[0].Problem, "is missing from the repository")
+		assert.Contains(t, issues[1].Problem, "uncommitted changes: test.txt")
+		assert.Contains(t, issues[2].Problem, "has no state")
+		for _, issue := range issues {
+			assert.NotEmpty(t, issue.Fix)
+			assert.False(t, issue.Fixed)
+		}
+
+		issues, err = repo.Fsck(ctx, env.ID, true)
+		require.NoError(t, err)
+		require.Len(t, issues, 3)
+		for _, issue := range issues {
+			assert.True(t, issue.Fixed, issue.Problem)
+		}
+
+		issues, err = repo.Fsck(ctx, env.ID, false)
+		require.NoError(t, err)
+		assert.Empty(t, issues)
+		assert.Equal(t, "committed content\n", user.ReadWorktreeFile(env.ID, "test.txt"))
+
+		// The environment keeps working with the restored state
+		info, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Test Fsck", info.State.Title)
+		user.FileWrite(env.ID, "test.txt", "new content\n", "Update test file after fsck")
+		assert.Equal(t, "new content\n", user.FileRead(env.ID, "test.txt"))
+
+		_, err = repo.Fsck(ctx, "non-existent-env", false)
+		assert.Error(t, err)
+	})
+}
+
+// TestRepositoryPrune tests that only environments older than the threshold are pruned
+func TestRepositoryPrune(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-prune", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Prune", "Testing prune")
+
+		pruned, err := repo.Prune(ctx, time.Hour)
+		require.NoError(t, err)
+		assert.Empty(t, pruned)
+
+		stale, err := repo.ListStale(ctx, 0)
+		require.NoError(t, err)
+		require.Len(t, stale, 1)
+		assert.Equal(t, env.ID, stale[0].ID)
+
+		pruned, err = repo.Prune(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{env.ID}, pruned)
+
+		envs, err := repo.List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, envs)
+	})
+}
+
+// TestRepositoryTouch tests that touched environments are fresh again and pinned ones are never pruned
+func TestRepositoryTouch(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-touch", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Touch", "Testing touch")
+		before, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, repo.Touch(ctx, env.ID))
+		after, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		assert.True(t, after.State.UpdatedAt.After(before.State.UpdatedAt))
+
+		require.NoError(t, repo.SetPinned(ctx, env.ID, true))
+		pruned, err := repo.Prune(ctx, 0)
+		require.NoError(t, err)
+		assert.Empty(t, pruned)
+
+		require.NoError(t, repo.SetPinned(ctx, env.ID, false))
+		pruned, err = repo.Prune(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{env.ID}, pruned)
+	})
+}
+
+// TestFileWriteWithMode tests that files are written with the requested permissions and committed as such
+func TestFileWriteWithMode(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "file-write-mode", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test File Mode", "Testing file modes")
+		require.NoError(t, env.FileWriteWithMode(ctx, "Add script", "run.sh", "#!/bin/sh\necho hello\n", 0755))
+		require.NoError(t, repo.UpdateFile(ctx, env, "run.sh", "Add script"))
+
+		assert.Equal(t, "hello\n", user.RunCommand(env.ID, "./run.sh", "Run script"))
+
+		info, err := os.Stat(filepath.Join(user.WorktreePath(env.ID), "run.sh"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	})
+}
+
+// TestFileMoveAndCopy tests that files are moved and copied with their permissions, each in a single commit
+func TestFileMoveAndCopy(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "file-move-copy", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test File Move", "Testing moving and copying files")
+		require.NoError(t, env.FileWriteWithMode(ctx, "Add script", "scripts/build.sh", "#!/bin/sh\necho build\n", 0755))
+		require.NoError(t, repo.Update(ctx, env, "Add script"))
+		user.FileWrite(env.ID, "scripts/test.sh", "old", "Add another script")
+
+		t.Run("rename within a directory", func(t *testing.T) {
+			user.FileMove(env.ID, "scripts/build.sh", "scripts/make.sh", "Rename the build script")
+
+			worktree := user.WorktreePath(env.ID)
+			assert.NoFileExists(t, filepath.Join(worktree, "scripts/build.sh"))
+			info, err := os.Stat(filepath.Join(worktree, "scripts/make.sh"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+			assert.Equal(t, "build\n", user.RunCommand(env.ID, "./scripts/make.sh", "Run the renamed script"))
+
+			log := user.GitCommand("log", "-1", "--name-status", "--format=%s", "container-use/"+env.ID)
+			assert.Contains(t, log, "Rename the build script")
+			assert.Contains(t, log, "scripts/make.sh")
+		})
+
+		t.Run("copy into a new directory", func(t *testing.T) {
+			user.FileCopy(env.ID, "scripts/make.sh", "ci/steps/make.sh", "Copy the build script for CI")
+			assert.Equal(t, "#!/bin/sh\necho build\n", user.ReadWorktreeFile(env.ID, "ci/steps/make.sh"))
+			assert.Equal(t, "#!/bin/sh\necho build\n", user.ReadWorktreeFile(env.ID, "scripts/make.sh"))
+		})
+
+		t.Run("overwrite the destination", func(t *testing.T) {
+			user.FileCopy(env.ID, "scripts/make.sh", "scripts/test.sh", "Replace the test script")
+			assert.Equal(t, "#!/bin/sh\necho build\n", user.ReadWorktreeFile(env.ID, "scripts/test.sh"))
+		})
+
+		t.Run("invalid moves", func(t *testing.T) {
+			env := user.GetEnvironment(env.ID)
+			assert.Error(t, env.FileMove(ctx, "Move a missing file", "missing.txt", "other.txt"))
+			assert.ErrorContains(t, env.FileMove(ctx, "Move onto itself", "scripts/make.sh", "./scripts/../scripts/make.sh"), "same file")
+		})
+	})
+}
+
+// TestFileListRecursive tests that recursive listings include nested files but leave ignored ones out
+func TestFileListRecursive(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "file-list-recursive", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test File List", "Testing recursive listings")
+		user.FileWrite(env.ID, ".gitignore", "*.log\n", "Ignore logs")
+		user.FileWrite(env.ID, "src/pkg/deep/main.go", "package deep\n", "Add a nested file")
+		user.RunCommand(env.ID, "mkdir -p node_modules/lib && touch node_modules/lib/index.js src/debug.log && echo lib > src/.gitignore && mkdir src/lib", "Add ignored files")
+		env = user.GetEnvironment(env.ID)
+
+		flat, err := env.FileList(ctx, ".")
+		require.NoError(t, err)
+		assert.Contains(t, flat, "src")
+		assert.NotContains(t, flat, "src/pkg/deep/main.go", "deep paths are only listed recursively")
+
+		tree, err := env.FileListWithOpts(ctx, ".", environment.FileListOpts{Recursive: true})
+		require.NoError(t, err)
+		assert.Contains(t, tree, "src/pkg/\n")
+		assert.Contains(t, tree, "src/pkg/deep/main.go\n")
+		assert.Contains(t, tree, "node_modules/lib/index.js\n", "files are only ignored as .gitignore says")
+		assert.NotContains(t, tree, "debug.log")
+		assert.NotContains(t, tree, "src/lib/", "nested .gitignore files apply")
+
+		tree, err = env.FileListWithOpts(ctx, "src", environment.FileListOpts{Recursive: true, MaxDepth: 2})
+		require.NoError(t, err)
+		assert.Contains(t, tree, "pkg/deep/\n")
+		assert.NotContains(t, tree, "pkg/deep/main.go")
+		assert.NotContains(t, tree, "debug.log", "the .gitignore files of parent directories apply")
+
+		tree, err = env.FileListWithOpts(ctx, "src", environment.FileListOpts{Recursive: true, IncludeIgnored: true})
+		require.NoError(t, err)
+		assert.Contains(t, tree, "debug.log\n")
+
+		tree, err = env.FileListWithOpts(ctx, ".", environment.FileListOpts{Recursive: true, MaxEntries: 2})
+		require.NoError(t, err)
+		assert.Contains(t, tree, "truncated after 2 entries")
+	})
+}
+
+func TestRecordCheckpoint(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "record_checkpoint", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Checkpoint", "Testing checkpoint records")
+		before, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+
+		// Recording a checkpoint only saves the state: nothing is committed
+		ref := "registry.example.com/app@sha256:0123456789abcdef"
+		require.NoError(t, repo.RecordCheckpoint(ctx, env.ID, ref))
+		after, err := repo.HeadCommit(ctx, env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+
+		envInfo, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		latest, err := envInfo.LatestCheckpoint()
+		require.NoError(t, err)
+		assert.Equal(t, ref, latest)
+	})
+}
+
+func TestGlob(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "glob", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Glob", "Testing globs")
+		user.FileWrite(env.ID, "a/b/c.go", "package b\n", "Add a Go file")
+		user.FileWrite(env.ID, "x.txt", "x\n", "Add a text file")
+		env = user.GetEnvironment(env.ID)
+
+		matches, truncated, err := env.Glob(ctx, "**/*.go")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/b/c.go"}, matches)
+		assert.False(t, truncated)
+
+		matches, _, err = env.Glob(ctx, "{a/**/*.go,*.txt}")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/b/c.go", "x.txt"}, matches)
+
+		matches, _, err = env.Glob(ctx, "*.md")
+		require.NoError(t, err)
+		assert.Empty(t, matches)
+
+		_, _, err = env.Glob(ctx, strings.Repeat("{a,b}", 10))
+		assert.ErrorContains(t, err, "expands to more than")
+	})
+}
+
+func TestGrep(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "grep", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Grep", "Testing searching files")
+		user.FileWrite(env.ID, "main.go", "package main\n\n// MAGIC_TOKEN is here\nfunc main() {}\n", "Add main")
+		user.FileWrite(env.ID, "pkg/util.go", "package pkg\n\nvar x = 1\n\nconst y = \"magic_token\"\n", "Add util")
+		env = user.GetEnvironment(env.ID)
+
+		matches, err := env.Grep(ctx, "MAGIC_TOKEN", environment.GrepOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, []environment.GrepMatch{{File: "main.go", Line: 3, Text: "// MAGIC_TOKEN is here"}}, matches)
+
+		matches, err = env.Grep(ctx, "MAGIC_TOKEN", environment.GrepOpts{IgnoreCase: true})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []environment.GrepMatch{
+			{File: "main.go", Line: 3, Text: "// MAGIC_TOKEN is here"},
+			{File: "pkg/util.go", Line: 5, Text: `const y = "magic_token"`},
+		}, matches)
+
+		// Line numbers match the ones of FileRead
+		lines := strings.Split(user.FileRead(env.ID, "pkg/util.go"), "\n")
+		assert.Equal(t, `const y = "magic_token"`, lines[5-1])
+
+		matches, err = env.Grep(ctx, "magic_token", environment.GrepOpts{Path: "pkg", IgnoreCase: true})
+		require.NoError(t, err)
+		assert.Equal(t, []environment.GrepMatch{{File: "pkg/util.go", Line: 5, Text: `const y = "magic_token"`}}, matches, "paths are relative to the workdir")
+
+		matches, err = env.Grep(ctx, "^package", environment.GrepOpts{MaxResults: 1})
+		require.NoError(t, err)
+		assert.Len(t, matches, 1)
+
+		matches, err = env.Grep(ctx, "NOT_THERE", environment.GrepOpts{})
+		require.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+}
+
+// TestRepositoryConcurrentUpdates reproduces two operations racing on the same environment: both load it, then
+// save their changes one after the other. The second save must keep the changes of the first one.
+func TestRepositoryConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-concurrent-updates", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Concurrent Updates", "Testing concurrent updates")
+		user.FileWrite(env.ID, "shared.txt", "line 1\nline 2\nline 3\n", "Add shared file")
+
+		first, err := repo.Get(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+		second, err := repo.Get(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+
+		// The first operation saves its changes
+		require.NoError(t, first.FileWrite(ctx, "Add first file", "first.txt", "first\n"))
+		require.NoError(t, first.FileWrite(ctx, "Edit shared file", "shared.txt", "line 1 (first)\nline 2\nline 3\n"))
+		require.NoError(t, repo.Update(ctx, first, "First operation"))
+
+		// The second one, loaded before, saves its own changes on top
+		_, err = second.Run(ctx, environment.RunOpts{Command: "echo second > second.txt && sed -i 's/line 3/line 3 (second)/' shared.txt", Shell: "sh"})
+		require.NoError(t, err)
+		require.NoError(t, repo.Update(ctx, second, "Second operation"))
+
+		worktree := user.WorktreePath(env.ID)
+		for file, expected := range map[string]string{
+			"first.txt":  "first\n",
+			"second.txt": "second\n",
+			"shared.txt": "line 1 (first)\nline 2\nline 3 (second)\n",
+		} {
+			content, err := os.ReadFile(filepath.Join(worktree, file))
+			require.NoError(t, err, file)
+			assert.Equal(t, expected, string(content), file)
+
+			// The container of the environment caught up as well
+			assert.Equal(t, expected, user.FileRead(env.ID, file), file)
+		}
+
+		// Changes to the same lines can't be reconciled
+		third, err := repo.Get(ctx, user.dag, env.ID)
+		require.NoError(t, err)
+		user.FileWrite(env.ID, "shared.txt", "line 1 (user)\nline 2\nline 3 (second)\n", "Edit shared file again")
+		require.NoError(t, third.FileWrite(ctx, "Conflicting edit", "shared.txt", "line 1 (third)\nline 2\nline 3 (second)\n"))
+		assert.Error(t, repo.Update(ctx, third, "Conflicting operation"))
+		assert.Equal(t, "line 1 (user)\nline 2\nline 3 (second)\n", user.ReadWorktreeFile(env.ID, "shared.txt"))
+	})
+}
+
+// TestRepositoryParallelUpdates races updates of the same environment from separate repository instances,
+// as separate MCP servers would.
+func TestRepositoryParallelUpdates(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-parallel-updates", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Parallel Updates", "Testing parallel updates")
+
+		const writers = 4
+		var wg sync.WaitGroup
+		errs := make([]error, writers)
+		for i := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, err := repository.OpenWithBasePath(ctx, repo.SourcePath(), user.configDir)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				e, err := r.Get(ctx, user.dag, env.ID)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				file := fmt.Sprintf("writer-%d.txt", i)
+				if err := e.FileWrite(ctx, "Parallel write", file, file); err != nil {
+					errs[i] = err
+					return
+				}
+				errs[i] = r.Update(ctx, e, "Parallel write "+file)
+			}()
+		}
+		wg.Wait()
+
+		for i := range writers {
+			require.NoError(t, errs[i])
+			file := fmt.Sprintf("writer-%d.txt", i)
+			assert.Equal(t, file, user.ReadWorktreeFile(env.ID, file))
+		}
+	})
+}
+
+// TestExportFiles tests packaging environment files into a zip archive
+func TestExportFiles(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "export-files", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Test Export Files", "Testing file export")
+		user.FileWrite(env.ID, "site/index.html", "<h1>hello</h1>", "Add page")
+		user.FileWrite(env.ID, "site/css/style.css", "h1 { color: red; }", "Add style")
+		user.FileWrite(env.ID, "notes.txt", "not exported", "Add notes")
+
+		env = user.GetEnvironment(env.ID)
+		archive, err := env.ExportFiles(ctx, "site/**", 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"site/css/style.css", "site/index.html"}, archive.Files)
+		assert.False(t, archive.Truncated())
+
+		zr, err := zip.NewReader(bytes.NewReader(archive.Data), int64(len(archive.Data)))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 2)
+		assert.Equal(t, "site/css/style.css", zr.File[0].Name)
+
+		// Files that don't fit are left out
+		archive, err = env.ExportFiles(ctx, "site/**", 16)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"site/index.html"}, archive.Files)
+		assert.Equal(t, []string{"site/css/style.css"}, archive.Skipped)
+
+		_, err = env.ExportFiles(ctx, "missing/**", 1<<20)
+		assert.Error(t, err)
+	})
+}
+
+// TestRepositoryForks tests that environments created from the branch of another one record their parent
+func TestRepositoryForks(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-forks", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		parent := user.CreateEnvironment("Parent", "Creating the parent environment")
+		user.FileWrite(parent.ID, "approach.txt", "shared work", "Shared work")
+
+		fork, err := repo.Create(ctx, user.dag, "Fork", "Trying an alternative", "container-use/"+parent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, parent.ID, fork.State.Parent)
+		assert.Equal(t, "shared work", user.FileRead(fork.ID, "approach.txt"))
+
+		// Environments created from other refs have no parent
+		assert.Empty(t, parent.State.Parent)
+
+		envs, err := repo.List(ctx)
+		require.NoError(t, err)
+		forks := repo.Forks(ctx, envs)
+		require.Len(t, forks, 1)
+		assert.Equal(t, parent.ID, forks[0].Parent)
+		assert.Equal(t, fork.ID, forks[0].Child)
+
+		parentHead := strings.TrimSpace(user.GitCommand("rev-parse", "container-use/"+parent.ID))
+		assert.Equal(t, parentHead, forks[0].MergeBase)
+	})
+}
+
+// TestRepositoryRename verifies that a renamed environment is found under its new ID only
+func TestRepositoryRename(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-rename", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Login form", "Adding a login form")
+		user.FileWrite(env.ID, "login.html", "<form></form>", "Add login form")
+		fork, err := repo.Create(ctx, user.dag, "Fork", "Trying an alternative", "container-use/"+env.ID)
+		require.NoError(t, err)
+		other := user.CreateEnvironment("Other", "Working on something else")
+
+		assert.Error(t, repo.Rename(ctx, env.ID, "Login Form"), "invalid names are refused")
+		assert.Error(t, repo.Rename(ctx, env.ID, other.ID), "names of other environments are refused")
+		assert.Error(t, repo.Rename(ctx, "unknown-env", "login-form"))
+
+		require.NoError(t, repo.Rename(ctx, env.ID, "login-form"))
+
+		renamed, err := repo.Get(ctx, user.dag, "login-form")
+		require.NoError(t, err)
+		assert.Equal(t, "login-form", renamed.ID)
+		assert.Equal(t, "Login form", renamed.State.Title)
+		assert.Equal(t, "<form></form>", user.FileRead("login-form", "login.html"))
+		_, err = repo.Get(ctx, user.dag, env.ID)
+		assert.Error(t, err, "the old ID is gone")
+
+		envs, err := repo.List(ctx)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, info := range envs {
+			ids = append(ids, info.ID)
+		}
+		assert.Contains(t, ids, "login-form")
+		assert.NotContains(t, ids, env.ID)
+
+		var log, diff bytes.Buffer
+		require.NoError(t, repo.Log(ctx, "login-form", false, false, &log))
+		assert.Contains(t, log.String(), "Add login form")
+		require.NoError(t, repo.Diff(ctx, "login-form", &diff))
+		assert.Contains(t, diff.String(), "login.html")
+
+		refs := user.GitCommand("for-each-ref", "--format=%(refname)", "refs/remotes/container-use/")
+		assert.Contains(t, refs, "refs/remotes/container-use/login-form")
+		assert.NotContains(t, refs, "refs/remotes/container-use/"+env.ID)
+
+		forkInfo, err := repo.Info(ctx, fork.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "login-form", forkInfo.State.Parent, "forks follow their parent")
+
+		// The environment keeps working under its new name
+		user.FileWrite("login-form", "login.css", "form {}", "Style login form")
+		assert.Equal(t, "form {}", user.FileRead("login-form", "login.css"))
+	})
+}
+
+// TestRepositoryBundle verifies that a bundled environment is imported into another repository with its history and state
+func TestRepositoryBundle(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-bundle", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Login form", "Adding a login form")
+		user.FileWrite(env.ID, "login.html", "<form></form>", "Add login form")
+
+		bundle := filepath.Join(t.TempDir(), "env.bundle")
+		require.NoError(t, repo.Bundle(ctx, env.ID, bundle))
+		assert.Error(t, repo.Bundle(ctx, "unknown-env", bundle))
+
+		_, err := repo.ImportBundle(ctx, bundle, "")
+		assert.Error(t, err, "the environment exists in its own repository")
+		_, err = repo.ImportBundle(ctx, bundle, "Login Form")
+		assert.Error(t, err, "invalid names are refused")
+
+		WithRepository(t, "repository-bundle-import", SetupNodeRepo, func(t *testing.T, target *repository.Repository, targetUser *UserActions) {
+			id, err := target.ImportBundle(ctx, bundle, "")
+			require.NoError(t, err)
+			assert.Equal(t, env.ID, id)
+
+			imported, err := target.Get(ctx, targetUser.dag, id)
+			require.NoError(t, err)
+			assert.Equal(t, "Login form", imported.State.Title)
+			assert.Equal(t, target.SourcePath(), imported.State.SourcePath)
+			assert.Equal(t, "<form></form>", targetUser.FileRead(id, "login.html"))
+
+			var log bytes.Buffer
+			require.NoError(t, target.Log(ctx, id, false, false, &log))
+			assert.Contains(t, log.String(), "Add login form")
+			assert.Contains(t, targetUser.GitCommand("for-each-ref", "--format=%(refname)", "refs/remotes/container-use/"), "refs/remotes/container-use/"+id)
+
+			renamed, err := target.ImportBundle(ctx, bundle, "login-form")
+			require.NoError(t, err)
+			assert.Equal(t, "login-form", renamed)
+			assert.Equal(t, "<form></form>", targetUser.FileRead("login-form", "login.html"))
+
+			// The imported environment keeps working
+			targetUser.FileWrite(id, "login.css", "form {}", "Style login form")
+			assert.Equal(t, "form {}", targetUser.FileRead(id, "login.css"))
+		})
+	})
+}
+
+// TestRepositoryPromote verifies that only one environment of a family is promoted at a time
+func TestRepositoryPromote(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-promote", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		parent := user.CreateEnvironment("Parent", "Creating the parent environment")
+		first, err := repo.Create(ctx, user.dag, "First attempt", "Trying one approach", "container-use/"+parent.ID)
+		require.NoError(t, err)
+		second, err := repo.Create(ctx, user.dag, "Second attempt", "Trying another approach", "container-use/"+parent.ID)
+		require.NoError(t, err)
+		unrelated := user.CreateEnvironment("Unrelated", "Working on something else")
+
+		demoted, err := repo.Promote(ctx, first.ID)
+		require.NoError(t, err)
+		assert.Empty(t, demoted)
+		demoted, err = repo.Promote(ctx, unrelated.ID)
+		require.NoError(t, err)
+		assert.Empty(t, demoted, "other families are left alone")
+
+		demoted, err = repo.Promote(ctx, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{first.ID}, demoted)
+
+		promoted := func(id string) bool {
+			info, err := repo.Info(ctx, id)
+			require.NoError(t, err)
+			return info.State.Promoted
+		}
+		assert.False(t, promoted(first.ID))
+		assert.True(t, promoted(second.ID))
+		assert.True(t, promoted(unrelated.ID))
+
+		require.NoError(t, repo.Demote(ctx, second.ID))
+		assert.False(t, promoted(second.ID))
+	})
+}
+
+func TestScratchEnvironment(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "scratch-environment", SetupNodeRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env, err := repo.CreateScratch(ctx, user.dag, "Quick experiment", "HEAD", repository.CreateOpts{})
+		require.NoError(t, err)
+		assert.True(t, env.State.Scratch)
+
+		user.FileWrite(env.ID, "scratch.txt", "throwaway", "Trying something")
+		output := user.RunCommand(env.ID, "cat scratch.txt package.json", "Reading files")
+		assert.Contains(t, output, "throwaway")
+		assert.Equal(t, "throwaway", user.FileRead(env.ID, "scratch.txt"), "changes are kept in memory")
+
+		branches := user.GitCommand("branch", "--all")
+		assert.NotContains(t, branches, env.ID, "no branch is created")
+		envs, err := repo.List(ctx)
+		require.NoError(t, err)
+		for _, info := range envs {
+			assert.NotEqual(t, env.ID, info.ID, "scratch environments aren't listed")
+		}
+
+		require.NoError(t, repo.Delete(ctx, env.ID))
+		_, err = repo.Get(ctx, user.dag, env.ID)
+		assert.Error(t, err)
+	})
+}
+
+func TestRepositoryLock(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-lock", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := t.Context()
+
+		env := user.CreateEnvironment("Finalized", "Work to protect")
+		user.FileWrite(env.ID, "result.txt", "reviewed", "Reviewed result")
+		// Loaded before the lock, like an agent still running in the environment
+		stale := user.GetEnvironment(env.ID)
+
+		require.NoError(t, repo.Lock(ctx, env.ID))
+		info, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		require.NoError(t, repository.CheckUnlocked(&environment.EnvironmentInfo{ID: env.ID, State: &environment.State{}}))
+		assert.ErrorIs(t, repository.CheckUnlocked(info), repository.ErrLocked)
+
+		require.NoError(t, stale.FileWrite(ctx, "Overwrite", "result.txt", "overwritten"))
+		assert.ErrorIs(t, repo.Update(ctx, stale, "Overwrite"), repository.ErrLocked)
+		assert.ErrorIs(t, repo.SaveState(ctx, stale), repository.ErrLocked)
+		assert.Equal(t, "reviewed", user.FileRead(env.ID, "result.txt"))
+
+		stalePruned, err := repo.ListStale(ctx, 0)
+		require.NoError(t, err)
+		for _, info := range stalePruned {
+			assert.NotEqual(t, env.ID, info.ID, "locked environments are never pruned")
+		}
+
+		require.NoError(t, repo.Unlock(ctx, env.ID))
+		user.FileWrite(env.ID, "result.txt", "updated", "Update after unlock")
+		assert.Equal(t, "updated", user.FileRead(env.ID, "result.txt"))
+	})
+}
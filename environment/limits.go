@@ -0,0 +1,176 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/dustin/go-humanize"
+)
+
+// ResourceLimits caps the resources of a single command, e.g. to sandbox untrusted code without changing the
+// environment's configuration. Dagger doesn't expose per-exec cgroup limits, so they are enforced with the
+// shell's ulimit in the container: they apply to each process of the command rather than to the whole command.
+type ResourceLimits struct {
+	// MemoryBytes caps the virtual memory of each process: allocations beyond it fail.
+	MemoryBytes int64
+	// CPUSeconds caps the CPU time of each process: it is killed with SIGXCPU beyond it.
+	CPUSeconds int
+}
+
+const (
+	// exitCodeCPULimit is the exit code of a shell command killed with SIGXCPU.
+	exitCodeCPULimit = 128 + 24
+	// exitCodeKilled is the exit code of a command killed with SIGKILL, e.g. by the kernel's OOM killer.
+	exitCodeKilled = 128 + 9
+)
+
+// oomKilledPath is where the wrapper of a memory limit records that the command was killed by the OOM killer.
+// It is removed once the command has run so it doesn't leak into the environment state.
+const oomKilledPath = "/tmp/.container-use-oom-killed"
+
+// oomKillsScript prints the oom_kill counter of the container's cgroup, or nothing without cgroup v2.
+const oomKillsScript = `oom_kills() { sed -n 's/^oom_kill //p' /sys/fs/cgroup/memory.events 2>/dev/null || true; }`
+
+// OOMKilledMessage explains the failure of commands killed by the OOM killer, see RunResult.OOMKilled.
+const OOMKilledMessage = "The command was killed due to out-of-memory (exit code 137): the container ran out of memory and the kernel killed the process. " +
+	"Reduce the memory the command needs (e.g. fewer parallel jobs), or give the container engine more memory (e.g. in the resource settings of Docker Desktop)."
+
+// KilledMessage explains the failure of commands killed with SIGKILL, for another reason than running out of
+// memory as far as we know.
+const KilledMessage = "The command was killed with SIGKILL (exit code 137), e.g. by `kill -9` or by the kernel's OOM killer. " +
+	"Set a memory_limit for out-of-memory kills to be reported as such."
+
+// oomKilled reports whether a command killed with SIGKILL was killed by the OOM killer. Dagger doesn't report the
+// cgroup OOM events of execs, so the wrapper of memory limits compares the oom_kill counter of the container's
+// cgroup before and after the command: without a memory limit, SIGKILLs are not attributed to the OOM killer.
+func oomKilled(ctx context.Context, container *dagger.Container, limits ResourceLimits, exitCode int) (bool, error) {
+	if exitCode != exitCodeKilled || limits.MemoryBytes <= 0 {
+		return false, nil
+	}
+	return container.Exists(ctx, oomKilledPath)
+}
+
+// KillMessage explains why the command was killed with SIGKILL, if it was.
+func (r *RunResult) KillMessage() string {
+	switch {
+	case r.OOMKilled:
+		return OOMKilledMessage
+	case r.ExitCode == exitCodeKilled:
+		return KilledMessage
+	}
+	return ""
+}
+
+var (
+	memoryLimitPattern = regexp.MustCompile(`^(\d+)\s*([kmgt]?)(i?b)?$`)
+	memoryUnits        = map[string]int64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+)
+
+// ParseMemoryLimit parses a memory limit such as "256MB", "512m" or "1GiB". Units are binary (1MB = 1024KB),
+// as with docker run --memory; a number without unit is in bytes.
+func ParseMemoryLimit(s string) (int64, error) {
+	m := memoryLimitPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory limit %q: expected a size such as 256MB or 1GB", s)
+	}
+	size, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q: %w", s, err)
+	}
+	size *= memoryUnits[m[2]]
+	if size < 1024*1024 {
+		return 0, fmt.Errorf("invalid memory limit %q: must be at least 1MB", s)
+	}
+	return size, nil
+}
+
+// IsZero reports whether no limit is set.
+func (l ResourceLimits) IsZero() bool {
+	return l.MemoryBytes == 0 && l.CPUSeconds == 0
+}
+
+// Validate checks that the limits are positive.
+func (l ResourceLimits) Validate() error {
+	if l.MemoryBytes < 0 {
+		return errors.New("memory limit must be positive")
+	}
+	if l.CPUSeconds < 0 {
+		return errors.New("CPU limit must be positive")
+	}
+	return nil
+}
+
+// wrap returns the exec args running args under the limits.
+func (l ResourceLimits) wrap(shell string, args []string) []string {
+	if l.IsZero() {
+		return args
+	}
+	script := []string{}
+	if l.MemoryBytes > 0 {
+		// ulimit -v is in KiB
+		script = append(script, fmt.Sprintf("ulimit -v %d", (l.MemoryBytes+1023)/1024))
+	}
+	if l.CPUSeconds > 0 {
+		script = append(script, fmt.Sprintf("ulimit -t %d", l.CPUSeconds))
+	}
+	if l.MemoryBytes > 0 {
+		// Record OOM kills, see oomKilled
+		script = append(script, oomKillsScript, `oom_before=$(oom_kills)`, fmt.Sprintf(
+			`{ "$@"; code=$?; if [ $code -eq %d ] && [ "$(oom_kills)" != "$oom_before" ]; then : > %s; fi; exit $code; }`,
+			exitCodeKilled, oomKilledPath))
+	} else {
+		script = append(script, `exec "$@"`)
+	}
+	return append([]string{shell, "-c", strings.Join(script, " && "), shell}, args...)
+}
+
+// applyLimits wraps the exec args of a command with its resource limits.
+func applyLimits(opts RunOpts, args []string) ([]string, error) {
+	if opts.Limits.IsZero() {
+		return args, nil
+	}
+	if err := opts.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("resource limits require a command or a script")
+	}
+	if opts.UseEntrypoint {
+		return nil, errors.New("resource limits can't be used with the image entrypoint")
+	}
+	return opts.Limits.wrap(opts.Shell, args), nil
+}
+
+// outOfMemoryMessages are common errors of programs failing to allocate memory.
+var outOfMemoryMessages = []string{
+	"cannot allocate memory",
+	"out of memory",
+	"memoryerror",
+	"bad_alloc",
+	"allocation failed",
+}
+
+// Exceeded describes the limit that a command which failed with exitCode and stderr most likely exceeded,
+// or returns "" if it doesn't look like it hit a limit.
+func (l ResourceLimits) Exceeded(exitCode int, stderr string) string {
+	if exitCode == 0 {
+		return ""
+	}
+	if l.CPUSeconds > 0 && exitCode == exitCodeCPULimit {
+		return fmt.Sprintf("the command was killed after exceeding its CPU time limit of %ds", l.CPUSeconds)
+	}
+	if l.MemoryBytes > 0 {
+		lower := strings.ToLower(stderr)
+		for _, msg := range outOfMemoryMessages {
+			if strings.Contains(lower, msg) {
+				return fmt.Sprintf("the command ran out of memory under its memory limit of %s", humanize.IBytes(uint64(l.MemoryBytes)))
+			}
+		}
+	}
+	return ""
+}
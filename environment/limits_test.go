@@ -0,0 +1,87 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMemoryLimit(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"256MB":   256 << 20,
+		"256m":    256 << 20,
+		"1GiB":    1 << 30,
+		" 2 g ":   2 << 30,
+		"1048576": 1 << 20,
+		"4096kb":  4 << 20,
+	} {
+		size, err := ParseMemoryLimit(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	for _, input := range []string{"", "lots", "-1MB", "1.5GB", "256XB", "1KB"} {
+		_, err := ParseMemoryLimit(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	args := []string{"sh", "-c", "python3 untrusted.py"}
+
+	wrapped, err := applyLimits(RunOpts{Shell: "sh"}, args)
+	require.NoError(t, err)
+	assert.Equal(t, args, wrapped)
+
+	wrapped, err = applyLimits(RunOpts{Shell: "sh", Limits: ResourceLimits{MemoryBytes: 256 << 20, CPUSeconds: 10}}, args)
+	require.NoError(t, err)
+	require.Len(t, wrapped, 7)
+	assert.Equal(t, []string{"sh", "-c"}, wrapped[:2])
+	assert.True(t, strings.HasPrefix(wrapped[2], "ulimit -v 262144 && ulimit -t 10 && "+oomKillsScript+" && oom_before=$(oom_kills) && "), wrapped[2])
+	assert.Contains(t, wrapped[2], `"$@"; code=$?`)
+	assert.Equal(t, []string{"sh", "sh", "-c", "python3 untrusted.py"}, wrapped[3:])
+
+	wrapped, err = applyLimits(RunOpts{Shell: "bash", Limits: ResourceLimits{CPUSeconds: 5}}, []string{"bash", "/tmp/script"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bash", "-c", `ulimit -t 5 && exec "$@"`, "bash", "bash", "/tmp/script"}, wrapped)
+
+	_, err = applyLimits(RunOpts{Shell: "sh", Limits: ResourceLimits{CPUSeconds: 5}}, nil)
+	assert.Error(t, err, "the default command can't be wrapped")
+
+	_, err = applyLimits(RunOpts{Shell: "sh", UseEntrypoint: true, Limits: ResourceLimits{CPUSeconds: 5}}, args)
+	assert.Error(t, err, "limits don't apply to the entrypoint")
+}
+
+func TestResourceLimitsExceeded(t *testing.T) {
+	limits := ResourceLimits{MemoryBytes: 256 << 20, CPUSeconds: 10}
+
+	assert.Empty(t, limits.Exceeded(0, ""))
+	assert.Empty(t, limits.Exceeded(1, "assertion failed"))
+	assert.Equal(t, "the command was killed after exceeding its CPU time limit of 10s", limits.Exceeded(152, ""))
+	assert.Equal(t, "the command ran out of memory under its memory limit of 256 MiB", limits.Exceeded(1, "Traceback (most recent call last):\nMemoryError"))
+
+	assert.Empty(t, ResourceLimits{}.Exceeded(152, "out of memory"), "no limit was set")
+}
+
+func TestOOMKilled(t *testing.T) {
+	ctx := t.Context()
+	limits := ResourceLimits{MemoryBytes: 256 << 20}
+
+	// Only SIGKILLs of commands run with a memory limit are checked, which needs no container otherwise
+	for _, tc := range []struct {
+		limits   ResourceLimits
+		exitCode int
+	}{{limits, 0}, {limits, 1}, {limits, exitCodeCPULimit}, {ResourceLimits{}, 137}, {ResourceLimits{CPUSeconds: 10}, 137}} {
+		killed, err := oomKilled(ctx, nil, tc.limits, tc.exitCode)
+		require.NoError(t, err)
+		assert.False(t, killed, tc)
+	}
+}
+
+func TestKillMessage(t *testing.T) {
+	assert.Empty(t, (&RunResult{ExitCode: 1}).KillMessage())
+	assert.Equal(t, KilledMessage, (&RunResult{ExitCode: 137}).KillMessage())
+	assert.Equal(t, OOMKilledMessage, (&RunResult{ExitCode: 137, OOMKilled: true}).KillMessage())
+}
@@ -6,6 +6,9 @@ import (
 	"sync"
 )
 
+// commandContinuationPrefix prefixes the lines of multi-line commands after the first one.
+const commandContinuationPrefix = "> "
+
 type Notes struct {
 	items []string
 	mu    sync.Mutex
@@ -18,16 +21,29 @@ func (n *Notes) Add(format string, a ...any) {
 	n.items = append(n.items, fmt.Sprintf(format, a...))
 }
 
+// AddCommand records a command along with its outcome. Like in an interactive shell, the first line of the
+// command is prefixed with "$ " and the next ones with "> ", which is what ParseCommands relies on.
 func (n *Notes) AddCommand(command string, exitCode int, stdout, stderr string) {
-	msg := fmt.Sprintf("$ %s", strings.TrimSpace(command))
+	n.addCommand("$ ", command, exitCode, stdout, stderr)
+}
+
+// AddServiceCommand records a command run against a service. The prompt names the service,
+// so that ParseCommands doesn't mistake it for a command of the environment.
+func (n *Notes) AddServiceCommand(service, command string, exitCode int, stdout, stderr string) {
+	n.addCommand(service+"$ ", command, exitCode, stdout, stderr)
+}
+
+func (n *Notes) addCommand(prompt, command string, exitCode int, stdout, stderr string) {
+	msg := prompt + strings.ReplaceAll(strings.TrimSpace(command), "\n", "\n"+commandContinuationPrefix)
 	if exitCode != 0 {
 		msg += fmt.Sprintf("\nexit %d", exitCode)
 	}
+	// Notes end up in git notes and JSON state: keep them valid UTF-8
 	if strings.TrimSpace(stdout) != "" {
-		msg += fmt.Sprintf("\n%s", stdout)
+		msg += fmt.Sprintf("\n%s", validUTF8(stdout))
 	}
 	if strings.TrimSpace(stderr) != "" {
-		msg += fmt.Sprintf("\nstderr: %s", stderr)
+		msg += fmt.Sprintf("\nstderr: %s", validUTF8(stderr))
 	}
 
 	n.Add("%s", msg)
@@ -56,3 +72,36 @@ func (n *Notes) Pop() string {
 
 	return out
 }
+
+// RecordedCommand is a command found in notes.
+type RecordedCommand struct {
+	Command  string
+	ExitCode int
+}
+
+// ParseCommands returns the commands recorded with AddCommand in notes, in order.
+// Commands are told apart from their output by their prefix, so output lines that look like commands are
+// mistaken for them. Multi-line commands recorded by older versions are cut to their first line.
+func ParseCommands(notes string) []RecordedCommand {
+	var commands []RecordedCommand
+	lines := strings.Split(notes, "\n")
+	for i := 0; i < len(lines); i++ {
+		command, ok := strings.CutPrefix(lines[i], "$ ")
+		if !ok {
+			continue
+		}
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], commandContinuationPrefix) {
+			i++
+			command += "\n" + strings.TrimPrefix(lines[i], commandContinuationPrefix)
+		}
+
+		recorded := RecordedCommand{Command: command}
+		if i+1 < len(lines) {
+			if _, err := fmt.Sscanf(lines[i+1], "exit %d", &recorded.ExitCode); err == nil {
+				i++
+			}
+		}
+		commands = append(commands, recorded)
+	}
+	return commands
+}
@@ -0,0 +1,24 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommands(t *testing.T) {
+	var notes Notes
+	notes.Add("Write main.go")
+	notes.AddCommand("go build ./...", 0, "", "")
+	notes.AddServiceCommand("db", "psql -U postgres <<EOF\nselect 1;\nEOF", 0, " ?column?\n", "")
+	notes.AddCommand("for f in *.go; do\n  gofmt -l $f\ndone", 0, "main.go\n", "")
+	notes.AddCommand("go test ./...", 1, "FAIL\n", "exit status 1\n")
+
+	assert.Equal(t, []RecordedCommand{
+		{Command: "go build ./..."},
+		{Command: "for f in *.go; do\n  gofmt -l $f\ndone"},
+		{Command: "go test ./...", ExitCode: 1},
+	}, ParseCommands(notes.String()))
+
+	assert.Empty(t, ParseCommands("Write main.go\nDelete old.go"))
+}
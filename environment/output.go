@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings of the output of commands, see RunOpts.OutputEncoding.
+const (
+	// OutputEncodingText returns the output as text. Bytes that aren't valid UTF-8 (e.g. binary data, or text in
+	// another encoding) are replaced with U+FFFD, so the output can always be embedded in JSON.
+	OutputEncodingText = "text"
+	// OutputEncodingBase64 returns the exact bytes written by the command, base64 encoded.
+	OutputEncodingBase64 = "base64"
+)
+
+// validateOutputEncoding checks an output encoding, empty meaning OutputEncodingText.
+func validateOutputEncoding(encoding string) error {
+	switch encoding {
+	case "", OutputEncodingText, OutputEncodingBase64:
+		return nil
+	default:
+		return fmt.Errorf("invalid output encoding %q: must be %s or %s", encoding, OutputEncodingText, OutputEncodingBase64)
+	}
+}
+
+// setOutput fills in the Output of a result from its Stdout and Stderr, in the given encoding.
+func (r *RunResult) setOutput(encoding string) {
+	r.InvalidUTF8 = !utf8.ValidString(r.Stdout) || !utf8.ValidString(r.Stderr)
+	if encoding == OutputEncodingBase64 {
+		r.Output = combinedOutput(encodeBase64(r.Stdout), encodeBase64(r.Stderr))
+		return
+	}
+	r.Output = combinedOutput(validUTF8(r.Stdout), validUTF8(r.Stderr))
+}
+
+func encodeBase64(s string) string {
+	if s == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// validUTF8 replaces the bytes of s that aren't valid UTF-8 with U+FFFD.
+func validUTF8(s string) string {
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
@@ -0,0 +1,35 @@
+package environment
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunResultSetOutput(t *testing.T) {
+	// Latin-1 encoded "café", and a truncated UTF-8 sequence
+	result := &RunResult{Stdout: "caf\xe9\n", Stderr: "warning \xe2\x82"}
+	result.setOutput(OutputEncodingText)
+	assert.True(t, result.InvalidUTF8)
+	assert.Equal(t, "caf�\n\nstderr: warning �", result.Output)
+	assert.Equal(t, "caf\xe9\n", result.Stdout, "stdout is kept as is")
+
+	out, err := json.Marshal(result.Output)
+	require.NoError(t, err)
+	var decoded string
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, result.Output, decoded, "the output survives a JSON round trip")
+
+	result.setOutput(OutputEncodingBase64)
+	assert.Equal(t, "Y2Fm6Qo=\nstderr: d2FybmluZyDigg==", result.Output)
+
+	valid := &RunResult{Stdout: "héllo"}
+	valid.setOutput("")
+	assert.False(t, valid.InvalidUTF8)
+	assert.Equal(t, "héllo", valid.Output)
+
+	assert.NoError(t, validateOutputEncoding(""))
+	assert.Error(t, validateOutputEncoding("latin1"))
+}
@@ -0,0 +1,126 @@
+package environment
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// ConfigSource identifies the layer a configuration value comes from.
+type ConfigSource string
+
+const (
+	// ConfigSourceDefault values are built into container-use.
+	ConfigSourceDefault ConfigSource = "default"
+	// ConfigSourceRepository values are set in the repository's .container-use/environment.json.
+	ConfigSourceRepository ConfigSource = "repository"
+	// ConfigSourceEnvironment values were changed for a single environment (e.g. by the agent).
+	ConfigSourceEnvironment ConfigSource = "environment"
+)
+
+// EffectiveConfig is a fully resolved configuration along with the layer each of its fields comes from.
+// Sources are keyed by the fields' JSON names.
+type EffectiveConfig struct {
+	Config  *EnvironmentConfig      `json:"config"`
+	Sources map[string]ConfigSource `json:"sources"`
+}
+
+// ResolveConfig resolves the configuration applied to new environments created from baseDir:
+// the defaults, overridden by the repository configuration.
+// If envConfig is not nil, it resolves the configuration of that environment instead. Its fields
+// that differ from the repository configuration are attributed to the environment.
+func ResolveConfig(baseDir string, envConfig *EnvironmentConfig) (*EffectiveConfig, error) {
+	repoConfig := DefaultConfig()
+	if err := repoConfig.Load(baseDir); err != nil {
+		return nil, err
+	}
+	repoKeys, err := loadConfigKeys(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := &EffectiveConfig{
+		Config:  repoConfig,
+		Sources: map[string]ConfigSource{},
+	}
+	for _, name := range configFieldNames() {
+		if repoKeys[name] {
+			effective.Sources[name] = ConfigSourceRepository
+		} else {
+			effective.Sources[name] = ConfigSourceDefault
+		}
+	}
+
+	if envConfig == nil {
+		return effective, nil
+	}
+
+	repoFields, err := configFieldValues(repoConfig)
+	if err != nil {
+		return nil, err
+	}
+	envFields, err := configFieldValues(envConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range configFieldNames() {
+		if envFields[name] != repoFields[name] {
+			effective.Sources[name] = ConfigSourceEnvironment
+		}
+	}
+	effective.Config = envConfig
+	return effective, nil
+}
+
+// configFieldNames returns the JSON names of the EnvironmentConfig fields, in declaration order.
+func configFieldNames() []string {
+	t := reflect.TypeFor[EnvironmentConfig]()
+	names := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// configFieldValues returns the JSON encoding of each non-empty field of config.
+func configFieldValues(config *EnvironmentConfig) (map[string]string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		values[name] = string(value)
+	}
+	return values, nil
+}
+
+// loadConfigKeys returns the fields set in the repository configuration file.
+func loadConfigKeys(baseDir string) (map[string]bool, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, configDir, environmentFile))
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(raw))
+	for name := range raw {
+		keys[name] = true
+	}
+	return keys, nil
+}
@@ -0,0 +1,38 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	// Without a repository configuration, everything comes from the defaults
+	effective, err := ResolveConfig(dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig().BaseImage, effective.Config.BaseImage)
+	assert.Equal(t, ConfigSourceDefault, effective.Sources["base_image"])
+	assert.Equal(t, ConfigSourceDefault, effective.Sources["setup_commands"])
+
+	repoConfig := DefaultConfig()
+	repoConfig.SetupCommands = []string{"apt-get update"}
+	require.NoError(t, repoConfig.Save(dir))
+
+	effective, err = ResolveConfig(dir, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apt-get update"}, effective.Config.SetupCommands)
+	assert.Equal(t, ConfigSourceRepository, effective.Sources["setup_commands"])
+	assert.Equal(t, ConfigSourceDefault, effective.Sources["context_dir"])
+
+	envConfig := repoConfig.Copy()
+	envConfig.BaseImage = "python:3.12"
+	effective, err = ResolveConfig(dir, envConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.12", effective.Config.BaseImage)
+	assert.Equal(t, ConfigSourceEnvironment, effective.Sources["base_image"])
+	assert.Equal(t, ConfigSourceRepository, effective.Sources["setup_commands"])
+	assert.Equal(t, ConfigSourceDefault, effective.Sources["context_dir"])
+}
@@ -0,0 +1,88 @@
+package environment
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+const (
+	// fileReadCacheMaxEntries bounds the number of files kept in memory.
+	fileReadCacheMaxEntries = 256
+	// fileReadCacheMaxFileSize is the size above which files are not cached.
+	fileReadCacheMaxFileSize = 1 << 20
+)
+
+// fileReadCache keeps the contents of recently read files in memory.
+// Entries are keyed by the environment's container state and the file path, so
+// any change to the environment (which always produces a new state) invalidates them. Mounts change
+// without the state changing, their files aren't cached (see Environment.uncachedPath).
+// The cache is process-wide since environments are reloaded for every tool call.
+var fileReadCache = newReadCache(fileReadCacheMaxEntries)
+
+type readCacheKey struct {
+	state string
+	path  string
+}
+
+type readCacheEntry struct {
+	key      readCacheKey
+	contents string
+}
+
+// readCache is a fixed size LRU cache.
+type readCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[readCacheKey]*list.Element
+	lru        *list.List
+}
+
+func newReadCache(maxEntries int) *readCache {
+	return &readCache{
+		maxEntries: maxEntries,
+		entries:    map[readCacheKey]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func newReadCacheKey(containerState, path string) readCacheKey {
+	// Container IDs can be large, only keep their digest around.
+	digest := sha256.Sum256([]byte(containerState))
+	return readCacheKey{state: hex.EncodeToString(digest[:]), path: path}
+}
+
+func (c *readCache) Get(key readCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*readCacheEntry).contents, true
+}
+
+func (c *readCache) Add(key readCacheKey, contents string) {
+	if len(contents) > fileReadCacheMaxFileSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*readCacheEntry).contents = contents
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&readCacheEntry{key: key, contents: contents})
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*readCacheEntry).key)
+	}
+}
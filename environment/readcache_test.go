@@ -0,0 +1,59 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCache(t *testing.T) {
+	cache := newReadCache(2)
+
+	a := newReadCacheKey("state-1", "a.txt")
+	b := newReadCacheKey("state-1", "b.txt")
+	c := newReadCacheKey("state-1", "c.txt")
+
+	cache.Add(a, "a")
+	cache.Add(b, "b")
+
+	// The same path in a different state is a different entry
+	_, ok := cache.Get(newReadCacheKey("state-2", "a.txt"))
+	assert.False(t, ok)
+
+	// Reading a makes b the least recently used entry
+	contents, ok := cache.Get(a)
+	assert.True(t, ok)
+	assert.Equal(t, "a", contents)
+
+	cache.Add(c, "c")
+	_, ok = cache.Get(b)
+	assert.False(t, ok, "least recently used entry should be evicted")
+	_, ok = cache.Get(a)
+	assert.True(t, ok)
+	_, ok = cache.Get(c)
+	assert.True(t, ok)
+
+	// Large files aren't cached
+	large := newReadCacheKey("state-1", "large.bin")
+	cache.Add(large, string(make([]byte, fileReadCacheMaxFileSize+1)))
+	_, ok = cache.Get(large)
+	assert.False(t, ok)
+}
+
+func TestUncachedPath(t *testing.T) {
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{Config: &EnvironmentConfig{
+		Workdir:    "/workdir",
+		ContextDir: "../docs",
+		Volumes:    VolumeConfigs{{Name: "shared", Path: "/data/"}},
+	}}}}
+
+	assert.True(t, env.uncachedPath("/context/README.md"))
+	assert.True(t, env.uncachedPath("/data"))
+	assert.True(t, env.uncachedPath("/data/fixtures.json"))
+	assert.False(t, env.uncachedPath("/database/dump.sql"))
+	assert.False(t, env.uncachedPath("/workdir/main.go"))
+
+	// Without a context directory, /context is a regular directory
+	env.State.Config.ContextDir = ""
+	assert.False(t, env.uncachedPath("/context/README.md"))
+}
@@ -0,0 +1,130 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// Resources describes the resources available to the commands of an environment, see Resources.
+type Resources struct {
+	// DiskTotalBytes and DiskAvailableBytes are the size and free space of the filesystem holding the workdir.
+	DiskTotalBytes     uint64 `json:"disk_total_bytes"`
+	DiskAvailableBytes uint64 `json:"disk_available_bytes"`
+	// MemoryTotalBytes and MemoryAvailableBytes are the memory of the machine running the containers (e.g. the VM
+	// of Docker Desktop), shared with other containers.
+	MemoryTotalBytes     uint64 `json:"memory_total_bytes"`
+	MemoryAvailableBytes uint64 `json:"memory_available_bytes"`
+	// MemoryLimitBytes is the memory limit of the container, 0 if it has none.
+	MemoryLimitBytes uint64 `json:"memory_limit_bytes,omitempty"`
+	// CPUCount is the number of CPUs of the machine running the containers.
+	CPUCount int `json:"cpu_count"`
+	// CPULimit is how many CPUs the container may use, 0 if it has no limit.
+	CPULimit float64 `json:"cpu_limit,omitempty"`
+}
+
+// resourcesScript prints the resources of the container as key=value lines. The workdir is passed as $1.
+// Memory and CPU limits come from cgroup v2, or v1 on older hosts.
+const resourcesScript = `df -Pk "$1" | awk 'NR == 2 { print "disk_total_kb=" $2; print "disk_available_kb=" $4 }'
+awk '/^MemTotal:/ { print "memory_total_kb=" $2 } /^MemAvailable:/ { print "memory_available_kb=" $2 }' /proc/meminfo
+if [ -r /sys/fs/cgroup/memory.max ]; then
+	echo "memory_limit=$(cat /sys/fs/cgroup/memory.max)"
+elif [ -r /sys/fs/cgroup/memory/memory.limit_in_bytes ]; then
+	echo "memory_limit=$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)"
+fi
+echo "cpu_count=$(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo)"
+if [ -r /sys/fs/cgroup/cpu.max ]; then
+	echo "cpu_max=$(cat /sys/fs/cgroup/cpu.max)"
+elif [ -r /sys/fs/cgroup/cpu/cpu.cfs_quota_us ]; then
+	echo "cpu_max=$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us) $(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us)"
+fi`
+
+// resourcesCacheBusterEnv is set while the resources are probed so that they're never served from the cache.
+const resourcesCacheBusterEnv = "_CONTAINER_USE_RESOURCES"
+
+// Resources probes the container of the environment for the disk space, memory and CPUs available to its
+// commands, e.g. to check there's enough room before a big build. The container is left untouched.
+func (env *Environment) Resources(ctx context.Context) (*Resources, error) {
+	output, err := env.container().
+		WithEnvVariable(resourcesCacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithExec([]string{"sh", "-c", resourcesScript, "sh", env.State.Config.Workdir}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe the resources of the container: %w", err)
+	}
+	return parseResources(output)
+}
+
+// maxCgroupLimit is above the limits cgroup v1 reports when there is none (e.g. 9223372036854771712).
+const maxCgroupLimit = 1 << 62
+
+// parseResources parses the output of resourcesScript. Missing values are left empty, as not every image has the
+// tools to probe them.
+func parseResources(output string) (*Resources, error) {
+	resources := &Resources{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || value == "" {
+			continue
+		}
+		var err error
+		switch key {
+		case "disk_total_kb":
+			resources.DiskTotalBytes, err = parseKilobytes(value)
+		case "disk_available_kb":
+			resources.DiskAvailableBytes, err = parseKilobytes(value)
+		case "memory_total_kb":
+			resources.MemoryTotalBytes, err = parseKilobytes(value)
+		case "memory_available_kb":
+			resources.MemoryAvailableBytes, err = parseKilobytes(value)
+		case "memory_limit":
+			if value == "max" {
+				continue
+			}
+			var limit uint64
+			if limit, err = strconv.ParseUint(value, 10, 64); err == nil && limit < maxCgroupLimit {
+				resources.MemoryLimitBytes = limit
+			}
+		case "cpu_count":
+			resources.CPUCount, err = strconv.Atoi(value)
+		case "cpu_max":
+			resources.CPULimit, err = parseCPUMax(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of the container: %w", key, err)
+		}
+	}
+	return resources, nil
+}
+
+func parseKilobytes(value string) (uint64, error) {
+	kb, err := strconv.ParseUint(value, 10, 64)
+	return kb * 1024, err
+}
+
+// parseCPUMax parses a CPU limit in the "<quota> <period>" format of cgroup v2's cpu.max, where the quota is "max"
+// (or -1 with cgroup v1) when there is none.
+func parseCPUMax(value string) (float64, error) {
+	quota, period, ok := strings.Cut(value, " ")
+	if !ok {
+		return 0, fmt.Errorf("invalid CPU limit %q", value)
+	}
+	if quota == "max" || quota == "-1" {
+		return 0, nil
+	}
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(period), 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid CPU limit %q", value)
+	}
+	return q / p, nil
+}
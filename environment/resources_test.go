@@ -0,0 +1,55 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResources(t *testing.T) {
+	t.Run("cgroup v2 with limits", func(t *testing.T) {
+		resources, err := parseResources(`disk_total_kb=61202244
+disk_available_kb=20480000
+memory_total_kb=8128000
+memory_available_kb=4064000
+memory_limit=536870912
+cpu_count=8
+cpu_max=150000 100000
+`)
+		require.NoError(t, err)
+		assert.Equal(t, &Resources{
+			DiskTotalBytes:       61202244 * 1024,
+			DiskAvailableBytes:   20480000 * 1024,
+			MemoryTotalBytes:     8128000 * 1024,
+			MemoryAvailableBytes: 4064000 * 1024,
+			MemoryLimitBytes:     512 << 20,
+			CPUCount:             8,
+			CPULimit:             1.5,
+		}, resources)
+	})
+
+	t.Run("no limits", func(t *testing.T) {
+		resources, err := parseResources("memory_limit=max\ncpu_count=4\ncpu_max=max 100000\n")
+		require.NoError(t, err)
+		assert.Equal(t, &Resources{CPUCount: 4}, resources)
+
+		resources, err = parseResources("memory_limit=9223372036854771712\ncpu_max=-1 100000\n")
+		require.NoError(t, err)
+		assert.Equal(t, &Resources{}, resources, "cgroup v1 reports huge values when unlimited")
+	})
+
+	t.Run("missing tools", func(t *testing.T) {
+		resources, err := parseResources("cpu_count=\n")
+		require.NoError(t, err)
+		assert.Equal(t, &Resources{}, resources)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		_, err := parseResources("cpu_count=many\n")
+		assert.ErrorContains(t, err, "cpu_count")
+
+		_, err = parseResources("cpu_max=150000\n")
+		assert.ErrorContains(t, err, "invalid CPU limit")
+	})
+}
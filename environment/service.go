@@ -4,6 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"dagger.io/dagger"
@@ -13,23 +19,181 @@ var (
 	serviceStartTimeout = 30 * time.Second
 )
 
+// ErrServiceNotFound is wrapped by the errors returned for a service the environment doesn't have.
+var ErrServiceNotFound = errors.New("not found")
+
+// ErrServiceLogsNotCaptured is wrapped by the errors returned for the logs of a service run by the entrypoint of
+// its image, without a command: only the output of commands is captured.
+var ErrServiceLogsNotCaptured = errors.New("no captured logs for image-entrypoint services")
+
+const (
+	// serviceLogsDir is where the output of services started with a command is captured. Dagger gives no access
+	// to the output of running services, so it goes to a cache volume that other containers can read.
+	serviceLogsDir  = "/var/log/container-use"
+	serviceLogsFile = serviceLogsDir + "/service.log"
+	// serviceLogsTail is how many lines of output are reported when a service fails to start.
+	serviceLogsTail = 50
+	// serviceLogsTimeout bounds reading the logs of a service, which starts a container.
+	serviceLogsTimeout = 30 * time.Second
+)
+
+// runningServices tracks the services and background commands started by this process for each environment,
+// so they can be released when the environment is paused.
+var runningServices = struct {
+	sync.Mutex
+	byEnv map[string][]*runningService
+}{byEnv: map[string][]*runningService{}}
+
+type runningService struct {
+	// handle is the ID of the background command, or the name of the service.
+	handle  string
+	svc     *dagger.Service
+	started time.Time
+	// tunnels expose the ports of the service on the host.
+	tunnels []*dagger.Service
+}
+
+func trackService(envID, handle string, svc *dagger.Service) *runningService {
+	runningServices.Lock()
+	defer runningServices.Unlock()
+	service := &runningService{handle: handle, svc: svc, started: time.Now()}
+	runningServices.byEnv[envID] = append(runningServices.byEnv[envID], service)
+	return service
+}
+
+// addTunnel records a tunnel exposing a port of the service, stopped along with it.
+func (s *runningService) addTunnel(tunnel *dagger.Service) {
+	runningServices.Lock()
+	defer runningServices.Unlock()
+	s.tunnels = append(s.tunnels, tunnel)
+}
+
+// stop stops the service and its tunnels, releasing the ports they expose on the host.
+func (s *runningService) stop(ctx context.Context) error {
+	runningServices.Lock()
+	tunnels := s.tunnels
+	runningServices.Unlock()
+
+	var errs []error
+	for _, tunnel := range tunnels {
+		if _, err := tunnel.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := s.svc.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// untrackServices stops tracking the services of the environment matching the predicate, and returns them.
+func untrackServices(envID string, match func(*runningService) bool) []*runningService {
+	runningServices.Lock()
+	defer runningServices.Unlock()
+
+	var matched, kept []*runningService
+	for _, service := range runningServices.byEnv[envID] {
+		if match(service) {
+			matched = append(matched, service)
+		} else {
+			kept = append(kept, service)
+		}
+	}
+	if len(kept) == 0 {
+		delete(runningServices.byEnv, envID)
+	} else {
+		runningServices.byEnv[envID] = kept
+	}
+	return matched
+}
+
+func stopRunningServices(ctx context.Context, services []*runningService) error {
+	var errs []error
+	for _, service := range services {
+		if err := service.stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StopServices stops every service and background command this process started for the environment.
+func StopServices(ctx context.Context, envID string) error {
+	return stopRunningServices(ctx, untrackServices(envID, func(*runningService) bool { return true }))
+}
+
+// StopRequestedServices stops the services and background commands this process started for which requested
+// returns true, e.g. because the CLI asked to stop them.
+func StopRequestedServices(ctx context.Context, requested func(envID, handle string, started time.Time) bool) error {
+	runningServices.Lock()
+	envIDs := []string{}
+	for envID := range runningServices.byEnv {
+		envIDs = append(envIDs, envID)
+	}
+	runningServices.Unlock()
+
+	var errs []error
+	for _, envID := range envIDs {
+		services := untrackServices(envID, func(service *runningService) bool {
+			return requested(envID, service.handle, service.started)
+		})
+		if err := stopRunningServices(ctx, services); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopRunning stops the service or background command this process started for the environment under handle.
+// It reports whether there was one.
+func stopRunning(ctx context.Context, envID, handle string) (bool, error) {
+	services := untrackServices(envID, func(service *runningService) bool { return service.handle == handle })
+	return len(services) > 0, stopRunningServices(ctx, services)
+}
+
 type Service struct {
 	Config    *ServiceConfig   `json:"config"`
 	Endpoints EndpointMappings `json:"endpoints"`
+	// Stale reports that this process doesn't run the service: its endpoints were recorded by the process that
+	// started it, e.g. another MCP server, which may have exited since and taken them down with it.
+	Stale bool `json:"stale,omitempty"`
 
 	svc *dagger.Service
 }
 
 type EndpointMapping struct {
+	Protocol            string `json:"protocol"`
 	EnvironmentInternal string `json:"environment_internal"`
 	HostExternal        string `json:"host_external"`
 }
 
-type EndpointMappings map[int]*EndpointMapping
+// EndpointMappings are keyed by port, in the ServicePort string format (e.g. "5432" or "53/udp").
+type EndpointMappings map[string]*EndpointMapping
+
+// networkProtocol returns the Dagger protocol for the port, defaulting to TCP.
+func (p ServicePort) networkProtocol() dagger.NetworkProtocol {
+	if p.Protocol == ProtocolUDP {
+		return dagger.NetworkProtocolUdp
+	}
+	return dagger.NetworkProtocolTcp
+}
+
+// scheme returns the URL scheme used in the port's endpoints.
+func (p ServicePort) scheme() string {
+	if p.Protocol == ProtocolUDP {
+		return ProtocolUDP
+	}
+	return ProtocolTCP
+}
 
 func (env *Environment) startServices(ctx context.Context) ([]*Service, error) {
+	env.mu.Lock()
+	env.State.ServiceEndpoints = nil
+	env.mu.Unlock()
+
 	services := []*Service{}
 	for _, cfg := range env.State.Config.Services {
+		env.reportProgress("Starting service %s (%s)", cfg.Name, cfg.Image)
 		service, err := env.startService(ctx, cfg)
 		if err != nil {
 			return nil, err
@@ -39,9 +203,39 @@ func (env *Environment) startServices(ctx context.Context) ([]*Service, error) {
 	return services, nil
 }
 
-func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*Service, error) {
-	container := env.dag.Container().From(cfg.Image)
-	container, err := containerWithEnvAndSecrets(env.dag, container, cfg.Env, env.State.Config.Secrets)
+// serviceContainer returns the container of a service, before its command is run.
+func (env *Environment) serviceContainer(cfg *ServiceConfig) (*dagger.Container, error) {
+	container := env.dag.Container()
+	if cfg.RegistryUsername != "" {
+		container = container.WithRegistryAuth(registryAddress(cfg.Image), cfg.RegistryUsername, env.dag.Secret(cfg.RegistryPassword))
+	}
+	container = container.From(cfg.Image)
+	return containerWithEnvAndSecrets(env.dag, container, cfg.Env, env.State.Config.Secrets)
+}
+
+// bindServices binds the services of the environment to the container without starting them, for Dagger to start
+// them when a command needs them, see Environment.DeferServices. The endpoints of the services started by the MCP
+// server are kept, except for services no longer in the configuration.
+func (env *Environment) bindServices(container *dagger.Container) (*dagger.Container, error) {
+	env.mu.Lock()
+	maps.DeleteFunc(env.State.ServiceEndpoints, func(name string, _ EndpointMappings) bool {
+		return env.State.Config.Services.Get(name) == nil
+	})
+	env.mu.Unlock()
+
+	for _, cfg := range env.State.Config.Services {
+		svc, err := env.serviceDefinition(cfg)
+		if err != nil {
+			return nil, err
+		}
+		container = container.WithServiceBinding(cfg.Name, svc)
+	}
+	return container, nil
+}
+
+// serviceDefinition returns the service running the image and command of cfg, not started yet.
+func (env *Environment) serviceDefinition(cfg *ServiceConfig) (*dagger.Service, error) {
+	container, err := env.serviceContainer(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -52,58 +246,80 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 
 	args := []string{}
 	if cfg.Command != "" {
-		args = []string{"sh", "-c", cfg.Command}
+		// Capture the output of the command, starting afresh, to report it if the service fails to start.
+		// Services running as a user who can't write the volume run without capture.
+		container = container.WithMountedCache(serviceLogsDir, env.serviceLogsVolume(cfg.Name))
+		args = []string{"sh", "-c", fmt.Sprintf(`if true 2>/dev/null > %[1]s; then exec sh -c "$1" >> %[1]s 2>&1; else exec sh -c "$1"; fi`, serviceLogsFile), "sh", cfg.Command}
 	}
 
 	// Expose ports
 	for _, port := range cfg.ExposedPorts {
-		container = container.WithExposedPort(port, dagger.ContainerWithExposedPortOpts{
-			Protocol:    dagger.NetworkProtocolTcp,
-			Description: fmt.Sprintf("Port %d", port),
+		container = container.WithExposedPort(port.Port, dagger.ContainerWithExposedPortOpts{
+			Protocol:    port.networkProtocol(),
+			Description: fmt.Sprintf("Port %s", port),
 		})
 	}
 
-	// Start the service
-	startCtx, cancel := context.WithTimeout(ctx, serviceStartTimeout)
-	defer cancel()
-	svc, err := container.AsService(dagger.ContainerAsServiceOpts{
+	return container.AsService(dagger.ContainerAsServiceOpts{
 		Args:          args,
 		UseEntrypoint: true,
-	}).Start(startCtx)
+	}), nil
+}
+
+func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*Service, error) {
+	definition, err := env.serviceDefinition(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start the service
+	startCtx, cancel := context.WithTimeout(ctx, cfg.startupTimeout())
+	defer cancel()
+	svc, err := definition.Start(startCtx)
 	if err != nil {
 		var exitErr *dagger.ExecError
 		if errors.As(err, &exitErr) {
+			if logs, ok := env.startupLogs(ctx, cfg); ok {
+				return nil, fmt.Errorf("command failed with exit code %d.\noutput: %s", exitErr.ExitCode, logs)
+			}
 			return nil, fmt.Errorf("command failed with exit code %d.\nstdout: %s\nstderr: %s", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
 		}
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, fmt.Errorf("service failed to start within %s timeout", serviceStartTimeout)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			msg := fmt.Sprintf("service %s failed to start within %s timeout: its exposed ports never accepted connections. Set a higher startup_timeout if it needs more time", cfg.Name, cfg.startupTimeout())
+			if logs, ok := env.startupLogs(ctx, cfg); ok {
+				return nil, fmt.Errorf("%s.\nLast lines of its output:\n%s", msg, logs)
+			}
+			return nil, errors.New(msg)
 		}
 		return nil, err
 	}
+	running := trackService(env.ID, cfg.Name, svc)
 
 	endpoints := EndpointMappings{}
 	for _, port := range cfg.ExposedPorts {
 		endpoint := &EndpointMapping{
-			EnvironmentInternal: fmt.Sprintf("tcp://%s:%d", cfg.Name, port),
+			Protocol:            port.scheme(),
+			EnvironmentInternal: fmt.Sprintf("%s://%s:%d", port.scheme(), cfg.Name, port.Port),
 		}
-		endpoints[port] = endpoint
+		endpoints[port.String()] = endpoint
 
 		// Expose ports on the host
 		tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
 			Ports: []dagger.PortForward{
 				{
-					Backend:  port,
+					Backend:  port.Port,
 					Frontend: 0,
-					Protocol: dagger.NetworkProtocolTcp,
+					Protocol: port.networkProtocol(),
 				},
 			},
 		}).Start(ctx)
 		if err != nil {
 			return nil, err
 		}
+		running.addTunnel(tunnel)
 
 		externalEndpoint, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{
-			Scheme: "tcp",
+			Scheme: port.scheme(),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to get endpoint for service %s: %w", cfg.Name, err)
@@ -111,6 +327,13 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 		endpoint.HostExternal = externalEndpoint
 	}
 
+	env.mu.Lock()
+	if env.State.ServiceEndpoints == nil {
+		env.State.ServiceEndpoints = map[string]EndpointMappings{}
+	}
+	env.State.ServiceEndpoints[cfg.Name] = endpoints
+	env.mu.Unlock()
+
 	return &Service{
 		Config:    cfg,
 		Endpoints: endpoints,
@@ -118,7 +341,87 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 	}, nil
 }
 
+// startupTimeout is how long the service may take to start.
+func (cfg *ServiceConfig) startupTimeout() time.Duration {
+	if cfg.StartupTimeout > 0 {
+		return time.Duration(cfg.StartupTimeout) * time.Second
+	}
+	return serviceStartTimeout
+}
+
+// serviceLogsVolume is the cache volume capturing the output of a service of the environment.
+func (env *Environment) serviceLogsVolume(name string) *dagger.CacheVolume {
+	return env.dag.CacheVolume(fmt.Sprintf("container-use-service-logs-%s-%s", env.ID, name))
+}
+
+// serviceLogs returns the last lines of the output of a service started with a command, or all of it if tail is
+// zero. The logs volume is read from a container of the service's image, which is known to have a shell.
+func (env *Environment) serviceLogs(ctx context.Context, cfg *ServiceConfig, tail int) (string, error) {
+	container, err := env.serviceContainer(cfg)
+	if err != nil {
+		return "", err
+	}
+	script := "cat " + serviceLogsFile
+	if tail > 0 {
+		script = fmt.Sprintf("tail -n %d %[2]s 2>/dev/null || cat %[2]s", tail, serviceLogsFile)
+	}
+	return container.
+		WithMountedCache(serviceLogsDir, env.serviceLogsVolume(cfg.Name)).
+		WithEnvVariable(serviceLogsCacheBusterEnv, strconv.FormatInt(time.Now().UnixNano(), 10)).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+}
+
+// ServiceLogs returns the last tailLines lines of the output (stdout and stderr) of a service of the environment,
+// or all of it if tailLines is zero. Only the output of services started with a command is captured; it is kept
+// after the service stops, until it is started again.
+func (env *Environment) ServiceLogs(ctx context.Context, name string, tailLines int) (string, error) {
+	cfg := env.State.Config.Services.Get(name)
+	if cfg == nil {
+		return "", env.serviceNotFoundError(name)
+	}
+	if tailLines < 0 {
+		return "", fmt.Errorf("tail must not be negative")
+	}
+	if cfg.Command == "" {
+		return "", fmt.Errorf("%w: service %s runs the entrypoint of %s, set a command to capture its output", ErrServiceLogsNotCaptured, name, cfg.Image)
+	}
+	logs, err := env.serviceLogs(ctx, cfg, tailLines)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("no output of service %s was captured: it hasn't started yet, or runs as a user who can't write %s", name, serviceLogsDir)
+		}
+		return "", fmt.Errorf("failed to read the logs of service %s: %w", name, err)
+	}
+	return logs, nil
+}
+
+// serviceLogsCacheBusterEnv is set while the logs of a service are read so that they're never served from the cache.
+const serviceLogsCacheBusterEnv = "_CONTAINER_USE_SERVICE_LOGS"
+
+// startupLogs returns the output of a service that failed to start, if it was captured.
+func (env *Environment) startupLogs(ctx context.Context, cfg *ServiceConfig) (string, bool) {
+	if cfg.Command == "" {
+		return "", false
+	}
+	logsCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serviceLogsTimeout)
+	defer cancel()
+	logs, err := env.serviceLogs(logsCtx, cfg, serviceLogsTail)
+	if err != nil {
+		slog.Warn("Failed to read the logs of service", "environment.id", env.ID, "service", cfg.Name, "err", err)
+		return "", false
+	}
+	if strings.TrimSpace(logs) == "" {
+		return "(no output)", true
+	}
+	return logs, true
+}
+
 func (env *Environment) AddService(ctx context.Context, explanation string, cfg *ServiceConfig) (*Service, error) {
+	if cfg.StartupTimeout < 0 {
+		return nil, fmt.Errorf("startup timeout of service %s must not be negative", cfg.Name)
+	}
 	if env.State.Config.Services.Get(cfg.Name) != nil {
 		return nil, fmt.Errorf("service %s already exists", cfg.Name)
 	}
@@ -138,3 +441,252 @@ func (env *Environment) AddService(ctx context.Context, explanation string, cfg
 
 	return svc, nil
 }
+
+// runningService returns the service with the given name if this process started it for the environment.
+func (env *Environment) runningService(name string) *dagger.Service {
+	for _, service := range env.Services {
+		if service.Config.Name == name && service.svc != nil {
+			return service.svc
+		}
+	}
+
+	runningServices.Lock()
+	defer runningServices.Unlock()
+	for _, service := range runningServices.byEnv[env.ID] {
+		if service.handle == name {
+			return service.svc
+		}
+	}
+	return nil
+}
+
+// ListServices returns the running services of the environment with their endpoints, in the order they were
+// added. Services started by another process (e.g. by the MCP server, when listed from the CLI) are reported with
+// the endpoints they had when started, as long as they weren't stopped, and marked Stale.
+func (env *Environment) ListServices(ctx context.Context) ([]*Service, error) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+
+	services := []*Service{}
+	for _, cfg := range env.State.Config.Services {
+		endpoints, ok := env.State.ServiceEndpoints[cfg.Name]
+		if !ok {
+			continue
+		}
+		svc := env.runningService(cfg.Name)
+		services = append(services, &Service{
+			Config:    cfg,
+			Endpoints: endpoints,
+			Stale:     svc == nil,
+			svc:       svc,
+		})
+	}
+	return services, nil
+}
+
+// serviceNotFoundError is the error returned for a service the environment doesn't have.
+func (env *Environment) serviceNotFoundError(name string) error {
+	names := []string{}
+	for _, service := range env.State.Config.Services {
+		names = append(names, service.Name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("service %s %w: the environment has no services", name, ErrServiceNotFound)
+	}
+	return fmt.Errorf("service %s %w, available services: %s", name, ErrServiceNotFound, strings.Join(names, ", "))
+}
+
+// StopService stops a service of the environment started by this process, releasing the ports it exposes on the
+// host. The service stays in the configuration: it is started again when the environment is rebuilt or resumed.
+func (env *Environment) StopService(ctx context.Context, name string) error {
+	if env.State.Config.Services.Get(name) == nil {
+		return env.serviceNotFoundError(name)
+	}
+	stopped, err := stopRunning(ctx, env.ID, name)
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", name, err)
+	}
+	if !stopped {
+		return fmt.Errorf("service %s is not running", name)
+	}
+	env.Services = slices.DeleteFunc(env.Services, func(service *Service) bool { return service.Config.Name == name })
+	env.mu.Lock()
+	delete(env.State.ServiceEndpoints, name)
+	env.mu.Unlock()
+
+	env.Notes.Add("Stop service %s\n\n", name)
+	return nil
+}
+
+// RemoveService stops a service and removes it from the environment's configuration. Dagger can't unbind a service
+// from a container, so the container is rebuilt from the workdir for the service's name to stop resolving: the other
+// services are restarted, unless they are deferred (see Environment.DeferServices), and changes to the container
+// outside of the workdir are discarded.
+// The error returned for a service the environment doesn't have wraps ErrServiceNotFound.
+func (env *Environment) RemoveService(ctx context.Context, explanation, name string) error {
+	if env.State.Config.Services.Get(name) == nil {
+		return env.serviceNotFoundError(name)
+	}
+
+	// Every service is stopped, not only the removed one: the rebuild starts the others again
+	services := untrackServices(env.ID, func(service *runningService) bool {
+		return env.State.Config.Services.Get(service.handle) != nil
+	})
+	if err := stopRunningServices(ctx, services); err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", name, err)
+	}
+
+	previous := env.State.Config.Services
+	env.State.Config.Services = slices.DeleteFunc(slices.Clone(previous), func(cfg *ServiceConfig) bool { return cfg.Name == name })
+	if err := env.Rebuild(ctx, env.Workdir()); err != nil {
+		env.State.Config.Services = previous
+		return fmt.Errorf("failed to rebuild the environment without service %s: %w", name, err)
+	}
+
+	env.Notes.Add("Remove service %s\n%s\n\n", name, explanation)
+	return nil
+}
+
+// StopBackground stops a command started with RunBackground, releasing the ports it exposes on the host, and
+// removes it from the state. Commands this process didn't start are only removed from the state.
+func (env *Environment) StopBackground(ctx context.Context, id string) error {
+	env.mu.RLock()
+	i := slices.IndexFunc(env.State.BackgroundCommands, func(bg *BackgroundCommand) bool { return bg.ID == id })
+	var command string
+	if i >= 0 {
+		command = env.State.BackgroundCommands[i].Command
+	}
+	env.mu.RUnlock()
+	if i < 0 {
+		return fmt.Errorf("background command %s not found in environment %s", id, env.ID)
+	}
+	if _, err := stopRunning(ctx, env.ID, id); err != nil {
+		return fmt.Errorf("failed to stop background command %s: %w", id, err)
+	}
+
+	env.mu.Lock()
+	env.State.BackgroundCommands = slices.DeleteFunc(env.State.BackgroundCommands, func(bg *BackgroundCommand) bool { return bg.ID == id })
+	env.mu.Unlock()
+
+	env.Notes.Add("Stop background command %s: %s\n\n", id, command)
+	return nil
+}
+
+// Stop stops a background command, given its ID, or a service, given its name.
+func (env *Environment) Stop(ctx context.Context, handle string) error {
+	for _, bg := range env.State.BackgroundCommands {
+		if bg.ID == handle {
+			return env.StopBackground(ctx, handle)
+		}
+	}
+	if env.State.Config.Services.Get(handle) != nil {
+		return env.StopService(ctx, handle)
+	}
+	return fmt.Errorf("%s is neither a background command nor a service of environment %s", handle, env.ID)
+}
+
+// RunInService runs a foreground command against one of the environment's services, e.g. psql for a database.
+// Dagger can't execute commands in the process tree of a running service: the command runs in a new container
+// built from the service's image, environment variables and secrets, which reaches the running service at its
+// name (e.g. `psql -h db`). The environment's container is left untouched.
+// Services that aren't running in this process (e.g. after a restart of the server) are started first.
+func (env *Environment) RunInService(ctx context.Context, name string, opts RunOpts) (*RunResult, error) {
+	if opts.Command != "" && opts.Script != "" {
+		return nil, errors.New("command and script are mutually exclusive")
+	}
+	if err := validateOutputEncoding(opts.OutputEncoding); err != nil {
+		return nil, err
+	}
+
+	cfg := env.State.Config.Services.Get(name)
+	if cfg == nil {
+		return nil, env.serviceNotFoundError(name)
+	}
+
+	svc := env.runningService(name)
+	if svc == nil {
+		env.reportProgress("Starting service %s (%s)", cfg.Name, cfg.Image)
+		service, err := env.startService(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("service %s is not running and failed to start: %w", name, err)
+		}
+		svc = service.svc
+	}
+
+	start := time.Now()
+	result := &RunResult{}
+
+	container, err := env.serviceContainer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	container, err = container.WithServiceBinding(cfg.Name, svc).Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision container for service %s: %w", name, err)
+	}
+	result.Timing.Provisioning = time.Since(start)
+
+	command := opts.Command
+	args := []string{}
+	if opts.Command != "" {
+		args = []string{opts.Shell, "-c", opts.Command}
+	}
+	if opts.Script != "" {
+		command = opts.Script
+		container = container.WithNewFile(runScriptPath, opts.Script)
+		args = []string{opts.Shell, runScriptPath}
+	}
+	if args, err = applyLimits(opts, args); err != nil {
+		return nil, err
+	}
+	if args, err = applyTTY(opts, args); err != nil {
+		return nil, err
+	}
+
+	execOpts := dagger.ContainerWithExecOpts{
+		UseEntrypoint: opts.UseEntrypoint,
+		Expect:        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
+	}
+	container, cleanupStdin, err := env.withExecStdin(container, opts.Stdin, &execOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupStdin()
+	newState := container.WithExec(args, execOpts)
+
+	execStart := time.Now()
+	result.ExitCode, err = execExitCode(ctx, newState, opts.Timeout)
+	if errors.Is(err, ErrCommandTimeout) {
+		env.Notes.AddServiceCommand(name, command, exitCodeTimeout, "", err.Error())
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exit code: %w", err)
+	}
+	result.Timing.Execution = time.Since(execStart)
+	if result.OOMKilled, err = oomKilled(ctx, newState, opts.Limits, result.ExitCode); err != nil {
+		return nil, fmt.Errorf("failed to check for OOM kills: %w", err)
+	}
+	if result.Stdout, err = newState.Stdout(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
+	}
+	if result.Stderr, err = newState.Stderr(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get stderr: %w", err)
+	}
+	result.setOutput(opts.OutputEncoding)
+	result.Timing.Total = time.Since(start)
+
+	env.Notes.AddServiceCommand(name, command, result.ExitCode, result.Stdout, result.Stderr)
+
+	return result, nil
+}
+
+// registryAddress returns the registry hosting an image reference, following Docker's conventions
+// (e.g. "ghcr.io/org/app:1.0" is hosted by "ghcr.io", "postgres:17" by "docker.io").
+func registryAddress(image string) string {
+	host, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return host
+	}
+	return "docker.io"
+}
@@ -0,0 +1,68 @@
+package environment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryAddress(t *testing.T) {
+	tests := map[string]string{
+		"postgres:17":                          "docker.io",
+		"bitnami/redis:7":                      "docker.io",
+		"ghcr.io/org/app:1.0":                  "ghcr.io",
+		"registry.example.com:5000/team/app":   "registry.example.com:5000",
+		"localhost/app@sha256:0123456789abcde": "localhost",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, registryAddress(image), image)
+	}
+}
+
+func TestServiceConfigStartupTimeout(t *testing.T) {
+	assert.Equal(t, serviceStartTimeout, (&ServiceConfig{}).startupTimeout())
+	assert.Equal(t, 2*time.Minute, (&ServiceConfig{StartupTimeout: 120}).startupTimeout())
+
+	config := DefaultConfig()
+	config.Services = ServiceConfigs{{Name: "db", Image: "postgres:17", StartupTimeout: -1}}
+	assert.ErrorContains(t, config.Validate(), "startup_timeout must not be negative")
+}
+
+func TestBindServicesDropsRemovedEndpoints(t *testing.T) {
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{
+		Config: DefaultConfig(),
+		ServiceEndpoints: map[string]EndpointMappings{
+			"db": {"5432/tcp": {Protocol: "tcp", HostExternal: "tcp://127.0.0.1:49152"}},
+		},
+	}}}
+
+	// Without services to bind, the container is left as is and no Dagger call is made
+	container, err := env.bindServices(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, container)
+	// The endpoints of services no longer in the configuration are dropped
+	assert.Empty(t, env.State.ServiceEndpoints)
+}
+
+func TestListServicesStale(t *testing.T) {
+	config := DefaultConfig()
+	config.Services = ServiceConfigs{
+		{Name: "db", Image: "postgres:17", ExposedPorts: []ServicePort{{Port: 5432, Protocol: ProtocolTCP}}},
+		{Name: "cache", Image: "redis:7"},
+	}
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{ID: "list-services-stale", State: &State{
+		Config: config,
+		// Recorded by a process that isn't running anymore, e.g. a previous MCP server
+		ServiceEndpoints: map[string]EndpointMappings{
+			"db": {"5432": {Protocol: "tcp", EnvironmentInternal: "tcp://db:5432", HostExternal: "tcp://127.0.0.1:49152"}},
+		},
+	}}}
+
+	services, err := env.ListServices(t.Context())
+	assert.NoError(t, err)
+	if assert.Len(t, services, 1, "stopped services aren't listed") {
+		assert.Equal(t, "db", services[0].Config.Name)
+		assert.True(t, services[0].Stale)
+	}
+}
@@ -14,6 +14,40 @@ type State struct {
 	Container      string             `json:"container,omitempty"`
 	Title          string             `json:"title,omitempty"`
 	SubmodulePaths []string           `json:"submodule_paths,omitempty"`
+
+	// SourcePath is the path of the repository the environment was created from.
+	SourcePath string `json:"source_path,omitempty"`
+
+	// BackgroundCommands are the commands started in the background, most recent last.
+	BackgroundCommands []*BackgroundCommand `json:"background_commands,omitempty"`
+
+	// ServiceEndpoints are the endpoints of the running services, by name, as of when they were started.
+	ServiceEndpoints map[string]EndpointMappings `json:"service_endpoints,omitempty"`
+
+	// Paused environments have had their services stopped and must be resumed before use.
+	Paused bool `json:"paused,omitempty"`
+
+	// Checkpoints are the image references published by Checkpoint, most recent last.
+	Checkpoints []string `json:"checkpoints,omitempty"`
+
+	// Pinned environments are never pruned, however old.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// Locked environments hold finalized work: changes to them are refused until they are unlocked.
+	Locked bool `json:"locked,omitempty"`
+
+	// Promoted environments were chosen as the canonical result among the attempts of their family.
+	Promoted bool `json:"promoted,omitempty"`
+
+	// Scratch environments live in the memory of the process that created them: they have no branch and
+	// their changes are never committed.
+	Scratch bool `json:"scratch,omitempty"`
+
+	// Parent is the environment this one was forked from, i.e. whose branch it was created from.
+	Parent string `json:"parent,omitempty"`
+
+	// BuildStatus is the outcome of the last build or test command, if one was recorded.
+	BuildStatus *BuildStatus `json:"build_status,omitempty"`
 }
 
 func (s *State) Marshal() ([]byte, error) {
@@ -0,0 +1,54 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+
+	"dagger.io/dagger"
+)
+
+// runStdinPath is where the standard input of a command is written inside the container when it can't be passed
+// inline. The file is removed once the command has run so it doesn't leak into the environment state.
+const runStdinPath = "/tmp/.container-use-stdin"
+
+// maxInlineStdin is the size above which the standard input of a command is uploaded from a file on the host
+// rather than inlined in the queries sent to Dagger, e.g. a large patch or SQL dump.
+const maxInlineStdin = 64 << 10
+
+// withStdinFile writes stdin to runStdinPath in the container. Large inputs are uploaded from a temporary file,
+// which cleanup removes once the container has been evaluated.
+func (env *Environment) withStdinFile(container *dagger.Container, stdin string) (*dagger.Container, func(), error) {
+	if len(stdin) <= maxInlineStdin {
+		return container.WithNewFile(runStdinPath, stdin), func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "container-use-stdin-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write stdin: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := f.WriteString(stdin); err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write stdin: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write stdin: %w", err)
+	}
+	return container.WithFile(runStdinPath, env.dag.Host().File(f.Name())), cleanup, nil
+}
+
+// withExecStdin sets the standard input of an exec: inline if it's small, redirected from runStdinPath otherwise.
+func (env *Environment) withExecStdin(container *dagger.Container, stdin string, opts *dagger.ContainerWithExecOpts) (*dagger.Container, func(), error) {
+	if len(stdin) <= maxInlineStdin {
+		opts.Stdin = stdin
+		return container, func() {}, nil
+	}
+	container, cleanup, err := env.withStdinFile(container, stdin)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.RedirectStdin = runStdinPath
+	return container, cleanup, nil
+}
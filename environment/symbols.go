@@ -0,0 +1,274 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// braceLanguages are the file extensions of languages whose declarations are delimited by braces.
+var braceLanguages = map[string]bool{
+	".go": true, ".js": true, ".jsx": true, ".mjs": true, ".cjs": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".kts": true, ".scala": true, ".groovy": true, ".swift": true, ".dart": true,
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".cxx": true, ".hpp": true, ".cs": true,
+	".rs": true, ".php": true,
+}
+
+// indentLanguages are the file extensions of languages whose declarations are delimited by indentation.
+var indentLanguages = map[string]bool{
+	".py": true, ".pyi": true,
+}
+
+var (
+	// controlStatement matches block headers that aren't declarations.
+	controlStatement = regexp.MustCompile(`^(}\s*)?(if|else|for|foreach|while|switch|match|case|default|catch|try|do|finally|return|with|select|go|defer|lock|using|synchronized|unsafe)\b`)
+	// namedDeclaration matches keyword based declarations of functions, types, ...
+	namedDeclaration = regexp.MustCompile(`\b((func|function|fn|fun|def)\b\s*(\([^)]*\)\s*)?\*?\s*[A-Za-z_$][\w$]*\s*[(<\[]|(class|struct|interface|enum|trait|impl|type|namespace|module|object|record|union)\s+[A-Za-z_$<])`)
+	// methodDeclaration matches C-like declarations: optional modifiers and return type, then a name and its parameters.
+	methodDeclaration = regexp.MustCompile(`^(?:[\w<>\[\],?*&:@]+\s+)*[A-Za-z_$~][\w$:~]*\s*\(`)
+	// functionAssignment matches functions assigned to variables and properties (JavaScript, TypeScript).
+	functionAssignment = regexp.MustCompile(`^(export\s+)?((const|let|var)\s+)?[A-Za-z_$][\w$.]*\s*[:=]\s*(async\s+)?(function\b|\([^)]*\)\s*(:[^=]*)?=>|[A-Za-z_$][\w$]*\s*=>)`)
+	// pythonDeclaration matches Python function and class definitions.
+	pythonDeclaration = regexp.MustCompile(`^(async\s+def|def|class)\s`)
+)
+
+// FileReadSymbol reads the function or class declaration enclosing the given (1-indexed) line of a file,
+// so that code can be read in coherent chunks. It returns the declaration along with its first and last lines.
+// Declarations are found with lightweight heuristics: braces for C-like languages, indentation for Python.
+func (env *Environment) FileReadSymbol(ctx context.Context, targetFile string, line int) (string, int, int, error) {
+	file, err := env.readFile(ctx, targetFile)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	lines := strings.Split(file, "\n")
+	start, end, err := enclosingDeclaration(targetFile, lines, line-1)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return strings.Join(lines[start:end+1], "\n"), start + 1, end + 1, nil
+}
+
+// enclosingDeclaration returns the first and last (0-indexed) lines of the innermost declaration enclosing line.
+func enclosingDeclaration(path string, lines []string, line int) (int, int, error) {
+	if line < 0 || line >= len(lines) {
+		return 0, 0, fmt.Errorf("line %d is out of range, the file has %d lines", line+1, len(lines))
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	var (
+		start, end int
+		found      bool
+	)
+	switch {
+	case braceLanguages[ext]:
+		start, end, found = enclosingBraceDeclaration(lines, line)
+	case indentLanguages[ext]:
+		start, end, found = enclosingIndentDeclaration(lines, line)
+	default:
+		return 0, 0, fmt.Errorf("reading by symbol is not supported for %q files, read a line range instead", ext)
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no function or class declaration found around line %d", line+1)
+	}
+	return start, end, nil
+}
+
+// braceBlock is a block delimited by braces, along with the lines of its header (e.g. a function signature).
+type braceBlock struct {
+	header, open, close int
+}
+
+func enclosingBraceDeclaration(lines []string, line int) (int, int, bool) {
+	var best *braceBlock
+	for _, block := range braceBlocks(lines) {
+		if line < block.header || line > block.close || !isDeclaration(lines, block) {
+			continue
+		}
+		if best == nil || block.header > best.header {
+			best = &block
+		}
+	}
+	if best == nil {
+		return 0, 0, false
+	}
+	return best.header, best.close, true
+}
+
+// braceBlocks lists the brace delimited blocks of a file, skipping braces in comments and string literals.
+func braceBlocks(lines []string) []braceBlock {
+	var (
+		blocks      []braceBlock
+		stack       []int
+		inBlockCmt  bool
+		inRawString bool // multi-line `...` strings (Go raw strings, JavaScript template literals)
+	)
+	for i, l := range lines {
+		for j := 0; j < len(l); j++ {
+			c := l[j]
+			switch {
+			case inBlockCmt:
+				if c == '*' && j+1 < len(l) && l[j+1] == '/' {
+					inBlockCmt = false
+					j++
+				}
+			case inRawString:
+				if c == '`' {
+					inRawString = false
+				}
+			case c == '/' && j+1 < len(l) && l[j+1] == '/':
+				j = len(l)
+			case c == '/' && j+1 < len(l) && l[j+1] == '*':
+				inBlockCmt = true
+				j++
+			case c == '`':
+				inRawString = true
+			case c == '"' || c == '\'':
+				// Strings don't span lines. Unterminated quotes are ignored (e.g. Rust lifetimes).
+				if k := closingQuote(l, j); k != -1 {
+					j = k
+				}
+			case c == '{':
+				stack = append(stack, i)
+			case c == '}':
+				if len(stack) > 0 {
+					open := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					blocks = append(blocks, braceBlock{header: blockHeader(lines, open), open: open, close: i})
+				}
+			}
+		}
+	}
+	return blocks
+}
+
+// closingQuote returns the index of the quote closing the string literal starting at start, or -1.
+func closingQuote(l string, start int) int {
+	for k := start + 1; k < len(l); k++ {
+		switch l[k] {
+		case '\\':
+			k++
+		case l[start]:
+			return k
+		}
+	}
+	return -1
+}
+
+// blockHeader returns the first line of the header of a block opening on line open, including the
+// signature lines that precede the brace and the doc comments and annotations that precede the signature.
+func blockHeader(lines []string, open int) int {
+	header := open
+	if strings.HasPrefix(strings.TrimSpace(lines[header]), "{") && header > 0 {
+		// Brace on its own line
+		header--
+	}
+	// Multi-line signatures
+	for header > 0 {
+		prev := strings.TrimSpace(lines[header-1])
+		if !strings.HasSuffix(prev, ",") && !strings.HasSuffix(prev, "(") {
+			break
+		}
+		header--
+	}
+	// Doc comments and annotations
+	for header > 0 {
+		prev := strings.TrimSpace(lines[header-1])
+		if !isCommentOrAnnotation(prev) {
+			break
+		}
+		header--
+	}
+	return header
+}
+
+func isCommentOrAnnotation(line string) bool {
+	for _, prefix := range []string{"//", "/*", "*", "@", "#["} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeclaration reports whether the block's header declares a function, method, class, ...
+func isDeclaration(lines []string, block braceBlock) bool {
+	var signature []string
+	for i := block.header; i <= block.open; i++ {
+		l := strings.TrimSpace(lines[i])
+		if isCommentOrAnnotation(l) {
+			continue
+		}
+		signature = append(signature, l)
+	}
+	header := strings.Join(signature, " ")
+	if header == "" || controlStatement.MatchString(header) {
+		return false
+	}
+	return namedDeclaration.MatchString(header) || methodDeclaration.MatchString(header) || functionAssignment.MatchString(header)
+}
+
+func enclosingIndentDeclaration(lines []string, line int) (int, int, bool) {
+	// Walk up to the innermost def or class that is less indented than the line.
+	decl := -1
+	limit := -1
+	for i := line; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		// Closing brackets of multi-line signatures don't delimit anything
+		if i != line && strings.ContainsAny(strings.TrimSpace(lines[i])[:1], ")]}") {
+			continue
+		}
+		indent := indentation(lines[i])
+		if i != line && limit != -1 && indent >= limit {
+			continue
+		}
+		if pythonDeclaration.MatchString(strings.TrimSpace(lines[i])) {
+			decl = i
+			break
+		}
+		limit = indent
+	}
+	if decl == -1 {
+		return 0, 0, false
+	}
+	declIndent := indentation(lines[decl])
+
+	// The body starts after the signature, which may span several lines.
+	body := decl
+	for body < len(lines)-1 && !strings.HasSuffix(stripPythonComment(lines[body]), ":") {
+		body++
+	}
+
+	end := body
+	for i := body + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if indentation(lines[i]) <= declIndent {
+			break
+		}
+		end = i
+	}
+
+	// Decorators
+	start := decl
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(lines[start-1]), "@") {
+		start--
+	}
+	return start, end, true
+}
+
+func indentation(l string) int {
+	return len(l) - len(strings.TrimLeft(l, " \t"))
+}
+
+func stripPythonComment(l string) string {
+	if i := strings.Index(l, "#"); i != -1 {
+		l = l[:i]
+	}
+	return strings.TrimSpace(l)
+}
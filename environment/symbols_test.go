@@ -0,0 +1,141 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnclosingDeclaration_Braces(t *testing.T) {
+	source := `package main
+
+import "fmt"
+
+// greet says hello.
+// The braces in "{strings}" and comments { are ignored.
+func greet(name string) {
+	if name == "" {
+		name = "}"
+	}
+	fmt.Println("hello", name)
+}
+
+type server struct {
+	addr string
+}
+
+func (s *server) run(
+	port int,
+) error {
+	handler := func() error {
+		return nil
+	}
+	return handler()
+}
+`
+	lines := strings.Split(source, "\n")
+
+	tests := []struct {
+		name       string
+		line       int
+		start, end int
+	}{
+		{name: "function body", line: 11, start: 5, end: 12},
+		{name: "inside control flow", line: 9, start: 5, end: 12},
+		{name: "doc comment", line: 5, start: 5, end: 12},
+		{name: "type", line: 15, start: 14, end: 16},
+		{name: "multi-line signature", line: 19, start: 18, end: 25},
+		{name: "anonymous function", line: 22, start: 18, end: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := enclosingDeclaration("main.go", lines, tt.line-1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.start, start+1)
+			assert.Equal(t, tt.end, end+1)
+		})
+	}
+
+	_, _, err := enclosingDeclaration("main.go", lines, 2)
+	assert.Error(t, err, "imports aren't inside any declaration")
+}
+
+func TestEnclosingDeclaration_Java(t *testing.T) {
+	source := `public class Greeter {
+    private final String name;
+
+    @Override
+    public String toString()
+    {
+        return name;
+    }
+}`
+	lines := strings.Split(source, "\n")
+
+	start, end, err := enclosingDeclaration("Greeter.java", lines, 6)
+	require.NoError(t, err)
+	assert.Equal(t, 3, start)
+	assert.Equal(t, 7, end)
+
+	start, end, err = enclosingDeclaration("Greeter.java", lines, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 8, end)
+}
+
+func TestEnclosingDeclaration_Python(t *testing.T) {
+	source := `import os
+
+
+class Greeter:
+    def __init__(self, name):
+        self.name = name
+
+    @property
+    def greeting(
+        self,
+    ):
+        if self.name:
+            return "hello " + self.name
+
+        return "hello"
+
+
+def main():
+    print(Greeter("world").greeting)
+`
+	lines := strings.Split(source, "\n")
+
+	tests := []struct {
+		name       string
+		line       int
+		start, end int
+	}{
+		{name: "method", line: 6, start: 5, end: 6},
+		{name: "decorated method", line: 13, start: 8, end: 15},
+		{name: "blank line in method", line: 14, start: 8, end: 15},
+		{name: "class", line: 4, start: 4, end: 15},
+		{name: "function", line: 19, start: 18, end: 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := enclosingDeclaration("greeter.py", lines, tt.line-1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.start, start+1)
+			assert.Equal(t, tt.end, end+1)
+		})
+	}
+
+	_, _, err := enclosingDeclaration("greeter.py", lines, 0)
+	assert.Error(t, err)
+}
+
+func TestEnclosingDeclaration_Unsupported(t *testing.T) {
+	_, _, err := enclosingDeclaration("README.md", []string{"# title"}, 0)
+	assert.Error(t, err)
+
+	_, _, err = enclosingDeclaration("main.go", []string{"package main"}, 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,212 @@
+package environment
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supported test output formats
+const (
+	TestFormatGoJSON = "go-json"
+	TestFormatJUnit  = "junit"
+)
+
+// maxTestFailureOutputLines bounds the output kept for each failure.
+const maxTestFailureOutputLines = 20
+
+// TestResults is a structured summary of a test run.
+type TestResults struct {
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"`
+	Skipped  int           `json:"skipped"`
+	Errors   int           `json:"errors"`
+	Failures []TestFailure `json:"failures,omitempty"`
+}
+
+// TestFailure describes a failed test, or an error (e.g. build failure) that prevented tests from running.
+type TestFailure struct {
+	Suite   string `json:"suite,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ParseTestResults parses the output of a test runner in the given format.
+func ParseTestResults(format, output string) (*TestResults, error) {
+	switch format {
+	case TestFormatGoJSON:
+		return parseGoTestJSON(output)
+	case TestFormatJUnit:
+		return parseJUnitXML(output)
+	default:
+		return nil, fmt.Errorf("unsupported test format %q, must be one of: %s, %s", format, TestFormatGoJSON, TestFormatJUnit)
+	}
+}
+
+// goTestEvent is an event emitted by `go test -json` (see `go doc test2json`).
+type goTestEvent struct {
+	Action     string
+	Package    string
+	ImportPath string
+	Test       string
+	Output     string
+	// FailedBuild is set on package failures caused by a build failure, reported by a build-fail event.
+	FailedBuild string
+}
+
+func parseGoTestJSON(output string) (*TestResults, error) {
+	results := &TestResults{}
+	outputs := map[string][]string{}
+	key := func(pkg, test string) string { return pkg + "\x00" + test }
+
+	events := 0
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var event goTestEvent
+		// go test mixes in non JSON lines (e.g. compiler errors on stderr), skip them.
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &event) != nil || event.Action == "" {
+			continue
+		}
+		events++
+
+		switch event.Action {
+		case "output", "build-output":
+			pkg := event.Package
+			if event.Action == "build-output" {
+				pkg = event.ImportPath
+			}
+			k := key(pkg, event.Test)
+			outputs[k] = append(outputs[k], strings.TrimRight(event.Output, "\n"))
+		case "pass":
+			if event.Test != "" {
+				results.Passed++
+			}
+		case "skip":
+			if event.Test != "" {
+				results.Skipped++
+			}
+		case "fail":
+			if event.Test != "" {
+				results.Failed++
+				results.Failures = append(results.Failures, TestFailure{
+					Suite:   event.Package,
+					Name:    event.Test,
+					Message: lastLines(outputs[key(event.Package, event.Test)], maxTestFailureOutputLines),
+				})
+			} else if event.FailedBuild == "" && !hasFailures(results, event.Package) {
+				// The package failed without any failing test: it didn't build or crashed.
+				results.Errors++
+				results.Failures = append(results.Failures, TestFailure{
+					Suite:   event.Package,
+					Message: lastLines(outputs[key(event.Package, "")], maxTestFailureOutputLines),
+				})
+			}
+		case "build-fail":
+			results.Errors++
+			results.Failures = append(results.Failures, TestFailure{
+				Suite:   event.ImportPath,
+				Message: lastLines(outputs[key(event.ImportPath, "")], maxTestFailureOutputLines),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if events == 0 {
+		return nil, errors.New("no go test -json events found in output, is the command running `go test -json`?")
+	}
+	return results, nil
+}
+
+func hasFailures(results *TestResults, pkg string) bool {
+	for _, failure := range results.Failures {
+		if failure.Suite == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+func lastLines(lines []string, n int) string {
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// junitSuite is either a <testsuites> or a <testsuite> element, which may be nested.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func (f *junitFailure) String() string {
+	message := strings.TrimSpace(f.Message)
+	if body := strings.TrimSpace(f.Body); body != "" {
+		if message != "" {
+			message += "\n"
+		}
+		message += lastLines(strings.Split(body, "\n"), maxTestFailureOutputLines)
+	}
+	return message
+}
+
+func parseJUnitXML(output string) (*TestResults, error) {
+	// The report may be preceded by other output
+	start := strings.Index(output, "<testsuite")
+	if start == -1 {
+		return nil, errors.New("no JUnit XML report found")
+	}
+
+	var root junitSuite
+	if err := xml.NewDecoder(strings.NewReader(output[start:])).Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid JUnit XML report: %w", err)
+	}
+
+	results := &TestResults{}
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			name := c.Classname
+			if name == "" {
+				name = suite.Name
+			}
+			switch {
+			case c.Failure != nil:
+				results.Failed++
+				results.Failures = append(results.Failures, TestFailure{Suite: name, Name: c.Name, Message: c.Failure.String()})
+			case c.Error != nil:
+				results.Errors++
+				results.Failures = append(results.Failures, TestFailure{Suite: name, Name: c.Name, Message: c.Error.String()})
+			case c.Skipped != nil:
+				results.Skipped++
+			default:
+				results.Passed++
+			}
+		}
+		for _, s := range suite.Suites {
+			walk(s)
+		}
+	}
+	walk(root)
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTestResults_GoJSON(t *testing.T) {
+	output := `{"Action":"start","Package":"example.com/foo"}
+{"Action":"run","Package":"example.com/foo","Test":"TestOK"}
+{"Action":"output","Package":"example.com/foo","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"example.com/foo","Test":"TestOK","Elapsed":0}
+{"Action":"run","Package":"example.com/foo","Test":"TestSkip"}
+{"Action":"skip","Package":"example.com/foo","Test":"TestSkip","Elapsed":0}
+{"Action":"run","Package":"example.com/foo","Test":"TestBroken"}
+{"Action":"output","Package":"example.com/foo","Test":"TestBroken","Output":"    foo_test.go:12: expected 1, got 2\n"}
+{"Action":"fail","Package":"example.com/foo","Test":"TestBroken","Elapsed":0}
+{"Action":"fail","Package":"example.com/foo","Elapsed":0.1}
+# example.com/bar
+{"ImportPath":"example.com/bar [example.com/bar.test]","Action":"build-output","Output":"bar_test.go:3:1: syntax error\n"}
+{"ImportPath":"example.com/bar [example.com/bar.test]","Action":"build-fail"}
+{"Action":"fail","Package":"example.com/bar","Elapsed":0,"FailedBuild":"example.com/bar [example.com/bar.test]"}
+{"Action":"output","Package":"example.com/baz","Output":"panic: boom\n"}
+{"Action":"fail","Package":"example.com/baz","Elapsed":0}
+`
+	results, err := ParseTestResults(TestFormatGoJSON, output)
+	require.NoError(t, err)
+	assert.Equal(t, 1, results.Passed)
+	assert.Equal(t, 1, results.Skipped)
+	assert.Equal(t, 1, results.Failed)
+	assert.Equal(t, 2, results.Errors)
+	assert.Equal(t, []TestFailure{
+		{Suite: "example.com/foo", Name: "TestBroken", Message: "    foo_test.go:12: expected 1, got 2"},
+		{Suite: "example.com/bar [example.com/bar.test]", Message: "bar_test.go:3:1: syntax error"},
+		{Suite: "example.com/baz", Message: "panic: boom"},
+	}, results.Failures)
+
+	_, err = ParseTestResults(TestFormatGoJSON, "ok  \texample.com/foo\t0.1s\n")
+	assert.Error(t, err)
+}
+
+func TestParseTestResults_JUnit(t *testing.T) {
+	output := `Running tests...
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="math" tests="4">
+    <testcase classname="math.Add" name="adds"/>
+    <testcase classname="math.Sub" name="subtracts">
+      <failure message="expected 1">AssertionError: expected 1 but got 2</failure>
+    </testcase>
+    <testcase name="divides">
+      <error message="division by zero"/>
+    </testcase>
+    <testcase classname="math.Mul" name="multiplies">
+      <skipped/>
+    </testcase>
+  </testsuite>
+</testsuites>`
+
+	results, err := ParseTestResults(TestFormatJUnit, output)
+	require.NoError(t, err)
+	assert.Equal(t, 1, results.Passed)
+	assert.Equal(t, 1, results.Failed)
+	assert.Equal(t, 1, results.Errors)
+	assert.Equal(t, 1, results.Skipped)
+	assert.Equal(t, []TestFailure{
+		{Suite: "math.Sub", Name: "subtracts", Message: "expected 1\nAssertionError: expected 1 but got 2"},
+		{Suite: "math", Name: "divides", Message: "division by zero"},
+	}, results.Failures)
+
+	// A single <testsuite> root works as well
+	results, err = ParseTestResults(TestFormatJUnit, `<testsuite name="s"><testcase name="a"/></testsuite>`)
+	require.NoError(t, err)
+	assert.Equal(t, 1, results.Passed)
+
+	_, err = ParseTestResults(TestFormatJUnit, "no report here")
+	assert.Error(t, err)
+}
+
+func TestParseTestResults_UnknownFormat(t *testing.T) {
+	_, err := ParseTestResults("tap", "")
+	assert.Error(t, err)
+}
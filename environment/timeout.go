@@ -0,0 +1,31 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// ErrCommandTimeout is returned when a command runs longer than the Timeout of its RunOpts.
+var ErrCommandTimeout = errors.New("command timed out")
+
+// exitCodeTimeout is the exit code recorded for commands stopped at their timeout, as with timeout(1).
+const exitCodeTimeout = 124
+
+// execExitCode waits for the exec of a command and returns its exit code. With a timeout, the exec is cancelled
+// once it's exceeded and ErrCommandTimeout is returned.
+func execExitCode(ctx context.Context, ctr *dagger.Container, timeout time.Duration) (int, error) {
+	if timeout <= 0 {
+		return ctr.ExitCode(ctx)
+	}
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	exitCode, err := ctr.ExitCode(execCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+		return 0, fmt.Errorf("%w: it was stopped after running for %s", ErrCommandTimeout, timeout)
+	}
+	return exitCode, err
+}
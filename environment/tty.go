@@ -0,0 +1,43 @@
+package environment
+
+import (
+	"errors"
+	"strings"
+)
+
+// ttyScript runs the command line $1 in a pseudo-terminal. Dagger only allocates terminals to interactive
+// sessions, not to execs, so this relies on script(1) from util-linux, or on Python's pty module.
+const ttyScript = `export TERM="${TERM:-xterm-256color}"
+if command -v script >/dev/null 2>&1 && script -qec true /dev/null >/dev/null 2>&1; then
+	exec script -qec "$1" /dev/null
+fi
+if command -v python3 >/dev/null 2>&1; then
+	exec python3 -c 'import os, pty, sys
+code = os.waitstatus_to_exitcode(pty.spawn(sys.argv[1:]))
+sys.exit(code if code >= 0 else 128 - code)' sh -c "$1"
+fi
+echo "tty: allocating a pseudo-terminal requires script (from util-linux) or python3 in the container" >&2
+exit 127`
+
+// applyTTY wraps the exec args of a command so that it runs in a pseudo-terminal, if requested.
+func applyTTY(opts RunOpts, args []string) ([]string, error) {
+	if !opts.TTY {
+		return args, nil
+	}
+	if len(args) == 0 {
+		return nil, errors.New("tty requires a command or a script")
+	}
+	if opts.UseEntrypoint {
+		return nil, errors.New("tty can't be used with the image entrypoint")
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return []string{"sh", "-c", ttyScript, "sh", strings.Join(quoted, " ")}, nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
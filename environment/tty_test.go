@@ -0,0 +1,26 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTTY(t *testing.T) {
+	args := []string{"sh", "-c", "echo 'it works'"}
+
+	wrapped, err := applyTTY(RunOpts{Shell: "sh"}, args)
+	require.NoError(t, err)
+	assert.Equal(t, args, wrapped)
+
+	wrapped, err = applyTTY(RunOpts{Shell: "sh", TTY: true}, args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", ttyScript, "sh", `'sh' '-c' 'echo '\''it works'\'''`}, wrapped)
+
+	_, err = applyTTY(RunOpts{Shell: "sh", TTY: true}, nil)
+	assert.Error(t, err, "the default command can't be wrapped")
+
+	_, err = applyTTY(RunOpts{Shell: "sh", UseEntrypoint: true, TTY: true}, args)
+	assert.Error(t, err, "the entrypoint can't be wrapped")
+}
@@ -0,0 +1,119 @@
+package environment
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// variableReference matches references to template variables: ${NAME}, or $${NAME} for a literal ${NAME}.
+var variableReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Resolve returns a copy of the configuration with the ${NAME} references to template variables replaced
+// by their values, so that a single configuration can be instantiated for e.g. several language versions
+// (`"base_image": "python:${PY_VERSION}"`). Values come from variables, falling back to the configuration's
+// own Variables. It fails if a referenced variable has no value: use $${NAME} for a literal ${NAME}, e.g.
+// `PATH=/opt/bin:$${PATH}` for the container to expand it.
+//
+// References are resolved in the workdir, base image, environment variables, secrets, PATH directories,
+// context directory, git configuration, volumes and services (except their command). Commands are left alone:
+// they are shell scripts with variables of their own, set env to pass template variables to them.
+func (config *EnvironmentConfig) Resolve(variables map[string]string) (*EnvironmentConfig, error) {
+	return config.resolve(variables, &EnvironmentConfig{})
+}
+
+// ResolveUpdate resolves an update of current, an already resolved configuration, like Resolve. The values the
+// update keeps from current are left as is: they were resolved already, and a literal ${NAME} they got from an
+// escaped $${NAME} must not be expanded now.
+func (config *EnvironmentConfig) ResolveUpdate(current *EnvironmentConfig) (*EnvironmentConfig, error) {
+	return config.resolve(nil, current)
+}
+
+func (config *EnvironmentConfig) resolve(variables map[string]string, current *EnvironmentConfig) (*EnvironmentConfig, error) {
+	values := maps.Clone(config.Variables)
+	if values == nil {
+		values = map[string]string{}
+	}
+	maps.Copy(values, variables)
+
+	missing := map[string]bool{}
+	// expand resolves s, unless it's one of the values it replaces in current
+	expand := func(s string, resolved ...string) string {
+		if slices.Contains(resolved, s) {
+			return s
+		}
+		return variableReference.ReplaceAllStringFunc(s, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+			name := ref[2 : len(ref)-1]
+			value, ok := values[name]
+			if !ok {
+				missing[name] = true
+			}
+			return value
+		})
+	}
+	expandAll := func(list []string, resolved []string) []string {
+		if list == nil {
+			return nil
+		}
+		expanded := make([]string, len(list))
+		for i, s := range list {
+			expanded[i] = expand(s, resolved...)
+		}
+		return expanded
+	}
+
+	resolved := config.Copy()
+	resolved.Workdir = expand(config.Workdir, current.Workdir)
+	resolved.BaseImage = expand(config.BaseImage, current.BaseImage)
+	resolved.Env = expandAll(config.Env, current.Env)
+	resolved.Secrets = expandAll(config.Secrets, current.Secrets)
+	resolved.PathPrepend = expandAll(config.PathPrepend, current.PathPrepend)
+	resolved.ContextDir = expand(config.ContextDir, current.ContextDir)
+	// The CA bundle isn't resolved: agents set variables, and must not choose which host file is read
+	for key, value := range resolved.GitConfig {
+		resolved.GitConfig[key] = expand(value, current.GitConfig[key])
+	}
+	for _, stage := range resolved.SetupStages {
+		var currentStage SetupStage
+		if i := slices.IndexFunc(current.SetupStages, func(s *SetupStage) bool { return s.Name == stage.Name }); i >= 0 {
+			currentStage = *current.SetupStages[i]
+		}
+		stage.CacheKey = expand(stage.CacheKey, currentStage.CacheKey)
+		stage.CachePaths = expandAll(stage.CachePaths, currentStage.CachePaths)
+	}
+	currentVolumes := map[string]bool{}
+	for _, volume := range current.Volumes {
+		currentVolumes[volume.Name+":"+volume.Path] = true
+	}
+	for _, volume := range resolved.Volumes {
+		if !currentVolumes[volume.Name+":"+volume.Path] {
+			volume.Name = expand(volume.Name)
+			volume.Path = expand(volume.Path)
+		}
+	}
+	for _, svc := range resolved.Services {
+		currentSvc := current.Services.Get(svc.Name)
+		if currentSvc == nil {
+			currentSvc = &ServiceConfig{}
+		}
+		svc.Image = expand(svc.Image, currentSvc.Image)
+		svc.Env = expandAll(svc.Env, currentSvc.Env)
+		svc.RegistryUsername = expand(svc.RegistryUsername, currentSvc.RegistryUsername)
+		svc.RegistryPassword = expand(svc.RegistryPassword, currentSvc.RegistryPassword)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undeclared template variables: %s (use $${NAME} for a literal ${NAME})", strings.Join(slices.Sorted(maps.Keys(missing)), ", "))
+	}
+
+	resolved.Variables = nil
+	if len(values) > 0 {
+		resolved.Variables = values
+	}
+	return resolved, nil
+}
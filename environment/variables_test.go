@@ -0,0 +1,90 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	config := &EnvironmentConfig{
+		Workdir:       "/workdir",
+		BaseImage:     "python:${PY_VERSION}-${VARIANT}",
+		SetupCommands: []string{"echo ${HOME}"},
+		Env:           KVList{"PY_VERSION=${PY_VERSION}", "TEMPLATE=$${PY_VERSION}"},
+		PathPrepend:   []string{"/opt/python${PY_VERSION}/bin"},
+		GitConfig:     map[string]string{"user.name": "${AUTHOR}"},
+		Services:      ServiceConfigs{{Name: "db", Image: "postgres:${PG_VERSION}", Command: "postgres -c ${FLAGS}"}},
+		Variables:     map[string]string{"VARIANT": "slim", "PY_VERSION": "3.11", "AUTHOR": "Agent", "PG_VERSION": "17"},
+	}
+
+	resolved, err := config.Resolve(map[string]string{"PY_VERSION": "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.12-slim", resolved.BaseImage)
+	assert.Equal(t, KVList{"PY_VERSION=3.12", "TEMPLATE=${PY_VERSION}"}, resolved.Env)
+	assert.Equal(t, []string{"/opt/python3.12/bin"}, resolved.PathPrepend)
+	assert.Equal(t, "Agent", resolved.GitConfig["user.name"])
+	assert.Equal(t, "postgres:17", resolved.Services[0].Image)
+	assert.Equal(t, "3.12", resolved.Variables["PY_VERSION"])
+
+	// Commands are shell scripts: their variables are left alone
+	assert.Equal(t, []string{"echo ${HOME}"}, resolved.SetupCommands)
+	assert.Equal(t, "postgres -c ${FLAGS}", resolved.Services[0].Command)
+
+	// The template itself is left untouched
+	assert.Equal(t, "python:${PY_VERSION}-${VARIANT}", config.BaseImage)
+	assert.Equal(t, "postgres:${PG_VERSION}", config.Services[0].Image)
+	assert.Equal(t, "3.11", config.Variables["PY_VERSION"])
+}
+
+func TestResolveUndeclaredVariables(t *testing.T) {
+	config := &EnvironmentConfig{
+		BaseImage: "python:${PY_VERSON}",
+		Env:       KVList{"PATH=/opt/bin:$${PATH}", "PY=${PY_VERSION}"},
+	}
+
+	_, err := config.Resolve(map[string]string{"PY_VERSION": "3.12"})
+	assert.ErrorContains(t, err, "undeclared template variables: PY_VERSON")
+
+	// Escaped references are left for the container to expand
+	config.BaseImage = "python:${PY_VERSION}"
+	resolved, err := config.Resolve(map[string]string{"PY_VERSION": "3.12"})
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.12", resolved.BaseImage)
+	assert.Equal(t, KVList{"PATH=/opt/bin:${PATH}", "PY=3.12"}, resolved.Env)
+}
+
+func TestResolveUpdate(t *testing.T) {
+	config := &EnvironmentConfig{
+		BaseImage: "python:${PY_VERSION}",
+		Env:       KVList{"PATH=/opt/bin:$${PATH}"},
+		Services:  ServiceConfigs{{Name: "db", Image: "postgres:latest", Env: KVList{"PGDATA=$${HOME}/data"}}},
+		Variables: map[string]string{"PY_VERSION": "3.12"},
+	}
+	current, err := config.Resolve(nil)
+	require.NoError(t, err)
+
+	// The values kept from the resolved configuration were resolved already
+	update := current.Copy()
+	update.Env = append(update.Env, "PY=${PY_VERSION}", "HOME_BIN=$${HOME}/bin")
+	resolved, err := update.ResolveUpdate(current)
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.12", resolved.BaseImage)
+	assert.Equal(t, KVList{"PATH=/opt/bin:${PATH}", "PY=3.12", "HOME_BIN=${HOME}/bin"}, resolved.Env)
+	assert.Equal(t, []string{"PGDATA=${HOME}/data"}, resolved.Services[0].Env)
+
+	// New values are still checked
+	update.BaseImage = "python:${PY_VERSON}"
+	_, err = update.ResolveUpdate(current)
+	assert.ErrorContains(t, err, "undeclared template variables: PY_VERSON")
+}
+
+func TestResolveWithoutVariables(t *testing.T) {
+	config := DefaultConfig()
+	resolved, err := config.Resolve(nil)
+	require.NoError(t, err)
+	assert.Equal(t, config.BaseImage, resolved.BaseImage)
+	assert.Equal(t, config.Workdir, resolved.Workdir)
+	assert.Nil(t, resolved.Variables)
+}
@@ -0,0 +1,195 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// commandAllowlist restricts the commands agents run, see ServerOptions.AllowedCommands: the commands of
+// environment_run_cmd, as well as the ones they add to the configuration or run as services.
+type commandAllowlist struct {
+	entries  []string
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+type commandAllowlistKey struct{}
+
+// allowlistShells are the shells commands may be interpreted by when an allowlist is set: any other program
+// (e.g. python3) would run the command as code of its own.
+var allowlistShells = []string{"sh", "bash", "dash", "ash", "zsh"}
+
+// allowlistEnv are the environment variables agents can't set when an allowlist is set: they change which binary
+// an allowed command runs (PATH), or run code of their own when a shell or program starts (e.g. BASH_ENV).
+var allowlistEnv = []string{"PATH", "ENV", "BASH_ENV", "ZDOTDIR", "PROMPT_COMMAND", "SHELLOPTS", "BASHOPTS", "PS4", "IFS", "LD_PRELOAD", "LD_LIBRARY_PATH", "LD_AUDIT"}
+
+// shellOperators chain, pipe, redirect or substitute commands. Commands using them are rejected when an allowlist is
+// set, as they would run commands the allowlist doesn't cover (e.g. "go test ./... ; curl ...").
+var shellOperators = []string{";", "&", "|", "`", "$(", ">", "<", "\n", "\r"}
+
+// newCommandAllowlist parses the entries of an allowlist: command prefixes (e.g. "go test"), matched on word
+// boundaries, or regular expressions between slashes (e.g. "/npm (ci|test)/") that whole commands must match.
+// It returns nil, allowing every command, if there are no entries.
+func newCommandAllowlist(entries []string) (*commandAllowlist, error) {
+	allowlist := &commandAllowlist{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		allowlist.entries = append(allowlist.entries, entry)
+		if expr, ok := strings.CutPrefix(entry, "/"); ok && len(expr) > 1 && strings.HasSuffix(expr, "/") {
+			pattern, err := regexp.Compile(`^(?:` + strings.TrimSuffix(expr, "/") + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed command %s: %w", entry, err)
+			}
+			allowlist.patterns = append(allowlist.patterns, pattern)
+			continue
+		}
+		allowlist.prefixes = append(allowlist.prefixes, entry)
+	}
+	if len(allowlist.entries) == 0 {
+		return nil, nil
+	}
+	return allowlist, nil
+}
+
+// check returns an error if the allowlist doesn't allow running command with shell. An empty command is rejected:
+// it would run the default command or entrypoint of an image, which the allowlist doesn't cover.
+func (a *commandAllowlist) check(command, shell string) error {
+	if a == nil {
+		return nil
+	}
+	if !slices.Contains(allowlistShells, path.Base(shell)) {
+		return fmt.Errorf("shell %s is not allowed by the server's command allowlist: use sh or bash", shell)
+	}
+
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return errors.New("an empty command is not allowed by the server's command allowlist: it would run the image's default command")
+	}
+	for _, operator := range shellOperators {
+		if strings.Contains(command, operator) {
+			return fmt.Errorf("command %q is not allowed by the server's command allowlist: run commands one at a time, without chaining, piping, redirecting or substituting them (%q)", command, operator)
+		}
+	}
+	for _, prefix := range a.prefixes {
+		if command == prefix || strings.HasPrefix(command, prefix+" ") {
+			return nil
+		}
+	}
+	for _, pattern := range a.patterns {
+		if pattern.MatchString(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not allowed by the server's command allowlist. Allowed commands: %s", command, strings.Join(a.entries, ", "))
+}
+
+// checkEnv returns an error if the allowlist doesn't allow setting one of the variables of env (see allowlistEnv).
+// Entries of previous were set by the user and are allowed.
+func (a *commandAllowlist) checkEnv(env, previous []string) error {
+	if a == nil {
+		return nil
+	}
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if slices.Contains(allowlistEnv, strings.TrimSpace(name)) && !slices.Contains(previous, entry) {
+			return fmt.Errorf("setting %s is not allowed by the server's command allowlist: it would change what allowed commands run", name)
+		}
+	}
+	return nil
+}
+
+// allowlistFromContext returns the allowlist of the server handling the tool call, or nil if it has none.
+func allowlistFromContext(ctx context.Context) *commandAllowlist {
+	allowlist, _ := ctx.Value(commandAllowlistKey{}).(*commandAllowlist)
+	return allowlist
+}
+
+// checkAllowedCommand checks command against the allowlist of the server handling the tool call, if any.
+func checkAllowedCommand(ctx context.Context, command, shell string) error {
+	return allowlistFromContext(ctx).check(command, shell)
+}
+
+// checkAllowedEntrypoint rejects running the entrypoint of the image when the server has an allowlist: the
+// entrypoint runs before the command, whatever it is.
+func checkAllowedEntrypoint(ctx context.Context, useEntrypoint bool) error {
+	if useEntrypoint && allowlistFromContext(ctx) != nil {
+		return errors.New("use_entrypoint is not allowed by the server's command allowlist: the entrypoint of the image would run unchecked")
+	}
+	return nil
+}
+
+// checkAllowedService checks a service an agent adds to an environment configured with config: its command, its
+// environment variables, and its image, which must be one the user configured (the base image or the image of a
+// configured service), since its binaries are what allowed commands run.
+func checkAllowedService(ctx context.Context, config *environment.EnvironmentConfig, service *environment.ServiceConfig) error {
+	allowlist := allowlistFromContext(ctx)
+	if allowlist == nil {
+		return nil
+	}
+	// Services run their command with sh
+	if err := allowlist.check(service.Command, "sh"); err != nil {
+		return fmt.Errorf("service command: %w", err)
+	}
+	if err := allowlist.checkEnv(service.Env, nil); err != nil {
+		return fmt.Errorf("service envs: %w", err)
+	}
+	configured := service.Image == config.BaseImage || slices.ContainsFunc(config.Services, func(svc *environment.ServiceConfig) bool {
+		return svc.Image == service.Image
+	})
+	if !configured {
+		return fmt.Errorf("service image %s is not allowed by the server's command allowlist: use the base image or the image of a configured service", service.Image)
+	}
+	return nil
+}
+
+// checkAllowedConfig checks the changes an agent makes to the configuration of an environment, which would
+// otherwise bypass the allowlist while the environment is built or when allowed commands run: the setup commands and
+// the commands of setup stages it adds, the base image, PATH directories and the variables of allowlistEnv. Values
+// already in the configuration were set by the user and are allowed.
+func checkAllowedConfig(ctx context.Context, current, updated *environment.EnvironmentConfig) error {
+	allowlist := allowlistFromContext(ctx)
+	if allowlist == nil {
+		return nil
+	}
+	if updated.BaseImage != current.BaseImage {
+		return fmt.Errorf("changing the base image is not allowed by the server's command allowlist: its binaries would run unchecked")
+	}
+	if !slices.Equal(updated.PathPrepend, current.PathPrepend) {
+		return errors.New("changing path_prepend is not allowed by the server's command allowlist: it would change what allowed commands run")
+	}
+	if err := allowlist.checkEnv(updated.Env, current.Env); err != nil {
+		return fmt.Errorf("envs: %w", err)
+	}
+	existing := configCommands(current)
+	for _, command := range configCommands(updated) {
+		if slices.Contains(existing, command) {
+			continue
+		}
+		if err := allowlist.check(command, "sh"); err != nil {
+			return fmt.Errorf("setup command: %w", err)
+		}
+	}
+	return nil
+}
+
+// configCommands are the commands run while an environment is built.
+func configCommands(config *environment.EnvironmentConfig) []string {
+	if config == nil {
+		return nil
+	}
+	commands := slices.Concat(config.SetupCommands, config.InstallCommands)
+	for _, stage := range config.SetupStages {
+		commands = append(commands, stage.Commands...)
+	}
+	return commands
+}
@@ -0,0 +1,53 @@
+package mcpserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+)
+
+// startAutoPrune deletes the environments of the working directory's repository that haven't been updated
+// for olderThan, once at startup and then every interval (if positive). It does nothing if olderThan isn't positive.
+func startAutoPrune(ctx context.Context, olderThan, interval time.Duration) {
+	if olderThan <= 0 {
+		return
+	}
+
+	go func() {
+		autoPrune(ctx, olderThan)
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				autoPrune(ctx, olderThan)
+			}
+		}
+	}()
+}
+
+func autoPrune(ctx context.Context, olderThan time.Duration) {
+	repo, err := repository.Open(ctx, ".")
+	if err != nil {
+		slog.Warn("Skipping auto-prune: failed to open repository", "err", err)
+		return
+	}
+
+	slog.Info("Auto-pruning environments", "older_than", olderThan)
+	pruned, err := repo.Prune(ctx, olderThan)
+	for _, id := range pruned {
+		slog.Info("Auto-pruned environment", "id", id)
+	}
+	if err != nil {
+		slog.Warn("Auto-prune failed", "err", err)
+	}
+	slog.Info("Auto-prune done", "pruned", len(pruned))
+}
@@ -0,0 +1,98 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// readinessTimeout bounds how long a readiness probe waits for the Dagger engine.
+	readinessTimeout = 5 * time.Second
+	// shutdownTimeout bounds how long in-flight requests are given to complete on shutdown.
+	shutdownTimeout = 10 * time.Second
+)
+
+// RunHTTPServer serves the MCP server over streamable HTTP on addr, at /mcp.
+// It also serves /healthz, reporting that the process is alive, and /readyz,
+// reporting whether the Dagger engine is reachable and tool calls can be served.
+func RunHTTPServer(ctx context.Context, dag *dagger.Client, addr string, opts ServerOptions) error {
+	ctx = context.WithValue(ctx, singleTenantKey{}, opts.SingleTenant)
+
+	s, err := newMCPServer(dag, opts)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", server.NewStreamableHTTPServer(s))
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler(func(ctx context.Context) error {
+		_, err := dag.Version(ctx)
+		return err
+	}))
+
+	httpSrv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, getNotifySignals()...)
+	defer cancel()
+
+	startAutoPrune(ctx, opts.AutoPrune, opts.AutoPruneInterval)
+	startStopRequestWatcher(ctx)
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("starting HTTP server", "addr", addr)
+		errCh <- httpSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// healthHandler reports liveness: the process is up and serving requests.
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler reports readiness: check must succeed for tool calls to be served.
+func readyHandler(check func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := check(ctx); err != nil {
+			slog.Warn("readiness check failed", "err", err)
+			http.Error(w, fmt.Sprintf("dagger engine unreachable: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
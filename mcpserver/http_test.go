@@ -0,0 +1,32 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		readyHandler(func(context.Context) error { return nil })(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("engine unreachable", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		readyHandler(func(context.Context) error { return errors.New("connection refused") })(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "connection refused")
+	})
+}
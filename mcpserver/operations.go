@@ -0,0 +1,84 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+)
+
+// errOperationCancelled is the cause of the cancellation of operations stopped by environment_cancel or `container-use cancel`.
+var errOperationCancelled = errors.New("operation cancelled")
+
+// cancelRequestPollInterval is how often running operations check whether the CLI asked to cancel them.
+const cancelRequestPollInterval = time.Second
+
+type operation struct {
+	cancel context.CancelCauseFunc
+}
+
+var (
+	// activeOperations tracks the operations running in each environment so that they can be cancelled
+	activeOperations   = map[string]map[*operation]struct{}{}
+	activeOperationsMu sync.Mutex
+)
+
+// trackOperation registers an operation running in the environment and returns its context, which is cancelled
+// by cancelOperations or when the CLI requests the cancellation (see repository.RequestCancel).
+// The returned function unregisters the operation and must be called once it is over.
+func trackOperation(ctx context.Context, envID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	op := &operation{cancel: cancel}
+
+	activeOperationsMu.Lock()
+	if activeOperations[envID] == nil {
+		activeOperations[envID] = map[*operation]struct{}{}
+	}
+	activeOperations[envID][op] = struct{}{}
+	activeOperationsMu.Unlock()
+
+	started := time.Now()
+	go func() {
+		ticker := time.NewTicker(cancelRequestPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if repository.CancelRequested(envID, started) {
+					cancel(errOperationCancelled)
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		activeOperationsMu.Lock()
+		delete(activeOperations[envID], op)
+		if len(activeOperations[envID]) == 0 {
+			delete(activeOperations, envID)
+		}
+		activeOperationsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelOperations cancels the operations running in the environment and returns how many were cancelled.
+func cancelOperations(envID string) int {
+	activeOperationsMu.Lock()
+	defer activeOperationsMu.Unlock()
+
+	for op := range activeOperations[envID] {
+		op.cancel(errOperationCancelled)
+	}
+	return len(activeOperations[envID])
+}
+
+// isOperationCancelled reports whether ctx was cancelled by cancelOperations or a cancel request.
+func isOperationCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errOperationCancelled)
+}
@@ -0,0 +1,33 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelOperations(t *testing.T) {
+	ctx := context.Background()
+
+	first, doneFirst := trackOperation(ctx, "env-a")
+	second, doneSecond := trackOperation(ctx, "env-a")
+	other, doneOther := trackOperation(ctx, "env-b")
+	defer doneOther()
+
+	assert.Equal(t, 2, cancelOperations("env-a"))
+	assert.True(t, isOperationCancelled(first))
+	assert.True(t, isOperationCancelled(second))
+	assert.NoError(t, other.Err())
+	assert.False(t, isOperationCancelled(other))
+
+	doneFirst()
+	doneSecond()
+	assert.Equal(t, 0, cancelOperations("env-a"))
+
+	// Finished operations aren't reported as cancelled
+	third, doneThird := trackOperation(ctx, "env-a")
+	doneThird()
+	assert.Error(t, third.Err())
+	assert.False(t, isOperationCancelled(third))
+}
@@ -0,0 +1,89 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultProgressInterval is how often clients are told that a long running command is still going.
+const defaultProgressInterval = 30 * time.Second
+
+// startProgressReporter periodically sends MCP progress notifications while a tool call is running, so that
+// slow-but-working commands can be told apart from hung ones. Dagger doesn't stream the output of commands,
+// so the notifications report the elapsed time.
+// Nothing is sent if the client didn't ask for progress or interval is not positive.
+// The returned function stops the reporter and must be called once the operation is over.
+func startProgressReporter(ctx context.Context, request mcp.CallToolRequest, interval time.Duration, what string) func() {
+	if interval <= 0 || request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return func() {}
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return func() {}
+	}
+	token := request.Params.Meta.ProgressToken
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		start := time.Now()
+		progress := 0
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				progress++
+				elapsed := time.Since(start).Round(time.Second)
+				sendProgress(ctx, srv, token, progress, fmt.Sprintf("%s still running after %s", what, elapsed))
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// progressNotifier returns a function sending each message it receives as a progress notification,
+// or nil if the client didn't ask for progress.
+func progressNotifier(ctx context.Context, request mcp.CallToolRequest) environment.ProgressFunc {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return nil
+	}
+	token := request.Params.Meta.ProgressToken
+
+	var (
+		mu       sync.Mutex
+		progress int
+	)
+	return func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress++
+		sendProgress(ctx, srv, token, progress, message)
+	}
+}
+
+func sendProgress(ctx context.Context, srv *server.MCPServer, token mcp.ProgressToken, progress int, message string) {
+	if err := srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+		"progressToken": token,
+		"progress":      progress,
+		"message":       message,
+	}); err != nil {
+		slog.Warn("failed to send progress notification", "err", err)
+	}
+}
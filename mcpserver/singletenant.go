@@ -1,67 +1,108 @@
 // Package mcpserver provides single-tenant mode functionality for MCP servers.
 //
-// In single-tenant mode, a single MCP server process is assumed to serve only one
-// chat session. This allows for optimizations where environment_id parameters can
-// be omitted from most tools, with the server maintaining the current environment
-// in memory.
+// In single-tenant mode, each MCP session is assumed to be a single chat session.
+// This allows for optimizations where environment_id parameters can be omitted
+// from most tools, with the server maintaining the current environment of each
+// session in memory. A process can host several sessions, e.g. over HTTP.
 
 package mcpserver
 
 import (
+	"context"
 	"fmt"
 	"sync"
+
+	"github.com/mark3labs/mcp-go/server"
 )
 
+// sessionEnvironment is the current environment of a session in single-tenant mode.
+type sessionEnvironment struct {
+	id     string
+	source string
+}
+
 var (
-	// currentEnvironmentID stores the current environment ID for single-tenant mode
+	// currentEnvironments stores the current environment of each session for single-tenant mode, by session ID.
 	// This is per-server-process, not persisted to disk
-	currentEnvironmentID string
-	// currentEnvironmentSource stores the current environment source for single-tenant mode
-	// This is per-server-process, not persisted to disk
-	currentEnvironmentSource string
-	currentEnvMutex          sync.RWMutex
+	currentEnvironments = map[string]sessionEnvironment{}
+	currentEnvMutex     sync.RWMutex
 )
 
-// getCurrentEnvironmentID returns the current environment ID for single-tenant mode
-func getCurrentEnvironmentID() (string, error) {
+// sessionID returns the ID of the MCP session of a tool call, or "" for calls made outside of a session.
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
+
+// currentEnvironment returns the current environment of the session of ctx. Calls made outside of a session
+// use the environment of the only session, as when a process served a single session.
+func currentEnvironment(ctx context.Context) sessionEnvironment {
 	currentEnvMutex.RLock()
 	defer currentEnvMutex.RUnlock()
 
-	if currentEnvironmentID == "" {
-		return "", fmt.Errorf("no current environment set. Use environment_create or environment_open first")
+	id := sessionID(ctx)
+	if env, ok := currentEnvironments[id]; ok || id != "" || len(currentEnvironments) != 1 {
+		return env
+	}
+	for _, env := range currentEnvironments {
+		return env
 	}
-	return currentEnvironmentID, nil
+	return sessionEnvironment{}
 }
 
-// getCurrentEnvironmentSource returns the current environment source for single-tenant mode
-func getCurrentEnvironmentSource() (string, error) {
-	currentEnvMutex.RLock()
-	defer currentEnvMutex.RUnlock()
+// getCurrentEnvironmentID returns the current environment ID of the session for single-tenant mode
+func getCurrentEnvironmentID(ctx context.Context) (string, error) {
+	env := currentEnvironment(ctx)
+	if env.id == "" {
+		return "", fmt.Errorf("no current environment set. Use environment_create or environment_open first")
+	}
+	return env.id, nil
+}
 
-	if currentEnvironmentSource == "" {
+// getCurrentEnvironmentSource returns the current environment source of the session for single-tenant mode
+func getCurrentEnvironmentSource(ctx context.Context) (string, error) {
+	env := currentEnvironment(ctx)
+	if env.source == "" {
 		return "", fmt.Errorf("no current environment set. Use environment_create or environment_open first")
 	}
-	return currentEnvironmentSource, nil
+	return env.source, nil
 }
 
-// setCurrentEnvironmentID sets the current environment ID for single-tenant mode
-func setCurrentEnvironmentID(envID string) {
+// updateCurrentEnvironment applies update to the current environment of the session.
+func updateCurrentEnvironment(ctx context.Context, update func(env *sessionEnvironment)) {
 	currentEnvMutex.Lock()
 	defer currentEnvMutex.Unlock()
-	currentEnvironmentID = envID
+
+	id := sessionID(ctx)
+	env := currentEnvironments[id]
+	update(&env)
+	if env == (sessionEnvironment{}) {
+		delete(currentEnvironments, id)
+		return
+	}
+	currentEnvironments[id] = env
 }
 
-// setCurrentEnvironmentSource sets the current environment source for single-tenant mode
-func setCurrentEnvironmentSource(envSource string) {
-	currentEnvMutex.Lock()
-	defer currentEnvMutex.Unlock()
-	currentEnvironmentSource = envSource
+// setCurrentEnvironmentID sets the current environment ID of the session for single-tenant mode
+func setCurrentEnvironmentID(ctx context.Context, envID string) {
+	updateCurrentEnvironment(ctx, func(env *sessionEnvironment) { env.id = envID })
+}
+
+// setCurrentEnvironmentSource sets the current environment source of the session for single-tenant mode
+func setCurrentEnvironmentSource(ctx context.Context, envSource string) {
+	updateCurrentEnvironment(ctx, func(env *sessionEnvironment) { env.source = envSource })
+}
+
+// setCurrentEnvironment sets both the current environment ID and source of the session for single-tenant mode
+func setCurrentEnvironment(ctx context.Context, envID, envSource string) {
+	updateCurrentEnvironment(ctx, func(env *sessionEnvironment) { env.id, env.source = envID, envSource })
 }
 
-// setCurrentEnvironment sets both the current environment ID and source for single-tenant mode
-func setCurrentEnvironment(envID, envSource string) {
+// forgetSession drops the current environment of a session once it is closed.
+func forgetSession(id string) {
 	currentEnvMutex.Lock()
 	defer currentEnvMutex.Unlock()
-	currentEnvironmentID = envID
-	currentEnvironmentSource = envSource
+	delete(currentEnvironments, id)
 }
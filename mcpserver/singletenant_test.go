@@ -1,19 +1,25 @@
 package mcpserver
 
 import (
+	"context"
 	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 func TestSingleTenantEnvironmentStorage(t *testing.T) {
+	ctx := context.Background()
+
 	// Test setting and getting environment ID
 	testEnvID := "test-env-id"
 	testEnvSource := "/test/source/path"
 
 	// Test individual setters and getters
-	setCurrentEnvironmentID(testEnvID)
-	setCurrentEnvironmentSource(testEnvSource)
+	setCurrentEnvironmentID(ctx, testEnvID)
+	setCurrentEnvironmentSource(ctx, testEnvSource)
 
-	retrievedID, err := getCurrentEnvironmentID()
+	retrievedID, err := getCurrentEnvironmentID(ctx)
 	if err != nil {
 		t.Fatalf("Expected no error getting environment ID, got: %v", err)
 	}
@@ -21,7 +27,7 @@ func TestSingleTenantEnvironmentStorage(t *testing.T) {
 		t.Fatalf("Expected environment ID %s, got: %s", testEnvID, retrievedID)
 	}
 
-	retrievedSource, err := getCurrentEnvironmentSource()
+	retrievedSource, err := getCurrentEnvironmentSource(ctx)
 	if err != nil {
 		t.Fatalf("Expected no error getting environment source, got: %v", err)
 	}
@@ -32,9 +38,9 @@ func TestSingleTenantEnvironmentStorage(t *testing.T) {
 	// Test combined setter
 	newEnvID := "new-env-id"
 	newEnvSource := "/new/source/path"
-	setCurrentEnvironment(newEnvID, newEnvSource)
+	setCurrentEnvironment(ctx, newEnvID, newEnvSource)
 
-	retrievedID, err = getCurrentEnvironmentID()
+	retrievedID, err = getCurrentEnvironmentID(ctx)
 	if err != nil {
 		t.Fatalf("Expected no error getting environment ID after combined set, got: %v", err)
 	}
@@ -42,7 +48,7 @@ func TestSingleTenantEnvironmentStorage(t *testing.T) {
 		t.Fatalf("Expected environment ID %s after combined set, got: %s", newEnvID, retrievedID)
 	}
 
-	retrievedSource, err = getCurrentEnvironmentSource()
+	retrievedSource, err = getCurrentEnvironmentSource(ctx)
 	if err != nil {
 		t.Fatalf("Expected no error getting environment source after combined set, got: %v", err)
 	}
@@ -51,21 +57,70 @@ func TestSingleTenantEnvironmentStorage(t *testing.T) {
 	}
 
 	// Clear state for other tests
-	setCurrentEnvironment("", "")
+	setCurrentEnvironment(ctx, "", "")
 }
 
 func TestSingleTenantEnvironmentStorageEmpty(t *testing.T) {
+	ctx := context.Background()
+
 	// Clear state
-	setCurrentEnvironment("", "")
+	setCurrentEnvironment(ctx, "", "")
 
 	// Test error when no environment is set
-	_, err := getCurrentEnvironmentID()
+	_, err := getCurrentEnvironmentID(ctx)
 	if err == nil {
 		t.Fatal("Expected error when no environment ID is set")
 	}
 
-	_, err = getCurrentEnvironmentSource()
+	_, err = getCurrentEnvironmentSource(ctx)
 	if err == nil {
 		t.Fatal("Expected error when no environment source is set")
 	}
 }
+
+// testSession is an MCP session with a given ID.
+type testSession struct {
+	id string
+}
+
+func (s testSession) Initialize()                                         {}
+func (s testSession) Initialized() bool                                   { return true }
+func (s testSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return nil }
+func (s testSession) SessionID() string                                   { return s.id }
+
+func TestSingleTenantEnvironmentStoragePerSession(t *testing.T) {
+	s := server.NewMCPServer("test", "1.0.0")
+	first := s.WithContext(context.Background(), testSession{id: "first"})
+	second := s.WithContext(context.Background(), testSession{id: "second"})
+	t.Cleanup(func() {
+		forgetSession("first")
+		forgetSession("second")
+	})
+
+	setCurrentEnvironment(first, "first-env", "/first")
+
+	// Calls outside of a session use the only session's environment
+	if id, err := getCurrentEnvironmentID(context.Background()); err != nil || id != "first-env" {
+		t.Fatalf("Expected the only session's environment first-env, got: %q, %v", id, err)
+	}
+	// Other sessions don't
+	if _, err := getCurrentEnvironmentID(second); err == nil {
+		t.Fatal("Expected error when the session has no environment")
+	}
+
+	setCurrentEnvironment(second, "second-env", "/second")
+	if id, _ := getCurrentEnvironmentID(first); id != "first-env" {
+		t.Fatalf("Expected first-env for the first session, got: %s", id)
+	}
+	if source, _ := getCurrentEnvironmentSource(second); source != "/second" {
+		t.Fatalf("Expected /second for the second session, got: %s", source)
+	}
+	if _, err := getCurrentEnvironmentID(context.Background()); err == nil {
+		t.Fatal("Expected error outside of a session when several sessions exist")
+	}
+
+	forgetSession("first")
+	if _, err := getCurrentEnvironmentID(first); err == nil {
+		t.Fatal("Expected error once the session is closed")
+	}
+}
@@ -0,0 +1,53 @@
+package mcpserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+)
+
+// stopRequestPollInterval is how often the server checks whether the CLI asked to stop a background command or a
+// service it runs.
+const stopRequestPollInterval = time.Second
+
+// startStopRequestWatcher stops the background commands and services the CLI asks to stop (see
+// repository.RequestStop) until ctx is done.
+func startStopRequestWatcher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(stopRequestPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stopRequestedServices(ctx)
+			}
+		}
+	}()
+}
+
+// stopRequestedServices stops the background commands and services the CLI asked to stop, then clears the requests
+// it acted on so that they don't pile up.
+func stopRequestedServices(ctx context.Context) {
+	type stopRequest struct{ envID, handle string }
+	stopped := []stopRequest{}
+	err := environment.StopRequestedServices(ctx, func(envID, handle string, started time.Time) bool {
+		if !repository.StopRequested(envID, handle, started) {
+			return false
+		}
+		stopped = append(stopped, stopRequest{envID, handle})
+		return true
+	})
+	if err != nil {
+		slog.Warn("Failed to stop services on request", "err", err)
+	}
+	for _, req := range stopped {
+		if err := repository.ClearStopRequest(req.envID, req.handle); err != nil {
+			slog.Warn("Failed to clear stop request", "id", req.envID, "handle", req.handle, "err", err)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package mcpserver
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
+	"math"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
@@ -36,7 +44,7 @@ func openRepository(ctx context.Context, request mcp.CallToolRequest) (*reposito
 		// In single-tenant mode, try to get from stored value first
 		source = request.GetString("environment_source", "")
 		if source == "" {
-			source, err = getCurrentEnvironmentSource()
+			source, err = getCurrentEnvironmentSource(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -62,27 +70,9 @@ func openEnvironment(ctx context.Context, request mcp.CallToolRequest) (*reposit
 		return nil, nil, err
 	}
 
-	// Check if we're in single-tenant mode
-	singleTenant, _ := ctx.Value(singleTenantKey{}).(bool)
-
-	var envID string
-	if singleTenant {
-		// in single-tenant mode, environment_open requests will have environment_id. all other env-scoped tools will have "".
-		envID = request.GetString("environment_id", "")
-		if envID == "" {
-			currentEnvID, err := getCurrentEnvironmentID()
-			if err != nil {
-				return nil, nil, err
-			}
-			envID = currentEnvID
-		}
-	} else {
-		// In multi-tenant mode, environment_id is required
-		var err error
-		envID, err = request.RequireString("environment_id")
-		if err != nil {
-			return nil, nil, err
-		}
+	envID, err := requestEnvironmentID(ctx, request)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
@@ -96,23 +86,96 @@ func openEnvironment(ctx context.Context, request mcp.CallToolRequest) (*reposit
 	return repo, env, nil
 }
 
+// openMutableEnvironment opens the environment of a tool call that modifies it, which fails for locked environments.
+func openMutableEnvironment(ctx context.Context, request mcp.CallToolRequest) (*repository.Repository, *environment.Environment, error) {
+	repo, env, err := openEnvironment(ctx, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := repository.CheckUnlocked(env.EnvironmentInfo); err != nil {
+		return nil, nil, err
+	}
+	return repo, env, nil
+}
+
+// requestEnvironmentID returns the ID of the environment targeted by an env-scoped tool call.
+func requestEnvironmentID(ctx context.Context, request mcp.CallToolRequest) (string, error) {
+	// Check if we're in single-tenant mode
+	singleTenant, _ := ctx.Value(singleTenantKey{}).(bool)
+
+	if singleTenant {
+		// in single-tenant mode, environment_open requests will have environment_id. all other env-scoped tools will have "".
+		if envID := request.GetString("environment_id", ""); envID != "" {
+			return envID, nil
+		}
+		return getCurrentEnvironmentID(ctx)
+	}
+	// In multi-tenant mode, environment_id is required
+	return request.RequireString("environment_id")
+}
+
 type Tool struct {
 	Definition mcp.Tool
 	Handler    server.ToolHandlerFunc
 }
 
-func RunStdioServer(ctx context.Context, dag *dagger.Client, singleTenant bool) error {
-	// Store single-tenant mode in context for tool handlers
-	ctx = context.WithValue(ctx, singleTenantKey{}, singleTenant)
+// ServerOptions configures the MCP server.
+type ServerOptions struct {
+	// SingleTenant makes the environment ID optional, assuming one session per server.
+	SingleTenant bool
+	// EnabledTools restricts the registered tools to the given names. All tools are registered when empty.
+	EnabledTools []string
+	// DisabledTools are never registered, even when listed in EnabledTools.
+	DisabledTools []string
+	// Instructions are appended to the default agent rules sent to clients, e.g. team or repository specific guidance.
+	Instructions string
+	// ReplaceInstructions makes Instructions replace the default agent rules instead of being appended to them.
+	ReplaceInstructions bool
+	// AutoPrune deletes, when the server starts, the environments of the working directory's repository
+	// that haven't been updated for this long. Disabled when zero.
+	AutoPrune time.Duration
+	// AutoPruneInterval repeats the auto-prune pass at this interval. Only at startup when zero.
+	AutoPruneInterval time.Duration
+	// AllowedCommands, if set, are the only commands agents run, with environment_run_cmd, as setup commands or as
+	// services: command prefixes (e.g. "go test"), or regular expressions between slashes (e.g. "/npm (ci|test)/")
+	// matching whole commands. Agents then can't choose the images or environment variables commands run with.
+	AllowedCommands []string
+}
 
-	s := server.NewMCPServer(
-		"Dagger",
-		"1.0.0",
-		server.WithInstructions(rules.AgentRules),
-	)
+// serverInstructions merges the custom instructions with the default agent rules.
+func serverInstructions(opts ServerOptions) string {
+	custom := strings.TrimSpace(opts.Instructions)
+	switch {
+	case custom == "":
+		return rules.AgentRules
+	case opts.ReplaceInstructions:
+		return custom + "\n"
+	default:
+		return strings.TrimRight(rules.AgentRules, "\n") + "\n\n## Additional Instructions\n\n" + custom + "\n"
+	}
+}
+
+// ReadOnlyTools are the tools that inspect environments without modifying them.
+var ReadOnlyTools = []string{
+	"environment_open",
+	"environment_list",
+	"environment_file_read",
+	"environment_file_list",
+	"environment_glob",
+	"environment_grep",
+	"environment_export_files",
+	"environment_list_services",
+	"environment_service_logs",
+	"environment_resources",
+}
+
+func RunStdioServer(ctx context.Context, dag *dagger.Client, opts ServerOptions) error {
+	// Store single-tenant mode in context for tool handlers
+	ctx = context.WithValue(ctx, singleTenantKey{}, opts.SingleTenant)
 
-	for _, t := range createTools(singleTenant) {
-		s.AddTool(t.Definition, wrapToolWithClient(t, dag, singleTenant).Handler)
+	s, err := newMCPServer(dag, opts)
+	if err != nil {
+		return err
 	}
 
 	slog.Info("starting server")
@@ -123,13 +186,46 @@ func RunStdioServer(ctx context.Context, dag *dagger.Client, singleTenant bool)
 	ctx, cancel := signal.NotifyContext(ctx, getNotifySignals()...)
 	defer cancel()
 
-	err := stdioSrv.Listen(ctx, os.Stdin, os.Stdout)
+	startAutoPrune(ctx, opts.AutoPrune, opts.AutoPruneInterval)
+	startStopRequestWatcher(ctx)
+
+	err = stdioSrv.Listen(ctx, os.Stdin, os.Stdout)
 	if err != nil && !errors.Is(err, context.Canceled) {
 		return err
 	}
 	return nil
 }
 
+// newMCPServer creates an MCP server with the tools enabled by opts, independently of the transport.
+func newMCPServer(dag *dagger.Client, opts ServerOptions) (*server.MCPServer, error) {
+	tools, err := filterTools(createTools(opts.SingleTenant), opts.EnabledTools, opts.DisabledTools)
+	if err != nil {
+		return nil, err
+	}
+	allowlist, err := newCommandAllowlist(opts.AllowedCommands)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions don't share their current environment in single-tenant mode, see singletenant.go
+	hooks := &server.Hooks{}
+	hooks.AddOnUnregisterSession(func(_ context.Context, session server.ClientSession) {
+		forgetSession(session.SessionID())
+	})
+
+	s := server.NewMCPServer(
+		"Dagger",
+		"1.0.0",
+		server.WithInstructions(serverInstructions(opts)),
+		server.WithHooks(hooks),
+	)
+
+	for _, t := range tools {
+		s.AddTool(t.Definition, wrapToolWithClient(t, dag, opts.SingleTenant, allowlist).Handler)
+	}
+	return s, nil
+}
+
 func createTools(singleTenant bool) []*Tool {
 	return []*Tool{
 		wrapTool(createEnvironmentOpenTool()),
@@ -140,11 +236,22 @@ func createTools(singleTenant bool) []*Tool {
 		wrapTool(createEnvironmentRunCmdTool(singleTenant)),
 		wrapTool(createEnvironmentFileReadTool(singleTenant)),
 		wrapTool(createEnvironmentFileListTool(singleTenant)),
+		wrapTool(createEnvironmentGlobTool(singleTenant)),
+		wrapTool(createEnvironmentGrepTool(singleTenant)),
+		wrapTool(createEnvironmentExportFilesTool(singleTenant)),
 		wrapTool(createEnvironmentFileWriteTool(singleTenant)),
 		wrapTool(createEnvironmentFileEditTool(singleTenant)),
 		wrapTool(createEnvironmentFileDeleteTool(singleTenant)),
+		wrapTool(createEnvironmentFileMoveTool(singleTenant)),
+		wrapTool(createEnvironmentFileCopyTool(singleTenant)),
 		wrapTool(createEnvironmentAddServiceTool(singleTenant)),
+		wrapTool(createEnvironmentRemoveServiceTool(singleTenant)),
+		wrapTool(createEnvironmentListServicesTool(singleTenant)),
+		wrapTool(createEnvironmentServiceLogsTool(singleTenant)),
+		wrapTool(createEnvironmentResourcesTool(singleTenant)),
 		wrapTool(createEnvironmentCheckpointTool(singleTenant)),
+		wrapTool(createEnvironmentCancelTool(singleTenant)),
+		wrapTool(createEnvironmentStopTool(singleTenant)),
 	}
 }
 
@@ -152,6 +259,36 @@ func Tools() []*Tool {
 	return createTools(false) // Default to multi-tenant mode when called outside of RunStdioServer
 }
 
+// filterTools keeps the tools listed in enabled (all of them if empty) minus the ones listed in disabled.
+// Unknown tool names are rejected so that typos don't silently expose or hide tools.
+func filterTools(tools []*Tool, enabled, disabled []string) ([]*Tool, error) {
+	known := map[string]bool{}
+	for _, t := range tools {
+		known[t.Definition.Name] = true
+	}
+	for _, name := range slices.Concat(enabled, disabled) {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown tool %q, valid tools are: %s", name, strings.Join(slices.Sorted(maps.Keys(known)), ", "))
+		}
+	}
+
+	filtered := []*Tool{}
+	for _, t := range tools {
+		name := t.Definition.Name
+		if len(enabled) > 0 && !slices.Contains(enabled, name) {
+			continue
+		}
+		if slices.Contains(disabled, name) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	if len(filtered) == 0 {
+		return nil, errors.New("all tools are disabled")
+	}
+	return filtered, nil
+}
+
 func wrapTool(tool *Tool) *Tool {
 	return &Tool{
 		Definition: tool.Definition,
@@ -169,18 +306,91 @@ func wrapTool(tool *Tool) *Tool {
 	}
 }
 
+// untrackedTools are not scoped to an existing environment and therefore can't be cancelled.
+var untrackedTools = map[string]bool{
+	"environment_create": true,
+	"environment_list":   true,
+	"environment_cancel": true,
+}
+
 // keeping this modular for now. we could move tool registration to RunStdioServer and collapse the 2 wrapTool functions.
-func wrapToolWithClient(tool *Tool, dag *dagger.Client, singleTenant bool) *Tool {
+func wrapToolWithClient(tool *Tool, dag *dagger.Client, singleTenant bool, allowlist *commandAllowlist) *Tool {
 	return &Tool{
 		Definition: tool.Definition,
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			ctx = context.WithValue(ctx, daggerClientKey{}, dag)
 			ctx = context.WithValue(ctx, singleTenantKey{}, singleTenant)
-			return tool.Handler(ctx, request)
+			ctx = context.WithValue(ctx, commandAllowlistKey{}, allowlist)
+			// Commits made by the tool call are annotated with it, see container-use log --notes
+			ctx = repository.WithToolCall(ctx, repository.ToolCall{
+				Tool:      tool.Definition.Name,
+				Arguments: summarizeArguments(request.GetArguments()),
+				StartedAt: time.Now(),
+			})
+			if untrackedTools[tool.Definition.Name] {
+				return tool.Handler(ctx, request)
+			}
+
+			// Track env-scoped calls so that they can be cancelled with environment_cancel
+			envID, err := requestEnvironmentID(ctx, request)
+			if err != nil {
+				return tool.Handler(ctx, request)
+			}
+			ctx, done := trackOperation(ctx, envID)
+			defer done()
+
+			result, err := tool.Handler(ctx, request)
+			if isOperationCancelled(ctx) && (err != nil || (result != nil && result.IsError)) {
+				return mcp.NewToolResultError(fmt.Sprintf("Operation cancelled in environment %s.", envID)), nil
+			}
+			return result, err
 		},
 	}
 }
 
+// maxArgumentSummary is the length beyond which argument values are truncated in commit metadata.
+const maxArgumentSummary = 200
+
+// summarizeArguments renders the arguments of a tool call for the commit metadata. File contents and the like are
+// truncated, and sensitive values masked: the metadata is stored in the repository. The environment ID and the
+// explanation are already recorded by the commit itself.
+func summarizeArguments(args map[string]any) map[string]string {
+	summary := map[string]string{}
+	for name, value := range args {
+		if name == "environment_id" || name == "explanation" {
+			continue
+		}
+		var str string
+		switch value := value.(type) {
+		case string:
+			str = value
+			if environment.IsSensitiveKey(name) {
+				str = environment.MaskedValue
+			}
+		case []any:
+			var encoded []byte
+			if list, err := stringList(name, value); err == nil {
+				// e.g. service variables, "API_TOKEN=..."
+				encoded, _ = json.Marshal(environment.KVList(list).Masked())
+			} else {
+				encoded, _ = json.Marshal(value)
+			}
+			str = string(encoded)
+		case map[string]any:
+			// Configurations may hold variable values: only record the fields set
+			str = "{" + strings.Join(slices.Sorted(maps.Keys(value)), ", ") + "}"
+		default:
+			encoded, _ := json.Marshal(value)
+			str = string(encoded)
+		}
+		if len(str) > maxArgumentSummary {
+			str = strings.ToValidUTF8(str[:maxArgumentSummary], "") + fmt.Sprintf("... (%d bytes)", len(str))
+		}
+		summary[name] = str
+	}
+	return summary
+}
+
 type EnvironmentResponse struct {
 	ID              string                         `json:"id"`
 	Title           string                         `json:"title"`
@@ -189,22 +399,47 @@ type EnvironmentResponse struct {
 	CheckoutCommand string                         `json:"checkout_command_to_share_with_user"`
 	LogCommand      string                         `json:"log_command_to_share_with_user"`
 	DiffCommand     string                         `json:"diff_command_to_share_with_user"`
+	DiffStatCommand string                         `json:"diff_stat_command_to_share_with_user,omitempty"`
 	Services        []*environment.Service         `json:"services,omitempty"`
+	// Scratch environments have no branch: the ref and commands are empty.
+	Scratch bool `json:"scratch,omitempty"`
+
+	BackgroundCommands []*environment.BackgroundCommand `json:"background_commands,omitempty"`
+	// BuildStatus is the outcome of the last build or test command recorded with build_status.
+	BuildStatus *environment.BuildStatus `json:"build_status,omitempty"`
 }
 
 func environmentResponseFromEnvInfo(envInfo *environment.EnvironmentInfo) *EnvironmentResponse {
+	if envInfo.State.Scratch {
+		return &EnvironmentResponse{
+			ID:      envInfo.ID,
+			Title:   envInfo.State.Title,
+			Config:  envInfo.State.Config.Masked(),
+			Scratch: true,
+
+			BackgroundCommands: envInfo.State.BackgroundCommands,
+			BuildStatus:        envInfo.State.BuildStatus,
+		}
+	}
 	return &EnvironmentResponse{
 		ID:              envInfo.ID,
 		Title:           envInfo.State.Title,
-		Config:          envInfo.State.Config,
+		Config:          envInfo.State.Config.Masked(),
 		RemoteRef:       fmt.Sprintf("container-use/%s", envInfo.ID),
 		CheckoutCommand: fmt.Sprintf("container-use checkout %s", envInfo.ID),
 		LogCommand:      fmt.Sprintf("container-use log %s", envInfo.ID),
 		DiffCommand:     fmt.Sprintf("container-use diff %s", envInfo.ID),
+		DiffStatCommand: fmt.Sprintf("container-use diff --stat %s", envInfo.ID),
 		Services:        nil, // EnvironmentInfo doesn't have "active" services, specifically useful for EndpointMappings
+
+		BackgroundCommands: envInfo.State.BackgroundCommands,
+		BuildStatus:        envInfo.State.BuildStatus,
 	}
 }
 
+// scratchNote warns that the changes to a scratch environment are not persisted.
+const scratchNote = "WARNING: this is a scratch environment: changes are NOT committed and are lost when the environment is deleted or the server exits."
+
 func environmentResponseFromEnv(env *environment.Environment) *EnvironmentResponse {
 	resp := environmentResponseFromEnvInfo(env.EnvironmentInfo)
 	resp.Services = env.Services
@@ -261,7 +496,7 @@ func createEnvironmentOpenTool() *Tool {
 			// In single-tenant mode, set this as the current environment
 			if singleTenantMode, _ := ctx.Value(singleTenantKey{}).(bool); singleTenantMode {
 				source, _ := request.RequireString("environment_source")
-				setCurrentEnvironment(env.ID, source)
+				setCurrentEnvironment(ctx, env.ID, source)
 			}
 
 			return EnvironmentToCallResult(env)
@@ -279,6 +514,16 @@ func createEnvironmentCreateTool(singleTenant bool) *Tool {
 		mcp.WithString("from_git_ref",
 			mcp.Description("Git reference to create the environment from (e.g., HEAD, main, feature-branch, SHA). Defaults to HEAD if not specified."),
 		),
+		mcp.WithObject("variables",
+			mcp.Description("Values of the template variables referenced as `${NAME}` in the environment configuration (e.g. `{\"PY_VERSION\": \"3.12\"}` for a `python:${PY_VERSION}` base image). They take precedence over the defaults of the configuration. Creation fails if a referenced variable has no value: use `$${NAME}` for a literal `${NAME}`, e.g. `$${PATH}` for the container to expand."),
+			mcp.AdditionalProperties(map[string]any{"type": "string"}),
+		),
+		mcp.WithBoolean("scratch",
+			mcp.Description("Create a throwaway scratch environment for quick experiments: commands can be run and files read and written, but nothing is committed, it has no branch to checkout, merge or share, and it is lost when the server exits. Use a regular environment for any work the user should get back."),
+		),
+		mcp.WithString("idempotency_key",
+			mcp.Description("Unique key of this creation request, e.g. a UUID. If a request with the same key created an environment in the last 24 hours, that environment is returned instead of creating a new one, so the request can be safely retried after a timeout or a lost response."),
+		),
 	}
 
 	// Add allow_replace parameter only in single-tenant mode
@@ -306,40 +551,75 @@ Environment configuration is managed by the user via cu config commands.`,
 				return nil, err
 			}
 
-			// In single-tenant mode, check allow_replace before creating environment
-			if singleTenantMode, _ := ctx.Value(singleTenantKey{}).(bool); singleTenantMode {
-				allowReplace := request.GetBool("allow_replace", false) // Default false to prevent accidental environment replacement
+			dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
+			if !ok {
+				return nil, fmt.Errorf("dagger client not found in context")
+			}
 
-				if !allowReplace {
-					// Check if environment already exists
-					if currentEnvID, err := getCurrentEnvironmentID(); err == nil {
-						// Environment exists, return error with info about existing env
-						return nil, fmt.Errorf("environment_id %s already exists for this session. Tools can be used directly. You can environment_open %s for more information, or set allow_replace=true to destructively replace it", currentEnvID, currentEnvID)
-					}
+			var variables map[string]string
+			if value, ok := request.GetArguments()["variables"]; ok {
+				if variables, err = stringMap("variables", value); err != nil {
+					return nil, err
 				}
 			}
 
-			dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
-			if !ok {
-				return nil, fmt.Errorf("dagger client not found in context")
+			create := func() (*environment.Environment, error) {
+				// In single-tenant mode, check allow_replace before creating environment
+				if singleTenantMode, _ := ctx.Value(singleTenantKey{}).(bool); singleTenantMode {
+					allowReplace := request.GetBool("allow_replace", false) // Default false to prevent accidental environment replacement
+
+					if !allowReplace {
+						// Check if environment already exists
+						if currentEnvID, err := getCurrentEnvironmentID(ctx); err == nil {
+							// Environment exists, return error with info about existing env
+							return nil, fmt.Errorf("environment_id %s already exists for this session. Tools can be used directly. You can environment_open %s for more information, or set allow_replace=true to destructively replace it", currentEnvID, currentEnvID)
+						}
+					}
+				}
+
+				gitRef := request.GetString("from_git_ref", "HEAD")
+				createOpts := repository.CreateOpts{
+					Progress:  progressNotifier(ctx, request),
+					Variables: variables,
+				}
+				var env *environment.Environment
+				var err error
+				if request.GetBool("scratch", false) {
+					env, err = repo.CreateScratch(ctx, dag, title, gitRef, createOpts)
+				} else {
+					env, err = repo.CreateWithOpts(ctx, dag, title, request.GetString("explanation", ""), gitRef, createOpts)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to create environment: %w", err)
+				}
+				return env, nil
 			}
 
-			gitRef := request.GetString("from_git_ref", "HEAD")
-			env, err := repo.Create(ctx, dag, title, request.GetString("explanation", ""), gitRef)
+			// A retried request gets the environment created by the first one, rather than a new one
+			var env *environment.Environment
+			existed := false
+			if key := request.GetString("idempotency_key", ""); key != "" {
+				env, existed, err = repo.CreateIdempotent(ctx, dag, key, create)
+			} else {
+				env, err = create()
+			}
 			if err != nil {
-				return nil, fmt.Errorf("failed to create environment: %w", err)
+				return nil, err
 			}
 
 			// In single-tenant mode, set this as the current environment
 			if singleTenantMode, _ := ctx.Value(singleTenantKey{}).(bool); singleTenantMode {
 				source, _ := request.RequireString("environment_source")
-				setCurrentEnvironment(env.ID, source)
+				setCurrentEnvironment(ctx, env.ID, source)
 			}
 
 			out, err := marshalEnvironment(env)
 			if err != nil {
 				return nil, fmt.Errorf("failed to marshal environment: %w", err)
 			}
+			if existed {
+				out += "\n\nThis environment was created by a previous request with the same idempotency_key: no new environment was created."
+			}
 
 			dirty, status, err := repo.IsDirty(ctx)
 			if err != nil {
@@ -375,7 +655,7 @@ func createEnvironmentUpdateMetadataTool(singleTenant bool) *Tool {
 			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -415,48 +695,78 @@ func createEnvironmentConfigTool(singleTenant bool) *Tool {
 						"type":        "string",
 						"description": "Base image for the environment",
 					},
+					"pin_base_image": map[string]any{
+						"type":        "boolean",
+						"description": "Pin the base image to the digest its tag resolves to when the environment is built, so that rebuilds use the same image even if the tag moves. Changing base_image pins the new image. Set to false to unpin it.",
+					},
 					"setup_commands": map[string]any{
 						"type":        "array",
 						"description": "Commands that should be executed on top of the base image to set up the environment. Similar to `RUN` instructions in Dockerfiles.",
 						"items":       map[string]any{"type": "string"},
 					},
+					"setup_stages": map[string]any{
+						"type":        "array",
+						"description": "Named groups of setup commands run in order after setup_commands, e.g. OS packages, then language dependencies, then project tooling. Each stage is cached on its own: changing a stage only reruns it and the following ones, so put the stages that change the most last. Replaces the previous stages.",
+						"items": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"name":        map[string]any{"type": "string", "description": "Name of the stage"},
+								"commands":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Commands of the stage"},
+								"cache_key":   map[string]any{"type": "string", "description": "Change it to rerun the stage without changing its commands, e.g. to pick up new package versions"},
+								"no_cache":    map[string]any{"type": "boolean", "description": "Rerun the stage (and the following ones) every time the environment is built"},
+								"cache_paths": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Directories such as package manager caches (`/var/cache/apt`, `/root/.cache/pip`) kept across runs of the stage, so reruns reuse downloads. They are not part of the environment."},
+							},
+							"required": []string{"name", "commands"},
+						},
+					},
+					"path_prepend": map[string]any{
+						"type":        "array",
+						"description": "Directories added in front of PATH for every command, e.g. where setup commands install tools (`[\"/opt/tools/bin\"]`). Avoids having to export PATH in every command.",
+						"items":       map[string]any{"type": "string"},
+					},
 					"envs": map[string]any{
 						"type":        "array",
-						"description": "The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`).",
+						"description": "The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`). Values of sensitive variables are shown as `***`: pass them back as is to keep their current value.",
 						"items":       map[string]any{"type": "string"},
 					},
+					"git_config": map[string]any{
+						"type":                 "object",
+						"description":          "Git configuration applied to every git command in the environment (e.g. `{\"user.name\": \"Agent\", \"safe.directory\": \"*\"}`). Replaces the previous git configuration.",
+						"additionalProperties": map[string]any{"type": "string"},
+					},
+					"volumes": map[string]any{
+						"type":        "array",
+						"description": "Named volumes shared between environments, e.g. a large dataset or a build cache (`[{\"name\": \"datasets\", \"path\": \"/data\"}]`). Every environment mounting a volume of the same name sees the same files, which are never committed. Writes of one environment are immediately visible to the others and nothing coordinates them: use `\"sharing\": \"locked\"` for volumes that several environments write to, so that their commands use it one at a time. Replaces the previous volumes.",
+						"items": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"name":    map[string]any{"type": "string", "description": "Name of the volume, shared by all environments"},
+								"path":    map[string]any{"type": "string", "description": "Absolute path where the volume is mounted, outside the workdir"},
+								"sharing": map[string]any{"type": "string", "enum": []string{environment.VolumeSharingShared, environment.VolumeSharingLocked}},
+							},
+							"required": []string{"name", "path"},
+						},
+					},
+					"variables": map[string]any{
+						"type":                 "object",
+						"description":          "Values of the template variables referenced as `${NAME}` in the other fields, except commands (e.g. `{\"PY_VERSION\": \"3.12\"}` with `\"base_image\": \"python:${PY_VERSION}\"`). Every referenced variable must have a value. Use `$${NAME}` for a literal `${NAME}`, e.g. `$${PATH}` for the container to expand.",
+						"additionalProperties": map[string]any{"type": "string"},
+					},
 				}),
 			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
 
-			updatedConfig := env.State.Config.Copy()
-
-			newConfig, ok := request.GetArguments()["config"].(map[string]any)
-			if !ok {
-				return nil, errors.New("invalid config")
-			}
-
-			if baseImage, ok := newConfig["base_image"].(string); ok {
-				updatedConfig.BaseImage = baseImage
-			}
-
-			if setupCommands, ok := newConfig["setup_commands"].([]any); ok {
-				updatedConfig.SetupCommands = make([]string, len(setupCommands))
-				for i, command := range setupCommands {
-					updatedConfig.SetupCommands[i] = command.(string)
-				}
+			updatedConfig, err := configFromArguments(env.State.Config, request.GetArguments()["config"])
+			if err != nil {
+				return nil, err
 			}
-
-			if envs, ok := newConfig["envs"].([]any); ok {
-				updatedConfig.Env = make([]string, len(envs))
-				for i, env := range envs {
-					updatedConfig.Env[i] = env.(string)
-				}
+			if err := checkAllowedConfig(ctx, env.State.Config, updatedConfig); err != nil {
+				return nil, err
 			}
 
 			if err := env.UpdateConfig(ctx, updatedConfig); err != nil {
@@ -475,15 +785,264 @@ func createEnvironmentConfigTool(singleTenant bool) *Tool {
 			message := fmt.Sprintf(`SUCCESS: Configuration successfully applied. Environment has been restarted, all previous commands have been lost.
 IMPORTANT: The configuration changes are LOCAL to this environment.
 TELL THE USER: To make these changes persistent, they will have to run "cu config import %s"
+To discard them, they can run "cu config reset %s"
 
 %s
-`, env.ID, out)
+`, env.ID, env.ID, out)
 
 			return mcp.NewToolResultText(message), nil
 		},
 	}
 }
 
+// configFromArguments returns a copy of config updated with the `config` argument of environment_config.
+// Malformed input is reported with the offending field rather than ignored.
+func configFromArguments(config *environment.EnvironmentConfig, arg any) (*environment.EnvironmentConfig, error) {
+	newConfig, ok := arg.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid config: must be an object")
+	}
+
+	updatedConfig := config.Copy()
+
+	if value, ok := newConfig["base_image"]; ok {
+		baseImage, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid config: base_image must be a string, got %T", value)
+		}
+		updatedConfig.SetBaseImage(baseImage)
+	}
+
+	if value, ok := newConfig["pin_base_image"]; ok {
+		pin, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid config: pin_base_image must be a boolean, got %T", value)
+		}
+		updatedConfig.PinBaseImage = pin
+		if !pin {
+			updatedConfig.BaseImageDigest = ""
+		}
+	}
+
+	if value, ok := newConfig["setup_commands"]; ok {
+		setupCommands, err := stringList("setup_commands", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.SetupCommands = setupCommands
+	}
+
+	if value, ok := newConfig["setup_stages"]; ok {
+		stages, err := stageList(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.SetupStages = stages
+	}
+
+	if value, ok := newConfig["path_prepend"]; ok {
+		pathPrepend, err := stringList("path_prepend", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.PathPrepend = pathPrepend
+	}
+
+	// The CA bundle is a host file copied into the container: agents don't get to pick which one
+	if _, ok := newConfig["ca_bundle"]; ok {
+		return nil, errors.New("invalid config: ca_bundle can only be set by the user, with `container-use config ca-bundle set`")
+	}
+
+	if value, ok := newConfig["envs"]; ok {
+		envs, err := stringList("envs", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		// Agents only ever see masked values, keep the actual ones if they hand them back
+		updatedConfig.Env = environment.KVList(envs).Unmasked(config.Env)
+	}
+
+	if value, ok := newConfig["git_config"]; ok {
+		gitConfig, err := stringMap("git_config", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.GitConfig = gitConfig
+	}
+
+	if value, ok := newConfig["volumes"]; ok {
+		volumes, err := volumeList(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.Volumes = volumes
+	}
+
+	if value, ok := newConfig["variables"]; ok {
+		variables, err := stringMap("variables", value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		updatedConfig.Variables = variables
+	}
+
+	return updatedConfig, nil
+}
+
+// volumeList converts the volumes argument of environment_config.
+func volumeList(value any) (environment.VolumeConfigs, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("volumes must be an array, got %T", value)
+	}
+	volumes := make(environment.VolumeConfigs, 0, len(items))
+	for i, item := range items {
+		fields, err := stringMap(fmt.Sprintf("volumes[%d]", i), item)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, &environment.VolumeConfig{
+			Name:    fields["name"],
+			Path:    fields["path"],
+			Sharing: fields["sharing"],
+		})
+	}
+	return volumes, nil
+}
+
+// stageList converts the setup_stages argument of environment_config.
+func stageList(value any) (environment.SetupStages, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("setup_stages must be an array, got %T", value)
+	}
+	stages := make(environment.SetupStages, 0, len(items))
+	for i, item := range items {
+		field := fmt.Sprintf("setup_stages[%d]", i)
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an object, got %T", field, item)
+		}
+		stage := &environment.SetupStage{}
+		for key, value := range fields {
+			var err error
+			switch key {
+			case "name":
+				stage.Name, ok = value.(string)
+			case "cache_key":
+				stage.CacheKey, ok = value.(string)
+			case "no_cache":
+				stage.NoCache, ok = value.(bool)
+			case "commands":
+				stage.Commands, err = stringList(field+".commands", value)
+			case "cache_paths":
+				stage.CachePaths, err = stringList(field+".cache_paths", value)
+			default:
+				return nil, fmt.Errorf("%s: unknown field %q", field, key)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s.%s has the wrong type %T", field, key, value)
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// stringMap converts a JSON object argument to a map of strings.
+func stringMap(field string, value any) (map[string]string, error) {
+	object, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an object, got %T", field, value)
+	}
+	m := make(map[string]string, len(object))
+	for key, item := range object {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s[%q] must be a string, got %T", field, key, item)
+		}
+		m[key] = str
+	}
+	return m, nil
+}
+
+// stringList converts a JSON array argument to a list of strings.
+func stringList(field string, value any) ([]string, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings, got %T", field, value)
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d] must be a string, got %T", field, i, item)
+		}
+		list[i] = str
+	}
+	return list, nil
+}
+
+// intList converts a JSON array of integers decoded by the MCP server (as float64s).
+func intList(field string, value any) ([]int, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of integers, got %T", field, value)
+	}
+	list := make([]int, len(items))
+	for i, item := range items {
+		number, ok := item.(float64)
+		if !ok || number != float64(int(number)) {
+			return nil, fmt.Errorf("%s[%d] must be an integer, got %v", field, i, item)
+		}
+		list[i] = int(number)
+	}
+	return list, nil
+}
+
+// portList converts a JSON array of port numbers decoded by the MCP server.
+func portList(field string, value any) ([]int, error) {
+	ports, err := intList(field, value)
+	if err != nil {
+		return nil, err
+	}
+	for i, port := range ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("%s[%d] must be a port number between 1 and 65535, got %d", field, i, port)
+		}
+	}
+	return ports, nil
+}
+
+func formatExitCodes(codes []int) string {
+	formatted := make([]string, len(codes))
+	for i, code := range codes {
+		formatted[i] = strconv.Itoa(code)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// parseFileMode parses octal file permissions such as "755", "0755" or "0o755".
+func parseFileMode(value string) (os.FileMode, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(value, "0o"), "0O")
+	mode, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || digits == "" || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q: must be octal permissions between 0000 and 0777, e.g. 0755", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// autoResponsesInput turns answers to prompts into the standard input of a command, one answer per line.
+func autoResponsesInput(responses []string) string {
+	if len(responses) == 0 {
+		return ""
+	}
+	return strings.Join(responses, "\n") + "\n"
+}
+
 func createEnvironmentListTool(_ bool) *Tool {
 	return &Tool{
 		Definition: newRepositoryTool(
@@ -529,6 +1088,9 @@ func createEnvironmentRunCmdTool(singleTenant bool) *Tool {
 			mcp.WithString("command",
 				mcp.Description("The terminal command to execute. If empty, the environment's default command is used."),
 			),
+			mcp.WithString("script",
+				mcp.Description("A multiline script to execute with the shell instead of command. The script is written to a temporary file, so no quoting or escaping is needed. Only works with foreground commands."),
+			),
 			mcp.WithString("shell",
 				mcp.Description("The shell that will be interpreting this command (default: sh)"),
 			),
@@ -545,6 +1107,63 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 				mcp.Description("Ports to expose. Only works with background environments. For each port, returns the environment_internal (for use inside environments) and host_external (for use by the user) addresses."),
 				mcp.Items(map[string]any{"type": "number"}),
 			),
+			mcp.WithBoolean("include_timing",
+				mcp.Description("Include a timing breakdown (container provisioning, command execution and total time) in the result. Only works with foreground commands."),
+			),
+			mcp.WithString("test_format",
+				mcp.Description("Parse the output of a test runner into a structured pass/fail/error summary returned alongside the raw output. Use go-json with `go test -json`, or junit with a JUnit XML report printed by the command or written to test_report. Only works with foreground commands."),
+				mcp.Enum(environment.TestFormatGoJSON, environment.TestFormatJUnit),
+			),
+			mcp.WithString("test_report",
+				mcp.Description("Path of the JUnit XML report written by the command, absolute or relative to the workdir. Only used with test_format junit."),
+			),
+			mcp.WithArray("expected_exit_codes",
+				mcp.Description("Exit codes that mean the command behaved as expected (default: [0]), e.g. [1] for a command that must fail. Other exit codes are reported as errors. Only works with foreground commands."),
+				mcp.Items(map[string]any{"type": "number"}),
+			),
+			mcp.WithString("stdin",
+				mcp.Description(`Data fed to the standard input of the command, e.g. a patch for "patch -p1", a SQL file for "psql" or a diff for "git apply". Large inputs are supported. Mutually exclusive with auto_responses.
+With background, the command can't use the image entrypoint.`),
+			),
+			mcp.WithArray("auto_responses",
+				mcp.Description(`Answers to the interactive prompts of the command (e.g. ["y", "", "admin"]), fed to its standard input one per line, in order. Only works with foreground commands.
+For a command asking the same confirmation over and over, pipe yes into it instead (e.g. "yes | ./install.sh").
+Prompts left unanswered (more prompts than responses, or programs reading from the terminal rather than stdin) may still hang the command: prefer non-interactive flags (e.g. "apt-get install -y") when they exist, and use environment_cancel if a command gets stuck.`),
+				mcp.Items(map[string]any{"type": "string"}),
+			),
+			mcp.WithBoolean("no_commit",
+				mcp.Description(`Don't commit changes to the workdir after the command, e.g. for read-only inspection commands or to run several commands and commit once. Only works with foreground commands.
+Changes stay in the environment and are visible to the next commands, but they are not in the container-use/<id> branch (and thus not visible to the user) until a later environment_run_cmd without no_commit commits them.`),
+			),
+			mcp.WithString("service",
+				mcp.Description(`Name of a service of the environment to run the command against instead of the environment's container, e.g. to run psql against a database service. Only works with foreground commands.
+The command runs in a new container built from the service's image, environment variables and secrets, that reaches the running service at its name (e.g. "psql -h db -U postgres"). Services that aren't running are started first. The environment's workdir is not changed.`),
+			),
+			mcp.WithString("memory_limit",
+				mcp.Description(`Cap the memory of this command only, e.g. "256MB" to run untrusted code. Only works with foreground commands.
+The limit applies to the virtual memory of each process of the command: allocations beyond it fail, and the command usually exits with an out of memory error that is pointed out in the result. Runtimes reserving a lot of address space upfront (e.g. Java, Node.js) need a higher limit than the memory they actually use.`),
+			),
+			mcp.WithNumber("cpu_quota",
+				mcp.Description(`Cap the CPU time, in seconds, of this command only, e.g. to stop runaway loops in untrusted code. Only works with foreground commands.
+The limit applies to each process of the command: a process exceeding it is killed (exit code 152), which is pointed out in the result. Time spent waiting (e.g. sleep, I/O) doesn't count.`),
+			),
+			mcp.WithString("output_encoding",
+				mcp.Description(`Encoding of the command's output. With text (the default), bytes that aren't valid UTF-8 (binary data, text in another encoding) are replaced with U+FFFD, which is pointed out in the result. Use base64 to get the exact bytes written to stdout and stderr, each base64 encoded. Only works with foreground commands.`),
+				mcp.Enum(environment.OutputEncodingText, environment.OutputEncodingBase64),
+			),
+			mcp.WithNumber("timeout",
+				mcp.Description("Stop the command if it runs for longer than this many seconds, e.g. for commands that may hang (default: no timeout). The changes made by a stopped command are discarded. Only works with foreground commands."),
+			),
+			mcp.WithBoolean("tty",
+				mcp.Description(`Run the command in a pseudo-terminal (default: false), for tools that refuse to run without one or only show progress and colors in one. Only works with foreground commands.
+The output then contains stderr merged into stdout, lines ending with \r\n, and possibly terminal control sequences (colors, cursor movements): prefer no tty when the tool works without it. Requires script (util-linux) or python3 in the container.`),
+			),
+			mcp.WithNumber("progress_interval",
+				mcp.Description(fmt.Sprintf("Seconds between progress notifications telling that a foreground command is still running (default: %d). Set to 0 to disable.", int(defaultProgressInterval.Seconds()))),
+			),
+			mcp.WithBoolean("build_status",
+				mcp.Description("Record the outcome of this command as the build status of the environment, shown to the user to tell whether the environment is green. Set it when running the project's build or test suite. The command passes if it exits as expected and, with test_format, no test fails. Only works with foreground commands."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			repo, env, err := openEnvironment(ctx, request)
@@ -554,6 +1173,16 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 
 			command := request.GetString("command", "")
 			shell := request.GetString("shell", "sh")
+			if script := request.GetString("script", ""); script != "" {
+				if err := checkAllowedCommand(ctx, script, shell); err != nil {
+					return nil, err
+				}
+			} else if err := checkAllowedCommand(ctx, command, shell); err != nil {
+				return nil, err
+			}
+			if err := checkAllowedEntrypoint(ctx, request.GetBool("use_entrypoint", false)); err != nil {
+				return nil, err
+			}
 
 			updateRepo := func() error {
 				if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
@@ -562,15 +1191,54 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 				return nil
 			}
 
+			service := request.GetString("service", "")
 			background := request.GetBool("background", false)
+			noCommit := request.GetBool("no_commit", false)
+			// Locked environments can still be inspected, by commands whose changes are discarded
+			locked := env.State.Locked
+			if locked && (background || (!noCommit && service == "")) {
+				return nil, fmt.Errorf("%w. Only foreground commands with no_commit or against a service can run, and their changes are discarded", repository.CheckUnlocked(env.EnvironmentInfo))
+			}
+			limits, err := resourceLimits(request)
+			if err != nil {
+				return nil, err
+			}
+			if background && !limits.IsZero() {
+				return nil, errors.New("memory_limit and cpu_quota only work with foreground commands")
+			}
+			if service != "" && background {
+				return nil, errors.New("commands can't run in the background against a service")
+			}
+			if service != "" && request.GetString("test_report", "") != "" {
+				return nil, errors.New("test_report can't be used with service: the report would be read from the environment's container")
+			}
+			recordBuildStatus := request.GetBool("build_status", false)
+			if recordBuildStatus && background {
+				return nil, errors.New("build_status only works with foreground commands")
+			}
+			tty := request.GetBool("tty", false)
+			if tty && background {
+				return nil, errors.New("tty only works with foreground commands")
+			}
+			timeout := request.GetFloat("timeout", 0)
+			if timeout < 0 {
+				return nil, errors.New("timeout must not be negative")
+			}
+			if timeout > 0 && background {
+				return nil, errors.New("timeout only works with foreground commands")
+			}
+			stdin := request.GetString("stdin", "")
+			if _, ok := request.GetArguments()["auto_responses"]; ok && stdin != "" {
+				return nil, errors.New("stdin and auto_responses are mutually exclusive")
+			}
 			if background {
 				ports := []int{}
-				if portList, ok := request.GetArguments()["ports"].([]any); ok {
-					for _, port := range portList {
-						ports = append(ports, int(port.(float64)))
+				if value, ok := request.GetArguments()["ports"]; ok {
+					if ports, err = portList("ports", value); err != nil {
+						return nil, err
 					}
 				}
-				endpoints, runErr := env.RunBackground(ctx, command, shell, ports, request.GetBool("use_entrypoint", false))
+				bg, runErr := env.RunBackground(ctx, command, shell, ports, request.GetBool("use_entrypoint", false), stdin)
 				// We want to update the repository even if the command failed.
 				if err := updateRepo(); err != nil {
 					return nil, err
@@ -579,41 +1247,176 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 					return nil, fmt.Errorf("failed to run command: %w", runErr)
 				}
 
-				out, err := json.Marshal(endpoints)
+				out, err := json.Marshal(bg.Endpoints)
 				if err != nil {
 					return nil, err
 				}
 
-				return mcp.NewToolResultText(fmt.Sprintf(`Command started in the background in NEW container. Endpoints are %s
+				return mcp.NewToolResultText(fmt.Sprintf(`Command started in the background in NEW container with handle %s. Use this handle to refer to the command later on, e.g. to stop it with environment_stop.
+Endpoints are %s
 
 To access from the user's machine: use host_external. To access from other commands in this environment: use environment_internal.
 
 Any changes to the container workdir (%s) WILL NOT be committed to container-use/%s
 
 Background commands are unaffected by filesystem and any other kind of changes. You need to start a new command for changes to take effect.`,
-					string(out), env.State.Config.Workdir, env.ID)), nil
+					bg.ID, string(out), env.State.Config.Workdir, env.ID)), nil
+			}
+
+			expectedExitCodes := []int{0}
+			if value, ok := request.GetArguments()["expected_exit_codes"]; ok {
+				if expectedExitCodes, err = intList("expected_exit_codes", value); err != nil {
+					return nil, err
+				}
 			}
 
-			stdout, runErr := env.Run(ctx, command, shell, request.GetBool("use_entrypoint", false))
-			// We want to update the repository even if the command failed.
-			if err := updateRepo(); err != nil {
+			if value, ok := request.GetArguments()["auto_responses"]; ok {
+				responses, err := stringList("auto_responses", value)
+				if err != nil {
+					return nil, err
+				}
+				stdin = autoResponsesInput(responses)
+			}
+
+			progressInterval := time.Duration(request.GetFloat("progress_interval", defaultProgressInterval.Seconds()) * float64(time.Second))
+			stopProgress := startProgressReporter(ctx, request, progressInterval, "Command")
+			opts := environment.RunOpts{
+				Command:       command,
+				Script:        request.GetString("script", ""),
+				Shell:         shell,
+				UseEntrypoint: request.GetBool("use_entrypoint", false),
+				Stdin:         stdin,
+				Limits:        limits,
+				TTY:           tty,
+				Timeout:       time.Duration(timeout * float64(time.Second)),
+
+				OutputEncoding: request.GetString("output_encoding", environment.OutputEncodingText),
+			}
+			var (
+				result *environment.RunResult
+				runErr error
+			)
+			if service != "" {
+				result, runErr = env.RunInService(ctx, service, opts)
+			} else {
+				result, runErr = env.Run(ctx, opts)
+			}
+			stopProgress()
+
+			// Tests are parsed before saving the state, which holds the build status
+			var (
+				tests       *environment.TestResults
+				testSummary string
+			)
+			testFormat := request.GetString("test_format", "")
+			if runErr == nil && testFormat != "" {
+				tests, testSummary = testResults(ctx, env, result, testFormat, request.GetString("test_report", ""))
+			}
+			if runErr == nil && recordBuildStatus {
+				label := cmp.Or(opts.Command, opts.Script)
+				env.RecordBuildStatus(label, result, slices.Contains(expectedExitCodes, result.ExitCode), tests)
+			}
+
+			if noCommit || service != "" {
+				// Keep the container state so the next commands see the changes, without committing them,
+				// unless the environment is locked.
+				if err := repo.SaveState(ctx, env); err != nil && !(locked && errors.Is(err, repository.ErrLocked)) {
+					return nil, fmt.Errorf("failed to save environment state: %w", err)
+				}
+			} else if err := updateRepo(); err != nil {
+				// We want to update the repository even if the command failed.
 				return nil, err
 			}
 			if runErr != nil {
 				return nil, fmt.Errorf("failed to run command: %w", runErr)
 			}
 
-			return mcp.NewToolResultText(fmt.Sprintf("%s\n\nAny changes to the container workdir (%s) have been committed and pushed to container-use/%s remote ref", stdout, env.State.Config.Workdir, env.ID)), nil
+			output := result.Output
+			if result.InvalidUTF8 && opts.OutputEncoding != environment.OutputEncodingBase64 {
+				output += "\n\nNote: the output contained bytes that aren't valid UTF-8, replaced with \uFFFD. Use output_encoding base64 to get the exact bytes."
+			}
+			if request.GetBool("include_timing", false) {
+				output += fmt.Sprintf("\n\nTiming: %s", result.Timing)
+			}
+			if testFormat != "" {
+				output += "\n\n" + testSummary
+			}
+			if recordBuildStatus {
+				output += fmt.Sprintf("\n\nBuild status: %s.", env.State.BuildStatus)
+			}
+			if exceeded := limits.Exceeded(result.ExitCode, result.Stderr); exceeded != "" {
+				output += fmt.Sprintf("\n\nResource limit exceeded: %s.", exceeded)
+			}
+			if msg := result.KillMessage(); msg != "" {
+				output += "\n\n" + msg
+			}
+
+			commitNote := fmt.Sprintf("Any changes to the container workdir (%s) have been committed and pushed to container-use/%s remote ref", env.State.Config.Workdir, env.ID)
+			if service != "" {
+				commitNote = fmt.Sprintf("The command ran against service %s: the container workdir (%s) was not changed.", service, env.State.Config.Workdir)
+			} else if env.State.Scratch {
+				commitNote = scratchNote
+			} else if locked {
+				commitNote = fmt.Sprintf("The environment is locked: changes to the container workdir (%s) were discarded.", env.State.Config.Workdir)
+			} else if noCommit {
+				commitNote = fmt.Sprintf("Changes to the container workdir (%s) have NOT been committed to container-use/%s. They will be committed by the next environment_run_cmd without no_commit.", env.State.Config.Workdir, env.ID)
+			}
+
+			if !slices.Contains(expectedExitCodes, result.ExitCode) {
+				return mcp.NewToolResultError(fmt.Sprintf("Command exited with unexpected code %d (expected %s)\n\n%s\n\n%s", result.ExitCode, formatExitCodes(expectedExitCodes), output, commitNote)), nil
+			}
+
+			return mcp.NewToolResultText(fmt.Sprintf("%s\n\n%s", output, commitNote)), nil
 		},
 	}
 }
 
+// resourceLimits returns the per-command resource limits of an environment_run_cmd request.
+func resourceLimits(request mcp.CallToolRequest) (environment.ResourceLimits, error) {
+	limits := environment.ResourceLimits{}
+	if memory := request.GetString("memory_limit", ""); memory != "" {
+		var err error
+		if limits.MemoryBytes, err = environment.ParseMemoryLimit(memory); err != nil {
+			return limits, err
+		}
+	}
+	if cpu := request.GetFloat("cpu_quota", 0); cpu != 0 {
+		if cpu < 1 || cpu != math.Trunc(cpu) {
+			return limits, fmt.Errorf("invalid cpu_quota %v: must be a whole number of seconds", cpu)
+		}
+		limits.CPUSeconds = int(cpu)
+	}
+	return limits, nil
+}
+
+// testResults parses and summarizes the test results of a command. Parsing failures are reported in the summary
+// rather than returned since the command itself ran fine, and the results are nil.
+func testResults(ctx context.Context, env *environment.Environment, result *environment.RunResult, format, report string) (*environment.TestResults, string) {
+	output := result.Stdout
+	if report != "" {
+		var err error
+		if output, err = env.FileRead(ctx, report, true, 0, 0); err != nil {
+			return nil, fmt.Sprintf("Test results unavailable: failed to read %s: %s", report, err)
+		}
+	}
+
+	results, err := environment.ParseTestResults(format, output)
+	if err != nil {
+		return nil, fmt.Sprintf("Test results unavailable: %s", err)
+	}
+	out, err := json.Marshal(results)
+	if err != nil {
+		return nil, fmt.Sprintf("Test results unavailable: %s", err)
+	}
+	return results, fmt.Sprintf("Test results: %s", out)
+}
+
 func createEnvironmentFileReadTool(singleTenant bool) *Tool {
 	return &Tool{
 		Definition: newEnvironmentTool(
 			envToolOptions{
 				name:                  "environment_file_read",
-				description:           "Read the contents of a file, specifying a line range or the entire file.",
+				description:           "Read the contents of a file, specifying a line range, the entire file, or the function or class enclosing a line.",
 				useCurrentEnvironment: singleTenant,
 			},
 			mcp.WithString("target_file",
@@ -629,9 +1432,15 @@ func createEnvironmentFileReadTool(singleTenant bool) *Tool {
 			mcp.WithNumber("end_line_one_indexed_inclusive",
 				mcp.Description("The ending line (1-indexed, inclusive) to read from the file. Must specify both start_line and end_line if not reading entire file."),
 			),
+			mcp.WithBoolean("include_metadata",
+				mcp.Description("Also return the size and mode of the file, and the last commit (SHA and explanation) that modified it, e.g. to decide how to handle a file without a separate call."),
+			),
+			mcp.WithNumber("symbol_line_one_indexed",
+				mcp.Description("Read the whole function, method or class declaration enclosing this line (1-indexed) instead of a line range, so that code isn't cut in half. Supported for Python and brace-delimited languages (Go, JavaScript, TypeScript, Java, C, C++, C#, Rust, ...)."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			_, env, err := openEnvironment(ctx, request)
+			repo, env, err := openEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -641,6 +1450,23 @@ func createEnvironmentFileReadTool(singleTenant bool) *Tool {
 				return nil, err
 			}
 
+			var header string
+			if request.GetBool("include_metadata", false) {
+				metadata, err := fileMetadata(ctx, repo, env, targetFile)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read file metadata: %w", err)
+				}
+				header = fmt.Sprintf("File metadata: %s\n\n", metadata)
+			}
+
+			if symbolLine := request.GetInt("symbol_line_one_indexed", 0); symbolLine > 0 {
+				contents, start, end, err := env.FileReadSymbol(ctx, targetFile, symbolLine)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read file: %w", err)
+				}
+				return mcp.NewToolResultText(fmt.Sprintf("%sLines %d-%d of %s:\n%s", header, start, end, targetFile, contents)), nil
+			}
+
 			shouldReadEntireFile := request.GetBool("should_read_entire_file", false)
 			startLineOneIndexedInclusive := request.GetInt("start_line_one_indexed_inclusive", 0)
 			endLineOneIndexedInclusive := request.GetInt("end_line_one_indexed_inclusive", 0)
@@ -650,23 +1476,50 @@ func createEnvironmentFileReadTool(singleTenant bool) *Tool {
 				return nil, fmt.Errorf("failed to read file: %w", err)
 			}
 
-			return mcp.NewToolResultText(fileContents), nil
+			return mcp.NewToolResultText(header + fileContents), nil
 		},
 	}
 }
 
+// fileMetadata returns the metadata of a file as JSON, with its last commit for files of the workdir.
+func fileMetadata(ctx context.Context, repo *repository.Repository, env *environment.Environment, targetFile string) (string, error) {
+	metadata, err := env.FileStat(ctx, targetFile)
+	if err != nil {
+		return "", err
+	}
+	if rel, ok := env.WorkdirRelativePath(targetFile); ok {
+		if metadata.LastCommit, err = repo.LastFileCommit(ctx, env.ID, rel); err != nil {
+			return "", err
+		}
+	}
+	out, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
 func createEnvironmentFileListTool(singleTenant bool) *Tool {
 	return &Tool{
 		Definition: newEnvironmentTool(
 			envToolOptions{
 				name:                  "environment_file_list",
-				description:           "List the contents of a directory",
+				description:           "List the contents of a directory. Directories are listed with a trailing slash. Use recursive to get the layout of a project in one call.",
 				useCurrentEnvironment: singleTenant,
 			},
 			mcp.WithString("path",
 				mcp.Description("Path of the directory to list contents of, absolute or relative to the workdir"),
 				mcp.Required(),
 			),
+			mcp.WithBoolean("recursive",
+				mcp.Description(fmt.Sprintf("List the contents of the subdirectories too, as paths relative to the directory (default: false). Files ignored by .gitignore are left out, and the listing stops after %d entries.", environment.DefaultFileListMaxEntries)),
+			),
+			mcp.WithNumber("max_depth",
+				mcp.Description("How deep recursive listings go, 1 listing the directory itself only (default: no limit)."),
+			),
+			mcp.WithBoolean("include_ignored",
+				mcp.Description("Include the files ignored by .gitignore (e.g. node_modules) in recursive listings (default: false)."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			_, env, err := openEnvironment(ctx, request)
@@ -679,7 +1532,15 @@ func createEnvironmentFileListTool(singleTenant bool) *Tool {
 				return nil, err
 			}
 
-			out, err := env.FileList(ctx, path)
+			maxDepth := request.GetInt("max_depth", 0)
+			if maxDepth < 0 {
+				return nil, errors.New("max_depth must not be negative")
+			}
+			out, err := env.FileListWithOpts(ctx, path, environment.FileListOpts{
+				Recursive:      request.GetBool("recursive", false),
+				MaxDepth:       maxDepth,
+				IncludeIgnored: request.GetBool("include_ignored", false),
+			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to list directory: %w", err)
 			}
@@ -689,12 +1550,183 @@ func createEnvironmentFileListTool(singleTenant bool) *Tool {
 	}
 }
 
+func createEnvironmentGlobTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_glob",
+				description:           fmt.Sprintf("Find the files and directories of the workdir matching a shell-style pattern, e.g. all the Go files of a project with \"**/*.go\", without listing directories one by one. Paths are relative to the workdir, as with the other file tools. At most %d paths are returned.", environment.MaxGlobResults),
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("pattern",
+				mcp.Description("Pattern relative to the workdir: * and ? don't match slashes, ** matches any number of directories and braces list alternatives (e.g. \"src/**/*.{ts,tsx}\")."),
+				mcp.Required(),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			pattern, err := request.RequireString("pattern")
+			if err != nil {
+				return nil, err
+			}
+			matches, truncated, err := env.Glob(ctx, pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return mcp.NewToolResultText(fmt.Sprintf("No files match %q.", pattern)), nil
+			}
+
+			out := &strings.Builder{}
+			for _, match := range matches {
+				fmt.Fprintf(out, "%s\n", match)
+			}
+			if truncated {
+				fmt.Fprintf(out, "... (truncated after %d paths: narrow the pattern to see the rest)\n", len(matches))
+			}
+			return mcp.NewToolResultText(out.String()), nil
+		},
+	}
+}
+
+// maxGrepMaxResults is the highest max_results accepted by environment_grep.
+const maxGrepMaxResults = 1000
+
+func createEnvironmentGrepTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_grep",
+				description:           "Search the files of the environment for lines matching a pattern, e.g. to find where a symbol is defined or used without reading every file. Matches are returned as file:line:text, with paths relative to the workdir and line numbers as used by environment_file_read. Files ignored by .gitignore are skipped when the image has ripgrep or git.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("pattern",
+				mcp.Description("Extended regular expression to search for (e.g. \"func [A-Z]\\w+\\(\")."),
+				mcp.Required(),
+			),
+			mcp.WithString("path",
+				mcp.Description("File or directory to search, absolute or relative to the workdir (default: the workdir)."),
+			),
+			mcp.WithBoolean("ignore_case",
+				mcp.Description("Match the pattern case-insensitively (default: false)."),
+			),
+			mcp.WithNumber("max_results",
+				mcp.Description(fmt.Sprintf("Maximum number of matches to return (default: %d, at most %d).", environment.DefaultGrepMaxResults, maxGrepMaxResults)),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			pattern, err := request.RequireString("pattern")
+			if err != nil {
+				return nil, err
+			}
+			maxResults := request.GetInt("max_results", environment.DefaultGrepMaxResults)
+			if maxResults <= 0 || maxResults > maxGrepMaxResults {
+				return nil, fmt.Errorf("max_results must be between 1 and %d", maxGrepMaxResults)
+			}
+
+			// One more match than asked for tells whether there are more
+			matches, err := env.Grep(ctx, pattern, environment.GrepOpts{
+				Path:       request.GetString("path", ""),
+				IgnoreCase: request.GetBool("ignore_case", false),
+				MaxResults: maxResults + 1,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return mcp.NewToolResultText(fmt.Sprintf("No matches for %q.", pattern)), nil
+			}
+
+			out := &strings.Builder{}
+			for i, match := range matches {
+				if i == maxResults {
+					fmt.Fprintf(out, "... (stopped after %d matches: narrow the pattern or the path to see the rest)\n", maxResults)
+					break
+				}
+				fmt.Fprintf(out, "%s\n", match)
+			}
+			return mcp.NewToolResultText(out.String()), nil
+		},
+	}
+}
+
+const (
+	// defaultExportMaxSize bounds the total size of the files exported by environment_export_files by default.
+	defaultExportMaxSize = 10 << 20
+	// maxExportMaxSize is the highest max_size accepted: the archive is returned inline, base64 encoded.
+	maxExportMaxSize = 50 << 20
+)
+
+func createEnvironmentExportFilesTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_export_files",
+				description:           "Package files of the environment into a zip archive, e.g. to hand deliverables (a generated site, a set of configs) to the user in one call. The archive is returned base64 encoded, as an embedded resource.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("pattern",
+				mcp.Description("Glob pattern of the files to export, relative to the workdir (e.g. \"site/**\", \"config/*.yaml\")."),
+				mcp.Required(),
+			),
+			mcp.WithNumber("max_size",
+				mcp.Description(fmt.Sprintf("Maximum total size of the exported files in bytes, before compression (default: %d, at most %d). Files that don't fit are left out and listed in the result.", defaultExportMaxSize, maxExportMaxSize)),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			pattern, err := request.RequireString("pattern")
+			if err != nil {
+				return nil, err
+			}
+			maxSize := request.GetInt("max_size", defaultExportMaxSize)
+			if maxSize <= 0 || maxSize > maxExportMaxSize {
+				return nil, fmt.Errorf("max_size must be between 1 and %d", maxExportMaxSize)
+			}
+
+			archive, err := env.ExportFiles(ctx, pattern, int64(maxSize))
+			if err != nil {
+				return nil, fmt.Errorf("failed to export files: %w", err)
+			}
+
+			summary := fmt.Sprintf("Exported %d files (%d bytes, %d bytes compressed) matching %q:\n%s", len(archive.Files), archive.Size, len(archive.Data), pattern, strings.Join(archive.Files, "\n"))
+			if archive.Truncated() {
+				summary += fmt.Sprintf("\n\nTRUNCATED: %d matching files were left out to stay within %d bytes:\n%s", len(archive.Skipped), maxSize, strings.Join(archive.Skipped, "\n"))
+			}
+
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					mcp.NewTextContent(summary),
+					mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+						URI:      fmt.Sprintf("container-use://%s/export.zip", env.ID),
+						MIMEType: "application/zip",
+						Blob:     base64.StdEncoding.EncodeToString(archive.Data),
+					}),
+				},
+			}, nil
+		},
+	}
+}
+
 func createEnvironmentFileEditTool(singleTenant bool) *Tool {
 	return &Tool{
 		Definition: newEnvironmentTool(
 			envToolOptions{
 				name:                  "environment_file_edit",
-				description:           "Find and replace text in a file.",
+				description:           "Find and replace text in a file, or replace a range of lines of a file (start_line and end_line, e.g. as numbered by a previous read) when the text to replace is ambiguous. Replacing lines returns the diff of the change.",
 				useCurrentEnvironment: singleTenant,
 			},
 			mcp.WithString("target_file",
@@ -702,19 +1734,24 @@ func createEnvironmentFileEditTool(singleTenant bool) *Tool {
 				mcp.Required(),
 			),
 			mcp.WithString("search_text",
-				mcp.Description("The text to find and replace."),
-				mcp.Required(),
+				mcp.Description("The text to find and replace. Required unless start_line and end_line are set."),
 			),
 			mcp.WithString("replace_text",
-				mcp.Description("The text to insert."),
+				mcp.Description("The text to insert. When replacing lines, an empty text deletes them."),
 				mcp.Required(),
 			),
 			mcp.WithString("which_match",
 				mcp.Description("The ID of the match to replace, if there were multiple matches."),
 			),
+			mcp.WithNumber("start_line",
+				mcp.Description("First line to replace, 1-indexed. Replaces lines instead of searching text, together with end_line."),
+			),
+			mcp.WithNumber("end_line",
+				mcp.Description("Last line to replace, 1-indexed and inclusive."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
 			}
@@ -723,22 +1760,35 @@ func createEnvironmentFileEditTool(singleTenant bool) *Tool {
 			if err != nil {
 				return nil, err
 			}
-			search, err := request.RequireString("search_text")
-			if err != nil {
-				return nil, err
-			}
 			replace, err := request.RequireString("replace_text")
 			if err != nil {
 				return nil, err
 			}
 
-			if err := env.FileEdit(ctx,
-				request.GetString("explanation", ""),
-				targetFile,
-				search,
-				replace,
-				request.GetString("which_match", ""),
-			); err != nil {
+			search := request.GetString("search_text", "")
+			startLine, endLine := request.GetInt("start_line", 0), request.GetInt("end_line", 0)
+			lineEdit := startLine != 0 || endLine != 0
+			var patch string
+			switch {
+			case lineEdit && search != "":
+				return nil, errors.New("search_text and start_line/end_line are mutually exclusive")
+			case lineEdit:
+				if startLine == 0 || endLine == 0 {
+					return nil, errors.New("start_line and end_line must be set together")
+				}
+				patch, err = env.FileEditLines(ctx, request.GetString("explanation", ""), targetFile, startLine, endLine, replace)
+			case search == "":
+				return nil, errors.New("either search_text or start_line and end_line are required")
+			default:
+				err = env.FileEdit(ctx,
+					request.GetString("explanation", ""),
+					targetFile,
+					search,
+					replace,
+					request.GetString("which_match", ""),
+				)
+			}
+			if err != nil {
 				return mcp.NewToolResultErrorFromErr("failed to write file", err), nil
 			}
 
@@ -746,7 +1796,14 @@ func createEnvironmentFileEditTool(singleTenant bool) *Tool {
 				return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
 			}
 
-			return mcp.NewToolResultText(fmt.Sprintf("file %s edited successfully and committed to container-use/%s remote ref", targetFile, env.ID)), nil
+			message := fmt.Sprintf("file %s edited successfully and committed to container-use/%s remote ref", targetFile, env.ID)
+			if env.State.Scratch {
+				message = fmt.Sprintf("file %s edited successfully\n\n%s", targetFile, scratchNote)
+			}
+			if patch != "" {
+				message += "\n\n" + patch
+			}
+			return mcp.NewToolResultText(message), nil
 		},
 	}
 }
@@ -767,9 +1824,12 @@ func createEnvironmentFileWriteTool(singleTenant bool) *Tool {
 				mcp.Description("Full text content of the file you want to write."),
 				mcp.Required(),
 			),
+			mcp.WithString("mode",
+				mcp.Description("Permissions of the file, in octal (default: 0644). Use 0755 for scripts so that they can be executed without a separate chmod."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -783,7 +1843,14 @@ func createEnvironmentFileWriteTool(singleTenant bool) *Tool {
 				return nil, err
 			}
 
-			if err := env.FileWrite(ctx, request.GetString("explanation", ""), targetFile, contents); err != nil {
+			mode := environment.DefaultFileMode
+			if value := request.GetString("mode", ""); value != "" {
+				if mode, err = parseFileMode(value); err != nil {
+					return nil, err
+				}
+			}
+
+			if err := env.FileWriteWithMode(ctx, request.GetString("explanation", ""), targetFile, contents, mode); err != nil {
 				return nil, fmt.Errorf("failed to write file: %w", err)
 			}
 
@@ -791,6 +1858,9 @@ func createEnvironmentFileWriteTool(singleTenant bool) *Tool {
 				return nil, fmt.Errorf("unable to update the environment: %w", err)
 			}
 
+			if env.State.Scratch {
+				return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully\n\n%s", targetFile, scratchNote)), nil
+			}
 			return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully and committed to container-use/%s remote ref", targetFile, env.ID)), nil
 		},
 	}
@@ -810,7 +1880,7 @@ func createEnvironmentFileDeleteTool(singleTenant bool) *Tool {
 			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -828,26 +1898,138 @@ func createEnvironmentFileDeleteTool(singleTenant bool) *Tool {
 				return nil, fmt.Errorf("failed to update env: %w", err)
 			}
 
+			if env.State.Scratch {
+				return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully\n\n%s", targetFile, scratchNote)), nil
+			}
 			return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully and committed to container-use/%s remote ref", targetFile, env.ID)), nil
 		},
 	}
 }
 
+func createEnvironmentFileMoveTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_file_move",
+				description:           "Moves or renames a file, keeping its permissions. Prefer it over reading, writing and deleting the file: it is faster, works with binary files and records the move in a single commit.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("source_file",
+				mcp.Description("Path of the file to move, absolute or relative to the workdir."),
+				mcp.Required(),
+			),
+			mcp.WithString("destination_file",
+				mcp.Description("Path to move the file to, absolute or relative to the workdir. Missing parent directories are created; an existing file is overwritten."),
+				mcp.Required(),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			repo, env, err := openMutableEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			sourceFile, err := request.RequireString("source_file")
+			if err != nil {
+				return nil, err
+			}
+			destinationFile, err := request.RequireString("destination_file")
+			if err != nil {
+				return nil, err
+			}
+
+			explanation := request.GetString("explanation", "")
+			if explanation == "" {
+				explanation = fmt.Sprintf("Move %s to %s", sourceFile, destinationFile)
+			}
+			if err := env.FileMove(ctx, explanation, sourceFile, destinationFile); err != nil {
+				return nil, fmt.Errorf("failed to move file: %w", err)
+			}
+
+			if err := repo.Update(ctx, env, explanation); err != nil {
+				return nil, fmt.Errorf("failed to update env: %w", err)
+			}
+
+			if env.State.Scratch {
+				return mcp.NewToolResultText(fmt.Sprintf("file %s moved to %s successfully\n\n%s", sourceFile, destinationFile, scratchNote)), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("file %s moved to %s successfully and committed to container-use/%s remote ref", sourceFile, destinationFile, env.ID)), nil
+		},
+	}
+}
+
+func createEnvironmentFileCopyTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_file_copy",
+				description:           "Copies a file, keeping its permissions. Prefer it over reading and writing the file: it is faster and works with binary files.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("source_file",
+				mcp.Description("Path of the file to copy, absolute or relative to the workdir."),
+				mcp.Required(),
+			),
+			mcp.WithString("destination_file",
+				mcp.Description("Path to copy the file to, absolute or relative to the workdir. Missing parent directories are created; an existing file is overwritten."),
+				mcp.Required(),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			repo, env, err := openMutableEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			sourceFile, err := request.RequireString("source_file")
+			if err != nil {
+				return nil, err
+			}
+			destinationFile, err := request.RequireString("destination_file")
+			if err != nil {
+				return nil, err
+			}
+
+			explanation := request.GetString("explanation", "")
+			if explanation == "" {
+				explanation = fmt.Sprintf("Copy %s to %s", sourceFile, destinationFile)
+			}
+			if err := env.FileCopy(ctx, explanation, sourceFile, destinationFile); err != nil {
+				return nil, fmt.Errorf("failed to copy file: %w", err)
+			}
+
+			if err := repo.Update(ctx, env, explanation); err != nil {
+				return nil, fmt.Errorf("failed to update env: %w", err)
+			}
+
+			if env.State.Scratch {
+				return mcp.NewToolResultText(fmt.Sprintf("file %s copied to %s successfully\n\n%s", sourceFile, destinationFile, scratchNote)), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("file %s copied to %s successfully and committed to container-use/%s remote ref", sourceFile, destinationFile, env.ID)), nil
+		},
+	}
+}
+
 func createEnvironmentCheckpointTool(singleTenant bool) *Tool {
 	return &Tool{
 		Definition: newEnvironmentTool(
 			envToolOptions{
-				name:                  "environment_checkpoint",
-				description:           "Checkpoints an environment in its current state as a container.",
+				name: "environment_checkpoint",
+				description: `Checkpoints an environment in its current state as a container.
+The image is labeled with the environment ID (` + environment.CheckpointLabelEnvironmentID + `), title (` + environment.CheckpointLabelTitle + `), source commit (` + environment.CheckpointLabelRevision + `) and creation time (` + environment.CheckpointLabelCreated + `).`,
 				useCurrentEnvironment: singleTenant,
 			},
 			mcp.WithString("destination",
 				mcp.Description("Container image destination to checkpoint to (e.g. registry.com/user/image:tag"),
 				mcp.Required(),
 			),
+			mcp.WithArray("labels",
+				mcp.Description("Additional OCI labels to set on the image (e.g. `[\"org.opencontainers.image.description=API server\"]`). Automatic labels cannot be overridden."),
+				mcp.Items(map[string]any{"type": "string"}),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			_, env, err := openEnvironment(ctx, request)
+			repo, env, err := openEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -857,16 +2039,103 @@ func createEnvironmentCheckpointTool(singleTenant bool) *Tool {
 				return nil, err
 			}
 
-			endpoint, err := env.Checkpoint(ctx, destination)
+			labels := map[string]string{}
+			for _, label := range request.GetStringSlice("labels", []string{}) {
+				k, v, found := strings.Cut(label, "=")
+				if !found {
+					return nil, fmt.Errorf("invalid label %q: must be in the form KEY=VALUE", label)
+				}
+				labels[k] = v
+			}
+
+			// Scratch environments can't record the checkpoint in their state, and locked ones hold finalized
+			// work: refuse them before pushing anything
+			if env.State.Scratch {
+				return nil, errors.New("scratch environments can't be checkpointed: they have no commit to trace the image back to")
+			}
+			if err := repository.CheckUnlocked(env.EnvironmentInfo); err != nil {
+				return nil, err
+			}
+			sourceCommit, err := repo.HeadCommit(ctx, env.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve environment commit: %w", err)
+			}
+
+			endpoint, err := env.Checkpoint(ctx, destination, sourceCommit, labels)
 			if err != nil {
 				return nil, fmt.Errorf("failed to checkpoint environment: %w", err)
 			}
 
+			// Record the checkpoint so that other environments can run it as a service, without committing
+			// the pending changes of the environment
+			if err := repo.RecordCheckpoint(ctx, env.ID, endpoint); err != nil {
+				return nil, fmt.Errorf("checkpoint pushed to %q but not recorded: %w", endpoint, err)
+			}
+
 			return mcp.NewToolResultText(fmt.Sprintf("Checkpoint pushed to %q. You MUST use the full content addressed (@sha256:...) reference in `docker` commands. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", endpoint)), nil
 		},
 	}
 }
 
+func createEnvironmentCancelTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_cancel",
+				description:           "Cancels the operations (e.g. a hung command) currently running in an environment. The cancelled tool calls return an error.",
+				useCurrentEnvironment: singleTenant,
+			},
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			envID, err := requestEnvironmentID(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			cancelled := cancelOperations(envID)
+			if cancelled == 0 {
+				return mcp.NewToolResultText(fmt.Sprintf("No operation running in environment %s.", envID)), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("Cancelled %d operation(s) in environment %s.", cancelled, envID)), nil
+		},
+	}
+}
+
+func createEnvironmentStopTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_stop",
+				description:           "Stops a background command started by environment_run_cmd, or a service of the environment, releasing its ports. Stopped background commands are forgotten; stopped services stay in the configuration and are started again when the environment is rebuilt.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("id",
+				mcp.Description("The ID of the background command, as returned by environment_run_cmd with background=true (e.g. bg-1a2b3c4d), or the name of the service to stop."),
+				mcp.Required(),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			repo, env, err := openMutableEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+			handle, err := request.RequireString("id")
+			if err != nil {
+				return nil, err
+			}
+
+			if err := env.Stop(ctx, handle); err != nil {
+				return nil, err
+			}
+			if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+				return nil, fmt.Errorf("failed to update env: %w", err)
+			}
+
+			return mcp.NewToolResultText(fmt.Sprintf("Stopped %s in environment %s.", handle, env.ID)), nil
+		},
+	}
+}
+
 func createEnvironmentAddServiceTool(singleTenant bool) *Tool {
 	return &Tool{
 		Definition: newEnvironmentTool(
@@ -880,23 +2149,36 @@ func createEnvironmentAddServiceTool(singleTenant bool) *Tool {
 				mcp.Required(),
 			),
 			mcp.WithString("image",
-				mcp.Description("The image of the service to start."),
+				mcp.Description("The image of the service to start. Use `checkpoint:<environment_id>` to run the latest checkpoint of another environment of this repository (e.g. a built backend to test against). Checkpoints use `sh` as entrypoint, so `command` is required to start them."),
 				mcp.Required(),
 			),
 			mcp.WithString("command",
 				mcp.Description("The command to start the service. If not provided the image default command will be used."),
 			),
 			mcp.WithArray("ports",
-				mcp.Description("Ports to expose. For each port, returns the container_internal (for use by environments) and host_external (for use by the user) address."),
+				mcp.Description("TCP ports to expose. For each port, returns the protocol, environment_internal (for use by environments) and host_external (for use by the user) address."),
+				mcp.Items(map[string]any{"type": "number"}),
+			),
+			mcp.WithArray("udp_ports",
+				mcp.Description("UDP ports to expose (e.g. for DNS or game servers). Endpoints are returned like for TCP ports, keyed as \"PORT/udp\"."),
 				mcp.Items(map[string]any{"type": "number"}),
 			),
 			mcp.WithArray("envs",
 				mcp.Description("The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
 				mcp.Items(map[string]any{"type": "string"}),
 			),
+			mcp.WithString("registry_username",
+				mcp.Description("Username for pulling the image from a private registry. By default, the user's registry credentials are used."),
+			),
+			mcp.WithString("registry_password",
+				mcp.Description("Secret reference of the password or token for the private registry (e.g. `env://REGISTRY_TOKEN`, `op://vault/item/field`). Requires registry_username."),
+			),
+			mcp.WithNumber("startup_timeout",
+				mcp.Description("How many seconds to wait for the service's ports to accept connections (default: 30). If the service isn't ready in time, an error with the last lines of its output is returned and the service isn't added."),
+			),
 		),
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			repo, env, err := openEnvironment(ctx, request)
+			repo, env, err := openMutableEnvironment(ctx, request)
 			if err != nil {
 				return nil, err
 			}
@@ -909,22 +2191,55 @@ func createEnvironmentAddServiceTool(singleTenant bool) *Tool {
 				return nil, err
 			}
 			command := request.GetString("command", "")
-			ports := []int{}
-			if portList, ok := request.GetArguments()["ports"].([]any); ok {
-				for _, port := range portList {
-					ports = append(ports, int(port.(float64)))
+			if checkpointEnvID, ok := environment.ParseCheckpointImage(image); ok {
+				if command == "" {
+					return nil, errors.New("command is required to run a checkpoint as a service: checkpoints use `sh` as entrypoint")
+				}
+				checkpointEnv, err := repo.Info(ctx, checkpointEnvID)
+				if err != nil {
+					return nil, err
+				}
+				if image, err = checkpointEnv.LatestCheckpoint(); err != nil {
+					return nil, err
+				}
+			}
+			registryUsername := request.GetString("registry_username", "")
+			registryPassword := request.GetString("registry_password", "")
+			if (registryUsername == "") != (registryPassword == "") {
+				return nil, errors.New("registry_username and registry_password must be set together")
+			}
+			ports := []environment.ServicePort{}
+			for _, protocol := range []struct{ field, name string }{{"ports", environment.ProtocolTCP}, {"udp_ports", environment.ProtocolUDP}} {
+				value, ok := request.GetArguments()[protocol.field]
+				if !ok {
+					continue
+				}
+				numbers, err := portList(protocol.field, value)
+				if err != nil {
+					return nil, err
+				}
+				for _, number := range numbers {
+					ports = append(ports, environment.ServicePort{Port: number, Protocol: protocol.name})
 				}
 			}
 
 			envs := request.GetStringSlice("envs", []string{})
 
-			service, err := env.AddService(ctx, request.GetString("explanation", ""), &environment.ServiceConfig{
+			serviceConfig := &environment.ServiceConfig{
 				Name:         serviceName,
 				Image:        image,
 				Command:      command,
 				ExposedPorts: ports,
 				Env:          envs,
-			})
+
+				RegistryUsername: registryUsername,
+				RegistryPassword: registryPassword,
+				StartupTimeout:   request.GetInt("startup_timeout", 0),
+			}
+			if err := checkAllowedService(ctx, env.State.Config, serviceConfig); err != nil {
+				return nil, err
+			}
+			service, err := env.AddService(ctx, request.GetString("explanation", ""), serviceConfig)
 			if err != nil {
 				return nil, fmt.Errorf("failed to add service: %w", err)
 			}
@@ -942,3 +2257,145 @@ func createEnvironmentAddServiceTool(singleTenant bool) *Tool {
 		},
 	}
 }
+
+func createEnvironmentRemoveServiceTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_remove_service",
+				description:           "Stop a service and remove it from the environment, releasing its ports. Unlike environment_stop, the service is not started again when the environment is rebuilt, and its name no longer resolves in environment_run_cmd. The environment is rebuilt from its files to unbind the service: the other services restart, and changes made outside of the workdir (e.g. installed packages) are lost.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("name",
+				mcp.Description("The name of the service to remove."),
+				mcp.Required(),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			repo, env, err := openMutableEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+			serviceName, err := request.RequireString("name")
+			if err != nil {
+				return nil, err
+			}
+
+			if err := env.RemoveService(ctx, request.GetString("explanation", ""), serviceName); err != nil {
+				if errors.Is(err, environment.ErrServiceNotFound) {
+					return mcp.NewToolResultText(fmt.Sprintf("Nothing to remove: %s.", err)), nil
+				}
+				return nil, fmt.Errorf("failed to remove service: %w", err)
+			}
+			if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+				return nil, fmt.Errorf("failed to update env: %w", err)
+			}
+
+			return mcp.NewToolResultText(fmt.Sprintf("Removed service %s from environment %s.", serviceName, env.ID)), nil
+		},
+	}
+}
+
+func createEnvironmentListServicesTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_list_services",
+				description:           "List the running services of the environment, with their image and, for each port, the protocol, environment_internal (for use by environments) and host_external (for use by the user) address, like environment_add_service returns them. Services marked stale weren't started by this server, e.g. before it restarted: their host_external addresses may be unreachable, re-add the service (environment_remove_service, then environment_add_service) to expose it again.",
+				useCurrentEnvironment: singleTenant,
+			},
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			services, err := env.ListServices(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list services: %w", err)
+			}
+			if len(services) == 0 {
+				return mcp.NewToolResultText(fmt.Sprintf("No service running in environment %s.", env.ID)), nil
+			}
+
+			output, err := json.Marshal(services)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal services: %w", err)
+			}
+			return mcp.NewToolResultText(string(output)), nil
+		},
+	}
+}
+
+// defaultServiceLogsTail is how many lines of output environment_service_logs returns by default.
+const defaultServiceLogsTail = 100
+
+func createEnvironmentServiceLogsTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_service_logs",
+				description:           "Return the output (stdout and stderr) of a service of the environment, e.g. to find out why it fails to start or misbehaves. Only the output of services started with a command is captured: services running the entrypoint of their image have no captured logs. To get them, add the service with a command running the entrypoint, e.g. `docker-entrypoint.sh postgres`.",
+				useCurrentEnvironment: singleTenant,
+			},
+			mcp.WithString("name",
+				mcp.Description("The name of the service."),
+				mcp.Required(),
+			),
+			mcp.WithNumber("tail",
+				mcp.Description(fmt.Sprintf("Number of lines to return from the end of the output (default: %d). 0 returns the whole output.", defaultServiceLogsTail)),
+			),
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+			name, err := request.RequireString("name")
+			if err != nil {
+				return nil, err
+			}
+
+			logs, err := env.ServiceLogs(ctx, name, request.GetInt("tail", defaultServiceLogsTail))
+			if errors.Is(err, environment.ErrServiceLogsNotCaptured) {
+				return mcp.NewToolResultText(fmt.Sprintf("No captured logs for service %s: it runs the entrypoint of its image, whose output isn't captured. Add it with a command for its output to be captured.", name)), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(logs) == "" {
+				return mcp.NewToolResultText(fmt.Sprintf("Service %s has no output.", name)), nil
+			}
+			return mcp.NewToolResultText(logs), nil
+		},
+	}
+}
+
+func createEnvironmentResourcesTool(singleTenant bool) *Tool {
+	return &Tool{
+		Definition: newEnvironmentTool(
+			envToolOptions{
+				name:                  "environment_resources",
+				description:           "Return the resources available to the commands of the environment, to check there's enough before heavy operations (e.g. a big build, a large download or adding a service): the size and free space of the disk holding the workdir, the memory of the machine and the container's memory limit, and the number of CPUs and the container's CPU limit. Sizes are in bytes; limits are omitted when there is none.",
+				useCurrentEnvironment: singleTenant,
+			},
+		),
+		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			_, env, err := openEnvironment(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+
+			resources, err := env.Resources(ctx)
+			if err != nil {
+				return nil, err
+			}
+			output, err := json.Marshal(resources)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal resources: %w", err)
+			}
+			return mcp.NewToolResultText(string(output)), nil
+		},
+	}
+}
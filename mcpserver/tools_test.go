@@ -0,0 +1,300 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFromArguments(t *testing.T) {
+	current := environment.DefaultConfig()
+	current.SetupCommands = []string{"apt-get update"}
+
+	// Decode like the MCP server does, so numbers are float64 and arrays are []any
+	parse := func(t *testing.T, raw string) any {
+		t.Helper()
+		var arg any
+		require.NoError(t, json.Unmarshal([]byte(raw), &arg))
+		return arg
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		updated, err := configFromArguments(current, parse(t, `{"base_image": "alpine", "setup_commands": ["apk add git"], "envs": ["FOO=bar"], "git_config": {"user.name": "Agent"}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "alpine", updated.BaseImage)
+		assert.Equal(t, []string{"apk add git"}, updated.SetupCommands)
+		assert.Equal(t, environment.KVList{"FOO=bar"}, updated.Env)
+		assert.Equal(t, map[string]string{"user.name": "Agent"}, updated.GitConfig)
+
+		// The current config is left untouched
+		assert.Equal(t, []string{"apt-get update"}, current.SetupCommands)
+	})
+
+	t.Run("masked env values are kept", func(t *testing.T) {
+		withSecret := current.Copy()
+		withSecret.Env = environment.KVList{"API_TOKEN=abc123"}
+
+		updated, err := configFromArguments(withSecret, parse(t, `{"envs": ["API_TOKEN=***", "DEBUG=1"]}`))
+		require.NoError(t, err)
+		assert.Equal(t, environment.KVList{"API_TOKEN=abc123", "DEBUG=1"}, updated.Env)
+	})
+
+	t.Run("template variables", func(t *testing.T) {
+		updated, err := configFromArguments(current, parse(t, `{"base_image": "python:${PY_VERSION}", "variables": {"PY_VERSION": "3.12"}}`))
+		require.NoError(t, err)
+		assert.Equal(t, "python:${PY_VERSION}", updated.BaseImage)
+		assert.Equal(t, map[string]string{"PY_VERSION": "3.12"}, updated.Variables)
+	})
+
+	t.Run("volumes", func(t *testing.T) {
+		updated, err := configFromArguments(current, parse(t, `{"volumes": [{"name": "datasets", "path": "/data", "sharing": "locked"}, {"name": "pip", "path": "/root/.cache/pip"}]}`))
+		require.NoError(t, err)
+		assert.Equal(t, environment.VolumeConfigs{
+			{Name: "datasets", Path: "/data", Sharing: environment.VolumeSharingLocked},
+			{Name: "pip", Path: "/root/.cache/pip"},
+		}, updated.Volumes)
+	})
+
+	t.Run("setup stages", func(t *testing.T) {
+		updated, err := configFromArguments(current, parse(t, `{"setup_stages": [{"name": "os", "commands": ["apt-get update", "apt-get install -y curl"], "cache_key": "2024-06", "cache_paths": ["/var/cache/apt"]}, {"name": "tools", "commands": ["make tools"], "no_cache": true}]}`))
+		require.NoError(t, err)
+		assert.Equal(t, environment.SetupStages{
+			{Name: "os", Commands: []string{"apt-get update", "apt-get install -y curl"}, CacheKey: "2024-06", CachePaths: []string{"/var/cache/apt"}},
+			{Name: "tools", Commands: []string{"make tools"}, NoCache: true},
+		}, updated.SetupStages)
+	})
+
+	t.Run("pinned base image", func(t *testing.T) {
+		pinned := current.Copy()
+		pinned.BaseImage = "python:3.12"
+		pinned.BaseImageDigest = "sha256:" + strings.Repeat("a", 64)
+		pinned.PinBaseImage = true
+
+		updated, err := configFromArguments(pinned, parse(t, `{"base_image": "python:3.12", "setup_commands": ["pip install uv"]}`))
+		require.NoError(t, err)
+		assert.Equal(t, pinned.BaseImageDigest, updated.BaseImageDigest, "the pin is kept while the base image is unchanged")
+
+		updated, err = configFromArguments(pinned, parse(t, `{"base_image": "python:3.13"}`))
+		require.NoError(t, err)
+		assert.Empty(t, updated.BaseImageDigest, "a new base image drops the pin")
+		assert.True(t, updated.PinBaseImage)
+
+		updated, err = configFromArguments(pinned, parse(t, `{"pin_base_image": false}`))
+		require.NoError(t, err)
+		assert.Empty(t, updated.BaseImageDigest)
+		assert.False(t, updated.PinBaseImage)
+	})
+
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{
+			name:     "not an object",
+			config:   `["apk add git"]`,
+			expected: "invalid config: must be an object",
+		},
+		{
+			name:     "non-string setup command",
+			config:   `{"setup_commands": ["apk add git", 42]}`,
+			expected: "setup_commands[1] must be a string, got float64",
+		},
+		{
+			name:     "setup commands not an array",
+			config:   `{"setup_commands": "apk add git"}`,
+			expected: "setup_commands must be an array of strings, got string",
+		},
+		{
+			name:     "non-string env",
+			config:   `{"envs": [{"FOO": "bar"}]}`,
+			expected: "envs[0] must be a string",
+		},
+		{
+			name:     "non-string base image",
+			config:   `{"base_image": 3.11}`,
+			expected: "base_image must be a string",
+		},
+		{
+			name:     "non-boolean pin",
+			config:   `{"pin_base_image": "yes"}`,
+			expected: "pin_base_image must be a boolean",
+		},
+		{
+			name:     "CA bundle",
+			config:   `{"ca_bundle": "/home/user/.ssh/id_rsa"}`,
+			expected: "ca_bundle can only be set by the user",
+		},
+		{
+			name:     "non-string git config value",
+			config:   `{"git_config": {"core.autocrlf": false}}`,
+			expected: `git_config["core.autocrlf"] must be a string`,
+		},
+		{
+			name:     "non-string variable",
+			config:   `{"variables": {"PY_VERSION": 3.12}}`,
+			expected: `variables["PY_VERSION"] must be a string`,
+		},
+		{
+			name:     "volume not an object",
+			config:   `{"volumes": ["datasets:/data"]}`,
+			expected: "volumes[0] must be an object",
+		},
+		{
+			name:     "non-boolean stage no_cache",
+			config:   `{"setup_stages": [{"name": "os", "commands": ["apt-get update"], "no_cache": "yes"}]}`,
+			expected: "setup_stages[0].no_cache has the wrong type string",
+		},
+		{
+			name:     "non-string stage command",
+			config:   `{"setup_stages": [{"name": "os", "commands": [42]}]}`,
+			expected: "setup_stages[0].commands[0] must be a string",
+		},
+		{
+			name:     "unknown stage field",
+			config:   `{"setup_stages": [{"name": "os", "run": ["apt-get update"]}]}`,
+			expected: `setup_stages[0]: unknown field "run"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			assert.NotPanics(t, func() {
+				_, err = configFromArguments(current, parse(t, tt.config))
+			})
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestFilterTools(t *testing.T) {
+	names := func(tools []*Tool) []string {
+		var names []string
+		for _, t := range tools {
+			names = append(names, t.Definition.Name)
+		}
+		return names
+	}
+
+	all := createTools(false)
+
+	t.Run("no filter", func(t *testing.T) {
+		tools, err := filterTools(all, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, names(all), names(tools))
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		tools, err := filterTools(all, ReadOnlyTools, nil)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, ReadOnlyTools, names(tools))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		tools, err := filterTools(all, nil, []string{"environment_run_cmd", "environment_file_delete"})
+		require.NoError(t, err)
+		assert.Len(t, tools, len(all)-2)
+		assert.NotContains(t, names(tools), "environment_run_cmd")
+		assert.NotContains(t, names(tools), "environment_file_delete")
+	})
+
+	t.Run("disabled wins over enabled", func(t *testing.T) {
+		tools, err := filterTools(all, []string{"environment_list", "environment_open"}, []string{"environment_open"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"environment_list"}, names(tools))
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		_, err := filterTools(all, nil, []string{"environment_run"})
+		require.ErrorContains(t, err, `unknown tool "environment_run"`)
+	})
+
+	t.Run("nothing left", func(t *testing.T) {
+		_, err := filterTools(all, []string{"environment_list"}, []string{"environment_list"})
+		require.Error(t, err)
+	})
+}
+
+func TestServerInstructions(t *testing.T) {
+	assert.Equal(t, rules.AgentRules, serverInstructions(ServerOptions{}))
+	assert.Equal(t, rules.AgentRules, serverInstructions(ServerOptions{Instructions: " \n"}))
+
+	merged := serverInstructions(ServerOptions{Instructions: "Always run the tests before finishing.\n"})
+	assert.True(t, strings.HasPrefix(merged, strings.TrimRight(rules.AgentRules, "\n")))
+	assert.True(t, strings.HasSuffix(merged, "## Additional Instructions\n\nAlways run the tests before finishing.\n"))
+
+	replaced := serverInstructions(ServerOptions{Instructions: "Only use Python.", ReplaceInstructions: true})
+	assert.Equal(t, "Only use Python.\n", replaced)
+}
+
+func TestIntList(t *testing.T) {
+	codes, err := intList("expected_exit_codes", []any{float64(0), float64(1)})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, codes)
+
+	_, err = intList("expected_exit_codes", float64(1))
+	assert.Error(t, err)
+	_, err = intList("expected_exit_codes", []any{"1"})
+	assert.Error(t, err)
+	_, err = intList("expected_exit_codes", []any{1.5})
+	assert.Error(t, err)
+}
+
+func TestPortList(t *testing.T) {
+	ports, err := portList("ports", []any{float64(80), float64(65535)})
+	require.NoError(t, err)
+	assert.Equal(t, []int{80, 65535}, ports)
+
+	for _, invalid := range []any{float64(8080), []any{"8080"}, []any{float64(0)}, []any{float64(-1)}, []any{float64(65536)}} {
+		_, err := portList("ports", invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSummarizeArguments(t *testing.T) {
+	summary := summarizeArguments(map[string]any{
+		"environment_id":     "fancy-mallard",
+		"explanation":        "Add a service",
+		"name":               "db",
+		"registry_password":  "hunter2",
+		"envs":               []any{"POSTGRES_USER=app", "POSTGRES_PASSWORD=hunter2"},
+		"environment_config": map[string]any{"env": []any{"API_TOKEN=hunter2"}, "base_image": "alpine"},
+		"background":         true,
+		"contents":           strings.Repeat("x", 1000),
+	})
+
+	assert.NotContains(t, summary, "environment_id")
+	assert.NotContains(t, summary, "explanation")
+	assert.Equal(t, "db", summary["name"])
+	assert.Equal(t, environment.MaskedValue, summary["registry_password"])
+	assert.Equal(t, `["POSTGRES_USER=app","POSTGRES_PASSWORD=***"]`, summary["envs"])
+	assert.Equal(t, "{base_image, env}", summary["environment_config"])
+	assert.Equal(t, "true", summary["background"])
+	assert.Equal(t, strings.Repeat("x", maxArgumentSummary)+"... (1000 bytes)", summary["contents"])
+}
+
+func TestParseFileMode(t *testing.T) {
+	for value, expected := range map[string]os.FileMode{"755": 0755, "0644": 0644, "0o700": 0700, "0": 0} {
+		mode, err := parseFileMode(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, mode, value)
+	}
+
+	for _, value := range []string{"", "rwx", "0o", "888", "1755", "-1"} {
+		_, err := parseFileMode(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestAutoResponsesInput(t *testing.T) {
+	assert.Equal(t, "", autoResponsesInput(nil))
+	assert.Equal(t, "y\n", autoResponsesInput([]string{"y"}))
+	assert.Equal(t, "y\n\nadmin\n", autoResponsesInput([]string{"y", "", "admin"}))
+}
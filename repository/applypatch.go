@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ApplyPatchOpts are the options of ApplyPatch.
+type ApplyPatchOpts struct {
+	// ThreeWay falls back on a 3-way merge when the patch doesn't apply cleanly, leaving conflict markers in the
+	// files that conflict. The changes are staged.
+	ThreeWay bool
+	// Check only checks that the patch applies, without changing the working tree.
+	Check bool
+}
+
+// DirtyFilesError is returned by ApplyPatch when files changed by the environment have uncommitted changes in the
+// source repository, which applying the patch would conflict with.
+type DirtyFilesError struct {
+	Environment string
+	Files       []string
+}
+
+func (e *DirtyFilesError) Error() string {
+	return fmt.Sprintf("files changed by environment %s have uncommitted changes: %s. Commit or stash them first", e.Environment, strings.Join(e.Files, ", "))
+}
+
+// ApplyPatch applies the changes of an environment, the diff shown by Diff, to the working tree of the source
+// repository as uncommitted changes, with `git apply`. Unlike Apply, nothing is merged: the changes can be committed
+// in any way, and the environment's history is not part of the branch.
+func (r *Repository) ApplyPatch(ctx context.Context, id string, opts ApplyPatchOpts, w io.Writer) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return err
+	}
+
+	changed, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--name-only", "-z", "--no-renames", revisionRange)
+	if err != nil {
+		return err
+	}
+	if dirty := r.dirtyFiles(ctx, strings.FieldsFunc(changed, func(c rune) bool { return c == 0 })); len(dirty) > 0 {
+		return &DirtyFilesError{Environment: id, Files: dirty}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "container-use-patch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	patch := filepath.Join(tmpDir, id+".patch")
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--binary", "--full-index", "--output="+patch, revisionRange); err != nil {
+		return err
+	}
+	if info, err := os.Stat(patch); err != nil {
+		return err
+	} else if info.Size() == 0 {
+		fmt.Fprintf(w, "Environment %s has no changes to apply\n", id)
+		return nil
+	}
+
+	args := []string{"apply", "--verbose"}
+	if opts.ThreeWay {
+		args = append(args, "--3way")
+	}
+	if opts.Check {
+		args = append(args, "--check")
+	}
+	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, append(args, patch)...)
+}
+
+// dirtyFiles returns the files among paths with staged, unstaged or untracked changes in the source repository.
+func (r *Repository) dirtyFiles(ctx context.Context, paths []string) []string {
+	if len(paths) == 0 {
+		return nil
+	}
+	status, err := RunGitCommand(ctx, r.userRepoPath, append([]string{"status", "--porcelain", "-z", "--untracked-files=all", "--no-renames", "--"}, paths...)...)
+	if err != nil {
+		return nil // git apply reports the conflicts itself
+	}
+	dirty := []string{}
+	for _, entry := range strings.Split(status, "\x00") {
+		if len(entry) > 3 {
+			dirty = append(dirty, entry[3:])
+		}
+	}
+	slices.Sort(dirty)
+	return dirty
+}
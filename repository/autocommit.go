@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// autoCommitPollInterval is how often AutoCommit looks for changes in the worktree.
+const autoCommitPollInterval = 500 * time.Millisecond
+
+// AutoCommitOpts are the options of AutoCommit.
+type AutoCommitOpts struct {
+	// Debounce is how long the worktree must go without changes before they are committed, so that a burst of
+	// edits (e.g. saving several files, or a formatter rewriting them) makes a single commit.
+	Debounce time.Duration
+	// OnCommit is called with the message of each commit made.
+	OnCommit func(message string)
+}
+
+// AutoCommit watches the worktree of an environment for changes made by hand, e.g. with an editor, and commits
+// them to the environment as they happen, like the changes of tools, until ctx is done.
+func (r *Repository) AutoCommit(ctx context.Context, dag *dagger.Client, id string, opts AutoCommitOpts) error {
+	if err := r.exists(ctx, id); err != nil {
+		return err
+	}
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return fmt.Errorf("failed to get worktree path: %w", err)
+	}
+
+	ticker := time.NewTicker(autoCommitPollInterval)
+	defer ticker.Stop()
+
+	var pending string
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		changed, deleted, err := worktreeEdits(ctx, worktreePath)
+		if err != nil {
+			return err
+		}
+		snapshot := editsSnapshot(worktreePath, changed, deleted)
+		switch {
+		case snapshot == "":
+			pending = ""
+			continue
+		case snapshot != pending:
+			pending, lastChange = snapshot, time.Now()
+			continue
+		case time.Since(lastChange) < opts.Debounce:
+			continue
+		}
+
+		message := autoCommitMessage(changed, deleted)
+		if err := r.saveWorktreeEdits(ctx, dag, id, worktreePath, changed, deleted, message); err != nil {
+			return err
+		}
+		pending = ""
+		if opts.OnCommit != nil {
+			opts.OnCommit(message)
+		}
+	}
+}
+
+// saveWorktreeEdits copies the files edited in the worktree to the environment's container, then commits them.
+func (r *Repository) saveWorktreeEdits(ctx context.Context, dag *dagger.Client, id, worktreePath string, changed, deleted []string, message string) error {
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return err
+	}
+	slog.Info("Committing changes made in the worktree", "environment.id", id, "changed", changed, "deleted", deleted)
+	if err := env.SyncWorkdirFiles(ctx, worktreePath, changed, deleted); err != nil {
+		return fmt.Errorf("failed to copy changes to the environment: %w", err)
+	}
+	return r.Update(ctx, env, message)
+}
+
+// worktreeEdits returns the files of the worktree changed since its last commit, and those deleted.
+func worktreeEdits(ctx context.Context, worktreePath string) (changed, deleted []string, err error) {
+	output, err := RunGitCommand(ctx, worktreePath, "status", "--porcelain", "-z", "--untracked-files=all", "--no-renames")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range strings.Split(output, "\x00") {
+		if len(entry) < 4 {
+			continue
+		}
+		status, path := entry[:2], entry[3:]
+		if strings.Contains(status, "D") {
+			deleted = append(deleted, path)
+		} else {
+			changed = append(changed, path)
+		}
+	}
+	return changed, deleted, nil
+}
+
+// editsSnapshot identifies the state of the edited files, so that further changes to a file already modified are
+// noticed: their size and modification time are part of it.
+func editsSnapshot(worktreePath string, changed, deleted []string) string {
+	var snapshot strings.Builder
+	for _, path := range changed {
+		info, err := os.Lstat(filepath.Join(worktreePath, path))
+		if err != nil {
+			fmt.Fprintf(&snapshot, "%s ?\n", path)
+			continue
+		}
+		fmt.Fprintf(&snapshot, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	for _, path := range deleted {
+		fmt.Fprintf(&snapshot, "%s deleted\n", path)
+	}
+	return snapshot.String()
+}
+
+// autoCommitMessage describes the edits of a commit, naming the files when there are only a few.
+func autoCommitMessage(changed, deleted []string) string {
+	describe := func(verb string, files []string) string {
+		if len(files) > 3 {
+			return fmt.Sprintf("%s %d files", verb, len(files))
+		}
+		return verb + " " + strings.Join(files, ", ")
+	}
+	parts := []string{}
+	if len(changed) > 0 {
+		parts = append(parts, describe("edit", slices.Sorted(slices.Values(changed))))
+	}
+	if len(deleted) > 0 {
+		parts = append(parts, describe("delete", slices.Sorted(slices.Values(deleted))))
+	}
+	return "Manual changes: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorktreeEdits(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, dir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, dir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, dir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, dir, "kept.txt", "kept")
+	writeFile(t, dir, "removed.txt", "removed")
+	_, err = RunGitCommand(ctx, dir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, dir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	changed, deleted, err := worktreeEdits(ctx, dir)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted)
+	assert.Empty(t, editsSnapshot(dir, changed, deleted))
+
+	writeFile(t, dir, "kept.txt", "edited")
+	writeFile(t, dir, "new dir/new file.txt", "new")
+	require.NoError(t, os.Remove(filepath.Join(dir, "removed.txt")))
+
+	changed, deleted, err = worktreeEdits(ctx, dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"kept.txt", "new dir/new file.txt"}, changed)
+	assert.Equal(t, []string{"removed.txt"}, deleted)
+
+	// Editing a file already modified changes the snapshot
+	snapshot := editsSnapshot(dir, changed, deleted)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "kept.txt"), time.Now(), time.Now().Add(time.Minute)))
+	assert.NotEqual(t, snapshot, editsSnapshot(dir, changed, deleted))
+}
+
+func TestAutoCommitMessage(t *testing.T) {
+	assert.Equal(t, "Manual changes: edit a.go, b.go", autoCommitMessage([]string{"b.go", "a.go"}, nil))
+	assert.Equal(t, "Manual changes: edit a.go, delete old.go", autoCommitMessage([]string{"a.go"}, []string{"old.go"}))
+	assert.Equal(t, "Manual changes: edit 4 files", autoCommitMessage([]string{"a", "b", "c", "d"}, nil))
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// bundleNotesPrefix is where bundles hold the notes of the environment's commits, under refs/notes/: only the notes
+// of its history are bundled, not those of every environment.
+const bundleNotesPrefix = "container-use-bundle/"
+
+// Bundle writes an environment to a git bundle file: its branch with the whole history, and the notes of its commits
+// (state, log and metadata). ImportBundle turns the bundle back into an environment, in any repository.
+func (r *Repository) Bundle(ctx context.Context, id, output string) error {
+	if err := r.exists(ctx, id); err != nil {
+		return err
+	}
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+
+	return r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		return r.lockManager.WithLock(ctx, LockTypeNotes, func() error {
+			defer r.deleteBundleNotes(ctx)
+			r.deleteBundleNotes(ctx) // Left behind by an interrupted bundle
+
+			history, err := RunGitCommand(ctx, r.forkRepoPath, "rev-list", "refs/heads/"+id)
+			if err != nil {
+				return err
+			}
+			commits := map[string]bool{}
+			for _, commit := range strings.Fields(history) {
+				commits[commit] = true
+			}
+
+			refs := []string{"refs/heads/" + id}
+			for _, ref := range commitNotesRefs {
+				notes, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", ref, "list")
+				if err != nil {
+					return err
+				}
+				bundled := false
+				for _, line := range strings.Split(strings.TrimSpace(notes), "\n") {
+					note, commit, ok := strings.Cut(line, " ")
+					if !ok || !commits[commit] {
+						continue
+					}
+					if _, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", bundleNotesPrefix+ref, "add", "-f", "-C", note, commit); err != nil {
+						return fmt.Errorf("failed to bundle notes: %w", err)
+					}
+					bundled = true
+				}
+				if bundled {
+					refs = append(refs, "refs/notes/"+bundleNotesPrefix+ref)
+				}
+			}
+
+			if _, err := RunGitCommand(ctx, r.forkRepoPath, append([]string{"bundle", "create", output}, refs...)...); err != nil {
+				return fmt.Errorf("failed to create bundle: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// ImportBundle creates an environment from a bundle written by Bundle, e.g. in another clone of the repository. The
+// environment is named id, or keeps the name it had when bundled if id is empty. It returns the environment's ID.
+func (r *Repository) ImportBundle(ctx context.Context, path, id string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	heads, err := RunGitCommand(ctx, r.forkRepoPath, "bundle", "list-heads", path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bundle %s: %w", path, err)
+	}
+	bundled, notesRefs := parseBundleHeads(heads)
+	if bundled == "" {
+		return "", fmt.Errorf("%s is not an environment bundle: it has no environment branch", path)
+	}
+
+	if id == "" {
+		id = bundled
+	}
+	if err := ValidateEnvironmentID(id); err != nil {
+		return "", err
+	}
+	if _, ok := r.scratch(ctx, id); ok {
+		return "", fmt.Errorf("environment %q already exists, import the bundle under another name", id)
+	}
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+id); err == nil {
+		return "", fmt.Errorf("environment %q already exists, import the bundle under another name", id)
+	}
+
+	return id, r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		if err := r.fetchBundle(ctx, path, bundled, id, notesRefs); err != nil {
+			return err
+		}
+
+		// Info creates the worktree and the container-use/<id> ref of the source repository
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, ref := range notesRefs {
+			if err := r.propagateGitNotes(ctx, ref); err != nil {
+				return err
+			}
+		}
+
+		// The environment was created from another repository, whose environments may not be here
+		changed := false
+		if envInfo.State.SourcePath != r.userRepoPath {
+			envInfo.State.SourcePath = r.userRepoPath
+			changed = true
+		}
+		if parent := envInfo.State.Parent; parent != "" && r.exists(ctx, parent) != nil {
+			envInfo.State.Parent = ""
+			changed = true
+		}
+		if changed {
+			return r.saveInfo(ctx, envInfo)
+		}
+		r.reindex(ctx, envInfo)
+		return nil
+	})
+}
+
+// fetchBundle fetches the branch of the environment bundled in path into the fork repository as the branch id, and
+// adds the bundled notes of its commits. Existing notes are kept: they are those of the same commits.
+func (r *Repository) fetchBundle(ctx context.Context, path, bundled, id string, notesRefs []string) error {
+	return r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+		return r.lockManager.WithLock(ctx, LockTypeNotes, func() error {
+			defer r.deleteBundleNotes(ctx)
+			r.deleteBundleNotes(ctx) // Left behind by an interrupted import
+
+			args := []string{"fetch", path, fmt.Sprintf("refs/heads/%s:refs/heads/%s", bundled, id)}
+			for _, ref := range notesRefs {
+				args = append(args, fmt.Sprintf("+refs/notes/%s%s:refs/notes/%s%s", bundleNotesPrefix, ref, bundleNotesPrefix, ref))
+			}
+			if _, err := RunGitCommand(ctx, r.forkRepoPath, args...); err != nil {
+				return fmt.Errorf("failed to import bundle: %w", err)
+			}
+
+			for _, ref := range notesRefs {
+				notes, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", bundleNotesPrefix+ref, "list")
+				if err != nil {
+					return err
+				}
+				for _, line := range strings.Split(strings.TrimSpace(notes), "\n") {
+					note, commit, ok := strings.Cut(line, " ")
+					if !ok {
+						continue
+					}
+					if _, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", ref, "add", "-C", note, commit); err != nil && !strings.Contains(err.Error(), "existing notes") {
+						return fmt.Errorf("failed to import notes: %w", err)
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// deleteBundleNotes deletes the notes refs used to bundle or import the notes of an environment.
+func (r *Repository) deleteBundleNotes(ctx context.Context) {
+	for _, ref := range commitNotesRefs {
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "update-ref", "-d", "refs/notes/"+bundleNotesPrefix+ref); err != nil {
+			slog.Warn("Failed to delete temporary bundle notes", "ref", ref, "err", err)
+		}
+	}
+}
+
+// parseBundleHeads parses the output of `git bundle list-heads` for a bundle written by Bundle: it returns the ID of
+// the bundled environment, and the notes refs the bundle has notes of. The ID is empty if the bundle has no branch,
+// or several.
+func parseBundleHeads(heads string) (string, []string) {
+	var branches, notesRefs []string
+	for _, line := range strings.Split(strings.TrimSpace(heads), "\n") {
+		_, ref, _ := strings.Cut(line, " ")
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			branches = append(branches, branch)
+		} else if notesRef, ok := strings.CutPrefix(ref, "refs/notes/"+bundleNotesPrefix); ok && slices.Contains(commitNotesRefs, notesRef) {
+			notesRefs = append(notesRefs, notesRef)
+		}
+	}
+	if len(branches) != 1 {
+		return "", notesRefs
+	}
+	return branches[0], notesRefs
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBundleHeads(t *testing.T) {
+	heads := `49d363a471d8e7dae7b8f4ecc55978576632d15b refs/heads/fancy-mallard
+df837591787fd4aed4c7df88e9153929d570518a refs/notes/container-use-bundle/container-use-state
+30ca0ed1b4976fb4482662debc29195b893df44e refs/notes/container-use-bundle/container-use
+e45c9c2666d44e0327c1f9c239a74c508336053e refs/notes/container-use-bundle/unknown
+91b693623939fc8764b6e2150d27a2e9721676d4 refs/tags/v1
+`
+	id, notesRefs := parseBundleHeads(heads)
+	assert.Equal(t, "fancy-mallard", id)
+	assert.Equal(t, []string{"container-use-state", "container-use"}, notesRefs)
+
+	id, _ = parseBundleHeads("49d363a471d8e7dae7b8f4ecc55978576632d15b refs/heads/main\n30ca0ed1b4976fb4482662debc29195b893df44e refs/heads/fancy-mallard\n")
+	assert.Empty(t, id, "bundles of several branches aren't environment bundles")
+	id, _ = parseBundleHeads("")
+	assert.Empty(t, id)
+}
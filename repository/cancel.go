@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cancelRequestPath returns the file used to ask MCP servers to cancel the operations running in an environment.
+// Servers run in a different process than the CLI, so the request goes through the filesystem: its modification
+// time tells servers which operations were started before the request.
+func cancelRequestPath(id string) string {
+	return filepath.Join(cuGlobalConfigPath, "cancel", id)
+}
+
+// RequestCancel asks the MCP servers running operations in the environment to cancel them.
+// Operations started after the request are not affected.
+func RequestCancel(id string) error {
+	path := cancelRequestPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cancel request directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to request cancellation: %w", err)
+	}
+	return nil
+}
+
+// CancelRequested reports whether cancellation of the environment's operations was requested after since.
+func CancelRequested(id string, since time.Time) bool {
+	info, err := os.Stat(cancelRequestPath(id))
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(since)
+}
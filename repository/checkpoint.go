@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+)
+
+// RecordCheckpoint records an image published by environment.Environment.Checkpoint in the state of the
+// environment, for other environments to run it as a service. Unlike Update, nothing is committed: only the
+// state is saved.
+func (r *Repository) RecordCheckpoint(ctx context.Context, id, ref string) error {
+	return r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		envInfo.State.Checkpoints = append(envInfo.State.Checkpoints, ref)
+		return r.saveInfo(ctx, envInfo)
+	})
+}
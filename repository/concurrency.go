@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+const (
+	// maxUpdateAttempts bounds how many times an update failing because of a concurrent git operation is attempted.
+	maxUpdateAttempts = 3
+	// updateRetryDelay is how long to wait before retrying an update, multiplied by the number of attempts so far.
+	updateRetryDelay = 200 * time.Millisecond
+)
+
+// errConflictingUpdate is returned when the changes of an environment conflict with changes saved concurrently
+// to its branch, e.g. by another agent working in the same environment.
+var errConflictingUpdate = errors.New("conflicting changes were saved concurrently to the environment")
+
+// environmentLockType serializes the updates of an environment across processes.
+func environmentLockType(id string) LockType {
+	return LockType("env-" + id)
+}
+
+// withEnvironmentUpdate runs update while holding the environment's lock. Updates failing because of a concurrent
+// git operation (e.g. a ref or index locked by another process) are retried: since they read the latest state
+// of the branch and apply the environment's changes on top of it, running them again is safe.
+func (r *Repository) withEnvironmentUpdate(ctx context.Context, env *environment.Environment, update func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := r.lockManager.WithLock(ctx, environmentLockType(env.ID), func() error {
+			if err := r.checkUnlocked(ctx, env.ID); err != nil {
+				return err
+			}
+			// Don't save back a lock lifted since the environment was loaded
+			env.State.Locked = false
+			return update()
+		})
+		if err == nil || attempt == maxUpdateAttempts || !isTransientGitError(err) {
+			return err
+		}
+
+		slog.Warn("Retrying environment update after a concurrent git operation",
+			"environment.id", env.ID, "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * updateRetryDelay):
+		}
+	}
+}
+
+// isTransientGitError reports whether err was caused by a git operation running at the same time, in which
+// case the operation may succeed if retried.
+func isTransientGitError(err error) bool {
+	if errors.Is(err, errConflictingUpdate) {
+		return false
+	}
+	msg := err.Error()
+	for _, symptom := range []string{
+		"index.lock",
+		"cannot lock ref",
+		"failed to update ref",
+		"[rejected]",
+		"non-fast-forward",
+		"fetch first",
+	} {
+		if strings.Contains(msg, symptom) {
+			return true
+		}
+	}
+	return false
+}
+
+func worktreeHead(ctx context.Context, worktreePath string) (string, error) {
+	head, err := RunGitCommand(ctx, worktreePath, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(head), nil
+}
+
+// reapplyChanges moves the changes exported to the worktree on top of its HEAD, when they were made from an older
+// commit (base). The worktree is left with the changes staged.
+func (r *Repository) reapplyChanges(ctx context.Context, env *environment.Environment, worktreePath, base string) error {
+	return r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+		if err := r.addNonBinaryFiles(ctx, worktreePath, env.State.SubmodulePaths); err != nil {
+			return err
+		}
+		tree, err := RunGitCommand(ctx, worktreePath, "write-tree")
+		if err != nil {
+			return err
+		}
+		patch, err := RunGitCommand(ctx, worktreePath, "diff", "--binary", base, strings.TrimSpace(tree))
+		if err != nil {
+			return err
+		}
+		if _, err := RunGitCommand(ctx, worktreePath, "reset", "-q", "--hard", "HEAD"); err != nil {
+			return err
+		}
+		if strings.TrimSpace(patch) == "" {
+			return nil
+		}
+
+		f, err := os.CreateTemp(os.TempDir(), ".container-use-patch-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := f.WriteString(patch); err != nil {
+			return err
+		}
+
+		if _, err := RunGitCommand(ctx, worktreePath, "apply", "--3way", "--binary", "--index", f.Name()); err != nil {
+			if _, resetErr := RunGitCommand(ctx, worktreePath, "reset", "-q", "--hard", "HEAD"); resetErr != nil {
+				slog.Error("Failed to reset worktree after conflicting update", "environment.id", env.ID, "err", resetErr)
+			}
+			return fmt.Errorf("%w: %w", errConflictingUpdate, err)
+		}
+		return nil
+	})
+}
+
+// syncConcurrentChanges copies the files changed on the branch between base and latest, by someone else, from the
+// worktree to the environment, so that its container doesn't undo them the next time it is saved.
+// skip is a file the environment itself is saving, whose version in the environment wins.
+func (r *Repository) syncConcurrentChanges(ctx context.Context, env *environment.Environment, worktreePath, base, latest, skip string) error {
+	output, err := RunGitCommand(ctx, worktreePath, "diff", "--name-status", "--no-renames", "-z", base, latest)
+	if err != nil {
+		return err
+	}
+
+	changed, deleted := parseNameStatus(output)
+	keep := func(path string) bool {
+		return path != skip && !slices.Contains(env.State.SubmodulePaths, path)
+	}
+	changed = slices.DeleteFunc(changed, func(path string) bool { return !keep(path) })
+	deleted = slices.DeleteFunc(deleted, func(path string) bool { return !keep(path) })
+	if len(changed) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	slog.Info("Syncing changes saved concurrently to the environment",
+		"environment.id", env.ID, "changed", changed, "deleted", deleted)
+	return env.SyncWorkdirFiles(ctx, worktreePath, changed, deleted)
+}
+
+// parseNameStatus parses the output of `git diff --name-status --no-renames -z` into the files that were added or
+// modified, and those that were deleted.
+func parseNameStatus(output string) (changed, deleted []string) {
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.HasPrefix(fields[i], "D") {
+			deleted = append(deleted, fields[i+1])
+		} else {
+			changed = append(changed, fields[i+1])
+		}
+	}
+	return changed, deleted
+}
+
+// catchUp brings the environment up to date with the changes saved concurrently to its branch since it was
+// loaded, if any (see syncConcurrentChanges).
+func (r *Repository) catchUp(ctx context.Context, env *environment.Environment, worktreePath, skip string) error {
+	if env.Head == "" {
+		return nil
+	}
+	latest, err := worktreeHead(ctx, worktreePath)
+	if err != nil {
+		return err
+	}
+	if latest == env.Head {
+		return nil
+	}
+	if err := r.syncConcurrentChanges(ctx, env, worktreePath, env.Head, latest, skip); err != nil {
+		return err
+	}
+	env.Head = latest
+	return nil
+}
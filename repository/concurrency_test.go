@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransientGitError(t *testing.T) {
+	assert.True(t, isTransientGitError(errors.New("git command failed (exit code 128): exit status 128\nOutput: fatal: Unable to create '/repo/.git/index.lock': File exists.")))
+	assert.True(t, isTransientGitError(errors.New("error: cannot lock ref 'refs/heads/fancy-mallard'")))
+	assert.True(t, isTransientGitError(errors.New(" ! [rejected]        refs/notes/container-use -> refs/notes/container-use (non-fast-forward)")))
+	assert.False(t, isTransientGitError(errors.New("fatal: not a git repository")))
+	assert.False(t, isTransientGitError(fmt.Errorf("%w: error: patch failed: index.lock", errConflictingUpdate)))
+}
+
+func TestParseNameStatus(t *testing.T) {
+	changed, deleted := parseNameStatus("M\x00main.go\x00A\x00dir/new file.txt\x00D\x00old.go\x00")
+	assert.Equal(t, []string{"main.go", "dir/new file.txt"}, changed)
+	assert.Equal(t, []string{"old.go"}, deleted)
+
+	changed, deleted = parseNameStatus("")
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted)
+}
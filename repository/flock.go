@@ -24,6 +24,8 @@ const (
 	// LockTypeNotes - Subset of fork repo operations for saving state, notes etc
 	// Notes are a global ref to that repository and we do many operations against them
 	LockTypeNotes LockType = "notes"
+	// LockTypeIdempotency - Updates of the idempotency keys of environment creations
+	LockTypeIdempotency LockType = "idempotency"
 )
 
 // RepositoryLockManager provides granular process-level locking for repository operations
@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// FsckIssue is an inconsistency between the branch of an environment, its worktree and the state stored with it.
+type FsckIssue struct {
+	// Problem describes the inconsistency.
+	Problem string `json:"problem"`
+	// Fix describes how the issue is fixed, empty if it can't be fixed automatically.
+	Fix string `json:"fix,omitempty"`
+	// Fixed reports whether the issue was fixed.
+	Fixed bool `json:"fixed,omitempty"`
+
+	fix func(ctx context.Context) error
+}
+
+// Fsck checks that the container-use/<id> branch of an environment, its worktree and the state and configuration
+// stored with it agree, e.g. after an interrupted operation. With fix, the issues found are fixed in order: fixing
+// the worktree comes first, as restoring the state relies on it.
+func (r *Repository) Fsck(ctx context.Context, id string, fix bool) ([]*FsckIssue, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var issues []*FsckIssue
+	err := r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		head, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "refs/heads/"+id)
+		if err != nil {
+			return err
+		}
+		head = strings.TrimSpace(head)
+
+		worktreePath, err := r.WorktreePath(id)
+		if err != nil {
+			return err
+		}
+
+		issues = append(issues, r.fsckRemoteBranch(ctx, id, head)...)
+		issues = append(issues, r.fsckWorktree(ctx, id, worktreePath)...)
+		stateIssues, err := r.fsckState(ctx, id, head, worktreePath)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, stateIssues...)
+
+		if !fix {
+			return nil
+		}
+		for _, issue := range issues {
+			if issue.fix == nil {
+				continue
+			}
+			if err := issue.fix(ctx); err != nil {
+				return fmt.Errorf("failed to fix %q: %w", issue.Problem, err)
+			}
+			issue.Fixed = true
+		}
+		return nil
+	})
+	return issues, err
+}
+
+// fsckRemoteBranch checks that the container-use remote branch of the user's repository, which commands like diff
+// and merge use, is up to date with the environment's branch.
+func (r *Repository) fsckRemoteBranch(ctx context.Context, id, head string) []*FsckIssue {
+	ref := fmt.Sprintf("refs/remotes/%s/%s", containerUseRemote, id)
+	fetch := func(ctx context.Context) error {
+		_, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id)
+		return err
+	}
+
+	remote, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", ref)
+	if err != nil {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("%s is missing from the repository", ref),
+			Fix:     "fetch the environment's branch",
+			fix:     fetch,
+		}}
+	}
+	if remote = strings.TrimSpace(remote); remote != head {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("%s is at %s instead of %s", ref, shortCommit(remote), shortCommit(head)),
+			Fix:     "fetch the environment's branch",
+			fix:     fetch,
+		}}
+	}
+	return nil
+}
+
+// fsckWorktree checks that the worktree of the environment is usable and has no uncommitted changes: every change
+// made by the environment's tools is committed, so changes left behind come from an interrupted operation.
+// Untracked files are not reported, as files container-use doesn't commit (e.g. binaries) are left untracked.
+func (r *Repository) fsckWorktree(ctx context.Context, id, worktreePath string) []*FsckIssue {
+	recreate := func(ctx context.Context) error {
+		return r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+			return r.recreateWorktree(ctx, worktreePath, id)
+		})
+	}
+
+	if _, err := os.Stat(worktreePath); err != nil {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("worktree %s is missing", worktreePath),
+			Fix:     "recreate the worktree from the environment's branch",
+			fix:     recreate,
+		}}
+	}
+	if err := r.checkWorktree(ctx, worktreePath, id); err != nil {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("worktree %s is unusable: %v", worktreePath, err),
+			Fix:     "recreate the worktree from the environment's branch",
+			fix:     recreate,
+		}}
+	}
+
+	status, err := RunGitCommand(ctx, worktreePath, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("worktree %s is unusable: %v", worktreePath, err),
+			Fix:     "recreate the worktree from the environment's branch",
+			fix:     recreate,
+		}}
+	}
+	if status = strings.TrimRight(status, "\n"); status != "" {
+		files := []string{}
+		for _, line := range strings.Split(status, "\n") {
+			if len(line) > 3 {
+				files = append(files, line[3:])
+			}
+		}
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("worktree %s has uncommitted changes: %s", worktreePath, strings.Join(files, ", ")),
+			Fix:     "recreate the worktree from the environment's branch, discarding them",
+			fix:     recreate,
+		}}
+	}
+	return nil
+}
+
+// fsckState checks that the latest commit of the environment's branch has a readable state, holding a valid
+// configuration.
+func (r *Repository) fsckState(ctx context.Context, id, head, worktreePath string) ([]*FsckIssue, error) {
+	state, err := r.readState(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.err != nil {
+		problem := fmt.Sprintf("the latest commit %s has no state", shortCommit(head))
+		if state != nil {
+			problem = fmt.Sprintf("the state of the latest commit %s is unreadable: %v", shortCommit(head), state.err)
+		}
+		return []*FsckIssue{r.restoreStateIssue(ctx, id, head, problem)}, nil
+	}
+
+	if state.Config == nil {
+		return []*FsckIssue{{
+			Problem: "the state has no configuration",
+			Fix:     "store the configuration of the worktree in the state",
+			fix: func(ctx context.Context) error {
+				config := environment.DefaultConfig()
+				if err := config.Load(worktreePath); err != nil {
+					return err
+				}
+				state.Config = config
+				return r.saveInfo(ctx, &environment.EnvironmentInfo{ID: id, State: state.State})
+			},
+		}}, nil
+	}
+	if err := state.Config.Validate(); err != nil {
+		return []*FsckIssue{{
+			Problem: fmt.Sprintf("the configuration stored in the state is invalid: %v", err),
+		}}, nil
+	}
+	return nil, nil
+}
+
+// restoreStateIssue reports a missing or unreadable state, fixed by restoring the most recent readable state of the
+// environment's history. Changes made to the state since are lost.
+func (r *Repository) restoreStateIssue(ctx context.Context, id, head, problem string) *FsckIssue {
+	history, err := RunGitCommand(ctx, r.forkRepoPath, "rev-list", "--skip=1", head)
+	if err != nil {
+		return &FsckIssue{Problem: problem}
+	}
+	notes, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesStateRef, "list")
+	if err != nil {
+		return &FsckIssue{Problem: problem}
+	}
+	annotated := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(notes), "\n") {
+		if _, commit, ok := strings.Cut(line, " "); ok {
+			annotated[commit] = true
+		}
+	}
+
+	for _, commit := range strings.Fields(history) {
+		if !annotated[commit] {
+			continue
+		}
+		state, err := r.readState(ctx, commit)
+		if err != nil || state == nil || state.err != nil {
+			continue
+		}
+		return &FsckIssue{
+			Problem: problem,
+			Fix:     fmt.Sprintf("restore the state of commit %s", shortCommit(commit)),
+			fix: func(ctx context.Context) error {
+				return r.saveInfo(ctx, &environment.EnvironmentInfo{ID: id, State: state.State})
+			},
+		}
+	}
+	return &FsckIssue{Problem: problem + ", and no earlier commit has a readable state"}
+}
+
+// storedState is a state read by readState, or the error it failed to be parsed with.
+type storedState struct {
+	*environment.State
+	err error
+}
+
+// readState reads the state stored with a commit of the fork repository. It returns nil if the commit has none.
+func (r *Repository) readState(ctx context.Context, commit string) (*storedState, error) {
+	var data string
+	err := r.lockManager.WithRLock(ctx, LockTypeNotes, func() error {
+		var err error
+		data, err = RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesStateRef, "show", commit)
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no note found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := &environment.State{}
+	if err := state.Unmarshal([]byte(data)); err != nil {
+		return &storedState{err: err}, nil
+	}
+	return &storedState{State: state}, nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
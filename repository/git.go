@@ -91,7 +91,7 @@ func (r *Repository) deleteWorktree(id string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Deleting worktree at %s\n", worktreePath)
+	slog.Info("Deleting worktree", "path", worktreePath)
 	return os.RemoveAll(worktreePath)
 }
 
@@ -183,6 +183,7 @@ func (r *Repository) initializeWorktree(ctx context.Context, id, gitRef string)
 }
 
 // getWorktree gets or recreates a worktree for an existing environment.
+// Worktrees left in a broken state (e.g. by an interrupted git operation) are recreated from the environment branch.
 // It assumes the environment branch already exists in the forkRepo and will fail if it doesn't.
 func (r *Repository) getWorktree(ctx context.Context, id string) (string, error) {
 	worktreePath, err := r.WorktreePath(id)
@@ -190,39 +191,94 @@ func (r *Repository) getWorktree(ctx context.Context, id string) (string, error)
 		return "", err
 	}
 
-	// Early return if the worktree already exists
+	// Early return if the worktree already exists and is usable
 	if _, err := os.Stat(worktreePath); err == nil {
-		return worktreePath, nil
+		err := r.checkWorktree(ctx, worktreePath, id)
+		if err == nil {
+			return worktreePath, nil
+		}
+		slog.Warn("Worktree is corrupted", "repository", r.userRepoPath, "environment-id", id, "err", err)
 	}
 
-	slog.Info("Recreating worktree for existing environment", "repository", r.userRepoPath, "environment-id", id)
-
 	return worktreePath, r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
 		// In case something has changed while waiting for lock. prolly too defensive.
 		if _, err := os.Stat(worktreePath); err == nil {
-			return nil
+			if r.checkWorktree(ctx, worktreePath, id) == nil {
+				return nil
+			}
 		}
 
-		// Verify the environment branch exists in forkRepo before creating worktree
-		_, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id)
-		if err != nil {
-			return fmt.Errorf("environment branch %s not found in fork repository: %w", id, err)
-		}
+		slog.Info("Recreating worktree for existing environment", "repository", r.userRepoPath, "environment-id", id)
+		return r.recreateWorktree(ctx, worktreePath, id)
+	})
+}
 
-		_, err = RunGitCommand(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id)
-		if err != nil {
-			return err
-		}
+// RepairWorktree unconditionally recreates the worktree of an environment from its branch.
+// The branch holds the truth, so nothing is lost.
+func (r *Repository) RepairWorktree(ctx context.Context, id string) error {
+	if err := r.exists(ctx, id); err != nil {
+		return err
+	}
 
-		_, err = RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id)
-		if err != nil {
-			return err
-		}
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return err
+	}
 
-		return nil
+	return r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+		return r.recreateWorktree(ctx, worktreePath, id)
 	})
 }
 
+// recreateWorktree removes whatever is left of a worktree and checks out the environment branch again.
+// The fork repository lock must be held.
+func (r *Repository) recreateWorktree(ctx context.Context, worktreePath, id string) error {
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return fmt.Errorf("failed to remove worktree: %w", err)
+	}
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "worktree", "prune"); err != nil {
+		return err
+	}
+
+	// Verify the environment branch exists in forkRepo before creating worktree
+	_, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id)
+	if err != nil {
+		return fmt.Errorf("environment branch %s not found in fork repository: %w", id, err)
+	}
+
+	_, err = RunGitCommand(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkWorktree verifies that the worktree of an environment is checked out on the environment branch
+// and isn't in the middle of a git operation.
+func (r *Repository) checkWorktree(ctx context.Context, worktreePath, id string) error {
+	out, err := RunGitCommand(ctx, worktreePath, "rev-parse", "--absolute-git-dir", "--symbolic-full-name", "HEAD")
+	if err != nil {
+		return err
+	}
+	gitDir, head, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	if head = strings.TrimSpace(head); head != "refs/heads/"+id {
+		return fmt.Errorf("worktree is on %q instead of the environment branch", head)
+	}
+
+	for _, name := range []string{"index.lock", "MERGE_HEAD", "CHERRY_PICK_HEAD", "rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(strings.TrimSpace(gitDir), name)); err == nil {
+			return fmt.Errorf("interrupted git operation left %s behind", name)
+		}
+	}
+	return nil
+}
+
 // createInitialCommit creates an empty commit with the environment creation message - this prevents multiple environments from overwriting the container-use-state on the parent commit
 func (r *Repository) createInitialCommit(ctx context.Context, worktreePath, id, title string) error {
 	commitMessage := fmt.Sprintf("Create environment %s: %s", id, title)
@@ -243,10 +299,30 @@ func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.E
 			"err", rerr)
 	}()
 
+	worktreePath, err := r.WorktreePath(env.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get worktree path: %w", err)
+	}
+	base := env.Head
+
 	if err := r.exportEnvironment(ctx, env); err != nil {
 		return err
 	}
 
+	latest, err := worktreeHead(ctx, worktreePath)
+	if err != nil {
+		return err
+	}
+	if base != "" && base != latest {
+		// The branch moved since the environment was loaded: the export would undo the changes saved meanwhile.
+		if err := r.reapplyChanges(ctx, env, worktreePath, base); err != nil {
+			return err
+		}
+		if err := r.catchUp(ctx, env, worktreePath, ""); err != nil {
+			return err
+		}
+	}
+
 	return r.propagateToGit(ctx, env, explanation)
 }
 
@@ -257,11 +333,19 @@ func (r *Repository) propagateToGit(ctx context.Context, env *environment.Enviro
 		return fmt.Errorf("failed to get worktree path: %w", err)
 	}
 
+	previous, err := worktreeHead(ctx, worktreePath)
+	if err != nil {
+		return err
+	}
 	if err := r.commitWorktreeChanges(ctx, worktreePath, explanation, env.State.SubmodulePaths); err != nil {
 		return fmt.Errorf("failed to commit worktree changes: %w", err)
 	}
+	if env.Head, err = worktreeHead(ctx, worktreePath); err != nil {
+		return err
+	}
+	committed := env.Head != previous
 
-	if err := r.saveState(ctx, env); err != nil {
+	if err := r.saveState(ctx, env.EnvironmentInfo); err != nil {
 		return fmt.Errorf("failed to add notes: %w", err)
 	}
 
@@ -277,6 +361,12 @@ func (r *Repository) propagateToGit(ctx context.Context, env *environment.Enviro
 		return err
 	}
 
+	if committed {
+		if err := r.addCommitMetadata(ctx, env.ID, worktreePath); err != nil {
+			return err
+		}
+	}
+
 	if note := env.Notes.Pop(); note != "" {
 		return r.addGitNote(ctx, env, note)
 	}
@@ -338,6 +428,14 @@ func (r *Repository) propagateFileToWorktree(ctx context.Context, env *environme
 		return err
 	}
 
+	worktreePath, err := r.WorktreePath(env.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get worktree path: %w", err)
+	}
+	if err := r.catchUp(ctx, env, worktreePath, filePath); err != nil {
+		return err
+	}
+
 	return r.propagateToGit(ctx, env, explanation)
 }
 
@@ -411,7 +509,7 @@ func (r *Repository) propagateGitNotes(ctx context.Context, ref string) error {
 	})
 }
 
-func (r *Repository) saveState(ctx context.Context, env *environment.Environment) error {
+func (r *Repository) saveState(ctx context.Context, env *environment.EnvironmentInfo) error {
 	state, err := env.State.Marshal()
 	if err != nil {
 		return err
@@ -430,10 +528,14 @@ func (r *Repository) saveState(ctx context.Context, env *environment.Environment
 		return err
 	}
 
-	return r.lockManager.WithLock(ctx, LockTypeNotes, func() error {
+	if err := r.lockManager.WithLock(ctx, LockTypeNotes, func() error {
 		_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "add", "-f", "-F", f.Name())
 		return err
-	})
+	}); err != nil {
+		return err
+	}
+	r.reindex(ctx, env)
+	return nil
 }
 
 func (r *Repository) loadState(ctx context.Context, worktreePath string) ([]byte, error) {
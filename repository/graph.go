@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// Fork is an environment created from the branch of another one, e.g. by an agent exploring an alternative.
+type Fork struct {
+	Parent string `json:"parent"`
+	Child  string `json:"child"`
+	// MergeBase is the most recent commit the environments have in common, empty if the parent was deleted.
+	MergeBase string `json:"merge_base,omitempty"`
+}
+
+// forkParent returns the environment whose branch gitRef designates (e.g. "container-use/fancy-mallard"),
+// or an empty string if gitRef isn't the branch of an environment.
+func (r *Repository) forkParent(ctx context.Context, gitRef string) string {
+	ref := strings.TrimPrefix(gitRef, "refs/remotes/")
+	id, ok := strings.CutPrefix(ref, containerUseRemote+"/")
+	if !ok || id == "" {
+		return ""
+	}
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+id); err != nil {
+		return ""
+	}
+	return id
+}
+
+// Forks returns the fork relationships between environments, along with the commit each fork has
+// in common with its parent.
+func (r *Repository) Forks(ctx context.Context, envs []*environment.EnvironmentInfo) []Fork {
+	forks := []Fork{}
+	for _, env := range envs {
+		if env.State.Parent == "" {
+			continue
+		}
+		fork := Fork{Parent: env.State.Parent, Child: env.ID}
+		if mergeBase, err := RunGitCommand(ctx, r.forkRepoPath, "merge-base", "refs/heads/"+fork.Parent, "refs/heads/"+fork.Child); err == nil {
+			fork.MergeBase = strings.TrimSpace(mergeBase)
+		}
+		forks = append(forks, fork)
+	}
+	return forks
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+// IdempotencyTTL is how long an idempotency key keeps returning the environment created with it.
+const IdempotencyTTL = 24 * time.Hour
+
+// idempotencyKeyLockType serializes the creations with the same idempotency key, so that a retry arriving while
+// the first request is still creating the environment waits for it rather than creating another one.
+func idempotencyKeyLockType(key string) LockType {
+	return LockType(fmt.Sprintf("idempotency-%x", hashString(key)))
+}
+
+// idempotencyEntry is the environment created with an idempotency key.
+type idempotencyEntry struct {
+	Environment string    `json:"environment"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateIdempotent returns the environment created with key in the last IdempotencyTTL, if it still exists,
+// and otherwise creates one with create. It reports whether the environment already existed, e.g. when a client
+// retries a request whose response was lost.
+func (r *Repository) CreateIdempotent(ctx context.Context, dag *dagger.Client, key string, create func() (*environment.Environment, error)) (*environment.Environment, bool, error) {
+	var env *environment.Environment
+	var existed bool
+	err := r.lockManager.WithLock(ctx, idempotencyKeyLockType(key), func() error {
+		id, err := r.idempotentEnvironment(ctx, key)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			if env, err = r.Get(ctx, dag, id); err == nil {
+				existed = true
+				return nil
+			}
+			slog.Warn("Environment of idempotency key is gone, creating a new one", "environment.id", id, "err", err)
+		}
+
+		if env, err = create(); err != nil {
+			return err
+		}
+		return r.recordIdempotencyKey(ctx, key, env.ID)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return env, existed, nil
+}
+
+func (r *Repository) idempotencyPath() string {
+	return filepath.Join(r.forkRepoPath, "container-use-idempotency.json")
+}
+
+// idempotentEnvironment returns the ID of the environment created with key, or "" if there is none or it expired.
+func (r *Repository) idempotentEnvironment(ctx context.Context, key string) (string, error) {
+	var id string
+	err := r.lockManager.WithRLock(ctx, LockTypeIdempotency, func() error {
+		entries, err := r.loadIdempotencyKeys()
+		if err != nil {
+			return err
+		}
+		if entry, ok := entries[key]; ok && time.Since(entry.CreatedAt) < IdempotencyTTL {
+			id = entry.Environment
+		}
+		return nil
+	})
+	return id, err
+}
+
+// recordIdempotencyKey maps key to an environment, and drops the expired keys.
+func (r *Repository) recordIdempotencyKey(ctx context.Context, key, id string) error {
+	return r.lockManager.WithLock(ctx, LockTypeIdempotency, func() error {
+		entries, err := r.loadIdempotencyKeys()
+		if err != nil {
+			return err
+		}
+		maps.DeleteFunc(entries, func(_ string, entry idempotencyEntry) bool {
+			return time.Since(entry.CreatedAt) >= IdempotencyTTL
+		})
+		entries[key] = idempotencyEntry{Environment: id, CreatedAt: time.Now()}
+		return r.writeIdempotencyKeys(entries)
+	})
+}
+
+func (r *Repository) writeIdempotencyKeys(entries map[string]idempotencyEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.idempotencyPath(), data, 0644)
+}
+
+func (r *Repository) loadIdempotencyKeys() (map[string]idempotencyEntry, error) {
+	entries := map[string]idempotencyEntry{}
+	data, err := os.ReadFile(r.idempotencyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid idempotency keys file %s: %w", r.idempotencyPath(), err)
+	}
+	return entries, nil
+}
+
+// renameIdempotentEnvironment makes the idempotency keys of a renamed environment return it under its new ID.
+func (r *Repository) renameIdempotentEnvironment(ctx context.Context, oldID, newID string) error {
+	return r.lockManager.WithLock(ctx, LockTypeIdempotency, func() error {
+		entries, err := r.loadIdempotencyKeys()
+		if err != nil {
+			return err
+		}
+		renamed := false
+		for key, entry := range entries {
+			if entry.Environment == oldID {
+				entry.Environment = newID
+				entries[key] = entry
+				renamed = true
+			}
+		}
+		if !renamed {
+			return nil
+		}
+		return r.writeIdempotencyKeys(entries)
+	})
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	repo := &Repository{
+		forkRepoPath: dir,
+		lockManager:  NewRepositoryLockManager(dir),
+	}
+
+	id, err := repo.idempotentEnvironment(ctx, "request-1")
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	require.NoError(t, repo.recordIdempotencyKey(ctx, "request-1", "fancy-mallard"))
+	id, err = repo.idempotentEnvironment(ctx, "request-1")
+	require.NoError(t, err)
+	assert.Equal(t, "fancy-mallard", id)
+
+	// Expired keys are ignored, and dropped on the next write
+	entries, err := repo.loadIdempotencyKeys()
+	require.NoError(t, err)
+	entries["request-0"] = idempotencyEntry{Environment: "quick-otter", CreatedAt: time.Now().Add(-IdempotencyTTL - time.Minute)}
+	data, err := json.Marshal(entries)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(repo.idempotencyPath(), data, 0644))
+
+	id, err = repo.idempotentEnvironment(ctx, "request-0")
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	require.NoError(t, repo.recordIdempotencyKey(ctx, "request-2", "calm-heron"))
+	entries, err = repo.loadIdempotencyKeys()
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.NotContains(t, entries, "request-0")
+	assert.Equal(t, "calm-heron", entries["request-2"].Environment)
+}
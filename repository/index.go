@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// LockTypeIndex - Updates of the file index: entries are written under a shared lock, Reset takes it exclusively
+const LockTypeIndex LockType = "index"
+
+// Reindex rebuilds the index of the environments' metadata from their branches, e.g. after they were changed by a version of
+// container-use that doesn't maintain it, and returns the number of indexed environments.
+// Without an index, one is created next to the environments' branches: it is used from then on, until
+// DisableIndex. Listing environments from it doesn't read every branch, which gets slow with thousands of
+// environments. Git remains the source of truth: the index is updated as environments are saved and deleted.
+func (r *Repository) Reindex(ctx context.Context) (int, error) {
+	envs, err := r.listFromGit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if r.index == nil {
+		if err := os.MkdirAll(r.fileIndexPath(), 0755); err != nil {
+			return 0, err
+		}
+		r.index = newFileIndex(r.fileIndexPath(), r.lockManager)
+	}
+	if err := r.index.Reset(ctx, envs); err != nil {
+		return 0, fmt.Errorf("failed to rebuild index: %w", err)
+	}
+	return len(envs), nil
+}
+
+// DisableIndex removes the file index created by Reindex: environments are listed from git again.
+func (r *Repository) DisableIndex() error {
+	if err := os.RemoveAll(r.fileIndexPath()); err != nil {
+		return err
+	}
+	r.index = nil
+	return nil
+}
+
+func (r *Repository) fileIndexPath() string {
+	return fileIndexPath(r.forkRepoPath)
+}
+
+// fileIndexPath is the directory of the file index of the environments of a fork repository.
+func fileIndexPath(forkRepoPath string) string {
+	return filepath.Join(forkRepoPath, "container-use-index")
+}
+
+// reindex records the latest metadata of an environment in the index, if any.
+// Git is the source of truth, so failures are logged rather than failing the operation: Reindex fixes them.
+func (r *Repository) reindex(ctx context.Context, env *environment.EnvironmentInfo) {
+	if r.index == nil {
+		return
+	}
+	if err := r.index.Put(ctx, env); err != nil {
+		slog.Warn("failed to index environment, run `container-use env reindex` to fix the index", "environment.id", env.ID, "err", err)
+	}
+}
+
+// unindex removes a deleted environment from the index, if any.
+func (r *Repository) unindex(ctx context.Context, id string) {
+	if r.index == nil {
+		return
+	}
+	if err := r.index.Delete(ctx, id); err != nil {
+		slog.Warn("failed to remove environment from the index, run `container-use env reindex` to fix the index", "environment.id", id, "err", err)
+	}
+}
+
+// fileIndex caches the metadata of environments in a directory, one JSON file per environment, so that updating
+// an environment only rewrites its own entry.
+type fileIndex struct {
+	path  string
+	locks *RepositoryLockManager
+}
+
+func newFileIndex(path string, locks *RepositoryLockManager) *fileIndex {
+	return &fileIndex{path: path, locks: locks}
+}
+
+// indexedState is the part of the state of an environment the index keeps: what listing environments shows,
+// without e.g. the container, service endpoints and checkpoints.
+func indexedState(state *environment.State) *environment.State {
+	return &environment.State{
+		CreatedAt:          state.CreatedAt,
+		UpdatedAt:          state.UpdatedAt,
+		Config:             state.Config,
+		Title:              state.Title,
+		SourcePath:         state.SourcePath,
+		BackgroundCommands: state.BackgroundCommands,
+		Paused:             state.Paused,
+		Pinned:             state.Pinned,
+		Locked:             state.Locked,
+		Promoted:           state.Promoted,
+		Scratch:            state.Scratch,
+		Parent:             state.Parent,
+		BuildStatus:        state.BuildStatus,
+	}
+}
+
+// Put adds or replaces the metadata of an environment.
+func (i *fileIndex) Put(ctx context.Context, env *environment.EnvironmentInfo) error {
+	return i.locks.WithRLock(ctx, LockTypeIndex, func() error {
+		return i.write(env)
+	})
+}
+
+// Delete removes an environment. Deleting an unknown environment is not an error.
+func (i *fileIndex) Delete(ctx context.Context, id string) error {
+	return i.locks.WithRLock(ctx, LockTypeIndex, func() error {
+		if err := os.Remove(i.entryPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// List returns the metadata of all the environments, in any order.
+func (i *fileIndex) List(ctx context.Context) ([]*environment.EnvironmentInfo, error) {
+	var envs []*environment.EnvironmentInfo
+	err := i.locks.WithRLock(ctx, LockTypeIndex, func() error {
+		var err error
+		envs, err = i.load()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// Reset replaces the contents of the index.
+func (i *fileIndex) Reset(ctx context.Context, envs []*environment.EnvironmentInfo) error {
+	return i.locks.WithLock(ctx, LockTypeIndex, func() error {
+		entries, err := os.ReadDir(i.path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := os.Remove(filepath.Join(i.path, entry.Name())); err != nil {
+				return err
+			}
+		}
+		for _, env := range envs {
+			if err := i.write(env); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (i *fileIndex) entryPath(id string) string {
+	return filepath.Join(i.path, id+".json")
+}
+
+func (i *fileIndex) load() ([]*environment.EnvironmentInfo, error) {
+	entries, err := os.ReadDir(i.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*environment.EnvironmentInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	envs := make([]*environment.EnvironmentInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(i.path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		env := &environment.EnvironmentInfo{}
+		if err := json.Unmarshal(data, env); err != nil {
+			return nil, fmt.Errorf("corrupted index entry %s: %w", entry.Name(), err)
+		}
+		if env.State == nil {
+			return nil, fmt.Errorf("corrupted index entry %s: no state", entry.Name())
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// write saves the entry of an environment atomically, so that readers never see a partial file.
+func (i *fileIndex) write(env *environment.EnvironmentInfo) error {
+	data, err := json.Marshal(&environment.EnvironmentInfo{ID: env.ID, State: indexedState(env.State)})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(i.path, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), i.entryPath(env.ID))
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileIndex(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	path := fileIndexPath(dir)
+	require.NoError(t, os.Mkdir(path, 0755))
+	index := newFileIndex(path, NewRepositoryLockManager(dir))
+
+	envs, err := index.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+
+	info := func(id, title string) *environment.EnvironmentInfo {
+		return &environment.EnvironmentInfo{
+			ID:    id,
+			State: &environment.State{Title: title, UpdatedAt: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)},
+		}
+	}
+
+	require.NoError(t, index.Put(ctx, info("fancy-mallard", "Fix the flaky test")))
+	require.NoError(t, index.Put(ctx, info("quick-otter", "Try a mutex")))
+	require.NoError(t, index.Put(ctx, info("fancy-mallard", "Fix the flaky test for good")))
+
+	envs, err = index.List(ctx)
+	require.NoError(t, err)
+	sortByUpdate(envs)
+	titles := map[string]string{}
+	for _, env := range envs {
+		titles[env.ID] = env.State.Title
+	}
+	assert.Equal(t, map[string]string{"fancy-mallard": "Fix the flaky test for good", "quick-otter": "Try a mutex"}, titles)
+
+	require.NoError(t, index.Delete(ctx, "quick-otter"))
+	require.NoError(t, index.Delete(ctx, "unknown"))
+	envs, err = index.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.Equal(t, "fancy-mallard", envs[0].ID)
+
+	require.NoError(t, index.Reset(ctx, []*environment.EnvironmentInfo{info("calm-heron", "Try channels")}))
+	envs, err = index.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.Equal(t, "calm-heron", envs[0].ID)
+}
+
+func TestFileIndexEntries(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	path := fileIndexPath(dir)
+	require.NoError(t, os.Mkdir(path, 0755))
+	index := newFileIndex(path, NewRepositoryLockManager(dir))
+
+	// Each environment has an entry of its own, without the bulk of its state
+	require.NoError(t, index.Put(ctx, &environment.EnvironmentInfo{
+		ID: "fancy-mallard",
+		State: &environment.State{
+			Title:            "Fix the flaky test",
+			Container:        "a-very-long-container-id",
+			Checkpoints:      []string{"registry.example.com/app:checkpoint"},
+			ServiceEndpoints: map[string]environment.EndpointMappings{"db": {}},
+			Locked:           true,
+		},
+	}))
+	require.NoError(t, index.Put(ctx, &environment.EnvironmentInfo{ID: "quick-otter", State: &environment.State{Title: "Try a mutex"}}))
+	assert.FileExists(t, filepath.Join(path, "fancy-mallard.json"))
+	assert.FileExists(t, filepath.Join(path, "quick-otter.json"))
+
+	envs, err := index.List(ctx)
+	require.NoError(t, err)
+	sortByID := func(a, b *environment.EnvironmentInfo) int { return strings.Compare(a.ID, b.ID) }
+	slices.SortFunc(envs, sortByID)
+	require.Len(t, envs, 2)
+	assert.Equal(t, &environment.State{Title: "Fix the flaky test", Locked: true}, envs[0].State)
+}
+
+func TestFileIndexDeleteWithoutIndex(t *testing.T) {
+	// Orphan cleanup drops environments from the file index of repositories that may not have one
+	dir := t.TempDir()
+	path := fileIndexPath(dir)
+	require.NoError(t, newFileIndex(path, NewRepositoryLockManager(dir)).Delete(t.Context(), "fancy-mallard"))
+	assert.NoDirExists(t, path)
+}
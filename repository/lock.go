@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// ErrLocked is returned when changes to a locked environment are saved, see Lock.
+var ErrLocked = errors.New("environment is locked")
+
+// Lock protects the work of an environment, e.g. once it has been reviewed: saving changes to the environment
+// fails with ErrLocked until it is unlocked. Agents still running in the environment can read it, but their
+// changes are lost. Locked environments are never pruned.
+func (r *Repository) Lock(ctx context.Context, id string) error {
+	return r.setLocked(ctx, id, true)
+}
+
+// Unlock lets changes to an environment locked by Lock be saved again.
+func (r *Repository) Unlock(ctx context.Context, id string) error {
+	return r.setLocked(ctx, id, false)
+}
+
+func (r *Repository) setLocked(ctx context.Context, id string, locked bool) error {
+	// Hold the environment's lock so that an update in progress either completes before or sees the lock
+	return r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		envInfo.State.Locked = locked
+		return r.saveInfo(ctx, envInfo)
+	})
+}
+
+// CheckUnlocked returns an error wrapping ErrLocked if the environment is locked.
+func CheckUnlocked(envInfo *environment.EnvironmentInfo) error {
+	if envInfo.State.Locked {
+		return fmt.Errorf("%w: %s holds finalized work and can't be modified, unless the user runs `container-use env unlock %s`", ErrLocked, envInfo.ID, envInfo.ID)
+	}
+	return nil
+}
+
+// checkUnlocked checks the saved state of an environment rather than the one of an instance loaded before
+// the environment was locked.
+func (r *Repository) checkUnlocked(ctx context.Context, id string) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+	return CheckUnlocked(envInfo)
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// MergeCheck is the outcome of a trial merge of an environment into the current branch.
+type MergeCheck struct {
+	Environment string `json:"environment"`
+	// Mergeable is set when the environment merges without conflicts.
+	Mergeable bool            `json:"mergeable"`
+	Conflicts []MergeConflict `json:"conflicts,omitempty"`
+}
+
+// MergeConflict is a file that can't be merged automatically.
+type MergeConflict struct {
+	Path string `json:"path"`
+	// Messages are git's explanations of the conflict, e.g. "CONFLICT (content): Merge conflict in main.go".
+	Messages []string `json:"messages,omitempty"`
+}
+
+// CheckMerge performs a trial merge of the environment into the current HEAD of the source repository and
+// reports the conflicting files. Neither the branch nor the working directory is modified.
+// Uncommitted changes aren't taken into account: merges stash them beforehand.
+func (r *Repository) CheckMerge(ctx context.Context, id string) (*MergeCheck, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"merge-tree", "--write-tree", "--name-only", "-z", "HEAD", "container-use/" + envInfo.ID}
+	slog.Info(fmt.Sprintf("[%s] $ git %s", r.userRepoPath, strings.Join(args, " ")))
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.userRepoPath
+	output, err := cmd.Output()
+
+	check := &MergeCheck{Environment: envInfo.ID}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		check.Mergeable = true
+		return check, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		// Exit code 1 means the merge has conflicts
+		check.Conflicts = parseMergeTreeConflicts(string(output))
+		return check, nil
+	case errors.As(err, &exitErr):
+		return nil, fmt.Errorf("git merge-tree failed (exit code %d): %w\nOutput: %s", exitErr.ExitCode(), err, string(exitErr.Stderr))
+	default:
+		return nil, fmt.Errorf("git merge-tree failed: %w", err)
+	}
+}
+
+// parseMergeTreeConflicts parses the output of `git merge-tree --write-tree --name-only -z`:
+// the tree, the conflicted files, an empty field, then for each message the number of paths it is about,
+// the paths, the type of message and the message itself.
+func parseMergeTreeConflicts(output string) []MergeConflict {
+	fields := strings.Split(output, "\x00")
+	if len(fields) < 2 {
+		return nil
+	}
+
+	conflicts := []MergeConflict{}
+	index := map[string]int{}
+	i := 1 // Skip the tree
+	for ; i < len(fields) && fields[i] != ""; i++ {
+		index[fields[i]] = len(conflicts)
+		conflicts = append(conflicts, MergeConflict{Path: fields[i]})
+	}
+	i++ // Skip the end of the conflicted files
+
+	for i < len(fields) && fields[i] != "" {
+		var count int
+		if _, err := fmt.Sscanf(fields[i], "%d", &count); err != nil || i+count+2 >= len(fields) {
+			break
+		}
+		paths := fields[i+1 : i+1+count]
+		messageType := fields[i+1+count]
+		message := strings.TrimSpace(fields[i+2+count])
+		i += count + 3
+
+		if !strings.HasPrefix(messageType, "CONFLICT") {
+			continue
+		}
+		for _, path := range paths {
+			if j, ok := index[path]; ok {
+				conflicts[j].Messages = append(conflicts[j].Messages, message)
+			}
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMergeTreeConflicts(t *testing.T) {
+	output := "7ea1210036d31b4b166f65f563c82a375c9eccc3\x00f\x00g\x00\x00" +
+		"1\x00f\x00Auto-merging\x00Auto-merging f\n\x00" +
+		"1\x00f\x00CONFLICT (contents)\x00CONFLICT (content): Merge conflict in f\n\x00" +
+		"1\x00g\x00Auto-merging\x00Auto-merging g\n\x00" +
+		"2\x00g\x00h\x00CONFLICT (rename/delete)\x00CONFLICT (rename/delete): h renamed to g in other, but deleted in HEAD.\n\x00"
+
+	assert.Equal(t, []MergeConflict{
+		{Path: "f", Messages: []string{"CONFLICT (content): Merge conflict in f"}},
+		{Path: "g", Messages: []string{"CONFLICT (rename/delete): h renamed to g in other, but deleted in HEAD."}},
+	}, parseMergeTreeConflicts(output))
+
+	assert.Empty(t, parseMergeTreeConflicts(""))
+}
+
+// TestAbortSquashMerge tests that a conflicting squash merge, which leaves no MERGE_HEAD, is undone along with
+// the stash of uncommitted changes made by --autostash
+func TestAbortSquashMerge(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		_, err := RunGitCommand(ctx, dir, args...)
+		require.NoError(t, err)
+	}
+
+	git("init", "-b", "main")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "Test User")
+	writeFile(t, dir, "conflict.txt", "base\n")
+	writeFile(t, dir, "notes.txt", "notes\n")
+	git("add", ".")
+	git("commit", "-m", "Initial commit")
+	git("checkout", "-b", "container-use/fancy-mallard")
+	writeFile(t, dir, "conflict.txt", "environment\n")
+	git("commit", "-am", "Change in the environment")
+	git("checkout", "main")
+	writeFile(t, dir, "conflict.txt", "main\n")
+	git("commit", "-am", "Change on main")
+	writeFile(t, dir, "notes.txt", "uncommitted\n")
+
+	_, mergeErr := RunGitCommand(ctx, dir, "merge", "--autostash", "--squash", "--", "container-use/fancy-mallard")
+	require.Error(t, mergeErr)
+
+	repo := &Repository{userRepoPath: dir}
+	assert.ErrorIs(t, repo.abortMerge(ctx, mergeErr), mergeErr)
+
+	status, err := RunGitCommand(ctx, dir, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Equal(t, " M notes.txt\n", status)
+	assert.Equal(t, "main\n", readTestFile(t, dir, "conflict.txt"))
+	assert.Equal(t, "uncommitted\n", readTestFile(t, dir, "notes.txt"))
+	stashes, err := RunGitCommand(ctx, dir, "stash", "list")
+	require.NoError(t, err)
+	assert.Empty(t, stashes)
+}
+
+func readTestFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mitchellh/go-homedir"
+)
+
+// OrphanedEnvironment is an environment whose source repository no longer exists.
+type OrphanedEnvironment struct {
+	ID         string
+	SourcePath string
+
+	basePath     string
+	forkRepoPath string
+}
+
+// ListOrphaned returns the environments, across all repositories, whose source repository has been removed.
+// Environments created before the source path was recorded are never reported.
+func ListOrphaned(ctx context.Context) ([]*OrphanedEnvironment, error) {
+	return ListOrphanedWithBasePath(ctx, cuGlobalConfigPath)
+}
+
+// ListOrphanedWithBasePath is like ListOrphaned with a custom base path for container-use data.
+func ListOrphanedWithBasePath(ctx context.Context, basePath string) ([]*OrphanedEnvironment, error) {
+	basePath, err := homedir.Expand(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	forks, err := findForkRepos(filepath.Join(basePath, "repos"))
+	if err != nil {
+		return nil, err
+	}
+
+	var orphaned []*OrphanedEnvironment
+	for _, fork := range forks {
+		branches, err := listBranches(ctx, fork)
+		if err != nil {
+			slog.Warn("failed to list environments", "repo", fork, "err", err)
+			continue
+		}
+
+		for _, id := range branches {
+			state, err := RunGitCommand(ctx, fork, "notes", "--ref", gitNotesStateRef, "show", id)
+			if err != nil {
+				// Not an environment
+				continue
+			}
+
+			var st environment.State
+			if err := st.Unmarshal([]byte(state)); err != nil || st.SourcePath == "" {
+				continue
+			}
+
+			if _, err := os.Stat(st.SourcePath); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			orphaned = append(orphaned, &OrphanedEnvironment{
+				ID:           id,
+				SourcePath:   st.SourcePath,
+				basePath:     basePath,
+				forkRepoPath: fork,
+			})
+		}
+	}
+
+	return orphaned, nil
+}
+
+// Delete removes the worktree and branch of an orphaned environment.
+// The fork repository is removed as well once it no longer holds any environment.
+func (o *OrphanedEnvironment) Delete(ctx context.Context) error {
+	worktreePath := filepath.Join(o.basePath, "worktrees", o.ID)
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return fmt.Errorf("failed to delete worktree: %w", err)
+	}
+
+	// The source repository is gone, so there is no user remote to prune: only clean up our fork.
+	lockManager := NewRepositoryLockManager(o.SourcePath)
+	return lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+		if _, err := RunGitCommand(ctx, o.forkRepoPath, "worktree", "prune"); err != nil {
+			return err
+		}
+		if _, err := RunGitCommand(ctx, o.forkRepoPath, "branch", "-D", o.ID); err != nil {
+			return err
+		}
+		// Drop the environment from the file index, if any, for it not to be listed anymore
+		if err := newFileIndex(fileIndexPath(o.forkRepoPath), lockManager).Delete(ctx, o.ID); err != nil {
+			slog.Warn("failed to remove orphaned environment from the index", "environment.id", o.ID, "err", err)
+		}
+
+		branches, err := listBranches(ctx, o.forkRepoPath)
+		if err != nil {
+			return err
+		}
+		if len(branches) == 0 {
+			slog.Info("Removing empty fork repository", "repo", o.forkRepoPath)
+			return os.RemoveAll(o.forkRepoPath)
+		}
+		return nil
+	})
+}
+
+// findForkRepos returns the paths of the bare fork repositories under reposPath.
+func findForkRepos(reposPath string) ([]string, error) {
+	var forks []string
+	err := filepath.WalkDir(reposPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isBareRepo(path) {
+			forks = append(forks, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return forks, err
+}
+
+func isBareRepo(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func listBranches(ctx context.Context, repoPath string) ([]string, error) {
+	output, err := RunGitCommand(ctx, repoPath, "branch", "--format", "%(refname:short)")
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	for branch := range strings.SplitSeq(output, "\n") {
+		if branch = strings.TrimSpace(branch); branch != "" {
+			branches = append(branches, branch)
+		}
+	}
+	return branches, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// Promote marks the environment as the canonical result among the attempts of its family (the environments
+// it was forked from or into, transitively), e.g. the winner picked by a human among alternatives explored by
+// agents. Other promoted environments of the family are demoted, and their IDs returned.
+func (r *Repository) Promote(ctx context.Context, id string) ([]string, error) {
+	envs, err := r.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments: %w", err)
+	}
+
+	if !slices.ContainsFunc(envs, func(env *environment.EnvironmentInfo) bool { return env.ID == id }) {
+		// Let Info report why the environment can't be found
+		if _, err := r.Info(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	demoted := []string{}
+	for _, env := range family(envs, id) {
+		if env.ID == id || !env.State.Promoted {
+			continue
+		}
+		changed, err := r.setPromoted(ctx, env.ID, false)
+		if err != nil {
+			return demoted, fmt.Errorf("failed to demote environment %s: %w", env.ID, err)
+		}
+		if changed {
+			demoted = append(demoted, env.ID)
+		}
+	}
+
+	_, err = r.setPromoted(ctx, id, true)
+	return demoted, err
+}
+
+// Demote removes the canonical mark set by Promote.
+func (r *Repository) Demote(ctx context.Context, id string) error {
+	_, err := r.setPromoted(ctx, id, false)
+	return err
+}
+
+// setPromoted saves the promoted mark of an environment, reloaded under its lock for the other changes saved
+// since it was listed to be kept. It reports whether the mark changed.
+func (r *Repository) setPromoted(ctx context.Context, id string, promoted bool) (bool, error) {
+	changed := false
+	err := r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		if envInfo.State.Promoted == promoted {
+			return nil
+		}
+		envInfo.State.Promoted = promoted
+		changed = true
+		return r.saveInfo(ctx, envInfo)
+	})
+	return changed, err
+}
+
+// family returns the environments connected to id by fork relationships, in any direction, including id.
+func family(envs []*environment.EnvironmentInfo, id string) []*environment.EnvironmentInfo {
+	neighbors := map[string][]string{}
+	for _, env := range envs {
+		if parent := env.State.Parent; parent != "" {
+			neighbors[env.ID] = append(neighbors[env.ID], parent)
+			neighbors[parent] = append(neighbors[parent], env.ID)
+		}
+	}
+
+	// Deleted environments still connect their forks
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range neighbors[current] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	members := []*environment.EnvironmentInfo{}
+	for _, env := range envs {
+		if seen[env.ID] {
+			members = append(members, env)
+		}
+	}
+	return members
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFamily(t *testing.T) {
+	env := func(id, parent string) *environment.EnvironmentInfo {
+		return &environment.EnvironmentInfo{ID: id, State: &environment.State{Parent: parent}}
+	}
+	envs := []*environment.EnvironmentInfo{
+		env("root", ""),
+		env("attempt-1", "root"),
+		env("attempt-2", "root"),
+		env("attempt-2b", "attempt-2"),
+		// Forked from a deleted environment, along with attempt-3
+		env("orphan", "deleted"),
+		env("attempt-3", "deleted"),
+		env("unrelated", ""),
+	}
+
+	ids := func(envs []*environment.EnvironmentInfo) []string {
+		ids := []string{}
+		for _, env := range envs {
+			ids = append(ids, env.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"root", "attempt-1", "attempt-2", "attempt-2b"}, ids(family(envs, "attempt-2b")))
+	assert.Equal(t, []string{"root", "attempt-1", "attempt-2", "attempt-2b"}, ids(family(envs, "root")))
+	assert.Equal(t, []string{"orphan", "attempt-3"}, ids(family(envs, "orphan")))
+	assert.Equal(t, []string{"unrelated"}, ids(family(envs, "unrelated")))
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ToolCall describes the tool call making changes to an environment, see WithToolCall.
+type ToolCall struct {
+	Tool string `json:"tool"`
+	// Arguments are summarized by the caller: long values truncated, sensitive ones masked.
+	Arguments map[string]string `json:"arguments,omitempty"`
+	StartedAt time.Time         `json:"started_at"`
+}
+
+// CommitMetadata is attached to each commit created for a tool call.
+type CommitMetadata struct {
+	ToolCall
+	Environment string `json:"environment"`
+	// Duration is the time from the start of the tool call to the commit, in milliseconds.
+	Duration int64 `json:"duration_ms"`
+}
+
+type toolCallKey struct{}
+
+// WithToolCall returns a context recording the tool call, which commits made with it are annotated with.
+func WithToolCall(ctx context.Context, call ToolCall) context.Context {
+	return context.WithValue(ctx, toolCallKey{}, call)
+}
+
+func toolCallFromContext(ctx context.Context) (ToolCall, bool) {
+	call, ok := ctx.Value(toolCallKey{}).(ToolCall)
+	return call, ok
+}
+
+// addCommitMetadata attaches the metadata of the tool call of ctx, if any, to the HEAD of the worktree.
+func (r *Repository) addCommitMetadata(ctx context.Context, envID, worktreePath string) error {
+	call, ok := toolCallFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	metadata, err := json.Marshal(CommitMetadata{
+		ToolCall:    call,
+		Environment: envID,
+		Duration:    time.Since(call.StartedAt).Milliseconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := r.lockManager.WithLock(ctx, LockTypeNotes, func() error {
+		_, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesMetadataRef, "add", "-f", "-m", string(metadata))
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to add commit metadata: %w", err)
+	}
+	return r.propagateGitNotes(ctx, gitNotesMetadataRef)
+}
+
+// CommitMetadata returns the metadata attached to a commit of an environment, or nil if it has none, e.g. for
+// commits made by older versions or outside of tool calls.
+func (r *Repository) CommitMetadata(ctx context.Context, commit string) (*CommitMetadata, error) {
+	var note string
+	err := r.lockManager.WithRLock(ctx, LockTypeNotes, func() error {
+		out, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesMetadataRef, "show", commit)
+		if err != nil {
+			if strings.Contains(err.Error(), "no note found") {
+				return nil
+			}
+			return err
+		}
+		note = out
+		return nil
+	})
+	if err != nil || note == "" {
+		return nil, err
+	}
+
+	var metadata CommitMetadata
+	if err := json.Unmarshal([]byte(note), &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata for commit %s: %w", commit, err)
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// ListStale returns the environments that haven't been updated for olderThan. Pinned and promoted environments
+// are left out.
+func (r *Repository) ListStale(ctx context.Context, olderThan time.Duration) ([]*environment.EnvironmentInfo, error) {
+	envs, err := r.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments: %w", err)
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	stale := []*environment.EnvironmentInfo{}
+	for _, env := range envs {
+		if !env.State.Pinned && !env.State.Promoted && !env.State.Locked && env.State.UpdatedAt.Before(cutoff) {
+			stale = append(stale, env)
+		}
+	}
+	return stale, nil
+}
+
+// Prune deletes the environments that haven't been updated for olderThan and returns the IDs of the deleted ones.
+// It keeps going when an environment fails to be deleted, and reports all failures.
+func (r *Repository) Prune(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	stale, err := r.ListStale(ctx, olderThan)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	var errs []error
+	for _, env := range stale {
+		if err := r.Delete(ctx, env.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete environment %s: %w", env.ID, err))
+			continue
+		}
+		deleted = append(deleted, env.ID)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// Touch marks the environment as updated now, so that it isn't pruned for being stale.
+// Only its state is updated: its branch is left untouched.
+func (r *Repository) Touch(ctx context.Context, id string) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	envInfo.State.UpdatedAt = time.Now()
+	return r.saveInfo(ctx, envInfo)
+}
+
+// SetPinned pins or unpins the environment. Pinned environments are never pruned.
+func (r *Repository) SetPinned(ctx context.Context, id string, pinned bool) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	envInfo.State.Pinned = pinned
+	return r.saveInfo(ctx, envInfo)
+}
+
+func (r *Repository) saveInfo(ctx context.Context, envInfo *environment.EnvironmentInfo) error {
+	if envInfo.State.Scratch {
+		return fmt.Errorf("environment %q: %w", envInfo.ID, errScratch)
+	}
+	if err := r.saveState(ctx, envInfo); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return r.propagateGitNotes(ctx, gitNotesStateRef)
+}
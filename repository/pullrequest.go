@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// GitHubRemote is the GitHub repository a git remote points to.
+type GitHubRemote struct {
+	// APIURL is the base URL of the GitHub API serving the repository: https://api.github.com, or the API of a
+	// GitHub Enterprise server.
+	APIURL string
+	Owner  string
+	Name   string
+}
+
+// GitHubRemote returns the GitHub repository of a remote of the source repository, e.g. to open pull requests.
+func (r *Repository) GitHubRemote(ctx context.Context, remote string) (*GitHubRemote, error) {
+	url, err := RunGitCommand(ctx, r.userRepoPath, "remote", "get-url", remote)
+	if err != nil {
+		return nil, fmt.Errorf("remote %q not found: add it with git remote add", remote)
+	}
+	return parseGitHubRemote(strings.TrimSpace(url))
+}
+
+// parseGitHubRemote parses the URL of a GitHub repository, either an HTTPS or an SSH one.
+func parseGitHubRemote(url string) (*GitHubRemote, error) {
+	normalized, err := normalizeGitURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("remote %s is not a GitHub repository: %w", url, err)
+	}
+	host, path, _ := strings.Cut(normalized, "/")
+	owner, name, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("remote %s is not a GitHub repository", url)
+	}
+
+	apiURL := "https://api.github.com"
+	if host != "github.com" {
+		apiURL = fmt.Sprintf("https://%s/api/v3", host)
+	}
+	return &GitHubRemote{APIURL: apiURL, Owner: owner, Name: name}, nil
+}
+
+// PullRequestContent is the title and description of a pull request opened from an environment.
+type PullRequestContent struct {
+	Title string
+	Body  string
+}
+
+// PullRequestContent describes the work of an environment for a pull request: its title, and the list of its
+// commits.
+func (r *Repository) PullRequestContent(ctx context.Context, id string) (*PullRequestContent, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+	log, err := RunGitCommand(ctx, r.userRepoPath, "log", "--reverse", "--format=- %s (%h)", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+	if log = strings.TrimSpace(log); log != "" {
+		fmt.Fprintf(&body, "## Commits\n\n%s\n\n", log)
+	}
+	fmt.Fprintf(&body, "Created from container-use environment `%s`.", id)
+
+	title := envInfo.State.Title
+	if title == "" {
+		title = "Environment " + id
+	}
+	return &PullRequestContent{Title: title, Body: body.String()}, nil
+}
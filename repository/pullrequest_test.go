@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGitHubRemote(t *testing.T) {
+	tests := []struct {
+		url  string
+		want GitHubRemote
+	}{
+		{"https://github.com/dagger/container-use.git", GitHubRemote{APIURL: "https://api.github.com", Owner: "dagger", Name: "container-use"}},
+		{"https://github.com/dagger/container-use", GitHubRemote{APIURL: "https://api.github.com", Owner: "dagger", Name: "container-use"}},
+		{"git@github.com:dagger/container-use.git", GitHubRemote{APIURL: "https://api.github.com", Owner: "dagger", Name: "container-use"}},
+		{"ssh://git@github.com/dagger/container-use.git", GitHubRemote{APIURL: "https://api.github.com", Owner: "dagger", Name: "container-use"}},
+		{"git@github.example.com:team/app.git", GitHubRemote{APIURL: "https://github.example.com/api/v3", Owner: "team", Name: "app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			remote, err := parseGitHubRemote(tt.url)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *remote)
+		})
+	}
+
+	for _, url := range []string{"/home/user/repo", "https://github.com/dagger", "https://gitlab.com/group/sub/project.git"} {
+		_, err := parseGitHubRemote(url)
+		assert.Error(t, err, url)
+	}
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pushAuthErrors are the git messages of pushes failing for lack of credentials or permissions.
+var pushAuthErrors = []string{
+	"authentication failed",
+	"could not read username",
+	"could not read password",
+	"permission denied",
+	"access denied",
+	"returned error: 403",
+}
+
+// PushBranch pushes the branch of an environment to a remote under a regular branch name, e.g. to open a pull
+// request from it. A branch of the same name on the remote is only fast-forwarded, unless force is set.
+// The output of git push goes to w.
+func (r *Repository) PushBranch(ctx context.Context, id, remote, branch string, force bool, w io.Writer) error {
+	if err := r.exists(ctx, id); err != nil {
+		return err
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "check-ref-format", "--branch", branch); err != nil {
+		return fmt.Errorf("invalid branch name %q", branch)
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "remote", "get-url", remote); err != nil {
+		return fmt.Errorf("remote %q not found: add it with git remote add", remote)
+	}
+
+	refspec := fmt.Sprintf("refs/remotes/%s/%s:refs/heads/%s", containerUseRemote, id, branch)
+	if force {
+		refspec = "+" + refspec
+	}
+	var output bytes.Buffer
+	if err := RunInteractiveGitCommand(ctx, r.userRepoPath, io.MultiWriter(w, &output), "push", remote, refspec); err != nil {
+		return pushError(remote, branch, output.String(), err)
+	}
+	return nil
+}
+
+// pushError explains the common failures of git push.
+func pushError(remote, branch, output string, err error) error {
+	lower := strings.ToLower(output)
+	if strings.Contains(lower, "[rejected]") || strings.Contains(lower, "non-fast-forward") || strings.Contains(lower, "fetch first") {
+		return fmt.Errorf("branch %s already exists on %s with commits that aren't in the environment: pick another name, or use --force to overwrite it", branch, remote)
+	}
+	for _, msg := range pushAuthErrors {
+		if strings.Contains(lower, msg) {
+			return fmt.Errorf("not allowed to push to %s, check your credentials for it (e.g. with git push): %w", remote, err)
+		}
+	}
+	return fmt.Errorf("failed to push to %s: %w", remote, err)
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// RebasedCommit is a commit of an environment replayed by Rebase.
+type RebasedCommit struct {
+	SHA     string `json:"sha"`
+	Subject string `json:"subject"`
+}
+
+// RebaseConflictError is returned by Rebase when the commits of the environment conflict with the new base.
+// The rebase is aborted: the environment is left as it was.
+type RebaseConflictError struct {
+	Environment string
+	Onto        string
+	Files       []string
+}
+
+func (e *RebaseConflictError) Error() string {
+	return fmt.Sprintf("environment %s conflicts with %s in %d file(s): %s", e.Environment, e.Onto, len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// commitNotesRefs are the notes attached to the commits of an environment, copied to their rebased version.
+var commitNotesRefs = []string{gitNotesLogRef, gitNotesStateRef, gitNotesMetadataRef}
+
+// Rebase replays the commits of an environment on top of onto, a revision of the source repository (e.g. "main"
+// after it moved forward), and returns the commits replayed. The files changed by the new base are synced to the
+// environment's container, and the container-use/<id> ref of the source repository is force-updated.
+func (r *Repository) Rebase(ctx context.Context, dag *dagger.Client, id, onto string) ([]RebasedCommit, error) {
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+	if env.State.Scratch {
+		return nil, errScratch
+	}
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree path: %w", err)
+	}
+	base, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", onto+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown revision %q: %w", onto, err)
+	}
+	base = strings.TrimSpace(base)
+
+	var replayed []RebasedCommit
+	err = r.withEnvironmentUpdate(ctx, env, func() error {
+		// Changes saved since the environment was loaded
+		if err := r.catchUp(ctx, env, worktreePath, ""); err != nil {
+			return err
+		}
+		if err := r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+			return r.rebaseWorktree(ctx, id, worktreePath, onto, base)
+		}); err != nil {
+			return err
+		}
+
+		// The container has the files of the old base: bring it up to date with the new one
+		if err := r.catchUp(ctx, env, worktreePath, ""); err != nil {
+			return err
+		}
+		if err := r.saveState(ctx, env.EnvironmentInfo); err != nil {
+			return fmt.Errorf("failed to add notes: %w", err)
+		}
+		if err := r.lockManager.WithLock(ctx, LockTypeUserRepo, func() error {
+			_, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", id, containerUseRemote, id))
+			return err
+		}); err != nil {
+			return err
+		}
+		for _, ref := range commitNotesRefs {
+			if _, err := RunGitCommand(ctx, r.forkRepoPath, "show-ref", "--verify", "--quiet", "refs/notes/"+ref); err != nil {
+				continue // No notes of this kind yet
+			}
+			if err := r.propagateGitNotes(ctx, ref); err != nil {
+				return err
+			}
+		}
+
+		replayed, err = rebasedCommits(ctx, worktreePath, base)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replayed, nil
+}
+
+// rebaseWorktree rebases the branch checked out in the worktree onto base, a commit of the source repository.
+// On failure, the rebase is aborted so that the branch is never left mid-rebase.
+func (r *Repository) rebaseWorktree(ctx context.Context, id, worktreePath, onto, base string) error {
+	// Make the new base available in the fork repository
+	tmpRef := "refs/container-use-rebase/" + id
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "push", containerUseRemote, fmt.Sprintf("%s:%s", base, tmpRef)); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "update-ref", "-d", tmpRef); err != nil {
+			slog.Warn("Failed to delete temporary rebase ref", "environment.id", id, "err", err)
+		}
+	}()
+
+	args := []string{}
+	for _, ref := range commitNotesRefs {
+		args = append(args, "-c", "notes.rewriteRef=refs/notes/"+ref)
+	}
+	args = append(args, "rebase", base)
+	if _, err := RunGitCommand(ctx, worktreePath, args...); err != nil {
+		conflicts, _ := RunGitCommand(ctx, worktreePath, "diff", "--name-only", "--diff-filter=U")
+		if _, abortErr := RunGitCommand(ctx, worktreePath, "rebase", "--abort"); abortErr != nil && !strings.Contains(abortErr.Error(), "No rebase in progress") {
+			return fmt.Errorf("%w (aborting the rebase failed too: %w)", err, abortErr)
+		}
+		if conflicts = strings.TrimSpace(conflicts); conflicts != "" {
+			return &RebaseConflictError{Environment: id, Onto: onto, Files: strings.Split(conflicts, "\n")}
+		}
+		return err
+	}
+	return nil
+}
+
+// rebasedCommits returns the commits of the worktree's branch that aren't in base, oldest first.
+func rebasedCommits(ctx context.Context, worktreePath, base string) ([]RebasedCommit, error) {
+	out, err := RunGitCommand(ctx, worktreePath, "log", "--reverse", "--format=%H%x00%s", base+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+	commits := []RebasedCommit{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if sha, subject, ok := strings.Cut(line, "\x00"); ok {
+			commits = append(commits, RebasedCommit{SHA: sha, Subject: subject})
+		}
+	}
+	return commits, nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// environmentIDPattern matches the IDs of environments: lowercase words separated by dashes, like the generated
+// ones (e.g. "fancy-mallard").
+var environmentIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// maxEnvironmentIDLength bounds the length of environment IDs, which are used as branch and directory names.
+const maxEnvironmentIDLength = 64
+
+// ValidateEnvironmentID checks that id can identify an environment: lowercase letters and digits, with words
+// separated by single dashes.
+func ValidateEnvironmentID(id string) error {
+	if len(id) > maxEnvironmentIDLength {
+		return fmt.Errorf("invalid environment ID %q: longer than %d characters", id, maxEnvironmentIDLength)
+	}
+	if !environmentIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid environment ID %q: use lowercase letters and digits, with words separated by dashes (e.g. login-form)", id)
+	}
+	return nil
+}
+
+// Rename changes the ID of an environment, e.g. to give it a meaningful name: its branch, its worktree and the
+// container-use/<id> ref of the source repository are renamed, and the environments forked from it are updated.
+// Its commits and their notes are unchanged.
+func (r *Repository) Rename(ctx context.Context, oldID, newID string) error {
+	if err := ValidateEnvironmentID(newID); err != nil {
+		return err
+	}
+	if err := r.exists(ctx, oldID); err != nil {
+		return err
+	}
+	if oldID == newID {
+		return fmt.Errorf("environment %q already has this name", oldID)
+	}
+	if _, ok := r.scratch(ctx, newID); ok {
+		return fmt.Errorf("environment %q already exists", newID)
+	}
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+newID); err == nil {
+		return fmt.Errorf("environment %q already exists", newID)
+	}
+
+	// Hold the locks of both IDs, so that no update of the environment runs while it moves
+	return r.lockManager.WithLock(ctx, environmentLockType(oldID), func() error {
+		return r.lockManager.WithLock(ctx, environmentLockType(newID), func() error {
+			// Info recreates the worktree if it is missing, so that there is one to move
+			envInfo, err := r.Info(ctx, oldID)
+			if err != nil {
+				return err
+			}
+			if err := r.renameBranch(ctx, oldID, newID); err != nil {
+				return err
+			}
+			if err := r.renameUserRefs(ctx, oldID, newID); err != nil {
+				return err
+			}
+
+			r.unindex(ctx, oldID)
+			envInfo.ID = newID
+			r.reindex(ctx, envInfo)
+			if err := r.renameIdempotentEnvironment(ctx, oldID, newID); err != nil {
+				slog.Warn("Failed to update the idempotency keys of renamed environment", "environment.id", newID, "err", err)
+			}
+			return r.renameForksParent(ctx, oldID, newID)
+		})
+	})
+}
+
+// renameBranch renames the branch of an environment in the fork repository, and moves its worktree along,
+// uncommitted changes included.
+func (r *Repository) renameBranch(ctx context.Context, oldID, newID string) error {
+	oldPath, err := r.WorktreePath(oldID)
+	if err != nil {
+		return err
+	}
+	newPath, err := r.WorktreePath(newID)
+	if err != nil {
+		return err
+	}
+
+	return r.lockManager.WithLock(ctx, LockTypeForkRepo, func() error {
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "worktree", "move", oldPath, newPath); err != nil {
+			return fmt.Errorf("failed to move worktree: %w", err)
+		}
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "branch", "-m", oldID, newID); err != nil {
+			if _, moveErr := RunGitCommand(ctx, r.forkRepoPath, "worktree", "move", newPath, oldPath); moveErr != nil {
+				return fmt.Errorf("failed to rename branch: %w (moving the worktree back failed too: %w)", err, moveErr)
+			}
+			return fmt.Errorf("failed to rename branch: %w", err)
+		}
+		return nil
+	})
+}
+
+// renameUserRefs renames the refs of an environment in the source repository: container-use/<id>, and the last
+// commit seen by whatsnew.
+func (r *Repository) renameUserRefs(ctx context.Context, oldID, newID string) error {
+	return r.lockManager.WithLock(ctx, LockTypeUserRepo, func() error {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", newID, containerUseRemote, newID)); err != nil {
+			return err
+		}
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", fmt.Sprintf("refs/remotes/%s/%s", containerUseRemote, oldID)); err != nil {
+			return err
+		}
+
+		seen, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format=%(objectname)", lastSeenRef(oldID))
+		if err != nil || strings.TrimSpace(seen) == "" {
+			return err
+		}
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", lastSeenRef(newID), strings.TrimSpace(seen)); err != nil {
+			return err
+		}
+		_, err = RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", lastSeenRef(oldID))
+		return err
+	})
+}
+
+// renameForksParent points the environments forked from a renamed environment to its new ID.
+func (r *Repository) renameForksParent(ctx context.Context, oldID, newID string) error {
+	envs, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, env := range envs {
+		if env.State.Parent != oldID {
+			continue
+		}
+		if err := r.lockManager.WithLock(ctx, environmentLockType(env.ID), func() error {
+			envInfo, err := r.Info(ctx, env.ID)
+			if err != nil {
+				return err
+			}
+			envInfo.State.Parent = newID
+			return r.saveInfo(ctx, envInfo)
+		}); err != nil {
+			return fmt.Errorf("failed to update the parent of environment %s: %w", env.ID, err)
+		}
+	}
+	return nil
+}
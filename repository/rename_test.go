@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEnvironmentID(t *testing.T) {
+	for _, id := range []string{"fancy-mallard", "login-form", "v2", "fix-issue-42"} {
+		assert.NoError(t, ValidateEnvironmentID(id), id)
+	}
+	for _, id := range []string{"", "Login-Form", "login_form", "login--form", "-login", "login-", "feature/login", "login form", "..", strings.Repeat("a", 65)} {
+		assert.Error(t, ValidateEnvironmentID(id), id)
+	}
+}
@@ -25,6 +25,8 @@ const (
 	containerUseRemote = "container-use"
 	gitNotesLogRef     = "container-use"
 	gitNotesStateRef   = "container-use-state"
+	// gitNotesMetadataRef holds the CommitMetadata of commits as JSON, apart from the free form command log.
+	gitNotesMetadataRef = "container-use-metadata"
 )
 
 // getDefaultConfigPath returns the default configuration path for the current OS
@@ -59,6 +61,7 @@ type Repository struct {
 	forkRepoPath string
 	basePath     string // defaults to OS-appropriate config path if empty
 	lockManager  *RepositoryLockManager
+	index        *fileIndex // optional cache of the environments' metadata, see Reindex
 }
 
 // getRepoPath returns the path for storing repository data
@@ -122,6 +125,9 @@ func OpenWithBasePath(ctx context.Context, repo string, basePath string) (*Repos
 	if err := r.ensureUserRemote(ctx); err != nil {
 		return nil, fmt.Errorf("unable to set container-use remote: %w", err)
 	}
+	if _, err := os.Stat(r.fileIndexPath()); err == nil {
+		r.index = newFileIndex(r.fileIndexPath(), r.lockManager)
+	}
 
 	return r, nil
 }
@@ -172,6 +178,9 @@ func (r *Repository) SourcePath() string {
 }
 
 func (r *Repository) exists(ctx context.Context, id string) error {
+	if _, ok := r.scratch(ctx, id); ok {
+		return fmt.Errorf("environment %q: %w", id, errScratch)
+	}
 	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id); err != nil {
 		if strings.Contains(err.Error(), "Needed a single revision") {
 			return fmt.Errorf("environment %q not found", id)
@@ -185,6 +194,26 @@ func (r *Repository) exists(ctx context.Context, id string) error {
 // The git reference can be HEAD (default), a SHA, a branch name, or a tag.
 // Requires a dagger client for container operations during environment initialization.
 func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description, explanation, gitRef string) (*environment.Environment, error) {
+	return r.CreateWithProgress(ctx, dag, description, explanation, gitRef, nil)
+}
+
+// CreateWithProgress is like Create, notifying progress of the setup commands, services and install commands as they run.
+// progress may be nil.
+func (r *Repository) CreateWithProgress(ctx context.Context, dag *dagger.Client, description, explanation, gitRef string, progress environment.ProgressFunc) (*environment.Environment, error) {
+	return r.CreateWithOpts(ctx, dag, description, explanation, gitRef, CreateOpts{Progress: progress})
+}
+
+// CreateOpts contains the optional arguments of CreateWithOpts.
+type CreateOpts struct {
+	// Progress, if set, is notified of the setup commands, services and install commands as they run.
+	Progress environment.ProgressFunc
+	// Variables are the values of the template variables referenced in the configuration (e.g. "PY_VERSION"
+	// for a "python:${PY_VERSION}" base image). They take precedence over the configuration's own variables.
+	Variables map[string]string
+}
+
+// CreateWithOpts is like Create, with optional arguments.
+func (r *Repository) CreateWithOpts(ctx context.Context, dag *dagger.Client, description, explanation, gitRef string, opts CreateOpts) (*environment.Environment, error) {
 	if gitRef == "" {
 		gitRef = "HEAD"
 	}
@@ -207,19 +236,7 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 	}
 	worktreeHead = strings.TrimSpace(worktreeHead)
 
-	var baseSourceDir *dagger.Directory
-	err = r.lockManager.WithRLock(ctx, LockTypeForkRepo, func() error {
-		var err error
-		baseSourceDir, err = dag.
-			Host().
-			Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}). // bust cache for each Create call
-			AsGit().
-			Ref(worktreeHead).
-			Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}).
-			Sync(ctx) // don't bust cache when loading from state
-
-		return err
-	})
+	baseSourceDir, err := r.sourceDir(ctx, dag, worktreeHead)
 	if err != nil {
 		return nil, fmt.Errorf("failed loading initial source directory: %w", err)
 	}
@@ -239,6 +256,9 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 		Config:           config,
 		InitialSourceDir: baseSourceDir,
 		SubmodulePaths:   submodulePaths,
+		SourcePath:       r.userRepoPath,
+		Progress:         opts.Progress,
+		Variables:        opts.Variables,
 	})
 	if err != nil {
 		return nil, err
@@ -249,6 +269,9 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 		env.Notes.Add("Warning: %s", submoduleWarning)
 	}
 
+	env.State.Parent = r.forkParent(ctx, gitRef)
+	applyAutoTitle(env, explanation)
+
 	if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
 		return nil, err
 	}
@@ -256,10 +279,31 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 	return env, nil
 }
 
+// sourceDir loads the tree of the given commit from the fork repository, without its .git directory.
+func (r *Repository) sourceDir(ctx context.Context, dag *dagger.Client, commit string) (*dagger.Directory, error) {
+	var dir *dagger.Directory
+	err := r.lockManager.WithRLock(ctx, LockTypeForkRepo, func() error {
+		var err error
+		dir, err = dag.
+			Host().
+			Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}). // bust cache for each call
+			AsGit().
+			Ref(commit).
+			Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}).
+			Sync(ctx) // don't bust cache when loading from state
+
+		return err
+	})
+	return dir, err
+}
+
 // Get retrieves a full Environment with dagger client embedded for container operations.
 // Use this when you need to perform container operations like running commands, terminals, etc.
 // For basic metadata access without container operations, use Info() instead.
 func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*environment.Environment, error) {
+	if env, ok := r.scratch(ctx, id); ok {
+		return env, nil
+	}
 	if err := r.exists(ctx, id); err != nil {
 		return nil, err
 	}
@@ -278,6 +322,17 @@ func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*e
 	if err != nil {
 		return nil, err
 	}
+	if env.Head, err = worktreeHead(ctx, worktree); err != nil {
+		return nil, err
+	}
+
+	if env.State.Paused {
+		// Release anything this process may still be running for the environment.
+		if err := environment.StopServices(ctx, id); err != nil {
+			slog.Warn("failed to stop services of paused environment", "environment.id", id, "err", err)
+		}
+		return nil, fmt.Errorf("environment %q is paused, run `container-use resume %s` to use it again", id, id)
+	}
 
 	return env, nil
 }
@@ -286,6 +341,9 @@ func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*e
 // This is more efficient than Get() when you only need access to configuration,
 // state, and other metadata without performing container operations.
 func (r *Repository) Info(ctx context.Context, id string) (*environment.EnvironmentInfo, error) {
+	if env, ok := r.scratch(ctx, id); ok {
+		return env.EnvironmentInfo, nil
+	}
 	if err := r.exists(ctx, id); err != nil {
 		return nil, err
 	}
@@ -311,7 +369,21 @@ func (r *Repository) Info(ctx context.Context, id string) (*environment.Environm
 // List returns information about all environments in the repository.
 // Returns EnvironmentInfo slice avoiding dagger client initialization.
 // Use Get() on individual environments when you need full Environment with container operations.
+// With an index (see Reindex), environments are listed from the index rather than from every branch.
 func (r *Repository) List(ctx context.Context) ([]*environment.EnvironmentInfo, error) {
+	if r.index != nil {
+		envs, err := r.index.List(ctx)
+		if err == nil {
+			sortByUpdate(envs)
+			return envs, nil
+		}
+		slog.Warn("failed to list environments from the index, listing them from git", "err", err)
+	}
+	return r.listFromGit(ctx)
+}
+
+// listFromGit reads the information of every environment from its branch.
+func (r *Repository) listFromGit(ctx context.Context) ([]*environment.EnvironmentInfo, error) {
 	branches, err := RunGitCommand(ctx, r.forkRepoPath, "branch", "--format", "%(refname:short)")
 	if err != nil {
 		return nil, err
@@ -381,12 +453,15 @@ func (r *Repository) List(ctx context.Context) ([]*environment.EnvironmentInfo,
 		return nil, err
 	}
 
-	// Sort by most recently updated environments first
+	sortByUpdate(envs)
+	return envs, nil
+}
+
+// sortByUpdate sorts environments by most recently updated first.
+func sortByUpdate(envs []*environment.EnvironmentInfo) {
 	sort.Slice(envs, func(i, j int) bool {
 		return envs[i].State.UpdatedAt.After(envs[j].State.UpdatedAt)
 	})
-
-	return envs, nil
 }
 
 // ListDescendantEnvironments returns environments that are descendants of the given commit.
@@ -422,19 +497,64 @@ func (r *Repository) isDescendantOfCommit(ctx context.Context, ancestorCommit, e
 
 // Update saves the provided environment to the repository.
 // Writes configuration and source code changes to the worktree and history + state to git notes.
+// Changes saved concurrently to the environment's branch since it was loaded are kept: the environment's changes
+// are applied on top of them, and fail with a conflict if they touch the same lines.
 func (r *Repository) Update(ctx context.Context, env *environment.Environment, explanation string) error {
-	return r.propagateToWorktree(ctx, env, explanation)
+	if env.State.Scratch {
+		return r.updateScratch(env)
+	}
+	applyAutoTitle(env, explanation)
+	return r.withEnvironmentUpdate(ctx, env, func() error {
+		return r.propagateToWorktree(ctx, env, explanation)
+	})
+}
+
+// SaveState persists the state of the environment, including its container, without committing its files.
+// Changes made to the workdir in the meantime are committed by the next Update.
+func (r *Repository) SaveState(ctx context.Context, env *environment.Environment) error {
+	if env.State.Scratch {
+		return r.updateScratch(env)
+	}
+	worktree, err := r.getWorktree(ctx, env.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.withEnvironmentUpdate(ctx, env, func() error {
+		if err := r.catchUp(ctx, env, worktree, ""); err != nil {
+			return err
+		}
+		if err := r.saveState(ctx, env.EnvironmentInfo); err != nil {
+			return fmt.Errorf("failed to add notes: %w", err)
+		}
+		if err := r.propagateGitNotes(ctx, gitNotesStateRef); err != nil {
+			return err
+		}
+		if note := env.Notes.Pop(); note != "" {
+			return r.addGitNote(ctx, env, note)
+		}
+		return nil
+	})
 }
 
 // UpdateFile saves only the specified file from the environment to the repository.
 // This is more efficient than Update() for single file operations as it only exports
 // and commits the specified file instead of the entire directory.
 func (r *Repository) UpdateFile(ctx context.Context, env *environment.Environment, filePath, explanation string) error {
-	return r.propagateFileToWorktree(ctx, env, filePath, explanation)
+	if env.State.Scratch {
+		return r.updateScratch(env)
+	}
+	applyAutoTitle(env, explanation)
+	return r.withEnvironmentUpdate(ctx, env, func() error {
+		return r.propagateFileToWorktree(ctx, env, filePath, explanation)
+	})
 }
 
 // Delete removes an environment from the repository.
 func (r *Repository) Delete(ctx context.Context, id string) error {
+	if _, ok := r.scratch(ctx, id); ok {
+		return r.deleteScratch(ctx, id)
+	}
 	if err := r.exists(ctx, id); err != nil {
 		return err
 	}
@@ -445,6 +565,13 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 	if err := r.deleteLocalRemoteBranch(id); err != nil {
 		return err
 	}
+	if err := r.deleteLastSeen(ctx, id); err != nil {
+		slog.Warn("Failed to delete last seen commit", "id", id, "err", err)
+	}
+	if err := clearStopRequests(id); err != nil {
+		slog.Warn("Failed to delete stop requests", "id", id, "err", err)
+	}
+	r.unindex(ctx, id)
 	return nil
 }
 
@@ -501,21 +628,185 @@ func (r *Repository) Checkout(ctx context.Context, id, branch string) (string, e
 	return branch, err
 }
 
-func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer) error {
+// Pause stops the services of an environment and marks it as paused so it can't be used until resumed.
+// The environment's branch is left untouched. Services and background commands run in the MCP server that started
+// them: it is asked to stop them with RequestStop.
+func (r *Repository) Pause(ctx context.Context, id string) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
 		return err
 	}
+	return r.withEnvironmentUpdate(ctx, &environment.Environment{EnvironmentInfo: envInfo}, func() error {
+		// Reload the state under the lock, it may have been saved since
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+		if envInfo.State.Paused {
+			return fmt.Errorf("environment %q is already paused", id)
+		}
 
-	logArgs := []string{
-		"log",
-		fmt.Sprintf("--notes=%s", gitNotesLogRef),
+		handles := []string{}
+		if envInfo.State.Config != nil {
+			for _, service := range envInfo.State.Config.Services {
+				handles = append(handles, service.Name)
+			}
+		}
+		for _, bg := range envInfo.State.BackgroundCommands {
+			handles = append(handles, bg.ID)
+		}
+		for _, handle := range handles {
+			if err := RequestStop(id, handle); err != nil {
+				return err
+			}
+		}
+		if err := environment.StopServices(ctx, id); err != nil {
+			return fmt.Errorf("failed to stop services: %w", err)
+		}
+
+		envInfo.State.Paused = true
+		envInfo.State.ServiceEndpoints = nil
+		envInfo.State.BackgroundCommands = nil
+		if err := r.saveState(ctx, envInfo); err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+		return r.propagateGitNotes(ctx, gitNotesStateRef)
+	})
+}
+
+// Resume rebuilds a paused environment from the latest commit of its branch and restarts its services.
+func (r *Repository) Resume(ctx context.Context, dag *dagger.Client, id string) (*environment.Environment, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
 	}
 
-	if patch {
-		logArgs = append(logArgs, "--patch")
-	} else {
-		logArgs = append(logArgs, "--format=%C(yellow)%h%Creset  %s %Cgreen(%cr)%Creset %+N")
+	worktree, err := r.getWorktree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := r.loadState(ctx, worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := environment.Load(ctx, dag, id, state, worktree)
+	if err != nil {
+		return nil, err
+	}
+	if !env.State.Paused {
+		return nil, fmt.Errorf("environment %q is not paused", id)
+	}
+
+	sourceDir, err := r.headSourceDir(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := env.Resume(ctx, sourceDir); err != nil {
+		return nil, err
+	}
+
+	if err := r.Update(ctx, env, "Resume environment"); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// Rebuild re-creates the container of an environment from scratch, applying its configuration
+// on top of the files of the latest commit of its branch.
+// Changes to the container outside of the committed files are discarded. Services are bound to the rebuilt
+// container without being started, see environment.Environment.DeferServices.
+func (r *Repository) Rebuild(ctx context.Context, dag *dagger.Client, id string) (*environment.Environment, error) {
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+	env.DeferServices = true
+
+	sourceDir, err := r.headSourceDir(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := env.Rebuild(ctx, sourceDir); err != nil {
+		return nil, err
+	}
+
+	if err := r.Update(ctx, env, "Rebuild environment"); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// headSourceDir loads the files of the latest commit of the environment's branch.
+func (r *Repository) headSourceDir(ctx context.Context, dag *dagger.Client, id string) (*dagger.Directory, error) {
+	head, err := r.HeadCommit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sourceDir, err := r.sourceDir(ctx, dag, head)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading source directory: %w", err)
+	}
+	return sourceDir, nil
+}
+
+// HeadCommit returns the SHA of the latest commit of the environment.
+func (r *Repository) HeadCommit(ctx context.Context, id string) (string, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return "", err
+	}
+
+	head, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", id)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(head), nil
+}
+
+// LastFileCommit returns the last commit of the environment that modified a file, given relative to the
+// workdir, or nil if the file was never committed.
+func (r *Repository) LastFileCommit(ctx context.Context, id, path string) (*environment.FileCommit, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+
+	out, err := RunGitCommand(ctx, r.forkRepoPath, "log", "-1", "--format=%H%x00%B", "refs/heads/"+id, "--", ":(literal)"+path)
+	if err != nil {
+		return nil, err
+	}
+	sha, explanation, found := strings.Cut(out, "\x00")
+	if !found {
+		return nil, nil
+	}
+	return &environment.FileCommit{SHA: strings.TrimSpace(sha), Explanation: strings.TrimSpace(explanation)}, nil
+}
+
+// Log writes the history of an environment with the command log. With metadata, the tool call metadata attached
+// to each commit is included.
+func (r *Repository) Log(ctx context.Context, id string, patch, metadata bool, w io.Writer) error {
+	return r.LogWithOpts(ctx, id, LogOpts{Patch: patch, Metadata: metadata}, w)
+}
+
+// LogOpts contains the optional arguments of LogWithOpts and WhatsNewWithOpts.
+type LogOpts struct {
+	// Patch includes the changes made by each commit.
+	Patch bool
+	// Metadata includes the tool call metadata attached to each commit.
+	Metadata bool
+	// DateFormat is the format of commit dates, as accepted by git log --date (e.g. iso-strict-local). Dates are
+	// relative when empty.
+	DateFormat string
+}
+
+// LogWithOpts is Log with optional arguments.
+func (r *Repository) LogWithOpts(ctx context.Context, id string, opts LogOpts, w io.Writer) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
 	}
 
 	revisionRange, err := r.revisionRange(ctx, envInfo)
@@ -523,38 +814,202 @@ func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer
 		return err
 	}
 
+	return r.logRange(ctx, revisionRange, opts, w)
+}
+
+func (r *Repository) logRange(ctx context.Context, revisionRange string, opts LogOpts, w io.Writer) error {
+	logArgs := []string{
+		"log",
+		fmt.Sprintf("--notes=%s", gitNotesLogRef),
+	}
+	if opts.Metadata {
+		logArgs = append(logArgs, fmt.Sprintf("--notes=%s", gitNotesMetadataRef))
+	}
+
+	date := "%cr"
+	if opts.DateFormat != "" {
+		logArgs = append(logArgs, "--date="+opts.DateFormat)
+		date = "%cd"
+	}
+
+	if opts.Patch {
+		logArgs = append(logArgs, "--patch")
+	} else {
+		logArgs = append(logArgs, "--format=%C(yellow)%h%Creset  %s %Cgreen("+date+")%Creset %+N")
+	}
+
 	logArgs = append(logArgs, revisionRange)
 
 	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, logArgs...)
 }
 
 func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
+	return r.DiffWithOpts(ctx, id, DiffOpts{}, w)
+}
+
+// DiffOpts contains the optional arguments of DiffWithOpts and DiffEnvironmentsWithOpts.
+type DiffOpts struct {
+	// Stat summarizes the changes instead of writing the patch: the lines added and removed in each file, and
+	// the totals (e.g. "2 files changed, 5 insertions(+), 1 deletion(-)").
+	Stat bool
+}
+
+func (o DiffOpts) args() []string {
+	args := []string{"diff"}
+	if o.Stat {
+		args = append(args, "--stat")
+	}
+	return args
+}
+
+// DiffWithOpts is like Diff, with optional arguments.
+func (r *Repository) DiffWithOpts(ctx context.Context, id string, opts DiffOpts, w io.Writer) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	diffArgs := []string{
-		"diff",
-	}
-
 	revisionRange, err := r.revisionRange(ctx, envInfo)
 	if err != nil {
 		return err
 	}
 
-	diffArgs = append(diffArgs, revisionRange)
+	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, append(opts.args(), revisionRange)...)
+}
 
-	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, diffArgs...)
+// DiffEnvironments writes the differences between the files of two environments, e.g. two attempts at the same
+// task, as a git diff from envA to envB. The environments don't need to share history.
+func (r *Repository) DiffEnvironments(ctx context.Context, envA, envB string, w io.Writer) error {
+	return r.DiffEnvironmentsWithOpts(ctx, envA, envB, DiffOpts{}, w)
+}
+
+// DiffEnvironmentsWithOpts is like DiffEnvironments, with optional arguments.
+func (r *Repository) DiffEnvironmentsWithOpts(ctx context.Context, envA, envB string, opts DiffOpts, w io.Writer) error {
+	for _, id := range []string{envA, envB} {
+		if err := r.exists(ctx, id); err != nil {
+			return err
+		}
+	}
+	refA := fmt.Sprintf("%s/%s", containerUseRemote, envA)
+	refB := fmt.Sprintf("%s/%s", containerUseRemote, envB)
+	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, append(opts.args(), refA, refB, "--")...)
 }
 
-func (r *Repository) Merge(ctx context.Context, id string, w io.Writer) error {
+// FormatPatch writes the environment's commits as a `git format-patch` series into outputDir, ready to be applied with `git am`.
+// Each patch keeps the message and author of its commit. Binary files are included.
+// It returns the paths of the patch files, in order.
+func (r *Repository) FormatPatch(ctx context.Context, id string, outputDir string) ([]string, error) {
 	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := RunGitCommand(ctx, r.userRepoPath, "format-patch", "--binary", "--full-index", "-o", outputDir, revisionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var patches []string
+	for line := range strings.SplitSeq(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			patches = append(patches, line)
+		}
+	}
+	return patches, nil
+}
+
+// MergeOpts are the options of Merge. The zero value creates a merge commit.
+type MergeOpts struct {
+	// FastForward lets the branch be fast-forwarded when possible, rather than always creating a merge commit.
+	FastForward bool
+	// Squash commits the changes of the environment as a single commit, without its history.
+	Squash bool
+	// Message is the message of the merge or squashed commit, "Merge environment <id>" by default.
+	Message string
+}
+
+// MergeConflictError is returned by Merge when the environment conflicts with the current branch.
+// The branch and the working directory are left untouched.
+type MergeConflictError struct {
+	Environment string
+	Conflicts   []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("environment %s conflicts with the current branch in %d file(s)", e.Environment, len(e.Conflicts))
+}
+
+// Merge merges the environment into the current branch of the source repository. Uncommitted changes are stashed
+// beforehand and restored afterwards. Conflicts are detected with a trial merge first: they are reported with a
+// MergeConflictError, and written to w like the output of git.
+func (r *Repository) Merge(ctx context.Context, id string, opts MergeOpts, w io.Writer) error {
+	check, err := r.CheckMerge(ctx, id)
 	if err != nil {
 		return err
 	}
+	if !check.Mergeable {
+		for _, conflict := range check.Conflicts {
+			for _, message := range conflict.Messages {
+				fmt.Fprintln(w, message)
+			}
+		}
+		return &MergeConflictError{Environment: check.Environment, Conflicts: check.Conflicts}
+	}
+
+	ref := "container-use/" + check.Environment
+	message := opts.Message
+	if message == "" {
+		message = "Merge environment " + check.Environment
+	}
+
+	if opts.Squash {
+		if err := RunInteractiveGitCommand(ctx, r.userRepoPath, w, "merge", "--autostash", "--squash", "--", ref); err != nil {
+			return r.abortMerge(ctx, err)
+		}
+		// Nothing is staged when the environment was already merged
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--cached", "--quiet"); err == nil {
+			return nil
+		}
+		return RunInteractiveGitCommand(ctx, r.userRepoPath, w, "commit", "-m", message)
+	}
 
-	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, "merge", "--no-ff", "--autostash", "-m", "Merge environment "+envInfo.ID, "--", "container-use/"+envInfo.ID)
+	args := []string{"merge", "--autostash", "-m", message}
+	if !opts.FastForward {
+		args = append(args, "--no-ff")
+	}
+	if err := RunInteractiveGitCommand(ctx, r.userRepoPath, w, append(args, "--", ref)...); err != nil {
+		return r.abortMerge(ctx, err)
+	}
+	return nil
+}
+
+// abortMerge undoes a merge that failed half-way, e.g. because the branch changed since the trial merge, so that
+// the working directory isn't left with conflict markers. Failed squash merges leave no MERGE_HEAD for
+// `git merge --abort` to work with: the index and working directory are reset to HEAD instead, and the changes
+// stashed by --autostash, which the reset moves to the stash list, are restored.
+func (r *Repository) abortMerge(ctx context.Context, mergeErr error) error {
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "-q", "--verify", "MERGE_HEAD"); err == nil {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "merge", "--abort"); err != nil {
+			return fmt.Errorf("%w (aborting the merge failed too: %w)", mergeErr, err)
+		}
+		return mergeErr
+	}
+
+	_, autostashErr := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "-q", "--verify", "MERGE_AUTOSTASH")
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "reset", "--merge"); err != nil {
+		return fmt.Errorf("%w (aborting the merge failed too: %w)", mergeErr, err)
+	}
+	if autostashErr == nil {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "stash", "pop"); err != nil {
+			return fmt.Errorf("%w (restoring your uncommitted changes failed, they are in `git stash list`: %w)", mergeErr, err)
+		}
+	}
+	return mergeErr
 }
 
 func (r *Repository) Apply(ctx context.Context, id string, w io.Writer) error {
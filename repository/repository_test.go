@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -66,3 +67,44 @@ func TestRepositoryOpen(t *testing.T) {
 		assert.Equal(t, repo.forkRepoPath, strings.TrimSpace(remote))
 	})
 }
+
+// TestListOrphaned tests that environments are reported and deleted once their source repository is removed
+func TestListOrphaned(t *testing.T) {
+	ctx := context.Background()
+	sourceDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, sourceDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, sourceDir, "-c", "user.email=test@example.com", "-c", "user.name=Test User", "commit", "--allow-empty", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, sourceDir, configDir)
+	require.NoError(t, err)
+
+	// Fake an environment: a branch in the fork with a state note recording its source
+	_, err = RunGitCommand(ctx, sourceDir, "push", containerUseRemote, "HEAD:refs/heads/orphan-env")
+	require.NoError(t, err)
+	state := fmt.Sprintf(`{"title": "Orphan", "source_path": %q}`, repo.SourcePath())
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "-c", "user.email=test@example.com", "-c", "user.name=Test User", "notes", "--ref", gitNotesStateRef, "add", "-m", state, "orphan-env")
+	require.NoError(t, err)
+
+	orphaned, err := ListOrphanedWithBasePath(ctx, configDir)
+	require.NoError(t, err)
+	assert.Empty(t, orphaned, "environments with an existing source should not be reported")
+
+	require.NoError(t, os.RemoveAll(sourceDir))
+
+	orphaned, err = ListOrphanedWithBasePath(ctx, configDir)
+	require.NoError(t, err)
+	require.Len(t, orphaned, 1)
+	assert.Equal(t, "orphan-env", orphaned[0].ID)
+	assert.Equal(t, repo.SourcePath(), orphaned[0].SourcePath)
+
+	require.NoError(t, orphaned[0].Delete(ctx))
+	assert.NoDirExists(t, repo.forkRepoPath, "empty fork repository should be removed")
+
+	orphaned, err = ListOrphanedWithBasePath(ctx, configDir)
+	require.NoError(t, err)
+	assert.Empty(t, orphaned)
+}
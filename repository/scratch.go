@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+// scratchIDPrefix makes scratch environments recognizable from their ID.
+const scratchIDPrefix = "scratch-"
+
+// errScratch is returned by operations that need the branch of an environment, which scratch environments don't have.
+var errScratch = errors.New("scratch environments have no branch and aren't persisted")
+
+// scratchEnvironments are the scratch environments created by this process, by ID, as *scratchEnvironment.
+var scratchEnvironments sync.Map
+
+// scratchEnvironment is the snapshot of a scratch environment as of its last update. Like for persisted
+// environments, each caller gets its own instance loaded from the snapshot rather than sharing one.
+type scratchEnvironment struct {
+	dag   *dagger.Client
+	state []byte
+}
+
+// storeScratch saves the snapshot of a scratch environment.
+func storeScratch(dag *dagger.Client, env *environment.Environment) error {
+	state, err := env.State.Marshal()
+	if err != nil {
+		return err
+	}
+	scratchEnvironments.Store(env.ID, &scratchEnvironment{dag: dag, state: state})
+	return nil
+}
+
+// CreateScratch creates a throwaway environment for quick experiments: unlike Create, no branch, worktree
+// or commit is created. The environment lives in the memory of this process only: commands can be run and
+// files read and written, but nothing is committed, and it is gone when the process exits.
+// Submodules are not initialized.
+func (r *Repository) CreateScratch(ctx context.Context, dag *dagger.Client, description, gitRef string, opts CreateOpts) (*environment.Environment, error) {
+	if gitRef == "" {
+		gitRef = "HEAD"
+	}
+	commit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", gitRef+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", gitRef, err)
+	}
+
+	sourceDir, err := dag.
+		Host().
+		Directory(r.userRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(strings.TrimSpace(commit)).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading source directory: %w", err)
+	}
+
+	config := environment.DefaultConfig()
+	if err := config.Load(r.userRepoPath); err != nil {
+		return nil, err
+	}
+
+	env, err := environment.New(ctx, environment.NewEnvArgs{
+		Dag:              dag,
+		ID:               scratchIDPrefix + petname.Generate(2, "-"),
+		Title:            description,
+		Config:           config,
+		InitialSourceDir: sourceDir,
+		SourcePath:       r.userRepoPath,
+		Progress:         opts.Progress,
+		Variables:        opts.Variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+	env.State.Scratch = true
+
+	if err := storeScratch(dag, env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// scratch returns an instance of the scratch environment of the repository with the given ID, if any.
+func (r *Repository) scratch(ctx context.Context, id string) (*environment.Environment, bool) {
+	value, ok := scratchEnvironments.Load(id)
+	if !ok {
+		return nil, false
+	}
+	snapshot := value.(*scratchEnvironment)
+	env, err := environment.Load(ctx, snapshot.dag, id, snapshot.state, r.userRepoPath)
+	if err != nil {
+		// Not expected, the snapshot was marshaled by storeScratch
+		slog.Error("failed to load scratch environment", "environment.id", id, "err", err)
+		return nil, false
+	}
+	if env.State.SourcePath != r.userRepoPath {
+		return nil, false
+	}
+	return env, true
+}
+
+// updateScratch keeps the changes of a scratch environment in memory: there is nothing to commit them to.
+func (r *Repository) updateScratch(env *environment.Environment) error {
+	value, ok := scratchEnvironments.Load(env.ID)
+	if !ok {
+		return fmt.Errorf("scratch environment %q not found: it was deleted or created by another process", env.ID)
+	}
+	env.State.UpdatedAt = time.Now()
+	// Notes are only recorded with commits
+	env.Notes.Pop()
+	return storeScratch(value.(*scratchEnvironment).dag, env)
+}
+
+// deleteScratch forgets a scratch environment, stopping its services.
+func (r *Repository) deleteScratch(ctx context.Context, id string) error {
+	scratchEnvironments.Delete(id)
+	return environment.StopServices(ctx, id)
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScratchInstances(t *testing.T) {
+	ctx := t.Context()
+	r := &Repository{userRepoPath: "/src/app"}
+	created := &environment.Environment{EnvironmentInfo: &environment.EnvironmentInfo{
+		ID:    scratchIDPrefix + "instances",
+		State: &environment.State{Title: "Quick experiment", SourcePath: "/src/app", Scratch: true, Config: environment.DefaultConfig()},
+	}}
+	require.NoError(t, storeScratch(nil, created))
+	t.Cleanup(func() { scratchEnvironments.Delete(created.ID) })
+
+	// Callers don't share instances: changes are only seen by others once saved
+	first, ok := r.scratch(ctx, created.ID)
+	require.True(t, ok)
+	second, ok := r.scratch(ctx, created.ID)
+	require.True(t, ok)
+	first.State.Title = "Renamed"
+	assert.Equal(t, "Quick experiment", second.State.Title)
+
+	require.NoError(t, r.updateScratch(first))
+	third, ok := r.scratch(ctx, created.ID)
+	require.True(t, ok)
+	assert.Equal(t, "Renamed", third.State.Title)
+
+	// Scratch environments of other repositories aren't visible
+	_, ok = (&Repository{userRepoPath: "/src/other"}).scratch(ctx, created.ID)
+	assert.False(t, ok)
+}
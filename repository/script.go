@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// scriptStep is a commit of an environment, replayed by its script.
+type scriptStep struct {
+	commit   string
+	author   string
+	subject  string
+	commands []environment.RecordedCommand
+	patch    string
+}
+
+// Script writes a shell script reproducing the work done in an environment: its configuration, then for each of
+// its commits, the commands recorded in the environment log followed by the changes of the commit.
+// The script is meant to be run at the root of a clean clone of the repository, in a container of the
+// environment's base image.
+func (r *Repository) Script(ctx context.Context, id string, w io.Writer) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	base, err := r.mergeBase(ctx, envInfo)
+	if err != nil {
+		return err
+	}
+
+	// Commits are separated by \x1e and their fields by \x1f, which don't show up in messages and notes.
+	log, err := RunGitCommand(ctx, r.userRepoPath, "log", "--reverse", "--notes="+gitNotesLogRef,
+		"--format=%H%x1f%an <%ae>%x1f%s%x1f%N%x1e", fmt.Sprintf("%s..%s/%s", base, containerUseRemote, envInfo.ID))
+	if err != nil {
+		return err
+	}
+
+	var steps []scriptStep
+	for record := range strings.SplitSeq(log, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		patch, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--binary", fields[0]+"^", fields[0])
+		if err != nil {
+			return err
+		}
+		steps = append(steps, scriptStep{
+			commit:   fields[0],
+			author:   fields[1],
+			subject:  fields[2],
+			commands: environment.ParseCommands(fields[3]),
+			patch:    patch,
+		})
+	}
+
+	_, err = io.WriteString(w, renderScript(envInfo, base, steps))
+	return err
+}
+
+// renderScript renders the script of an environment whose commits are steps, on top of the base commit.
+func renderScript(envInfo *environment.EnvironmentInfo, base string, steps []scriptStep) string {
+	config := envInfo.State.Config
+	var b strings.Builder
+	fmt.Fprintf(&b, `#!/bin/sh
+# Replays the work done in container-use environment %s (%s).
+#
+# Run it at the root of a clean clone of the repository, in a container of the environment's base image, e.g.:
+#   docker run --rm -it -v "$PWD:%s" -w %s %s sh ./replay.sh
+#
+# For each commit of the environment, the commands recorded in its log are run again, for their effects outside of
+# the repository (e.g. installed packages). The changes of the commit are then applied as is, so the files end up
+# exactly as in the environment even if commands behave differently this time. Commits keep their author, git
+# must be configured with a committer identity.
+set -e
+
+if [ -n "$(git status --porcelain)" ]; then
+	echo "The working tree must be clean: the script discards the changes made by commands" >&2
+	exit 1
+fi
+git checkout -q -b %s %s
+`, envInfo.ID, envInfo.State.Title, config.Workdir, config.Workdir, config.BaseImageRef(),
+		shellQuote("replay/"+envInfo.ID), base)
+
+	if keys := config.Env.Keys(); len(keys) > 0 {
+		b.WriteString("\n# Environment variables\n")
+		for _, key := range keys {
+			// Values can refer to other variables, as in the environment
+			fmt.Fprintf(&b, "export %s=%s\n", key, shellDoubleQuote(config.Env.Get(key)))
+		}
+	}
+	if keys := config.Secrets.Keys(); len(keys) > 0 {
+		b.WriteString("\n# Secrets aren't included, set them before running the script:\n")
+		for _, key := range keys {
+			fmt.Fprintf(&b, "#   export %s=... (from %s)\n", key, config.Secrets.Get(key))
+		}
+	}
+	if config.CABundle != "" {
+		fmt.Fprintf(&b, "\n# The CA certificates of %s aren't installed by the script, add them to the trust store first.\n", config.CABundle)
+	}
+	if len(config.PathPrepend) > 0 {
+		fmt.Fprintf(&b, "\nexport PATH=%s:\"$PATH\"\n", shellQuote(strings.Join(config.PathPrepend, ":")))
+	}
+
+	writeCommands := func(title string, commands []string) {
+		if len(commands) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n# %s\n", title)
+		for _, command := range commands {
+			fmt.Fprintf(&b, "%s\n", command)
+		}
+	}
+	writeCommands("Setup commands", config.SetupCommands)
+	for _, stage := range config.SetupStages {
+		writeCommands(fmt.Sprintf("Setup stage %s", stage.Name), stage.Commands)
+	}
+	writeCommands("Install commands", config.InstallCommands)
+	writeCommands("Seed commands", config.SeedCommands)
+	if len(config.Services) > 0 {
+		b.WriteString("\n# Services aren't started by the script:\n")
+		for _, service := range config.Services {
+			fmt.Fprintf(&b, "#   %s (%s)\n", service.Name, service.Image)
+		}
+	}
+
+	for _, step := range steps {
+		fmt.Fprintf(&b, "\n# %s %s\n", step.commit[:min(len(step.commit), 12)], step.subject)
+		for _, command := range step.commands {
+			if command.ExitCode != 0 {
+				fmt.Fprintf(&b, "# Exited with code %d in the environment\n(\n%s\n) || true\n", command.ExitCode, command.Command)
+				continue
+			}
+			fmt.Fprintf(&b, "(\n%s\n)\n", command.Command)
+		}
+		if len(step.commands) > 0 {
+			b.WriteString("git reset -q --hard && git clean -qfd\n")
+		}
+		if step.patch != "" {
+			delimiter := heredocDelimiter(step.patch)
+			fmt.Fprintf(&b, "git apply --binary --index <<'%s'\n%s%s\n", delimiter, step.patch, delimiter)
+		}
+		fmt.Fprintf(&b, "git commit -q --allow-empty --author=%s -m %s\n", shellQuote(step.author), shellQuote(step.subject))
+	}
+
+	return b.String()
+}
+
+// heredocDelimiter returns a here-document delimiter that doesn't show up in content.
+func heredocDelimiter(content string) string {
+	delimiter := "CONTAINER_USE_PATCH"
+	for i := 1; strings.Contains(content, delimiter); i++ {
+		delimiter = fmt.Sprintf("CONTAINER_USE_PATCH_%d", i)
+	}
+	return delimiter
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellDoubleQuote quotes s so that its variables are still expanded.
+func shellDoubleQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(s) + `"`
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderScript(t *testing.T) {
+	config := environment.DefaultConfig()
+	config.SetupCommands = []string{"apt-get update && apt-get install -y git"}
+	config.Env = environment.KVList{"PATH=/opt/bin:$PATH"}
+	envInfo := &environment.EnvironmentInfo{
+		ID:    "fancy-mallard",
+		State: &environment.State{Title: "Fix the build", Config: config},
+	}
+
+	script := renderScript(envInfo, "abc123", []scriptStep{
+		{
+			commit:  "0123456789abcdef",
+			author:  "Jane Doe <jane@example.com>",
+			subject: "Write main.go",
+			patch:   "diff --git a/main.go b/main.go\n",
+		},
+		{
+			commit:   "fedcba9876543210",
+			author:   "Jane Doe <jane@example.com>",
+			subject:  "Run the tests",
+			commands: []environment.RecordedCommand{{Command: "go test ./..."}, {Command: "false", ExitCode: 1}},
+		},
+	})
+
+	assert.Contains(t, script, "git checkout -q -b 'replay/fancy-mallard' abc123\n")
+	assert.Contains(t, script, "export PATH=\"/opt/bin:$PATH\"\n")
+	assert.Contains(t, script, "\n# Setup commands\napt-get update && apt-get install -y git\n")
+	assert.Contains(t, script, "# 0123456789ab Write main.go\ngit apply --binary --index <<'CONTAINER_USE_PATCH'\ndiff --git a/main.go b/main.go\nCONTAINER_USE_PATCH\n"+
+		"git commit -q --allow-empty --author='Jane Doe <jane@example.com>' -m 'Write main.go'\n")
+	assert.Contains(t, script, "# fedcba987654 Run the tests\n(\ngo test ./...\n)\n# Exited with code 1 in the environment\n(\nfalse\n) || true\n"+
+		"git reset -q --hard && git clean -qfd\ngit commit -q --allow-empty --author='Jane Doe <jane@example.com>' -m 'Run the tests'\n")
+}
+
+func TestHeredocDelimiter(t *testing.T) {
+	assert.Equal(t, "CONTAINER_USE_PATCH", heredocDelimiter("diff"))
+	assert.Equal(t, "CONTAINER_USE_PATCH_1", heredocDelimiter("CONTAINER_USE_PATCH\n"))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	assert.Equal(t, `"say \"hi\" to $USER"`, shellDoubleQuote(`say "hi" to $USER`))
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvironmentSize is the disk footprint of an environment, in bytes.
+type EnvironmentSize struct {
+	// Worktree is the environment's checkout along with its git metadata (index, ...).
+	Worktree int64 `json:"worktree"`
+	// State is the environment's state stored in git notes.
+	State int64 `json:"state"`
+}
+
+// Total returns the whole footprint of the environment.
+func (s *EnvironmentSize) Total() int64 {
+	return s.Worktree + s.State
+}
+
+// Size computes the disk footprint of an environment.
+// Git objects shared with other environments and the Dagger cache are not accounted for.
+func (r *Repository) Size(ctx context.Context, id string) (*EnvironmentSize, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, err
+	}
+	size := &EnvironmentSize{}
+	if size.Worktree, err = dirSize(worktreePath); err != nil {
+		return nil, err
+	}
+	// The worktree's git metadata lives in the fork repository
+	if gitDir := worktreeGitDir(worktreePath); gitDir != "" {
+		metadata, err := dirSize(gitDir)
+		if err != nil {
+			return nil, err
+		}
+		size.Worktree += metadata
+	}
+
+	state, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesStateRef, "show", id)
+	if err == nil {
+		size.State = int64(len(state))
+	}
+	return size, nil
+}
+
+// worktreeGitDir returns the git directory of a worktree, as found in its .git file, or "" if unknown.
+func worktreeGitDir(worktreePath string) string {
+	data, err := os.ReadFile(filepath.Join(worktreePath, ".git"))
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return ""
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(worktreePath, gitDir)
+	}
+	return gitDir
+}
+
+// dirSize returns the total size of the regular files under path, or 0 if it doesn't exist.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return size, err
+}
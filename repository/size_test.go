@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "dir", "b"), make([]byte, 50), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "link")))
+
+	size, err := dirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(150), size)
+
+	size, err = dirSize(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Zero(t, size)
+}
+
+func TestWorktreeGitDir(t *testing.T) {
+	worktree := t.TempDir()
+	assert.Empty(t, worktreeGitDir(worktree))
+
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: /repos/fork/worktrees/fancy-mallard\n"), 0644))
+	assert.Equal(t, "/repos/fork/worktrees/fancy-mallard", worktreeGitDir(worktree))
+
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: ../fork/worktrees/fancy-mallard\n"), 0644))
+	assert.Equal(t, filepath.Join(filepath.Dir(worktree), "fork", "worktrees", "fancy-mallard"), worktreeGitDir(worktree))
+}
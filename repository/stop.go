@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+// stopRequestPath returns the file used to ask MCP servers to stop a background command or a service of an
+// environment. Like cancel requests, stop requests go through the filesystem: their modification time tells
+// servers which of the processes they run were started before the request.
+func stopRequestPath(id, handle string) string {
+	return filepath.Join(cuGlobalConfigPath, "stop", id, handle)
+}
+
+// RequestStop asks the MCP servers running a background command (given its ID) or a service (given its name) of
+// the environment to stop it. Processes started after the request are not affected.
+func RequestStop(id, handle string) error {
+	path := stopRequestPath(id, handle)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create stop request directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to request stop: %w", err)
+	}
+	return nil
+}
+
+// StopRequested reports whether stopping the background command or service of the environment was requested
+// after since.
+func StopRequested(id, handle string, since time.Time) bool {
+	info, err := os.Stat(stopRequestPath(id, handle))
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(since)
+}
+
+// ClearStopRequest removes the request to stop the background command or service of the environment, once the
+// server running it acted on it.
+func ClearStopRequest(id, handle string) error {
+	if err := os.Remove(stopRequestPath(id, handle)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear stop request: %w", err)
+	}
+	// Only succeeds once the environment has no other pending request
+	os.Remove(filepath.Dir(stopRequestPath(id, handle)))
+	return nil
+}
+
+// clearStopRequests removes all the stop requests of an environment, e.g. for processes no server runs anymore.
+func clearStopRequests(id string) error {
+	if err := os.RemoveAll(filepath.Join(cuGlobalConfigPath, "stop", id)); err != nil {
+		return fmt.Errorf("failed to clear stop requests: %w", err)
+	}
+	return nil
+}
+
+// Stop stops a background command (given its ID) or a service (given its name) of an environment from another
+// process than the MCP server running it: the server is asked to stop it with RequestStop, and background commands
+// are removed from the state right away, as are the endpoints of services.
+func (r *Repository) Stop(ctx context.Context, id, handle string) error {
+	return r.lockManager.WithLock(ctx, environmentLockType(id), func() error {
+		envInfo, err := r.Info(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		background := slices.IndexFunc(envInfo.State.BackgroundCommands, func(bg *environment.BackgroundCommand) bool { return bg.ID == handle })
+		if background < 0 && envInfo.State.Config.Services.Get(handle) == nil {
+			return fmt.Errorf("%s is neither a background command nor a service of environment %s", handle, id)
+		}
+		if err := RequestStop(id, handle); err != nil {
+			return err
+		}
+		if background < 0 {
+			delete(envInfo.State.ServiceEndpoints, handle)
+		} else {
+			envInfo.State.BackgroundCommands = slices.Delete(envInfo.State.BackgroundCommands, background, background+1)
+		}
+		return r.saveInfo(ctx, envInfo)
+	})
+}
+
+// RemoveService removes a service from an environment from another process than the MCP server running it: the
+// environment is rebuilt without the service (see environment.Environment.RemoveService), then the server is asked
+// to stop it with RequestStop. The other services are bound to the rebuilt container without being started, their
+// endpoints are left to the server.
+func (r *Repository) RemoveService(ctx context.Context, dag *dagger.Client, id, name, explanation string) error {
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return err
+	}
+	env.DeferServices = true
+	if err := env.RemoveService(ctx, explanation, name); err != nil {
+		return err
+	}
+	if err := RequestStop(id, name); err != nil {
+		return err
+	}
+	return r.Update(ctx, env, explanation)
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClearStopRequests(t *testing.T) {
+	configPath := cuGlobalConfigPath
+	cuGlobalConfigPath = t.TempDir()
+	t.Cleanup(func() { cuGlobalConfigPath = configPath })
+
+	require.NoError(t, RequestStop("env", "web"))
+	require.NoError(t, RequestStop("env", "db"))
+	assert.True(t, StopRequested("env", "web", time.Time{}))
+
+	require.NoError(t, ClearStopRequest("env", "web"))
+	assert.False(t, StopRequested("env", "web", time.Time{}))
+	assert.True(t, StopRequested("env", "db", time.Time{}))
+	require.NoError(t, ClearStopRequest("env", "web"))
+
+	require.NoError(t, ClearStopRequest("env", "db"))
+	assert.NoDirExists(t, filepath.Join(cuGlobalConfigPath, "stop", "env"))
+
+	require.NoError(t, RequestStop("other", "web"))
+	require.NoError(t, clearStopRequests("other"))
+	assert.NoDirExists(t, filepath.Join(cuGlobalConfigPath, "stop", "other"))
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// maxAutoTitleLength is the maximum length, in runes, of derived titles.
+const maxAutoTitleLength = 60
+
+// placeholderTitles are titles that don't say anything about what happens in an environment.
+var placeholderTitles = map[string]bool{
+	"":                true,
+	"environment":     true,
+	"new environment": true,
+	"untitled":        true,
+	"test":            true,
+	"tmp":             true,
+	"todo":            true,
+	"tbd":             true,
+	"wip":             true,
+	"work":            true,
+	"task":            true,
+}
+
+func isPlaceholderTitle(title string) bool {
+	return placeholderTitles[strings.ToLower(strings.TrimSpace(title))]
+}
+
+// titleFromExplanation derives a title from the first line of a commit explanation.
+// It returns an empty string if the explanation isn't substantive enough to describe the environment.
+func titleFromExplanation(explanation string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(explanation), "\n")
+	line = strings.TrimSpace(line)
+	if len(strings.Fields(line)) < 2 || isPlaceholderTitle(line) {
+		return ""
+	}
+	if runes := []rune(line); len(runes) > maxAutoTitleLength {
+		line = strings.TrimSpace(string(runes[:maxAutoTitleLength-1])) + "…"
+	}
+	return line
+}
+
+// branchTitle is the title given to environments until a substantive commit comes in.
+func branchTitle(id string) string {
+	return "container-use/" + id
+}
+
+// applyAutoTitle replaces a placeholder title with one derived from explanation when the
+// environment's configuration enables it. Explicit titles are never changed.
+// Without a substantive explanation, the environment is titled after its branch.
+func applyAutoTitle(env *environment.Environment, explanation string) {
+	if env.State.Config == nil || !env.State.Config.AutoTitle {
+		return
+	}
+	if !isPlaceholderTitle(env.State.Title) && env.State.Title != branchTitle(env.ID) {
+		return
+	}
+	if title := titleFromExplanation(explanation); title != "" {
+		env.State.Title = title
+	} else {
+		env.State.Title = branchTitle(env.ID)
+	}
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTitleFromExplanation(t *testing.T) {
+	tests := []struct {
+		explanation string
+		expected    string
+	}{
+		{"Add login form with validation", "Add login form with validation"},
+		{"Fix flaky test\n\nThe test depended on map ordering", "Fix flaky test"},
+		{"  Refactor parser  ", "Refactor parser"},
+		{"", ""},
+		{"update", ""},
+		{"New environment", ""},
+		{"Implement the new caching layer for the file read tool so that agents stop hitting the engine", "Implement the new caching layer for the file read tool so t…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.explanation, func(t *testing.T) {
+			assert.Equal(t, tt.expected, titleFromExplanation(tt.explanation))
+		})
+	}
+}
+
+func TestApplyAutoTitle(t *testing.T) {
+	newEnv := func(title string, autoTitle bool) *environment.Environment {
+		config := environment.DefaultConfig()
+		config.AutoTitle = autoTitle
+		return &environment.Environment{
+			EnvironmentInfo: &environment.EnvironmentInfo{
+				ID:    "fancy-mallard",
+				State: &environment.State{Title: title, Config: config},
+			},
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		env := newEnv("", false)
+		applyAutoTitle(env, "Add login form")
+		assert.Equal(t, "", env.State.Title)
+	})
+
+	t.Run("explicit title is kept", func(t *testing.T) {
+		env := newEnv("Login page", true)
+		applyAutoTitle(env, "Add login form")
+		assert.Equal(t, "Login page", env.State.Title)
+	})
+
+	t.Run("placeholder is replaced", func(t *testing.T) {
+		env := newEnv("untitled", true)
+		applyAutoTitle(env, "Add login form")
+		assert.Equal(t, "Add login form", env.State.Title)
+	})
+
+	t.Run("branch name until a substantive commit", func(t *testing.T) {
+		env := newEnv("", true)
+		applyAutoTitle(env, "init")
+		assert.Equal(t, "container-use/fancy-mallard", env.State.Title)
+
+		applyAutoTitle(env, "Add login form")
+		assert.Equal(t, "Add login form", env.State.Title)
+
+		// Once derived, the title sticks
+		applyAutoTitle(env, "Style login form")
+		assert.Equal(t, "Add login form", env.State.Title)
+	})
+}
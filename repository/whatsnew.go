@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lastSeenRefPrefix holds, in the user's repository, the environment commit the user last looked at.
+// These refs are local to the user and never pushed.
+const lastSeenRefPrefix = "refs/container-use-seen/"
+
+func lastSeenRef(id string) string {
+	return lastSeenRefPrefix + id
+}
+
+// WhatsNew writes the log of the commits made in the environment since the user last called WhatsNew, then
+// marks the environment's current commit as seen. The first time, or when the previously seen commit is no
+// longer part of the environment's history, every commit of the environment is shown.
+// It returns false if there was nothing new.
+func (r *Repository) WhatsNew(ctx context.Context, id string, patch bool, w io.Writer) (bool, error) {
+	return r.WhatsNewWithOpts(ctx, id, LogOpts{Patch: patch}, w)
+}
+
+// WhatsNewWithOpts is WhatsNew with optional arguments.
+func (r *Repository) WhatsNewWithOpts(ctx context.Context, id string, opts LogOpts, w io.Writer) (bool, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	envGitRef := fmt.Sprintf("%s/%s", containerUseRemote, envInfo.ID)
+	head, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", envGitRef)
+	if err != nil {
+		return false, err
+	}
+	head = strings.TrimSpace(head)
+
+	since, err := r.lastSeen(ctx, envInfo.ID, head)
+	if err != nil {
+		return false, err
+	}
+	if since == head {
+		return false, nil
+	}
+	if since == "" {
+		if since, err = r.mergeBase(ctx, envInfo); err != nil {
+			return false, err
+		}
+	}
+
+	if err := r.logRange(ctx, fmt.Sprintf("%s..%s", since, head), opts, w); err != nil {
+		return false, err
+	}
+
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", lastSeenRef(envInfo.ID), head); err != nil {
+		return false, fmt.Errorf("failed to record last seen commit: %w", err)
+	}
+	return true, nil
+}
+
+// lastSeen returns the last commit of the environment seen by the user, or an empty string if
+// there is none or it isn't an ancestor of head anymore.
+func (r *Repository) lastSeen(ctx context.Context, id, head string) (string, error) {
+	seen, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format=%(objectname)", lastSeenRef(id))
+	if err != nil {
+		return "", err
+	}
+	seen = strings.TrimSpace(seen)
+	if seen == "" {
+		return "", nil
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "merge-base", "--is-ancestor", seen, head); err != nil {
+		return "", nil
+	}
+	return seen, nil
+}
+
+// deleteLastSeen forgets the last seen commit of a deleted environment.
+func (r *Repository) deleteLastSeen(ctx context.Context, id string) error {
+	seen, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format=%(refname)", lastSeenRef(id))
+	if err != nil || strings.TrimSpace(seen) == "" {
+		return err
+	}
+	_, err = RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", lastSeenRef(id))
+	return err
+}